@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"go-networking/config"
+	"go-networking/crypto/identity"
 	"go-networking/db"
 	"go-networking/docs"
 	"go-networking/ginh/file"
@@ -50,15 +51,21 @@ func main() {
 
 	insertOrderData()
 
-	go startTcpServer()
-
-	// tcpServer.Stop()
-
+	// TCP服务端的processor依赖配置中的存储路径，需要先加载配置
 	ctx := context.Background()
 	if err := envconfig.Process(ctx, &config.ApplicationConfig); err != nil {
 		log.ErrorErr(err)
 	}
 
+	// 上传完成的文件需要写入FileInfo，数据库要在TCP服务端启动前初始化
+	db.InitDB()
+	user.InitAutoMigrate(db.GetDB())
+	file.InitFileMigrate(db.GetDB())
+
+	go startTcpServer()
+
+	// tcpServer.Stop()
+
 	startHttpServer()
 
 }
@@ -182,19 +189,47 @@ func startTcpServer() {
 		Host: "localhost",
 		Port: "8081",
 	}
-	tcpServer, _ := network.NewTcpServer(&network.TcpServerConfig{
-		Network: "tcp",
-		Addr:    addr,
-	})
+	tcpServerConfig := &network.TcpServerConfig{
+		Network:        "tcp",
+		Addr:           addr,
+		TicketLifetime: config.GetTicketLifetime(),
+		// AUTH中的令牌与HTTP登录签发的JWT相同
+		Authenticate: func(token string) (uint32, error) {
+			userId, err := user.ParseToken(token)
+			return uint32(userId), err
+		},
+	}
+	if keyFile := config.GetIdentityKeyFile(); len(keyFile) != 0 {
+		identityKey, err := identity.LoadPrivateKey(keyFile)
+		if err != nil {
+			log.ErrorErrMsg(err, "TCP server identity key load failure.")
+			return
+		}
+		tcpServerConfig.IdentityKey = identityKey
+	}
+	tcpServer, _ := network.NewTcpServer(tcpServerConfig)
 	err := tcpServer.Init()
 	if err != nil {
 		log.ErrorErrMsg(err, "TCP server init failure.")
 		return
 	}
 
-	tcpServer.AddProcessor(network.CONN, processor.NewConnProcs(tcpServer))
+	tcpServer.AddProcessor(network.CONN, processor.NewConnProcs(tcpServer, config.IsInsecureDHAllowed()))
+	tcpServer.AddProcessor(network.REKEY, processor.NewRekeyProcs(tcpServer, config.IsInsecureDHAllowed()))
+	tcpServer.AddProcessor(network.RESUME, processor.NewResumeProcs(tcpServer))
+	tcpServer.AddProcessor(network.AUTH, processor.NewAuthProcs(tcpServer))
 	tcpServer.AddProcessor(network.PING, processor.NewPingProcs(tcpServer))
-	tcpServer.AddProcessor(network.LISTDIR, processor.NewListdireProcs(tcpServer))
+	tcpServer.AddProcessor(network.CLOSE, processor.NewCloseProcs(tcpServer))
+	tcpServer.AddProcessor(network.LISTDIR, processor.NewListdireProcs(tcpServer, config.GetAppStorePath()))
+	subscribeProcs := processor.NewSubscribeProcs(tcpServer)
+	tcpServer.AddProcessor(network.SUBSCRIBE, subscribeProcs)
+	tcpServer.AddProcessor(network.UNSUBSCRIBE, subscribeProcs)
+	fileTransferProcs := processor.NewFileTransferProcs(tcpServer, config.GetAppStorePath(), config.GetMaxCachedFiles())
+	tcpServer.AddProcessor(network.FILETRANSFER, fileTransferProcs)
+	tcpServer.AddProcessor(network.TRANSFERACK, fileTransferProcs)
+	fileUploadProcs := processor.NewFileUploadProcs(tcpServer, config.GetAppStorePath(), file.NewFileService(db.GetDB()), config.GetMaxUploadSize())
+	tcpServer.AddProcessor(network.FILEUPLOAD, fileUploadProcs)
+	tcpServer.AddProcessor(network.TRANSFER, fileUploadProcs)
 
 	err = tcpServer.Start()
 	if err != nil {
@@ -218,10 +253,6 @@ func startHttpServer() {
 
 	r := gin.Default()
 
-	db.InitDB()
-	user.InitAutoMigrate(db.GetDB())
-	file.InitFileMigrate(db.GetDB())
-
 	server := &http.Server{
 		Addr:           ":8080",
 		Handler:        r,
@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 type Config struct {
 	// Http server config
 	HttpServerConfig struct {
@@ -15,6 +17,16 @@ type Config struct {
 	TcpServerConfig struct {
 		Port string `env:"TCP_SERVER_PORT"`
 		Host string `env:"TCP_SERVER_HOST"`
+		// 是否允许客户端使用p=23的演示DH群，只用于测试
+		AllowInsecureDH bool `env:"TCP_ALLOW_INSECURE_DH, default=false"`
+		// 服务端Ed25519身份私钥文件(PKCS#8 PEM)，用于签名CONNACK，为空时不做服务端认证
+		IdentityKeyFile string `env:"TCP_IDENTITY_KEY_FILE"`
+		// 会话恢复票据的有效期，单位秒，为0时不签发票据
+		TicketLifetime int `env:"TCP_TICKET_LIFETIME, default=3600"`
+		// 上传文件的最大长度，单位字节，为0时使用processor.DefaultMaxUploadSize
+		MaxUploadSize uint64 `env:"TCP_MAX_UPLOAD_SIZE, default=1073741824"`
+		// 下载时缓存元数据的文件数，为0时使用processor.DefaultMaxCachedFiles
+		MaxCachedFiles int `env:"TCP_MAX_CACHED_FILES, default=1024"`
 	}
 
 	// application config
@@ -40,3 +52,23 @@ func GetHttpServerConfig() *struct {
 func GetAppStorePath() string {
 	return ApplicationConfig.AppConfig.StorePath
 }
+
+func IsInsecureDHAllowed() bool {
+	return ApplicationConfig.TcpServerConfig.AllowInsecureDH
+}
+
+func GetIdentityKeyFile() string {
+	return ApplicationConfig.TcpServerConfig.IdentityKeyFile
+}
+
+func GetTicketLifetime() time.Duration {
+	return time.Duration(ApplicationConfig.TcpServerConfig.TicketLifetime) * time.Second
+}
+
+func GetMaxUploadSize() uint64 {
+	return ApplicationConfig.TcpServerConfig.MaxUploadSize
+}
+
+func GetMaxCachedFiles() int {
+	return ApplicationConfig.TcpServerConfig.MaxCachedFiles
+}
@@ -8,6 +8,11 @@ import (
 // var aesCipherTable map[string]interface{} = make(map[string]interface{})
 
 func AesEncrypt(plainText []byte, key []byte, nonce []byte) ([]byte, error) {
+	return AesEncryptWithAAD(plainText, key, nonce, nil)
+}
+
+// AesEncryptWithAAD 使用AES-GCM加密，additionalData不加密但参与认证
+func AesEncryptWithAAD(plainText []byte, key []byte, nonce []byte, additionalData []byte) ([]byte, error) {
 	cipherBlock, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -18,10 +23,15 @@ func AesEncrypt(plainText []byte, key []byte, nonce []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	return cipherGCM.Seal(nil, nonce, plainText, nil), nil
+	return cipherGCM.Seal(nil, nonce, plainText, additionalData), nil
 }
 
 func AesDecrypt(cipherText []byte, nonce []byte, key []byte) ([]byte, error) {
+	return AesDecryptWithAAD(cipherText, nonce, key, nil)
+}
+
+// AesDecryptWithAAD 解密并校验AesEncryptWithAAD的结果，additionalData需与加密时一致
+func AesDecryptWithAAD(cipherText []byte, nonce []byte, key []byte, additionalData []byte) ([]byte, error) {
 	cipherBlock, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -32,7 +42,7 @@ func AesDecrypt(cipherText []byte, nonce []byte, key []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	decrypted, err := cipherGCM.Open(nil, nonce, cipherText, nil)
+	decrypted, err := cipherGCM.Open(nil, nonce, cipherText, additionalData)
 	if err != nil {
 		return nil, err
 	}
@@ -16,6 +16,7 @@ func GenDHKP(p, g *big.Int) (*big.Int, *big.Int, error) {
 	return privateKey, publicKey, nil
 }
 
+// FastGenDHKP 使用p=23, g=5的演示群生成密钥对，不安全，只用于测试，连接加密使用NewKeyPair
 func FastGenDHKP() (*big.Int, *big.Int, error) {
 	// mod P
 	p := new(big.Int).SetInt64(23)
@@ -30,6 +31,7 @@ func GenDHSharedKey(otherPublicKey, myPrivateKey, p *big.Int) *big.Int {
 	return new(big.Int).Exp(otherPublicKey, myPrivateKey, p)
 }
 
+// FastGenDHSharedKey 在p=23的演示群中计算共享密钥，不安全，只用于测试
 func FastGenDHSharedKey(otherPublicKey, myPrivateKey *big.Int) *big.Int {
 	p := new(big.Int).SetInt64(23) // mod P
 	return GenDHSharedKey(otherPublicKey, myPrivateKey, p)
@@ -0,0 +1,204 @@
+package dh
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Group 密钥交换使用的群，在CONN中协商
+type Group uint8
+
+const (
+	// GroupX25519 默认的密钥交换群
+	GroupX25519 Group = 1
+	// GroupMODP2048 RFC 3526中的2048位MODP群，生成元为2
+	GroupMODP2048 Group = 2
+	// GroupInsecure p=23, g=5的演示群，密钥空间只有22，只能在显式允许时用于测试
+	GroupInsecure Group = 0xFF
+)
+
+// 派生会话密钥时区分两个方向的info
+const (
+	clientToServerInfo = "go-networking client to server key"
+	serverToClientInfo = "go-networking server to client key"
+	resumptionInfo     = "go-networking resumption secret"
+	resumeBinderInfo   = "go-networking resume binder"
+	// AES-256的密钥长度
+	sessionKeyLen = 32
+)
+
+var (
+	Err_Unsupported_Group  = errors.New("unsupported key exchange group")
+	Err_Invalid_Public_Key = errors.New("invalid key exchange public key")
+)
+
+// RFC 3526 2048-bit MODP Group
+var modp2048Prime, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+		"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"+
+		"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D"+
+		"C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F"+
+		"83655D23DCA3AD961C62F356208552BB9ED529077096966D"+
+		"670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B"+
+		"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9"+
+		"DE2BCBF6955817183995497CEA956AE515D2261898FA0510"+
+		"15728E5A8AACAA68FFFFFFFFFFFFFFFF", 16)
+
+var modp2048Generator = big.NewInt(2)
+
+// KeyPair 一次密钥交换使用的临时密钥对
+type KeyPair struct {
+	group      Group
+	x25519Key  *ecdh.PrivateKey
+	privateKey *big.Int
+	publicKey  []byte
+}
+
+// NewKeyPair 在指定的群中生成临时密钥对
+func NewKeyPair(group Group) (*KeyPair, error) {
+	switch group {
+	case GroupX25519:
+		privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &KeyPair{
+			group:     group,
+			x25519Key: privateKey,
+			publicKey: privateKey.PublicKey().Bytes(),
+		}, nil
+	case GroupMODP2048:
+		// 私钥取自[2, p-2]
+		max := new(big.Int).Sub(modp2048Prime, big.NewInt(3))
+		privateKey, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		privateKey.Add(privateKey, big.NewInt(2))
+		publicKey := new(big.Int).Exp(modp2048Generator, privateKey, modp2048Prime)
+		return &KeyPair{
+			group:      group,
+			privateKey: privateKey,
+			publicKey:  publicKey.FillBytes(make([]byte, 256)),
+		}, nil
+	case GroupInsecure:
+		privateKey, publicKey, err := FastGenDHKP()
+		if err != nil {
+			return nil, err
+		}
+		return &KeyPair{
+			group:      group,
+			privateKey: privateKey,
+			publicKey:  publicKey.Bytes(),
+		}, nil
+	}
+
+	return nil, Err_Unsupported_Group
+}
+
+func (kp *KeyPair) Group() Group {
+	return kp.group
+}
+
+// PublicKey 发送给对端的公钥
+func (kp *KeyPair) PublicKey() []byte {
+	return kp.publicKey
+}
+
+// SharedSecret 使用对端公钥计算共享秘密，对端公钥不合法时返回Err_Invalid_Public_Key
+func (kp *KeyPair) SharedSecret(peerPublicKey []byte) ([]byte, error) {
+	switch kp.group {
+	case GroupX25519:
+		peerKey, err := ecdh.X25519().NewPublicKey(peerPublicKey)
+		if err != nil {
+			return nil, Err_Invalid_Public_Key
+		}
+		// 低阶点会得到全零的共享秘密，ECDH返回错误
+		secret, err := kp.x25519Key.ECDH(peerKey)
+		if err != nil {
+			return nil, Err_Invalid_Public_Key
+		}
+		return secret, nil
+	case GroupMODP2048:
+		peerKey := new(big.Int).SetBytes(peerPublicKey)
+		// 对端公钥需要满足1 < y < p-1，避免落入小子群
+		pMinusOne := new(big.Int).Sub(modp2048Prime, big.NewInt(1))
+		if peerKey.Cmp(big.NewInt(1)) <= 0 || peerKey.Cmp(pMinusOne) >= 0 {
+			return nil, Err_Invalid_Public_Key
+		}
+		secret := new(big.Int).Exp(peerKey, kp.privateKey, modp2048Prime)
+		return secret.FillBytes(make([]byte, 256)), nil
+	case GroupInsecure:
+		peerKey := new(big.Int).SetBytes(peerPublicKey)
+		return FastGenDHSharedKey(peerKey, kp.privateKey).Bytes(), nil
+	}
+
+	return nil, Err_Unsupported_Group
+}
+
+// DeriveSessionKeys 使用HKDF-SHA256从共享秘密派生两个方向的AES-256密钥。
+// 双方的公钥作为salt，将密钥与本次握手绑定。
+// cKey用于客户端发往服务端的数据，sKey用于服务端发往客户端的数据。
+func DeriveSessionKeys(secret []byte, clientPublicKey []byte, serverPublicKey []byte) (cKey []byte, sKey []byte, err error) {
+	salt := make([]byte, 0, len(clientPublicKey)+len(serverPublicKey))
+	salt = append(salt, clientPublicKey...)
+	salt = append(salt, serverPublicKey...)
+
+	cKey = make([]byte, sessionKeyLen)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(clientToServerInfo)), cKey); err != nil {
+		return nil, nil, err
+	}
+	sKey = make([]byte, sessionKeyLen)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(serverToClientInfo)), sKey); err != nil {
+		return nil, nil, err
+	}
+
+	return cKey, sKey, nil
+}
+
+// DeriveResumptionSecret 派生会话恢复使用的秘密，与会话密钥相互独立。
+// 恢复时以该秘密代替共享秘密、双方的随机数代替公钥调用DeriveSessionKeys，
+// 每次恢复都得到新的会话密钥，新的连接上计数器重新开始也不会重用nonce。
+func DeriveResumptionSecret(secret []byte, clientPublicKey []byte, serverPublicKey []byte) ([]byte, error) {
+	salt := make([]byte, 0, len(clientPublicKey)+len(serverPublicKey))
+	salt = append(salt, clientPublicKey...)
+	salt = append(salt, serverPublicKey...)
+
+	resumptionSecret := make([]byte, sessionKeyLen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(resumptionInfo)), resumptionSecret); err != nil {
+		return nil, err
+	}
+	return resumptionSecret, nil
+}
+
+// ResumeBinder 客户端在RESUME中证明持有恢复秘密的MAC，覆盖客户端随机数和票据。
+// 只截获票据而不知道恢复秘密的一方不能恢复会话，也不能使票据失效。
+func ResumeBinder(secret []byte, clientNonce []byte, ticket []byte) ([]byte, error) {
+	binderKey := make([]byte, sessionKeyLen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(resumeBinderInfo)), binderKey); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, binderKey)
+	// 随机数长度固定，放在票据之前不会产生歧义
+	mac.Write(clientNonce)
+	mac.Write(ticket)
+	return mac.Sum(nil), nil
+}
+
+// VerifyResumeBinder 使用恢复秘密校验RESUME中的MAC
+func VerifyResumeBinder(secret []byte, clientNonce []byte, ticket []byte, binder []byte) bool {
+	expected, err := ResumeBinder(secret, clientNonce, ticket)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, binder)
+}
@@ -0,0 +1,96 @@
+package dh_test
+
+import (
+	"go-networking/crypto/dh"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyPairShouldGetSameSharedSecretWhenGivenSupportedGroups(t *testing.T) {
+	for _, group := range []dh.Group{dh.GroupX25519, dh.GroupMODP2048, dh.GroupInsecure} {
+		alice, err := dh.NewKeyPair(group)
+		require.NoError(t, err)
+		bob, err := dh.NewKeyPair(group)
+		require.NoError(t, err)
+
+		secretAlice, err := alice.SharedSecret(bob.PublicKey())
+		require.NoError(t, err)
+		secretBob, err := bob.SharedSecret(alice.PublicKey())
+		require.NoError(t, err)
+
+		assert.Equal(t, secretAlice, secretBob, "group %d", group)
+	}
+}
+
+func TestNewKeyPairShouldReturnErrorWhenGroupUnsupported(t *testing.T) {
+	_, err := dh.NewKeyPair(dh.Group(0))
+	assert.ErrorIs(t, err, dh.Err_Unsupported_Group)
+}
+
+func TestSharedSecretShouldReturnErrorWhenPeerPublicKeyInvalid(t *testing.T) {
+	x25519, err := dh.NewKeyPair(dh.GroupX25519)
+	require.NoError(t, err)
+	// 全零公钥是低阶点
+	_, err = x25519.SharedSecret(make([]byte, 32))
+	assert.ErrorIs(t, err, dh.Err_Invalid_Public_Key)
+	_, err = x25519.SharedSecret([]byte{1, 2, 3})
+	assert.ErrorIs(t, err, dh.Err_Invalid_Public_Key)
+
+	modp, err := dh.NewKeyPair(dh.GroupMODP2048)
+	require.NoError(t, err)
+	_, err = modp.SharedSecret([]byte{1})
+	assert.ErrorIs(t, err, dh.Err_Invalid_Public_Key)
+}
+
+func TestDeriveSessionKeysShouldReturnDistinctDirectionKeys(t *testing.T) {
+	client, err := dh.NewKeyPair(dh.GroupX25519)
+	require.NoError(t, err)
+	server, err := dh.NewKeyPair(dh.GroupX25519)
+	require.NoError(t, err)
+	secret, err := client.SharedSecret(server.PublicKey())
+	require.NoError(t, err)
+
+	cKey, sKey, err := dh.DeriveSessionKeys(secret, client.PublicKey(), server.PublicKey())
+	require.NoError(t, err)
+	assert.Len(t, cKey, 32)
+	assert.Len(t, sKey, 32)
+	assert.NotEqual(t, cKey, sKey)
+
+	cKey2, sKey2, err := dh.DeriveSessionKeys(secret, client.PublicKey(), server.PublicKey())
+	require.NoError(t, err)
+	assert.Equal(t, cKey, cKey2)
+	assert.Equal(t, sKey, sKey2)
+}
+
+func TestDeriveResumptionSecretShouldDifferFromSessionKeys(t *testing.T) {
+	secret := []byte("shared secret")
+	cKey, sKey, err := dh.DeriveSessionKeys(secret, []byte("client"), []byte("server"))
+	require.NoError(t, err)
+	resumptionSecret, err := dh.DeriveResumptionSecret(secret, []byte("client"), []byte("server"))
+	require.NoError(t, err)
+	assert.Len(t, resumptionSecret, 32)
+	assert.NotEqual(t, cKey, resumptionSecret)
+	assert.NotEqual(t, sKey, resumptionSecret)
+
+	// 每次恢复使用不同的随机数，得到不同的会话密钥
+	cKey1, _, err := dh.DeriveSessionKeys(resumptionSecret, []byte("nonce-1"), []byte("server-nonce"))
+	require.NoError(t, err)
+	cKey2, _, err := dh.DeriveSessionKeys(resumptionSecret, []byte("nonce-2"), []byte("server-nonce"))
+	require.NoError(t, err)
+	assert.NotEqual(t, cKey1, cKey2)
+}
+
+func TestResumeBinderShouldVerifyOnlyWithResumptionSecret(t *testing.T) {
+	secret := []byte("resumption secret")
+	nonce := []byte("client nonce")
+	binder, err := dh.ResumeBinder(secret, nonce, []byte("ticket"))
+	require.NoError(t, err)
+	assert.True(t, dh.VerifyResumeBinder(secret, nonce, []byte("ticket"), binder))
+
+	assert.False(t, dh.VerifyResumeBinder([]byte("other secret"), nonce, []byte("ticket"), binder))
+	assert.False(t, dh.VerifyResumeBinder(secret, []byte("other nonce"), []byte("ticket"), binder))
+	assert.False(t, dh.VerifyResumeBinder(secret, nonce, []byte("other ticket"), binder))
+	assert.False(t, dh.VerifyResumeBinder(secret, nonce, []byte("ticket"), nil))
+}
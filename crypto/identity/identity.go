@@ -0,0 +1,106 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"strings"
+)
+
+// 签名内容的前缀，避免签名被挪用到其他协议
+const handshakeContext = "go-networking CONNACK v1"
+
+var (
+	Err_Invalid_Identity_Key = errors.New("invalid ed25519 identity key")
+	Err_Invalid_Signature    = errors.New("invalid handshake signature")
+	Err_Identity_Not_Pinned  = errors.New("server identity does not match the pinned key")
+)
+
+// LoadPrivateKey 读取PEM格式(PKCS#8)的Ed25519私钥，
+// 可以使用 openssl genpkey -algorithm ed25519 生成
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, Err_Invalid_Identity_Key
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, Err_Invalid_Identity_Key
+	}
+	return privateKey, nil
+}
+
+// Fingerprint 公钥的SHA-256指纹，十六进制小写
+func Fingerprint(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:])
+}
+
+// Transcript 握手中需要服务端签名的内容，包括协商的群、双方的临时公钥、连接ID和时间戳
+func Transcript(group uint8, clientPublicKey []byte, serverPublicKey []byte, connID string, timestamp int64) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(handshakeContext)
+	buf.WriteByte(group)
+	writeLV(buf, clientPublicKey)
+	writeLV(buf, serverPublicKey)
+	writeLV(buf, []byte(connID))
+	binary.Write(buf, binary.BigEndian, timestamp)
+	return buf.Bytes()
+}
+
+// Sign 使用身份私钥签名握手内容
+func Sign(privateKey ed25519.PrivateKey, transcript []byte) []byte {
+	return ed25519.Sign(privateKey, transcript)
+}
+
+// Verify 校验握手签名，公钥长度不合法或签名不匹配时返回Err_Invalid_Signature
+func Verify(publicKey []byte, transcript []byte, signature []byte) error {
+	if len(publicKey) != ed25519.PublicKeySize || !ed25519.Verify(publicKey, transcript, signature) {
+		return Err_Invalid_Signature
+	}
+	return nil
+}
+
+// Pin 客户端预先信任的服务端身份，PublicKey和Fingerprint任选其一
+type Pin struct {
+	PublicKey   ed25519.PublicKey
+	Fingerprint string
+}
+
+// Enabled 是否配置了服务端身份
+func (p *Pin) Enabled() bool {
+	return p != nil && (len(p.PublicKey) != 0 || len(p.Fingerprint) != 0)
+}
+
+// Match 服务端在握手中出示的身份公钥是否与预先信任的一致
+func (p *Pin) Match(publicKey []byte) error {
+	if len(p.PublicKey) != 0 {
+		if !bytes.Equal(p.PublicKey, publicKey) {
+			return Err_Identity_Not_Pinned
+		}
+		return nil
+	}
+	if !strings.EqualFold(p.Fingerprint, Fingerprint(publicKey)) {
+		return Err_Identity_Not_Pinned
+	}
+	return nil
+}
+
+func writeLV(buf *bytes.Buffer, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint16(len(data)))
+	buf.Write(data)
+}
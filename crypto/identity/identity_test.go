@@ -0,0 +1,65 @@
+package identity_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"go-networking/crypto/identity"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyShouldRejectSignatureWhenTranscriptChanged(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	transcript := identity.Transcript(1, []byte("client"), []byte("server"), "id", 100)
+	signature := identity.Sign(privateKey, transcript)
+	assert.NoError(t, identity.Verify(publicKey, transcript, signature))
+
+	tampered := identity.Transcript(1, []byte("client"), []byte("proxy"), "id", 100)
+	assert.ErrorIs(t, identity.Verify(publicKey, tampered, signature), identity.Err_Invalid_Signature)
+	assert.ErrorIs(t, identity.Verify(publicKey[:8], transcript, signature), identity.Err_Invalid_Signature)
+}
+
+func TestPinShouldMatchWhenGivenPublicKeyOrFingerprint(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	byKey := &identity.Pin{PublicKey: publicKey}
+	assert.NoError(t, byKey.Match(publicKey))
+	assert.ErrorIs(t, byKey.Match(otherKey), identity.Err_Identity_Not_Pinned)
+
+	byFingerprint := &identity.Pin{Fingerprint: strings.ToUpper(identity.Fingerprint(publicKey))}
+	assert.NoError(t, byFingerprint.Match(publicKey))
+	assert.ErrorIs(t, byFingerprint.Match(otherKey), identity.Err_Identity_Not_Pinned)
+
+	var none *identity.Pin
+	assert.False(t, none.Enabled())
+	assert.False(t, (&identity.Pin{}).Enabled())
+}
+
+func TestLoadPrivateKeyShouldParsePKCS8PEM(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "identity.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+
+	loaded, err := identity.LoadPrivateKey(path)
+	require.NoError(t, err)
+	assert.Equal(t, privateKey, loaded)
+
+	require.NoError(t, os.WriteFile(path, []byte("not a key"), 0600))
+	_, err = identity.LoadPrivateKey(path)
+	assert.ErrorIs(t, err, identity.Err_Invalid_Identity_Key)
+}
@@ -10,6 +10,7 @@ import (
 type FileServiceI interface {
 	ListFile(cmd *ListFileCmd, userId uint) (*ListFilePageDto, error)
 	GetFile(fileId uint) (*FileInfo, error)
+	UploadFile(cmd *UploadFileCmd, userId uint) (*FileInfo, error)
 }
 
 type FileService struct {
@@ -104,6 +105,8 @@ func (f *FileService) UploadFile(cmd *UploadFileCmd, userId uint) (*FileInfo, er
 		tx.Rollback()
 		return nil, err
 	}
-	tx.Commit()
-	return nil, nil
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return fileinfo, nil
 }
@@ -1,6 +1,7 @@
 package user
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -25,3 +26,22 @@ func (ts *TokenService) GenerateToken(userid uint, username string) (string, err
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte("secret")) //  todo : secret
 }
+
+// ParseToken 校验GenerateToken签发的token，返回其中的用户ID
+func ParseToken(tokenStr string) (uint, error) {
+	claims := &CustomClaims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		// 确保token方法与预期一致
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte("secret"), nil //  todo : secret
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := claims.Valid(); err != nil {
+		return 0, err
+	}
+	return claims.UserId, nil
+}
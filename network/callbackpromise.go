@@ -0,0 +1,130 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var Err_Promise_Closed = errors.New("response promise closed")
+
+// ResponseCallback 异步请求结束时调用，收到响应时err为nil，否则frame为nil
+type ResponseCallback func(frame *Frame, err error)
+
+// callbackPromise 收到响应、失败或超时时调用一次回调的promise。
+// 作为ResponsePromise登记在PromiseM中，超过timeout没有结束时由PromiseM以超时错误结束。
+type callbackPromise struct {
+	seq        uint64
+	callback   ResponseCallback
+	createTime time.Time
+	timeout    time.Duration
+	// 执行回调，TcpClientConfig.CallbackExecutor为nil时在当前goroutine中执行
+	execute func(task func())
+	// 回调之前调用，从PromiseM删除该promise
+	release   func()
+	completed atomic.Bool
+	mu        sync.Mutex
+	frame     *Frame
+	err       error
+	done      chan struct{}
+}
+
+func newCallbackPromise(seq uint64, callback ResponseCallback, timeout time.Duration, execute func(task func())) *callbackPromise {
+	return &callbackPromise{
+		seq:        seq,
+		callback:   callback,
+		createTime: time.Now(),
+		timeout:    timeout,
+		execute:    execute,
+		done:       make(chan struct{}),
+	}
+}
+
+func (cp *callbackPromise) Add(frame *Frame) {
+	cp.complete(frame, nil)
+}
+
+// Wait 等待回调执行前的结果，与回调收到的参数相同
+func (cp *callbackPromise) Wait() (*Frame, error) {
+	<-cp.done
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.frame, cp.err
+}
+
+// Close 提前结束请求，回调收到Err_Promise_Closed，客户端停止时回调收到Err_Client_Closed
+func (cp *callbackPromise) Close() {
+	cp.complete(nil, Err_Promise_Closed)
+}
+
+func (cp *callbackPromise) Fail(err error) {
+	cp.complete(nil, err)
+}
+
+func (cp *callbackPromise) Timestamp() time.Time {
+	return cp.createTime
+}
+
+func (cp *callbackPromise) Timeout() time.Duration {
+	return cp.timeout
+}
+
+// complete 只有第一次调用生效，之后的响应或错误被丢弃
+func (cp *callbackPromise) complete(frame *Frame, err error) {
+	if !cp.completed.CompareAndSwap(false, true) {
+		return
+	}
+	cp.mu.Lock()
+	cp.frame, cp.err = frame, err
+	cp.mu.Unlock()
+	close(cp.done)
+	if cp.release != nil {
+		cp.release()
+	}
+	cp.execute(func() {
+		cp.callback(frame, err)
+	})
+}
+
+// SendAsyncWithCallback 发送frame，收到响应、服务端回复ERROR、连接关闭或超过config.Timeout时调用callback。
+// callback默认在netpoll的读取回调或时间轮的goroutine中执行，不能长时间阻塞，
+// 配置CallbackExecutor后交给executor执行。发送失败时返回错误，callback不会被调用。
+func (c *TcpClient) SendAsyncWithCallback(serverAddr string, frame *Frame, callback ResponseCallback) error {
+	if frame == nil {
+		return errors.New("frame is nil")
+	}
+	if callback == nil {
+		return errors.New("callback is nil")
+	}
+	hostConn, err := c.route(serverAddr, frame)
+	if err != nil {
+		return err
+	}
+	frame.Seq = uint64(c.seqIncr.Increment())
+	seq := frame.Seq
+	cp := newCallbackPromise(seq, callback, c.config.Timeout, c.callbackExecutor())
+	cp.release = func() {
+		c.promiseM.DelSeqPromise(seq)
+	}
+	c.promiseM.AddAddrPromise(hostConn.key, seq, cp)
+
+	if err := c.send(hostConn, frame); err != nil {
+		// 连接在发送过程中被关闭时回调已经收到错误，不再返回
+		if !cp.completed.CompareAndSwap(false, true) {
+			return nil
+		}
+		c.promiseM.DelSeqPromise(seq)
+		return err
+	}
+	return nil
+}
+
+func (c *TcpClient) callbackExecutor() func(task func()) {
+	if c.config.CallbackExecutor != nil {
+		return c.config.CallbackExecutor
+	}
+	return func(task func()) {
+		task()
+	}
+}
@@ -0,0 +1,108 @@
+package network_test
+
+import (
+	"context"
+	"go-networking/log"
+	"go-networking/network"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// callbackResult 回调收到的参数
+type callbackResult struct {
+	frame *network.Frame
+	err   error
+}
+
+func recordCallback(results chan callbackResult) network.ResponseCallback {
+	return func(frame *network.Frame, err error) {
+		results <- callbackResult{frame: frame, err: err}
+	}
+}
+
+func awaitCallback(t *testing.T, results chan callbackResult) callbackResult {
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback was not called")
+		return callbackResult{}
+	}
+}
+
+func TestSendAsyncWithCallbackShouldCallCallbackWithResponse(t *testing.T) {
+	log.InitLogger()
+	tcpSrv, processor := startDispatchingServer(t, 0, false)
+	defer tcpSrv.Stop()
+	defer close(processor.release)
+	executed := make(chan struct{}, 1)
+	tcpClient := network.NewTcpClient(&network.TcpClientConfig{
+		Network: "tcp",
+		Timeout: 5 * time.Second,
+		CallbackExecutor: func(task func()) {
+			executed <- struct{}{}
+			go task()
+		},
+	})
+	tcpClient.Init()
+	tcpClient.Start()
+	defer tcpClient.Stop()
+
+	results := make(chan callbackResult, 1)
+	frame := fastFrame()
+	require.NoError(t, tcpClient.SendAsyncWithCallback(fileServerAddr, frame, recordCallback(results)))
+	result := awaitCallback(t, results)
+	require.NoError(t, result.err)
+	assert.Equal(t, frame.Seq, result.frame.Seq)
+	assert.Equal(t, blockingCmd, result.frame.CmdType)
+	assert.Len(t, executed, 1)
+}
+
+func TestSendAsyncWithCallbackShouldCallCallbackWithErrorWhenTimeout(t *testing.T) {
+	log.InitLogger()
+	tcpSrv, processor := startDispatchingServer(t, 0, false)
+	defer tcpSrv.Stop()
+	tcpClient := network.NewTcpClient(&network.TcpClientConfig{
+		Network: "tcp",
+		Timeout: 200 * time.Millisecond,
+	})
+	tcpClient.Init()
+	tcpClient.Start()
+	defer tcpClient.Stop()
+	recorder := &notifyRecorder{frames: make(chan *network.Frame, 1)}
+	tcpClient.AddProcessor(blockingCmd, recorder)
+
+	results := make(chan callbackResult, 2)
+	start := time.Now()
+	frame := slowFrame()
+	require.NoError(t, tcpClient.SendAsyncWithCallback(fileServerAddr, frame, recordCallback(results)))
+	result := awaitCallback(t, results)
+	assert.Nil(t, result.frame)
+	assert.ErrorIs(t, result.err, context.DeadlineExceeded)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// 超时后的响应直接丢弃，不会再次调用回调，也不会交给客户端的processor
+	close(processor.release)
+	assert.Equal(t, frame.Seq, <-processor.processed)
+	recorder.assertNothingDispatched(t, 500*time.Millisecond)
+	assert.Empty(t, results)
+}
+
+func TestSendAsyncWithCallbackShouldCallCallbackWithErrorWhenClientStops(t *testing.T) {
+	log.InitLogger()
+	tcpSrv, processor := startDispatchingServer(t, 0, false)
+	defer tcpSrv.Stop()
+	defer close(processor.release)
+	tcpClient := StartFileTcpClient()
+
+	results := make(chan callbackResult, 1)
+	require.NoError(t, tcpClient.SendAsyncWithCallback(fileServerAddr, slowFrame(), recordCallback(results)))
+	tcpClient.Stop()
+	result := awaitCallback(t, results)
+	assert.Nil(t, result.frame)
+	assert.ErrorIs(t, result.err, network.Err_Client_Closed)
+}
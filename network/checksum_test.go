@@ -0,0 +1,108 @@
+package network_test
+
+import (
+	"encoding/binary"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// corruptingCodec 编码后翻转帧中的一位，模拟未加密链路上的传输错误
+type corruptingCodec struct {
+	network.Codec
+	// 相对长度前缀之后的偏移
+	offset int
+}
+
+func (c *corruptingCodec) Encode(frame *network.Frame) ([]byte, error) {
+	data, err := c.Codec.Encode(frame)
+	if err != nil {
+		return nil, err
+	}
+	_, n := binary.Uvarint(data)
+	data[n+c.offset] ^= 0x01
+	return data, nil
+}
+
+func (c *corruptingCodec) WithCrypto(crypto network.CryptoAlg) network.Codec {
+	return &corruptingCodec{Codec: c.Codec.WithCrypto(crypto), offset: c.offset}
+}
+
+func TestChecksumLVCodecShouldAppendCrc32cWhenEncoding(t *testing.T) {
+	frame := newListDirFrame(7, []byte("payload"))
+	frame.Version = network.VERSION_2_CHECKSUM
+	data, err := network.NewChecksumLVCodec().Encode(frame)
+	require.NoError(t, err)
+
+	body := stripLength(t, data)
+	content, trailer := body[:len(body)-4], body[len(body)-4:]
+	assert.Equal(t, crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli)), binary.BigEndian.Uint32(trailer))
+
+	// 去掉校验和后与LV格式相同
+	lvFrame := newListDirFrame(7, []byte("payload"))
+	lvFrame.Version = network.VERSION_2_CHECKSUM
+	lvData, err := network.NewLVCodec().Encode(lvFrame)
+	require.NoError(t, err)
+	assert.Equal(t, stripLength(t, lvData), content)
+
+	decoded, err := network.Decode(body)
+	require.NoError(t, err)
+	assert.Equal(t, network.VERSION_2_CHECKSUM, decoded.Version)
+	assert.Equal(t, uint64(7), decoded.Seq)
+	assert.Equal(t, []byte("payload"), decoded.Payload)
+}
+
+func TestChecksumLVCodecShouldReturnChecksumErrorWhenBitFlipped(t *testing.T) {
+	frame := newListDirFrame(7, []byte("payload"))
+	data, err := network.NewChecksumLVCodec().Encode(frame)
+	require.NoError(t, err)
+	body := stripLength(t, data)
+
+	// 依次翻转Version之后每个字节的一位，包括HLen和校验和本身
+	for i := 1; i < len(body); i++ {
+		corrupted := append([]byte(nil), body...)
+		corrupted[i] ^= 0x01
+		_, err := network.NewChecksumLVCodec().Decode(corrupted)
+		var checksumErr *network.ChecksumError
+		require.ErrorAs(t, err, &checksumErr, "offset %d", i)
+		assert.NotEqual(t, checksumErr.Expected, checksumErr.Actual)
+		assert.ErrorIs(t, err, network.Err_Frame_Corrupted)
+	}
+}
+
+func TestChecksumLVCodecShouldVerifyEncryptedFrameWhenCryptoSet(t *testing.T) {
+	client, server := newGcmPair(t)
+	frame := newListDirFrame(3, []byte("secret"))
+	data, err := network.NewChecksumLVCodec().WithCrypto(client).Encode(frame)
+	require.NoError(t, err)
+
+	decoded, err := network.NewChecksumLVCodec().WithCrypto(server).Decode(stripLength(t, data))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), decoded.Payload)
+}
+
+func TestTcpServerShouldCloseConnectionWhenChecksumMismatch(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+
+	// 带校验和的客户端正常通信
+	tcpClient := StartVersionedFileTcpClient(network.VERSION_2_CHECKSUM, nil)
+	defer tcpClient.Stop()
+	_, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+
+	// 翻转CmdType的一位，服务端回复CLOSEACK并关闭连接，而不是按错误的命令处理
+	corruptClient := StartVersionedFileTcpClient(network.VERSION_2_CHECKSUM, &corruptingCodec{Codec: network.DefaultCodecs, offset: 1})
+	defer corruptClient.Stop()
+	start := time.Now()
+	_, err = corruptClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	assert.ErrorIs(t, err, network.Err_Frame_Corrupted)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,103 @@
+package network_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseShouldFlushPendingTransferWhenDownloadInProgress(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	content := make([]byte, 1024*1024+3)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data.bin"), content, 0644))
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	destPath := filepath.Join(t.TempDir(), "data.bin")
+	downloadErr := make(chan error, 1)
+	go func() {
+		_, err := tcpClient.DownloadFile(fileServerAddr, "/data.bin", destPath)
+		downloadErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, tcpClient.Close(fileServerAddr))
+	require.NoError(t, <-downloadErr)
+
+	downloaded, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, downloaded), "downloaded file should equal the source file")
+}
+
+func TestCloseShouldFailOutstandingRequestsWhenConnectionClosed(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	// 服务端不会回复未知传输的TRANSFERACK，请求会一直等待到连接关闭
+	sendErr := make(chan error, 1)
+	go func() {
+		frame := network.NewFrame(network.TRANSFERACK, &codec.TransferAck{FileID: 1}, nil)
+		_, err := tcpClient.SendSync(fileServerAddr, frame, 5*time.Second)
+		sendErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, tcpClient.Close(fileServerAddr))
+	select {
+	case err := <-sendErr:
+		assert.ErrorIs(t, err, network.Err_Conn_Closed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("outstanding request should fail after close")
+	}
+
+	// 关闭后再次请求会重新建立连接
+	_, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	assert.NoError(t, err)
+}
+
+func TestWaitDrainedShouldNotLeaveGoroutinesWhenTimedOut(t *testing.T) {
+	conn := &network.Conn{}
+	assert.True(t, conn.WaitDrained(time.Second), "connection without pending tasks is drained")
+	require.True(t, conn.Acquire())
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		assert.False(t, conn.WaitDrained(10*time.Millisecond))
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before, "timed out waits should not leave goroutines behind")
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- conn.WaitDrained(3 * time.Second)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	conn.Release()
+	select {
+	case drained := <-done:
+		assert.True(t, drained)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Release should wake up WaitDrained")
+	}
+	assert.True(t, conn.WaitDrained(time.Second))
+}
@@ -5,17 +5,25 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sync"
 )
 
+// CryptoAlg 加密帧的Header和负载，aad为帧开头的Version、CmdType、Seq和Flags，只认证不加密
 type CryptoAlg interface {
-	Encrypt(plain []byte) ([]byte, error)
-	Decrypt(encrypted []byte) ([]byte, error)
+	Encrypt(plain []byte, aad []byte) ([]byte, error)
+	Decrypt(encrypted []byte, aad []byte) ([]byte, error)
 }
 
+// Codec 帧的线上格式。Encode的结果包含长度前缀，Decode的输入不含长度前缀。
+// 所有格式都以长度前缀和uvarint编码的Version开头，之后的内容由各格式自行定义。
 type Codec interface {
 	Encode(frame *Frame) ([]byte, error)
 	Decode(data []byte) (*Frame, error)
-	GetLength() int
+	// WithCrypto 返回使用crypto加解密Header和负载的编解码器，crypto为nil时不加密
+	WithCrypto(crypto CryptoAlg) Codec
 }
 
 type HeaderCodec interface {
@@ -24,24 +32,47 @@ type HeaderCodec interface {
 }
 
 type LVCodec struct {
+	// 命令类型对应的Header编解码器
+	commands *CommandFactory
+	// 握手完成后用于加解密Header和负载，为nil时不加密
+	crypto CryptoAlg
+	// 是否在帧末尾附加CRC32C校验和，用于未加密的链路
+	checksum bool
 }
 
+// NewLVCodec 使用AddHeaderCodec注册的Header编解码器
 func NewLVCodec() *LVCodec {
-	return &LVCodec{}
+	return &LVCodec{commands: cmdFactory}
 }
 
+// NewLVCodecWithCommands 使用独立的Header编解码器表，不受AddHeaderCodec影响
+func NewLVCodecWithCommands(commands *CommandFactory) *LVCodec {
+	return &LVCodec{commands: commands}
+}
+
+// NewCryptoLVCodec 除握手帧外，加密所有帧的Header和负载并认证帧头
+func NewCryptoLVCodec(crypto CryptoAlg) *LVCodec {
+	return &LVCodec{commands: cmdFactory, crypto: crypto}
+}
+
+// NewChecksumLVCodec LV格式的帧末尾附加4字节大端CRC32C，覆盖长度前缀之后的全部内容，
+// 用于VERSION_2_CHECKSUM。校验失败时Decode返回*ChecksumError，不再解析帧的内容。
+func NewChecksumLVCodec() *LVCodec {
+	return &LVCodec{commands: cmdFactory, checksum: true}
+}
+
+// AddHeaderCodec 向默认的Header编解码器表注册命令，NewLVCodec和DefaultCodecs使用该表
 func AddHeaderCodec(cmdType CommandType, headerCodec HeaderCodec) {
-	cmdFactory.addCmdCodec(cmdType, headerCodec)
+	cmdFactory.AddCmdCodec(cmdType, headerCodec)
 }
 
-func (codec *LVCodec) Encode(frame *Frame) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	encodeVersion(frame, buf)
-	encodeCmdType(frame, buf)
-	encodeSeq(frame, buf)
+func (codec *LVCodec) WithCrypto(crypto CryptoAlg) Codec {
+	return &LVCodec{commands: codec.commands, crypto: crypto, checksum: codec.checksum}
+}
 
+func (codec *LVCodec) Encode(frame *Frame) ([]byte, error) {
 	// 编码SubHeader数据
-	subHeaderCodec, err := cmdFactory.getCmdCodec(frame.CmdType)
+	subHeaderCodec, err := codec.commands.GetCmdCodec(frame.CmdType)
 	if err != nil {
 		return nil, err
 	}
@@ -52,10 +83,34 @@ func (codec *LVCodec) Encode(frame *Frame) ([]byte, error) {
 		return nil, err
 	}
 	frame.HLen = uint16(len(subHeaderData))
-	encodeHLen(frame, buf)
-	buf.Write(subHeaderData)
+	frame.Flags &^= FlagEncrypted
+	if codec.encrypts(frame) {
+		frame.Flags |= FlagEncrypted
+	}
 
-	buf.Write(frame.Payload)
+	buf := new(bytes.Buffer)
+	encodeVersion(frame, buf)
+	encodeCmdType(frame, buf)
+	encodeSeq(frame, buf)
+	encodeFlags(frame, buf)
+
+	body := new(bytes.Buffer)
+	encodeHLen(frame, body)
+	body.Write(subHeaderData)
+	body.Write(frame.Payload)
+	if frame.Flags&FlagEncrypted != 0 {
+		// Header和负载一起加密，帧头只认证不加密
+		sealed, err := codec.crypto.Encrypt(body.Bytes(), buf.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(sealed)
+	} else {
+		buf.Write(body.Bytes())
+	}
+	if codec.checksum {
+		buf.Write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(buf.Bytes(), castagnoli)))
+	}
 	var lengthBytes []byte = make([]byte, binary.MaxVarintLen32)
 	encodeLen := binary.PutUvarint(lengthBytes, uint64(buf.Len()))
 
@@ -69,6 +124,13 @@ func (codec *LVCodec) Decode(data []byte) (*Frame, error) {
 	if len(data) < 2 {
 		return nil, errors.New("frame too short to decode")
 	}
+	// 先校验再解析，损坏的HLen等字段不会被当作有效数据使用
+	if codec.checksum {
+		var err error
+		if data, err = verifyChecksum(data); err != nil {
+			return nil, err
+		}
+	}
 
 	buf := bytes.NewReader(data)
 
@@ -86,33 +148,132 @@ func (codec *LVCodec) Decode(data []byte) (*Frame, error) {
 		return nil, err
 	}
 
+	if err := decodeFlags(buf, &frame.Flags); err != nil {
+		return nil, err
+	}
+
+	if frame.Flags&FlagEncrypted != 0 {
+		aadLen := len(data) - buf.Len()
+		plain, err := decryptBody(codec.crypto, data[aadLen:], data[:aadLen])
+		if err != nil {
+			return nil, err
+		}
+		buf = bytes.NewReader(plain)
+	}
+
 	if err := decodeHLen(buf, &frame.HLen); err != nil {
 		return nil, err
 	}
 
+	if int(frame.HLen) > buf.Len() {
+		return nil, errors.New("failed to read the correct subheader length")
+	}
+
 	varintHeaderData := make([]byte, frame.HLen)
 	if n, err := buf.Read(varintHeaderData); err != nil || n != int(frame.HLen) {
 		return nil, errors.New("failed to read the correct subheader length")
 	}
 
-	headerCodec, err := cmdFactory.getCmdCodec(frame.CmdType)
+	headerCodec, err := codec.commands.GetCmdCodec(frame.CmdType)
 	if err != nil {
-		return nil, err
+		return nil, unknownCommandError(frame, err)
 	}
 
 	header, err := headerCodec.Decode(varintHeaderData)
 	if err != nil {
-		return nil, err
+		return nil, badHeaderError(frame, err)
 	}
 	frame.Header = header
+	// 控制帧可以没有payload，此时不再读取
 	frame.Payload = make([]byte, buf.Len())
-	if _, err := buf.Read(frame.Payload); err != nil {
+	if _, err := io.ReadFull(buf, frame.Payload); err != nil {
 		return nil, errors.New("failed to read payload")
 	}
 
+	if err := checkPlaintext(codec.crypto, frame); err != nil {
+		return nil, err
+	}
 	return frame, nil
 }
 
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Err_Frame_Corrupted 帧的校验和不匹配，*ChecksumError会解包为该错误
+var Err_Frame_Corrupted = errors.New("frame checksum mismatch")
+
+// ChecksumError 帧末尾的CRC32C与内容计算的结果不一致
+type ChecksumError struct {
+	// 帧末尾携带的校验和
+	Expected uint32
+	// 按收到的内容计算的校验和
+	Actual uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%v: expected 0x%08x, actual 0x%08x", Err_Frame_Corrupted, e.Expected, e.Actual)
+}
+
+func (e *ChecksumError) Unwrap() error {
+	return Err_Frame_Corrupted
+}
+
+// verifyChecksum 校验并去掉帧末尾的CRC32C
+func verifyChecksum(data []byte) ([]byte, error) {
+	if len(data) < crc32.Size {
+		return nil, errors.New("frame too short to contain checksum")
+	}
+	body, trailer := data[:len(data)-crc32.Size], data[len(data)-crc32.Size:]
+	expected := binary.BigEndian.Uint32(trailer)
+	if actual := crc32.Checksum(body, castagnoli); actual != expected {
+		return nil, &ChecksumError{Expected: expected, Actual: actual}
+	}
+	return body, nil
+}
+
+// encrypts 握手帧、会话恢复帧和认证失败的通知帧始终明文传输
+func (codec *LVCodec) encrypts(frame *Frame) bool {
+	return encryptsFrame(codec.crypto, frame)
+}
+
+// encryptsFrame 各线上格式共用的加密规则，crypto为nil时不加密。
+// 加密的帧设置FlagEncrypted，Header和负载一起加密，Version、CmdType、Seq和Flags作为附加认证数据
+func encryptsFrame(crypto CryptoAlg, frame *Frame) bool {
+	if crypto == nil {
+		return false
+	}
+	switch frame.CmdType {
+	case CONN, CONNACK, RESUME, RESUMEACK:
+		return false
+	case CLOSEACK:
+		header, ok := frame.Header.(interface{ AuthFailed() bool })
+		return !ok || !header.AuthFailed()
+	}
+	return true
+}
+
+// decryptBody 解密带FlagEncrypted的帧，连接还没有会话密钥时同样视为认证失败
+func decryptBody(crypto CryptoAlg, sealed []byte, aad []byte) ([]byte, error) {
+	if crypto == nil {
+		return nil, fmt.Errorf("%w: encrypted frame before handshake", Err_Frame_Auth_Failed)
+	}
+	return crypto.Decrypt(sealed, aad)
+}
+
+// checkPlaintext 握手后只接受按加密规则可以明文传输的帧，防止攻击者去掉FlagEncrypted发送明文帧。
+// 已有会话的连接上不再接受CONN和RESUME，否则明文帧就能覆盖会话密钥和用户ID
+func checkPlaintext(crypto CryptoAlg, frame *Frame) error {
+	if crypto == nil {
+		return nil
+	}
+	if frame.CmdType == CONN || frame.CmdType == RESUME {
+		return fmt.Errorf("%w: handshake frame after handshake, cmd type: %d", Err_Frame_Auth_Failed, frame.CmdType)
+	}
+	if frame.Flags&FlagEncrypted == 0 && encryptsFrame(crypto, frame) {
+		return fmt.Errorf("%w: plaintext frame after handshake, cmd type: %d", Err_Frame_Auth_Failed, frame.CmdType)
+	}
+	return nil
+}
+
 func encodeVersion(frame *Frame, buf *bytes.Buffer) {
 	encodeIntBuf(uint64(frame.Version), buf)
 }
@@ -125,6 +286,10 @@ func encodeSeq(frame *Frame, buf *bytes.Buffer) {
 	encodeIntBuf(frame.Seq, buf)
 }
 
+func encodeFlags(frame *Frame, buf *bytes.Buffer) {
+	encodeIntBuf(uint64(frame.Flags), buf)
+}
+
 func encodeHLen(frame *Frame, buf *bytes.Buffer) {
 	encodeIntBuf(uint64(frame.HLen), buf)
 }
@@ -164,10 +329,26 @@ func decodeSeq(buf *bytes.Reader, sequence *uint64) error {
 	return nil
 }
 
+func decodeFlags(buf *bytes.Reader, flags *FrameFlags) error {
+	decFlags, err := binary.ReadUvarint(buf)
+	if err != nil {
+		return errors.New("failed to decode flags, invalid bytes")
+	}
+	if decFlags > math.MaxUint8 {
+		return fmt.Errorf("unknown frame flags: 0x%x", decFlags)
+	}
+
+	*flags = FrameFlags(decFlags)
+	return nil
+}
+
 func decodeHLen(buf *bytes.Reader, headerLen *uint16) error {
 	decVersion, err := binary.ReadUvarint(buf)
 	if err != nil {
-		return errors.New("failed to decode command type, invalid bytes")
+		return errors.New("failed to decode header length, invalid bytes")
+	}
+	if decVersion > math.MaxUint16 {
+		return Err_Header_Too_Large
 	}
 
 	*headerLen = uint16(decVersion)
@@ -188,23 +369,29 @@ func DecodeInteger(buf *bytes.Reader) (uint64, error) {
 	return variable, nil
 }
 
-var cmdFactory = newCommandFactory()
+var cmdFactory = NewCommandFactory()
 
-type commandFactory struct {
+// CommandFactory 命令类型到Header编解码器的映射
+type CommandFactory struct {
+	mu             sync.RWMutex
 	commandToCodec map[CommandType]HeaderCodec
 }
 
-func newCommandFactory() *commandFactory {
-	return &commandFactory{
+func NewCommandFactory() *CommandFactory {
+	return &CommandFactory{
 		commandToCodec: make(map[CommandType]HeaderCodec),
 	}
 }
 
-func (cmdFactory *commandFactory) addCmdCodec(cmdType CommandType, headerCodec HeaderCodec) {
+func (cmdFactory *CommandFactory) AddCmdCodec(cmdType CommandType, headerCodec HeaderCodec) {
+	cmdFactory.mu.Lock()
+	defer cmdFactory.mu.Unlock()
 	cmdFactory.commandToCodec[cmdType] = headerCodec
 }
 
-func (cmdFactory *commandFactory) getCmdCodec(cmdType CommandType) (HeaderCodec, error) {
+func (cmdFactory *CommandFactory) GetCmdCodec(cmdType CommandType) (HeaderCodec, error) {
+	cmdFactory.mu.RLock()
+	defer cmdFactory.mu.RUnlock()
 	var headerCodec HeaderCodec
 	var exists bool
 	if headerCodec, exists = cmdFactory.commandToCodec[cmdType]; !exists {
@@ -0,0 +1,30 @@
+package codec
+
+// AuthHeader 客户端在CONN握手之后发送认证令牌。AUTH只在加密的连接上发送，令牌不会出现在明文握手中。
+// AUTH和AUTHACK的Header由StructCodec按wire标签编解码。
+type AuthHeader struct {
+	// CONNACK分配的连接ID
+	Id        string `wire:"lv16"`
+	Timestamp int64  `wire:"fixed"`
+	// 认证令牌，例如HTTP登录签发的JWT
+	Token string `wire:"lv16"`
+}
+
+// AuthAckHeader 服务端的应答，没有Payload
+type AuthAckHeader struct {
+	StatusCode uint16 `wire:"fixed"`
+	Timestamp  int64  `wire:"fixed"`
+	// 令牌所属的用户ID，失败时为0
+	UserId uint32 `wire:"varint"`
+}
+
+// AUTHACK 中的状态码
+const (
+	AuthOK uint16 = 200
+	// 令牌没有通过校验
+	AuthUnauthorized uint16 = 401
+	// 连接ID不属于当前连接，或者连接已经认证为其他用户
+	AuthForbidden uint16 = 403
+	// 连接尚未完成CONN握手
+	AuthNotConnected uint16 = 409
+)
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"io"
 )
 
 type CloseHeader struct {
@@ -16,6 +17,30 @@ type CloseAckHeader struct {
 	Details    string
 }
 
+// CLOSEACK 中的状态码
+const (
+	CloseOK uint16 = 200
+	// 负载的压缩标志不合法或无法解压，发送后连接被关闭
+	CloseBadPayload uint16 = 400
+	// 帧认证失败，该CLOSEACK以明文发送，发送后连接被关闭
+	CloseAuthFailed uint16 = 401
+	// CLOSE中的连接ID属于其他连接，连接仍会被关闭但不会删除该ID
+	CloseIdMismatch uint16 = 403
+	// 待发送的帧没有在超时前发送完毕
+	CloseDrainTimeout uint16 = 408
+	// 帧或Header超过接收方的大小限制，发送后连接被关闭
+	CloseFrameTooLarge uint16 = 413
+	// 帧的校验和不匹配，之后的字节流无法可靠解析，发送后连接被关闭
+	CloseChecksumMismatch uint16 = 422
+	// 帧的协议版本没有注册，未协商版本时该CLOSEACK使用VERSION_2发送，发送后连接被关闭
+	CloseUnsupportedVersion uint16 = 505
+)
+
+// AuthFailed 是否为帧认证失败的通知，这类CLOSEACK不加密
+func (h *CloseAckHeader) AuthFailed() bool {
+	return h.StatusCode == CloseAuthFailed
+}
+
 type CloseHeaderCodec struct{}
 
 func (codec *CloseHeaderCodec) Encode(header interface{}) ([]byte, error) {
@@ -106,8 +131,9 @@ func ReadLVString(reader *bytes.Reader) (string, error) {
 	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
 		return "", err
 	}
+	// 空字符串位于末尾时Read会返回EOF，使用ReadFull
 	strBytes := make([]byte, length)
-	if _, err := reader.Read(strBytes); err != nil {
+	if _, err := io.ReadFull(reader, strBytes); err != nil {
 		return "", err
 	}
 	return string(strBytes), nil
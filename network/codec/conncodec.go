@@ -20,16 +20,42 @@ func (codec *ConnHeaderCodec) Encode(header interface{}) ([]byte, error) {
 
 	// Write timestamp (8 bytes)
 	binary.Write(buf, binary.BigEndian, connHeader.Timestamp)
+	// Write key exchange group (1 byte)
+	buf.WriteByte(connHeader.Group)
+	// Write offered compressions, omitted when the client does not compress
+	if len(connHeader.Compressions) > 0 {
+		writeLVBytes(buf, connHeader.Compressions)
+	}
 
 	return buf.Bytes(), nil
 }
 
 func (codec *ConnHeaderCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) < 8 {
+		return nil, errors.New("data too short for decoding CONN header")
+	}
 	// Read timestamp
 	timestamp := int64(binary.BigEndian.Uint64(data[:8]))
 
+	// 旧的客户端不携带群，由服务端使用默认群
+	var group uint8
+	if len(data) > 8 {
+		group = data[8]
+	}
+	// 不压缩的客户端不携带算法列表
+	var compressions []uint8
+	if len(data) > 9 {
+		var err error
+		compressions, _, err = readLVBytes(data[9:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &ConnHeader{
-		Timestamp: timestamp,
+		Timestamp:    timestamp,
+		Group:        group,
+		Compressions: compressions,
 	}, nil
 }
 
@@ -47,6 +73,15 @@ func (codec *ConnAckHeaderCodec) Encode(header interface{}) ([]byte, error) {
 
 	// Write timestamp (8 bytes)
 	binary.Write(buf, binary.BigEndian, connAckHeader.Timestamp)
+	// Write negotiated key exchange group (1 byte)
+	buf.WriteByte(connAckHeader.Group)
+	// Write server identity key and handshake signature, both empty when the server has no identity
+	writeLVBytes(buf, connAckHeader.IdentityKey)
+	writeLVBytes(buf, connAckHeader.Signature)
+	// Write resumption ticket, empty when the server does not issue tickets
+	writeLVBytes(buf, []byte(connAckHeader.Ticket))
+	// Write negotiated compression (1 byte)
+	buf.WriteByte(connAckHeader.Compression)
 	// Write UUID string (no fixed byte length)
 	buf.WriteString(connAckHeader.Id)
 
@@ -54,25 +89,91 @@ func (codec *ConnAckHeaderCodec) Encode(header interface{}) ([]byte, error) {
 }
 
 func (codec *ConnAckHeaderCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) < 9 {
+		return nil, errors.New("data too short for decoding CONNACK header")
+	}
 	// Read timestamp
 	timestamp := int64(binary.BigEndian.Uint64(data[:8]))
+	// Read group
+	group := data[8]
+	rest := data[9:]
+	// Read server identity key and handshake signature
+	identityKey, rest, err := readLVBytes(rest)
+	if err != nil {
+		return nil, err
+	}
+	signature, rest, err := readLVBytes(rest)
+	if err != nil {
+		return nil, err
+	}
+	// Read resumption ticket
+	ticket, rest, err := readLVBytes(rest)
+	if err != nil {
+		return nil, err
+	}
+	// Read compression
+	if len(rest) < 1 {
+		return nil, errors.New("data too short for decoding CONNACK compression")
+	}
+	compression := rest[0]
+	rest = rest[1:]
 	// Read UUID
-	// The UUID is the rest of the buffer after the timestamp.
-	id := string(data[8:])
+	// The UUID is the rest of the buffer after the signature.
+	id := string(rest)
 
 	return &ConnAckHeader{
-		Id:        id,
-		Timestamp: timestamp,
+		Id:          id,
+		Timestamp:   timestamp,
+		Group:       group,
+		IdentityKey: identityKey,
+		Signature:   signature,
+		Ticket:      string(ticket),
+		Compression: compression,
 	}, nil
 }
 
+// writeLVBytes 写入2字节长度和数据
+func writeLVBytes(buf *bytes.Buffer, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint16(len(data)))
+	buf.Write(data)
+}
+
+// readLVBytes 读取writeLVBytes写入的数据，返回剩余的部分
+func readLVBytes(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("data too short for decoding length")
+	}
+	length := int(binary.BigEndian.Uint16(data[:2]))
+	if len(data) < 2+length {
+		return nil, nil, errors.New("data too short for decoding value")
+	}
+	if length == 0 {
+		return nil, data[2:], nil
+	}
+	return data[2 : 2+length], data[2+length:], nil
+}
+
 type ConnHeader struct {
 	// Timestamp
 	Timestamp int64
+	// 客户端选择的密钥交换群，取值见dh.Group，0表示使用服务端默认的群
+	Group uint8
+	// 客户端支持的压缩算法，按优先级排列，取值见network.CompressionType，为空时不压缩
+	Compressions []uint8
 }
 
 type ConnAckHeader struct {
 	// Client's Connection ID，定义更新使用UUID
 	Id        string
 	Timestamp int64
+	// 服务端实际使用的密钥交换群
+	Group uint8
+	// 服务端的Ed25519身份公钥，未配置身份时为空
+	IdentityKey []byte
+	// 服务端身份私钥对握手内容的签名，见identity.Transcript
+	Signature []byte
+	// 会话恢复票据，TCP断开后凭它通过RESUME恢复连接ID，服务端未启用恢复时为空
+	Ticket string
+	// 服务端选择的压缩算法，0表示不压缩
+	Compression uint8
 }
@@ -0,0 +1,22 @@
+package codec
+
+// ErrorHeader 服务端无法处理请求时的应答，没有Payload。
+// ERROR的Header由StructCodec按wire标签编解码。
+type ErrorHeader struct {
+	// 失败的请求的Seq
+	Seq     uint64 `wire:"varint"`
+	Code    uint16 `wire:"fixed"`
+	Message string `wire:"lv16"`
+}
+
+// ERROR 中的错误码
+const (
+	// 请求的Header无法解码
+	ErrorBadRequest uint16 = 400
+	// 服务端没有该命令的编解码器或processor
+	ErrorUnknownCommand uint16 = 404
+	// processor处理请求失败
+	ErrorInternal uint16 = 500
+	// 连接正在关闭，不再接受新的请求
+	ErrorConnClosing uint16 = 503
+)
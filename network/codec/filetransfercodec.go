@@ -3,11 +3,32 @@ package codec
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// FILETRANSFERACK 和 FILEUPLOADACK 中的错误码
+const (
+	FileTransferOK               uint32 = iota // 协商成功
+	FileTransferNotFound                       // 请求的文件不存在
+	FileTransferInvalidPath                    // 请求的路径非法，例如越出存储根目录或者是目录
+	FileTransferInternal                       // 服务端读取文件失败
+	FileTransferChanged                        // 断点续传时文件已经发生变化，需要重新下载
+	FileTransferInvalidRange                   // 请求的块范围超出文件长度
+	FileTransferChecksumMismatch               // 上传完成后校验和与FILEUPLOAD中声明的不一致
+	FileTransferForbidden                      // 连接没有通过认证，或者FILEUPLOAD中的UserId不是认证的用户
+	FileTransferTooLarge                       // FILEUPLOAD中的文件长度超过服务端允许的上限
 )
 
 type FileTransfer struct {
 	Length   uint32
 	FilePath string
+	// 断点续传时携带上次FILETRANSFERACK中的文件ID和校验和，新下载为0
+	FileID   uint32
+	Checksum uint32
+	// 请求的块范围，从StartBlock开始共BlockCount块，BlockCount为0表示直到文件末尾
+	StartBlock uint32
+	BlockCount uint32
 }
 
 type FileTransferAck struct {
@@ -18,10 +39,16 @@ type FileTransferAck struct {
 	ErrorCode uint32
 }
 
+// Transfer 一个数据块的位置，块的内容在帧的负载中，可以随负载压缩
 type Transfer struct {
 	FileID uint32
 	Seq    uint32
-	Block  []byte
+}
+
+// TransferAck 客户端对已连续收到的数据块的累计确认，Seq之前（含）的块均已落盘
+type TransferAck struct {
+	FileID uint32
+	Seq    uint32
 }
 
 // Codec struct for FileTransfer
@@ -33,8 +60,16 @@ type FileTransferAckCodec struct{}
 // Codec struct for Transfer
 type TransferCodec struct{}
 
+// Codec struct for TransferAck
+type TransferAckCodec struct{}
+
 // Methods for FileTransferCodec
-func (ftc *FileTransferCodec) Encode(ft *FileTransfer) ([]byte, error) {
+func (ftc *FileTransferCodec) Encode(header interface{}) ([]byte, error) {
+	ft, ok := header.(*FileTransfer)
+	if !ok {
+		return nil, errors.New("invalid header type for FILETRANSFER")
+	}
+
 	buf := new(bytes.Buffer)
 
 	if err := binary.Write(buf, binary.BigEndian, ft.Length); err != nil {
@@ -45,10 +80,16 @@ func (ftc *FileTransferCodec) Encode(ft *FileTransfer) ([]byte, error) {
 		return nil, err
 	}
 
+	for _, field := range []uint32{ft.FileID, ft.Checksum, ft.StartBlock, ft.BlockCount} {
+		if err := binary.Write(buf, binary.BigEndian, field); err != nil {
+			return nil, err
+		}
+	}
+
 	return buf.Bytes(), nil
 }
 
-func (ftc *FileTransferCodec) Decode(data []byte) (*FileTransfer, error) {
+func (ftc *FileTransferCodec) Decode(data []byte) (interface{}, error) {
 	reader := bytes.NewReader(data)
 
 	ft := &FileTransfer{}
@@ -63,11 +104,22 @@ func (ftc *FileTransferCodec) Decode(data []byte) (*FileTransfer, error) {
 		return nil, err
 	}
 
+	for _, field := range []*uint32{&ft.FileID, &ft.Checksum, &ft.StartBlock, &ft.BlockCount} {
+		if err := binary.Read(reader, binary.BigEndian, field); err != nil {
+			return nil, err
+		}
+	}
+
 	return ft, nil
 }
 
 // Methods for FileTransferAckCodec
-func (ftac *FileTransferAckCodec) Encode(fta *FileTransferAck) ([]byte, error) {
+func (ftac *FileTransferAckCodec) Encode(header interface{}) ([]byte, error) {
+	fta, ok := header.(*FileTransferAck)
+	if !ok {
+		return nil, errors.New("invalid header type for FILETRANSFERACK")
+	}
+
 	buf := new(bytes.Buffer)
 
 	if err := binary.Write(buf, binary.BigEndian, fta.FileID); err != nil {
@@ -93,7 +145,7 @@ func (ftac *FileTransferAckCodec) Encode(fta *FileTransferAck) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func (ftac *FileTransferAckCodec) Decode(data []byte) (*FileTransferAck, error) {
+func (ftac *FileTransferAckCodec) Decode(data []byte) (interface{}, error) {
 	reader := bytes.NewReader(data)
 
 	fta := &FileTransferAck{}
@@ -122,7 +174,12 @@ func (ftac *FileTransferAckCodec) Decode(data []byte) (*FileTransferAck, error)
 }
 
 // Methods for TransferCodec
-func (tc *TransferCodec) Encode(tr *Transfer) ([]byte, error) {
+func (tc *TransferCodec) Encode(header interface{}) ([]byte, error) {
+	tr, ok := header.(*Transfer)
+	if !ok {
+		return nil, errors.New("invalid header type for TRANSFER")
+	}
+
 	buf := new(bytes.Buffer)
 
 	if err := binary.Write(buf, binary.BigEndian, tr.FileID); err != nil {
@@ -133,14 +190,10 @@ func (tc *TransferCodec) Encode(tr *Transfer) ([]byte, error) {
 		return nil, err
 	}
 
-	if err := encodeBytes(buf, tr.Block); err != nil {
-		return nil, err
-	}
-
 	return buf.Bytes(), nil
 }
 
-func (tc *TransferCodec) Decode(data []byte) (*Transfer, error) {
+func (tc *TransferCodec) Decode(data []byte) (interface{}, error) {
 	reader := bytes.NewReader(data)
 
 	tr := &Transfer{}
@@ -153,13 +206,31 @@ func (tc *TransferCodec) Decode(data []byte) (*Transfer, error) {
 		return nil, err
 	}
 
-	var err error
-	tr.Block, err = decodeBytes(reader)
-	if err != nil {
-		return nil, err
+	return tr, nil
+}
+
+// Methods for TransferAckCodec
+func (tac *TransferAckCodec) Encode(header interface{}) ([]byte, error) {
+	ta, ok := header.(*TransferAck)
+	if !ok {
+		return nil, errors.New("invalid header type for TRANSFERACK")
 	}
 
-	return tr, nil
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint32(buf[0:4], ta.FileID)
+	binary.BigEndian.PutUint32(buf[4:8], ta.Seq)
+	return buf, nil
+}
+
+func (tac *TransferAckCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) < 8 {
+		return nil, errors.New("data too short for decoding TRANSFERACK header")
+	}
+
+	return &TransferAck{
+		FileID: binary.BigEndian.Uint32(data[0:4]),
+		Seq:    binary.BigEndian.Uint32(data[4:8]),
+	}, nil
 }
 
 // Helper functions
@@ -177,8 +248,11 @@ func decodeString(reader *bytes.Reader) (string, error) {
 	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
 		return "", err
 	}
+	if int64(length) > int64(reader.Len()) {
+		return "", errors.New("string length exceeds remaining data")
+	}
 	data := make([]byte, length)
-	if _, err := reader.Read(data); err != nil {
+	if _, err := io.ReadFull(reader, data); err != nil {
 		return "", err
 	}
 	return string(data), nil
@@ -197,8 +271,11 @@ func decodeBytes(reader *bytes.Reader) ([]byte, error) {
 	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
 		return nil, err
 	}
+	if int64(length) > int64(reader.Len()) {
+		return nil, errors.New("bytes length exceeds remaining data")
+	}
 	data := make([]byte, length)
-	if _, err := reader.Read(data); err != nil {
+	if _, err := io.ReadFull(reader, data); err != nil {
 		return nil, err
 	}
 	return data, nil
@@ -11,10 +11,22 @@ import (
 func TestFileTransferCodec_Encode_ShouldReturnBytes_WhenGivenFileTransfer(t *testing.T) {
 	ftc := codec.FileTransferCodec{}
 	ft := codec.FileTransfer{
-		Length:   4,
-		FilePath: "/path/to/file",
+		Length:     13,
+		FilePath:   "/path/to/file",
+		FileID:     1,
+		Checksum:   12345,
+		StartBlock: 2,
+		BlockCount: 3,
 	}
-	expected := []byte{0, 0, 0, 15, '/', 'p', 'a', 't', 'h', '/', 't', 'o', '/', 'f', 'i', 'l', 'e'}
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0, 0, 0, 13}) // Length
+	buf.Write([]byte{0, 0, 0, 13}) // FilePath length
+	buf.WriteString("/path/to/file")
+	buf.Write([]byte{0, 0, 0, 1})   // FileID
+	buf.Write([]byte{0, 0, 48, 57}) // Checksum
+	buf.Write([]byte{0, 0, 0, 2})   // StartBlock
+	buf.Write([]byte{0, 0, 0, 3})   // BlockCount
+	expected := buf.Bytes()
 
 	encoded, err := ftc.Encode(&ft)
 	if err != nil {
@@ -27,10 +39,19 @@ func TestFileTransferCodec_Encode_ShouldReturnBytes_WhenGivenFileTransfer(t *tes
 
 func TestFileTransferCodec_Decode_ShouldReturnFileTransfer_WhenGivenBytes(t *testing.T) {
 	ftc := codec.FileTransferCodec{}
-	data := []byte{0, 0, 0, 15, '/', 'p', 'a', 't', 'h', '/', 't', 'o', '/', 'f', 'i', 'l', 'e'}
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0, 0, 0, 13}) // Length
+	buf.Write([]byte{0, 0, 0, 13}) // FilePath length
+	buf.WriteString("/path/to/file")
+	buf.Write([]byte{0, 0, 0, 0}) // FileID
+	buf.Write([]byte{0, 0, 0, 0}) // Checksum
+	buf.Write([]byte{0, 0, 0, 5}) // StartBlock
+	buf.Write([]byte{0, 0, 0, 0}) // BlockCount
+	data := buf.Bytes()
 	expected := codec.FileTransfer{
-		Length:   15,
-		FilePath: "/path/to/file",
+		Length:     13,
+		FilePath:   "/path/to/file",
+		StartBlock: 5,
 	}
 
 	decoded, err := ftc.Decode(data)
@@ -42,6 +63,16 @@ func TestFileTransferCodec_Decode_ShouldReturnFileTransfer_WhenGivenBytes(t *tes
 	}
 }
 
+func TestFileTransferCodec_Decode_ShouldReturnError_WhenPathLengthExceedsData(t *testing.T) {
+	ftc := codec.FileTransferCodec{}
+	data := []byte{0, 0, 0, 15, '/', 'p', 'a', 't', 'h', '/', 't', 'o', '/', 'f', 'i', 'l', 'e'}
+
+	_, err := ftc.Decode(data)
+	if err == nil {
+		t.Errorf("Decode() error = nil, want error")
+	}
+}
+
 func TestFileTransferAckCodec_Encode_ShouldReturnBytes_WhenGivenFileTransferAck(t *testing.T) {
 	ftac := codec.FileTransferAckCodec{}
 	fta := codec.FileTransferAck{
@@ -105,7 +136,6 @@ func TestTransferCodec_Encode_ShouldReturnBytes_WhenGivenTransfer(t *testing.T)
 	tr := codec.Transfer{
 		FileID: 1,
 		Seq:    2,
-		Block:  []byte("Hello, world!"),
 	}
 	buf := new(bytes.Buffer)
 	buf.WriteByte(0)
@@ -116,8 +146,6 @@ func TestTransferCodec_Encode_ShouldReturnBytes_WhenGivenTransfer(t *testing.T)
 	buf.WriteByte(0)
 	buf.WriteByte(0)
 	buf.WriteByte(2) // Seq
-	binary.Write(buf, binary.BigEndian, uint32(len(tr.Block)))
-	buf.Write(tr.Block)
 	expected := buf.Bytes()
 
 	encoded, err := tc.Encode(&tr)
@@ -140,14 +168,10 @@ func TestTransferCodec_Decode_ShouldReturnTransfer_WhenGivenBytes(t *testing.T)
 	buf.WriteByte(0)
 	buf.WriteByte(0)
 	buf.WriteByte(2) // Seq
-	blockData := []byte("Hello, world!")
-	binary.Write(buf, binary.BigEndian, uint32(len(blockData)))
-	buf.Write(blockData)
 	data := buf.Bytes()
 	expected := codec.Transfer{
 		FileID: 1,
 		Seq:    2,
-		Block:  blockData,
 	}
 
 	decoded, err := tc.Decode(data)
@@ -158,3 +182,24 @@ func TestTransferCodec_Decode_ShouldReturnTransfer_WhenGivenBytes(t *testing.T)
 		t.Errorf("Decode() got = %v, want %v", decoded, &expected)
 	}
 }
+
+func TestTransferAckCodec_ShouldRoundTrip_WhenGivenTransferAck(t *testing.T) {
+	tac := codec.TransferAckCodec{}
+	ta := codec.TransferAck{
+		FileID: 7,
+		Seq:    42,
+	}
+
+	encoded, err := tac.Encode(&ta)
+	if err != nil {
+		t.Errorf("Encode() error = %v, wantErr %v", err, nil)
+	}
+
+	decoded, err := tac.Decode(encoded)
+	if err != nil {
+		t.Errorf("Decode() error = %v, wantErr %v", err, nil)
+	}
+	if !reflect.DeepEqual(decoded, &ta) {
+		t.Errorf("Decode() got = %v, want %v", decoded, &ta)
+	}
+}
@@ -0,0 +1,110 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+type FileUpload struct {
+	FileName string
+	FileLen  uint64
+	Checksum uint32
+	// 上传文件所属的用户和父级目录，写入FileInfo
+	UserId   uint32
+	ParentId uint32
+}
+
+// FileUploadAck 服务端对上传的两次回复：协商时Done为false，全部块落盘并记录后Done为true
+type FileUploadAck struct {
+	FileID    uint32
+	BlockSize uint32
+	ErrorCode uint32
+	Done      bool
+}
+
+// Codec struct for FileUpload
+type FileUploadCodec struct{}
+
+// Codec struct for FileUploadAck
+type FileUploadAckCodec struct{}
+
+// Methods for FileUploadCodec
+func (fuc *FileUploadCodec) Encode(header interface{}) ([]byte, error) {
+	fu, ok := header.(*FileUpload)
+	if !ok {
+		return nil, errors.New("invalid header type for FILEUPLOAD")
+	}
+
+	buf := new(bytes.Buffer)
+
+	if err := encodeString(buf, fu.FileName); err != nil {
+		return nil, err
+	}
+
+	if err := binary.Write(buf, binary.BigEndian, fu.FileLen); err != nil {
+		return nil, err
+	}
+
+	for _, field := range []uint32{fu.Checksum, fu.UserId, fu.ParentId} {
+		if err := binary.Write(buf, binary.BigEndian, field); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (fuc *FileUploadCodec) Decode(data []byte) (interface{}, error) {
+	reader := bytes.NewReader(data)
+
+	fu := &FileUpload{}
+
+	var err error
+	fu.FileName, err = decodeString(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := binary.Read(reader, binary.BigEndian, &fu.FileLen); err != nil {
+		return nil, err
+	}
+
+	for _, field := range []*uint32{&fu.Checksum, &fu.UserId, &fu.ParentId} {
+		if err := binary.Read(reader, binary.BigEndian, field); err != nil {
+			return nil, err
+		}
+	}
+
+	return fu, nil
+}
+
+// Methods for FileUploadAckCodec
+func (fuac *FileUploadAckCodec) Encode(header interface{}) ([]byte, error) {
+	fua, ok := header.(*FileUploadAck)
+	if !ok {
+		return nil, errors.New("invalid header type for FILEUPLOADACK")
+	}
+
+	buf := make([]byte, 13)
+	binary.BigEndian.PutUint32(buf[0:4], fua.FileID)
+	binary.BigEndian.PutUint32(buf[4:8], fua.BlockSize)
+	binary.BigEndian.PutUint32(buf[8:12], fua.ErrorCode)
+	if fua.Done {
+		buf[12] = 1
+	}
+	return buf, nil
+}
+
+func (fuac *FileUploadAckCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) < 13 {
+		return nil, errors.New("data too short for decoding FILEUPLOADACK header")
+	}
+
+	return &FileUploadAck{
+		FileID:    binary.BigEndian.Uint32(data[0:4]),
+		BlockSize: binary.BigEndian.Uint32(data[4:8]),
+		ErrorCode: binary.BigEndian.Uint32(data[8:12]),
+		Done:      data[12] == 1,
+	}, nil
+}
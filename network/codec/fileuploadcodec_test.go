@@ -0,0 +1,63 @@
+package codec_test
+
+import (
+	"go-networking/network/codec"
+	"reflect"
+	"testing"
+)
+
+func TestFileUploadCodec_ShouldRoundTrip_WhenGivenFileUpload(t *testing.T) {
+	fuc := codec.FileUploadCodec{}
+	fu := codec.FileUpload{
+		FileName: "report.pdf",
+		FileLen:  1 << 33,
+		Checksum: 12345,
+		UserId:   7,
+		ParentId: 3,
+	}
+
+	encoded, err := fuc.Encode(&fu)
+	if err != nil {
+		t.Errorf("Encode() error = %v, wantErr %v", err, nil)
+	}
+
+	decoded, err := fuc.Decode(encoded)
+	if err != nil {
+		t.Errorf("Decode() error = %v, wantErr %v", err, nil)
+	}
+	if !reflect.DeepEqual(decoded, &fu) {
+		t.Errorf("Decode() got = %v, want %v", decoded, &fu)
+	}
+}
+
+func TestFileUploadAckCodec_ShouldRoundTrip_WhenGivenFileUploadAck(t *testing.T) {
+	fuac := codec.FileUploadAckCodec{}
+	fua := codec.FileUploadAck{
+		FileID:    1,
+		BlockSize: 1024,
+		ErrorCode: codec.FileTransferChecksumMismatch,
+		Done:      true,
+	}
+
+	encoded, err := fuac.Encode(&fua)
+	if err != nil {
+		t.Errorf("Encode() error = %v, wantErr %v", err, nil)
+	}
+
+	decoded, err := fuac.Decode(encoded)
+	if err != nil {
+		t.Errorf("Decode() error = %v, wantErr %v", err, nil)
+	}
+	if !reflect.DeepEqual(decoded, &fua) {
+		t.Errorf("Decode() got = %v, want %v", decoded, &fua)
+	}
+}
+
+func TestFileUploadAckCodec_Decode_ShouldReturnError_WhenDataTooShort(t *testing.T) {
+	fuac := codec.FileUploadAckCodec{}
+
+	_, err := fuac.Decode([]byte{0, 0, 0, 1})
+	if err == nil {
+		t.Errorf("Decode() error = nil, want error")
+	}
+}
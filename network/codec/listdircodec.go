@@ -3,7 +3,6 @@ package codec
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"io"
 )
@@ -13,18 +12,49 @@ type ListDirHeader struct {
 	Timestamp int64
 }
 
+// ListDirPayload Cursor为空表示从头开始列出，Limit为0时使用服务端的默认分页大小
 type ListDirPayload struct {
 	DirPath string
+	Cursor  string
+	Limit   uint32
 }
 
 type ListDirAckHeader struct {
 	StatusCode uint16
 }
 
+// LISTDIRACK 中的状态码
+const (
+	ListDirOK            uint16 = 200
+	ListDirBadRequest    uint16 = 400
+	ListDirForbidden     uint16 = 403
+	ListDirNotFound      uint16 = 404
+	ListDirCursorExpired uint16 = 410
+	ListDirInternal      uint16 = 500
+	ListDirBusy          uint16 = 503
+)
+
+// FileEntry 目录中的一项，符号链接不会被跟随，Mode中带有os.ModeSymlink
+type FileEntry struct {
+	Name    string
+	Size    int64
+	Mode    uint32
+	ModTime int64
+	IsDir   bool
+}
+
+// ListDirAckPayload NextCursor为空表示目录已经全部列出
 type ListDirAckPayload struct {
-	Files []string
+	Entries    []FileEntry
+	NextCursor string
 }
 
+// Codec struct for ListDirHeader
+type ListDirHeaderCodec struct{}
+
+// Codec struct for ListDirAckHeader
+type ListDirAckHeaderCodec struct{}
+
 func writeLVString(writer io.Writer, data string) error {
 	if err := binary.Write(writer, binary.BigEndian, uint16(len(data))); err != nil {
 		return err
@@ -69,16 +99,34 @@ func (p *ListDirPayload) Encode() ([]byte, error) {
 	if err := writeLVString(buf, p.DirPath); err != nil {
 		return nil, err
 	}
+	if len(p.Cursor) == 0 && p.Limit == 0 {
+		return buf.Bytes(), nil
+	}
+	if err := writeLVString(buf, p.Cursor); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buf, binary.BigEndian, p.Limit); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
+// Decode 只带目录路径的请求表示不分页的第一页，兼容旧的客户端
 func (p *ListDirPayload) Decode(data []byte) error {
-	dirPath, err := readLVString(bytes.NewReader(data))
+	reader := bytes.NewReader(data)
+	dirPath, err := readLVString(reader)
 	if err != nil {
 		return err
 	}
 	p.DirPath = dirPath
-	return nil
+	if reader.Len() == 0 {
+		return nil
+	}
+
+	if p.Cursor, err = readLVString(reader); err != nil {
+		return err
+	}
+	return binary.Read(reader, binary.BigEndian, &p.Limit)
 }
 
 func (h *ListDirAckHeader) Encode() ([]byte, error) {
@@ -96,22 +144,98 @@ func (h *ListDirAckHeader) Decode(data []byte) error {
 	return binary.Read(bytes.NewReader(data), binary.BigEndian, &h.StatusCode)
 }
 
+// Encode 先写入项数，每一项依次为名称、大小、权限、修改时间和是否目录，最后是下一页的游标
 func (p *ListDirAckPayload) Encode() ([]byte, error) {
-	jsonData, err := json.Marshal(p.Files)
-	if err != nil {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.BigEndian, uint32(len(p.Entries))); err != nil {
 		return nil, err
 	}
-	buf := new(bytes.Buffer)
-	if err := writeLVString(buf, string(jsonData)); err != nil {
+	for _, entry := range p.Entries {
+		if err := writeLVString(buf, entry.Name); err != nil {
+			return nil, err
+		}
+		var isDir uint8
+		if entry.IsDir {
+			isDir = 1
+		}
+		for _, field := range []interface{}{entry.Size, entry.Mode, entry.ModTime, isDir} {
+			if err := binary.Write(buf, binary.BigEndian, field); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := writeLVString(buf, p.NextCursor); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
 }
 
 func (p *ListDirAckPayload) Decode(data []byte) error {
-	jsonString, err := readLVString(bytes.NewReader(data))
+	reader := bytes.NewReader(data)
+	var count uint32
+	if err := binary.Read(reader, binary.BigEndian, &count); err != nil {
+		return err
+	}
+	// 每一项至少占用23个字节，防止伪造的项数导致分配过多内存
+	if uint64(count)*23 > uint64(reader.Len()) {
+		return errors.New("entry count exceeds data length")
+	}
+
+	entries := make([]FileEntry, count)
+	for i := range entries {
+		name, err := readLVString(reader)
+		if err != nil {
+			return err
+		}
+		entries[i].Name = name
+		var isDir uint8
+		for _, field := range []interface{}{&entries[i].Size, &entries[i].Mode, &entries[i].ModTime, &isDir} {
+			if err := binary.Read(reader, binary.BigEndian, field); err != nil {
+				return err
+			}
+		}
+		entries[i].IsDir = isDir == 1
+	}
+
+	nextCursor, err := readLVString(reader)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal([]byte(jsonString), &p.Files)
+	p.Entries = entries
+	p.NextCursor = nextCursor
+	return nil
+}
+
+// Methods for ListDirHeaderCodec
+func (c *ListDirHeaderCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*ListDirHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for LISTDIR")
+	}
+	return h.Encode()
+}
+
+func (c *ListDirHeaderCodec) Decode(data []byte) (interface{}, error) {
+	h := &ListDirHeader{}
+	if err := h.Decode(data); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Methods for ListDirAckHeaderCodec
+func (c *ListDirAckHeaderCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*ListDirAckHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for LISTDIRACK")
+	}
+	return h.Encode()
+}
+
+func (c *ListDirAckHeaderCodec) Decode(data []byte) (interface{}, error) {
+	h := &ListDirAckHeader{}
+	if err := h.Decode(data); err != nil {
+		return nil, err
+	}
+	return h, nil
 }
@@ -3,7 +3,6 @@ package codec_test
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/json"
 	"go-networking/network/codec"
 	"io"
 	"testing"
@@ -115,31 +114,66 @@ func TestListDirAckHeader_Decode_ShouldReturnError_WhenGivenInvalidBytes(t *test
 
 func TestListDirAckPayload_Encode_ShouldReturnBytes_WhenGivenValidPayload(t *testing.T) {
 	payload := codec.ListDirAckPayload{
-		Files: []string{"file1.txt", "file2.txt"},
+		Entries: []codec.FileEntry{
+			{Name: "file1.txt", Size: 10},
+			{Name: "file2.txt", Size: 20},
+		},
 	}
 	encoded, err := payload.Encode()
 	assert.NoError(t, err)
 
-	// Check if the first two bytes represent the length correctly
-	var length uint16
-	err = binary.Read(bytes.NewReader(encoded), binary.BigEndian, &length)
-	assert.NoError(t, err)
-	assert.Equal(t, uint16(len(encoded[2:])), length)
+	// Check if the first four bytes represent the entry count correctly
+	assert.Equal(t, uint32(2), binary.BigEndian.Uint32(encoded))
 }
 
 func TestListDirAckPayload_Decode_ShouldReturnPayload_WhenGivenValidBytes(t *testing.T) {
-	files := []string{"file1.txt", "file2.txt"}
-	jsonData, err := json.Marshal(files)
+	expected := codec.ListDirAckPayload{
+		Entries: []codec.FileEntry{
+			{Name: "file1.txt", Size: 10, Mode: 0644, ModTime: time.Now().Unix()},
+			{Name: "docs", Size: 4096, Mode: uint32(0755), ModTime: time.Now().Unix(), IsDir: true},
+		},
+		NextCursor: "cursor",
+	}
+	data, err := expected.Encode()
 	require.NoError(t, err)
 
-	buf := new(bytes.Buffer)
-	WriteLVString(buf, string(jsonData))
-	data := buf.Bytes()
+	payload := codec.ListDirAckPayload{}
+	err = payload.Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, payload)
+}
+
+func TestListDirAckPayload_Decode_ShouldReturnError_WhenEntryCountExceedsData(t *testing.T) {
+	data := []byte{0xff, 0xff, 0xff, 0xff, 0, 0}
 
 	payload := codec.ListDirAckPayload{}
+	err := payload.Decode(data)
+	assert.Error(t, err)
+}
+
+func TestListDirPayload_Decode_ShouldReturnCursor_WhenPayloadHasCursor(t *testing.T) {
+	expected := codec.ListDirPayload{DirPath: "/docs", Cursor: "abc", Limit: 100}
+	data, err := expected.Encode()
+	require.NoError(t, err)
+
+	payload := codec.ListDirPayload{}
 	err = payload.Decode(data)
 	assert.NoError(t, err)
-	assert.Equal(t, files, payload.Files)
+	assert.Equal(t, expected, payload)
+}
+
+func TestListDirHeaderCodec_ShouldRoundTrip_WhenGivenListDirHeader(t *testing.T) {
+	hc := codec.ListDirHeaderCodec{}
+	header := &codec.ListDirHeader{
+		Id:        "0123456789ABCDEF0123456789ABCDEF",
+		Timestamp: time.Now().Unix(),
+	}
+	encoded, err := hc.Encode(header)
+	require.NoError(t, err)
+
+	decoded, err := hc.Decode(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, header, decoded)
 }
 
 func TestListDirAckPayload_Decode_ShouldReturnError_WhenGivenInvalidBytes(t *testing.T) {
@@ -0,0 +1,17 @@
+package codec
+
+// NotifyHeader 服务端主动推送给客户端的通知，Payload由事件自行定义。
+// NOTIFY的Header由StructCodec按wire标签编解码。
+type NotifyHeader struct {
+	Event uint16 `wire:"fixed"`
+	// 事件的对象，例如发生变化的文件路径
+	Subject string `wire:"lv16"`
+}
+
+// NOTIFY 中的事件
+const (
+	// 服务端的文件发生了变化
+	NotifyFileChanged uint16 = 1
+	// 服务端即将停止，客户端应当尽快结束传输并断开
+	NotifyShutdown uint16 = 2
+)
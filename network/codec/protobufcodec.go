@@ -0,0 +1,573 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 以protobuf编码的Header编解码器，消息定义见proto/frame.proto。
+// 为零的字段不写出，解码时忽略未知字段，便于其他语言的客户端直接使用生成的代码。
+
+// PBEncoder 按字段号追加protobuf字段，值为零的字段省略，与proto3的默认行为一致
+type PBEncoder struct {
+	buf []byte
+}
+
+func (e *PBEncoder) Bytes() []byte {
+	return e.buf
+}
+
+func (e *PBEncoder) WriteUint(num protowire.Number, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.buf = protowire.AppendTag(e.buf, num, protowire.VarintType)
+	e.buf = protowire.AppendVarint(e.buf, v)
+}
+
+func (e *PBEncoder) WriteInt(num protowire.Number, v int64) {
+	e.WriteUint(num, uint64(v))
+}
+
+func (e *PBEncoder) WriteBool(num protowire.Number, v bool) {
+	if v {
+		e.WriteUint(num, 1)
+	}
+}
+
+func (e *PBEncoder) WriteBytes(num protowire.Number, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	e.buf = protowire.AppendTag(e.buf, num, protowire.BytesType)
+	e.buf = protowire.AppendBytes(e.buf, v)
+}
+
+func (e *PBEncoder) WriteString(num protowire.Number, v string) {
+	if v == "" {
+		return
+	}
+	e.buf = protowire.AppendTag(e.buf, num, protowire.BytesType)
+	e.buf = protowire.AppendString(e.buf, v)
+}
+
+// PBMessage 解码后的protobuf消息，重复出现的字段以最后一次为准，repeated字段由ReadRepeatedBytes读取。
+// 读取字段时值超出Go类型的范围会记录错误，由Err返回。
+type PBMessage struct {
+	varints  map[protowire.Number]uint64
+	bytes    map[protowire.Number][]byte
+	repeated map[protowire.Number][][]byte
+	err      error
+}
+
+// ParsePBMessage 解析protobuf消息，返回的bytes字段引用data，不会复制
+func ParsePBMessage(data []byte) (*PBMessage, error) {
+	m := &PBMessage{
+		varints:  make(map[protowire.Number]uint64),
+		bytes:    make(map[protowire.Number][]byte),
+		repeated: make(map[protowire.Number][][]byte),
+	}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(data)
+			m.varints[num] = v
+		case protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(data)
+			m.bytes[num] = v
+			m.repeated[num] = append(m.repeated[num], v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return m, nil
+}
+
+func (m *PBMessage) Err() error {
+	return m.err
+}
+
+func (m *PBMessage) ReadUint64(num protowire.Number) uint64 {
+	return m.varints[num]
+}
+
+func (m *PBMessage) ReadInt64(num protowire.Number) int64 {
+	return int64(m.varints[num])
+}
+
+func (m *PBMessage) ReadUint32(num protowire.Number) uint32 {
+	return uint32(m.readBounded(num, math.MaxUint32))
+}
+
+func (m *PBMessage) ReadUint16(num protowire.Number) uint16 {
+	return uint16(m.readBounded(num, math.MaxUint16))
+}
+
+func (m *PBMessage) ReadUint8(num protowire.Number) uint8 {
+	return uint8(m.readBounded(num, math.MaxUint8))
+}
+
+func (m *PBMessage) ReadBool(num protowire.Number) bool {
+	return m.varints[num] != 0
+}
+
+func (m *PBMessage) ReadBytes(num protowire.Number) []byte {
+	return m.bytes[num]
+}
+
+func (m *PBMessage) ReadString(num protowire.Number) string {
+	return string(m.bytes[num])
+}
+
+// ReadRepeatedBytes 按出现的顺序返回repeated的bytes、string或消息字段
+func (m *PBMessage) ReadRepeatedBytes(num protowire.Number) [][]byte {
+	return m.repeated[num]
+}
+
+func (m *PBMessage) readBounded(num protowire.Number, max uint64) uint64 {
+	v := m.varints[num]
+	if v > max && m.err == nil {
+		m.err = fmt.Errorf("protobuf field %d out of range: %d > %d", num, v, max)
+	}
+	return v
+}
+
+type ConnHeaderPBCodec struct{}
+
+func (codec *ConnHeaderPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*ConnHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for CONN")
+	}
+	e := &PBEncoder{}
+	e.WriteInt(1, h.Timestamp)
+	e.WriteUint(2, uint64(h.Group))
+	e.WriteBytes(3, h.Compressions)
+	return e.Bytes(), nil
+}
+
+func (codec *ConnHeaderPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	h := &ConnHeader{
+		Timestamp:    m.ReadInt64(1),
+		Group:        m.ReadUint8(2),
+		Compressions: m.ReadBytes(3),
+	}
+	return h, m.Err()
+}
+
+type ConnAckHeaderPBCodec struct{}
+
+func (codec *ConnAckHeaderPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*ConnAckHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for CONNACK")
+	}
+	e := &PBEncoder{}
+	e.WriteString(1, h.Id)
+	e.WriteInt(2, h.Timestamp)
+	e.WriteUint(3, uint64(h.Group))
+	e.WriteBytes(4, h.IdentityKey)
+	e.WriteBytes(5, h.Signature)
+	e.WriteString(6, h.Ticket)
+	e.WriteUint(7, uint64(h.Compression))
+	return e.Bytes(), nil
+}
+
+func (codec *ConnAckHeaderPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	h := &ConnAckHeader{
+		Id:          m.ReadString(1),
+		Timestamp:   m.ReadInt64(2),
+		Group:       m.ReadUint8(3),
+		IdentityKey: m.ReadBytes(4),
+		Signature:   m.ReadBytes(5),
+		Ticket:      m.ReadString(6),
+		Compression: m.ReadUint8(7),
+	}
+	return h, m.Err()
+}
+
+type PingHeaderPBCodec struct{}
+
+func (codec *PingHeaderPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*PingHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for PING")
+	}
+	e := &PBEncoder{}
+	e.WriteInt(1, h.Timestamp)
+	e.WriteString(2, h.Id)
+	return e.Bytes(), nil
+}
+
+func (codec *PingHeaderPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	return &PingHeader{Timestamp: m.ReadInt64(1), Id: m.ReadString(2)}, nil
+}
+
+type PongHeaderPBCodec struct{}
+
+func (codec *PongHeaderPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*PongHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for PONG")
+	}
+	e := &PBEncoder{}
+	e.WriteInt(1, h.Timestamp)
+	return e.Bytes(), nil
+}
+
+func (codec *PongHeaderPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	return &PongHeader{Timestamp: m.ReadInt64(1)}, nil
+}
+
+type CloseHeaderPBCodec struct{}
+
+func (codec *CloseHeaderPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*CloseHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for CLOSE")
+	}
+	e := &PBEncoder{}
+	e.WriteString(1, h.Id)
+	e.WriteString(2, h.Reason)
+	return e.Bytes(), nil
+}
+
+func (codec *CloseHeaderPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	return &CloseHeader{Id: m.ReadString(1), Reason: m.ReadString(2)}, nil
+}
+
+type CloseAckHeaderPBCodec struct{}
+
+func (codec *CloseAckHeaderPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*CloseAckHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for CLOSEACK")
+	}
+	e := &PBEncoder{}
+	e.WriteUint(1, uint64(h.StatusCode))
+	e.WriteString(2, h.Details)
+	return e.Bytes(), nil
+}
+
+func (codec *CloseAckHeaderPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	h := &CloseAckHeader{StatusCode: m.ReadUint16(1), Details: m.ReadString(2)}
+	return h, m.Err()
+}
+
+type ListDirHeaderPBCodec struct{}
+
+func (codec *ListDirHeaderPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*ListDirHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for LISTDIR")
+	}
+	e := &PBEncoder{}
+	e.WriteString(1, h.Id)
+	e.WriteInt(2, h.Timestamp)
+	return e.Bytes(), nil
+}
+
+func (codec *ListDirHeaderPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	return &ListDirHeader{Id: m.ReadString(1), Timestamp: m.ReadInt64(2)}, nil
+}
+
+type ListDirAckHeaderPBCodec struct{}
+
+func (codec *ListDirAckHeaderPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*ListDirAckHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for LISTDIRACK")
+	}
+	e := &PBEncoder{}
+	e.WriteUint(1, uint64(h.StatusCode))
+	return e.Bytes(), nil
+}
+
+func (codec *ListDirAckHeaderPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	h := &ListDirAckHeader{StatusCode: m.ReadUint16(1)}
+	return h, m.Err()
+}
+
+// EncodeProtobuf 按proto/frame.proto中的ListDirPayload编码，用于VERSION_PROTOBUF的帧
+func (p *ListDirPayload) EncodeProtobuf() ([]byte, error) {
+	e := &PBEncoder{}
+	e.WriteString(1, p.DirPath)
+	e.WriteString(2, p.Cursor)
+	e.WriteUint(3, uint64(p.Limit))
+	return e.Bytes(), nil
+}
+
+func (p *ListDirPayload) DecodeProtobuf(data []byte) error {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return err
+	}
+	p.DirPath = m.ReadString(1)
+	p.Cursor = m.ReadString(2)
+	p.Limit = m.ReadUint32(3)
+	return m.Err()
+}
+
+// EncodeProtobuf 按proto/frame.proto中的ListDirAckPayload编码，每一项为一个ListDirEntry消息
+func (p *ListDirAckPayload) EncodeProtobuf() ([]byte, error) {
+	e := &PBEncoder{}
+	for _, entry := range p.Entries {
+		entryEncoder := &PBEncoder{}
+		entryEncoder.WriteString(1, entry.Name)
+		entryEncoder.WriteInt(2, entry.Size)
+		entryEncoder.WriteUint(3, uint64(entry.Mode))
+		entryEncoder.WriteInt(4, entry.ModTime)
+		entryEncoder.WriteBool(5, entry.IsDir)
+		// 所有字段都为零的项同样需要写出
+		e.buf = protowire.AppendTag(e.buf, 1, protowire.BytesType)
+		e.buf = protowire.AppendBytes(e.buf, entryEncoder.Bytes())
+	}
+	e.WriteString(2, p.NextCursor)
+	return e.Bytes(), nil
+}
+
+func (p *ListDirAckPayload) DecodeProtobuf(data []byte) error {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return err
+	}
+	encodedEntries := m.ReadRepeatedBytes(1)
+	entries := make([]FileEntry, len(encodedEntries))
+	for i, encoded := range encodedEntries {
+		entryMessage, err := ParsePBMessage(encoded)
+		if err != nil {
+			return err
+		}
+		entries[i] = FileEntry{
+			Name:    entryMessage.ReadString(1),
+			Size:    entryMessage.ReadInt64(2),
+			Mode:    entryMessage.ReadUint32(3),
+			ModTime: entryMessage.ReadInt64(4),
+			IsDir:   entryMessage.ReadBool(5),
+		}
+		if err := entryMessage.Err(); err != nil {
+			return err
+		}
+	}
+	p.Entries = entries
+	p.NextCursor = m.ReadString(2)
+	return nil
+}
+
+type FileTransferPBCodec struct{}
+
+func (codec *FileTransferPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*FileTransfer)
+	if !ok {
+		return nil, errors.New("invalid header type for FILETRANSFER")
+	}
+	e := &PBEncoder{}
+	e.WriteUint(1, uint64(h.Length))
+	e.WriteString(2, h.FilePath)
+	e.WriteUint(3, uint64(h.FileID))
+	e.WriteUint(4, uint64(h.Checksum))
+	e.WriteUint(5, uint64(h.StartBlock))
+	e.WriteUint(6, uint64(h.BlockCount))
+	return e.Bytes(), nil
+}
+
+func (codec *FileTransferPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	h := &FileTransfer{
+		Length:     m.ReadUint32(1),
+		FilePath:   m.ReadString(2),
+		FileID:     m.ReadUint32(3),
+		Checksum:   m.ReadUint32(4),
+		StartBlock: m.ReadUint32(5),
+		BlockCount: m.ReadUint32(6),
+	}
+	return h, m.Err()
+}
+
+type FileTransferAckPBCodec struct{}
+
+func (codec *FileTransferAckPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*FileTransferAck)
+	if !ok {
+		return nil, errors.New("invalid header type for FILETRANSFERACK")
+	}
+	e := &PBEncoder{}
+	e.WriteUint(1, uint64(h.FileID))
+	e.WriteUint(2, h.FileLen)
+	e.WriteUint(3, uint64(h.Checksum))
+	e.WriteUint(4, uint64(h.BlockSize))
+	e.WriteUint(5, uint64(h.ErrorCode))
+	return e.Bytes(), nil
+}
+
+func (codec *FileTransferAckPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	h := &FileTransferAck{
+		FileID:    m.ReadUint32(1),
+		FileLen:   m.ReadUint64(2),
+		Checksum:  m.ReadUint32(3),
+		BlockSize: m.ReadUint32(4),
+		ErrorCode: m.ReadUint32(5),
+	}
+	return h, m.Err()
+}
+
+type TransferPBCodec struct{}
+
+func (codec *TransferPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*Transfer)
+	if !ok {
+		return nil, errors.New("invalid header type for TRANSFER")
+	}
+	e := &PBEncoder{}
+	e.WriteUint(1, uint64(h.FileID))
+	e.WriteUint(2, uint64(h.Seq))
+	return e.Bytes(), nil
+}
+
+func (codec *TransferPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	h := &Transfer{
+		FileID: m.ReadUint32(1),
+		Seq:    m.ReadUint32(2),
+	}
+	return h, m.Err()
+}
+
+type TransferAckPBCodec struct{}
+
+func (codec *TransferAckPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*TransferAck)
+	if !ok {
+		return nil, errors.New("invalid header type for TRANSFERACK")
+	}
+	e := &PBEncoder{}
+	e.WriteUint(1, uint64(h.FileID))
+	e.WriteUint(2, uint64(h.Seq))
+	return e.Bytes(), nil
+}
+
+func (codec *TransferAckPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	h := &TransferAck{FileID: m.ReadUint32(1), Seq: m.ReadUint32(2)}
+	return h, m.Err()
+}
+
+type FileUploadPBCodec struct{}
+
+func (codec *FileUploadPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*FileUpload)
+	if !ok {
+		return nil, errors.New("invalid header type for FILEUPLOAD")
+	}
+	e := &PBEncoder{}
+	e.WriteString(1, h.FileName)
+	e.WriteUint(2, h.FileLen)
+	e.WriteUint(3, uint64(h.Checksum))
+	e.WriteUint(4, uint64(h.UserId))
+	e.WriteUint(5, uint64(h.ParentId))
+	return e.Bytes(), nil
+}
+
+func (codec *FileUploadPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	h := &FileUpload{
+		FileName: m.ReadString(1),
+		FileLen:  m.ReadUint64(2),
+		Checksum: m.ReadUint32(3),
+		UserId:   m.ReadUint32(4),
+		ParentId: m.ReadUint32(5),
+	}
+	return h, m.Err()
+}
+
+type FileUploadAckPBCodec struct{}
+
+func (codec *FileUploadAckPBCodec) Encode(header interface{}) ([]byte, error) {
+	h, ok := header.(*FileUploadAck)
+	if !ok {
+		return nil, errors.New("invalid header type for FILEUPLOADACK")
+	}
+	e := &PBEncoder{}
+	e.WriteUint(1, uint64(h.FileID))
+	e.WriteUint(2, uint64(h.BlockSize))
+	e.WriteUint(3, uint64(h.ErrorCode))
+	e.WriteBool(4, h.Done)
+	return e.Bytes(), nil
+}
+
+func (codec *FileUploadAckPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	h := &FileUploadAck{
+		FileID:    m.ReadUint32(1),
+		BlockSize: m.ReadUint32(2),
+		ErrorCode: m.ReadUint32(3),
+		Done:      m.ReadBool(4),
+	}
+	return h, m.Err()
+}
@@ -0,0 +1,109 @@
+package codec_test
+
+import (
+	"go-networking/network/codec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestProtobufHeaderCodecShouldIgnoreUnknownFields(t *testing.T) {
+	data, err := (&codec.CloseHeaderPBCodec{}).Encode(&codec.CloseHeader{Id: "id", Reason: "bye"})
+	require.NoError(t, err)
+	// 新版本客户端可能携带当前版本不认识的字段
+	data = protowire.AppendTag(data, 15, protowire.Fixed32Type)
+	data = protowire.AppendFixed32(data, 7)
+
+	decoded, err := (&codec.CloseHeaderPBCodec{}).Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, &codec.CloseHeader{Id: "id", Reason: "bye"}, decoded)
+}
+
+func TestProtobufHeaderCodecShouldReturnErrorWhenValueOutOfRange(t *testing.T) {
+	e := &codec.PBEncoder{}
+	e.WriteUint(1, 70000)
+	_, err := (&codec.CloseAckHeaderPBCodec{}).Decode(e.Bytes())
+	assert.Error(t, err)
+
+	_, err = (&codec.CloseAckHeaderPBCodec{}).Decode([]byte{0x08})
+	assert.Error(t, err)
+}
+
+func TestListDirPayloadsShouldRoundTripAsProtobufMessages(t *testing.T) {
+	req := &codec.ListDirPayload{DirPath: "/docs", Cursor: "cursor", Limit: 50}
+	data, err := req.EncodeProtobuf()
+	require.NoError(t, err)
+	decodedReq := &codec.ListDirPayload{}
+	require.NoError(t, decodedReq.DecodeProtobuf(data))
+	assert.Equal(t, req, decodedReq)
+
+	// 所有字段都为零的项也要保留
+	ack := &codec.ListDirAckPayload{
+		Entries: []codec.FileEntry{
+			{Name: "a.txt", Size: 3, Mode: 0644, ModTime: 1700000000},
+			{},
+			{Name: "sub", Mode: 0755 | 1<<31, ModTime: 1700000001, IsDir: true},
+		},
+		NextCursor: "next",
+	}
+	data, err = ack.EncodeProtobuf()
+	require.NoError(t, err)
+	decodedAck := &codec.ListDirAckPayload{}
+	require.NoError(t, decodedAck.DecodeProtobuf(data))
+	assert.Equal(t, ack, decodedAck)
+
+	// 每一项是字段1中的一个ListDirEntry消息
+	m, err := codec.ParsePBMessage(data)
+	require.NoError(t, err)
+	require.Len(t, m.ReadRepeatedBytes(1), 3)
+	entry, err := codec.ParsePBMessage(m.ReadRepeatedBytes(1)[0])
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt", entry.ReadString(1))
+	assert.Equal(t, "next", m.ReadString(2))
+}
+
+func TestStructPBCodecShouldFollowProtoFieldOrderWhenDerivedFromWireTags(t *testing.T) {
+	headerCodec := codec.MustStructPBCodec(&codec.ResumeAckHeader{})
+	header := &codec.ResumeAckHeader{StatusCode: codec.ResumeOK, Id: "id", Timestamp: -1, SealedTicket: []byte("next"), Compression: 3}
+	data, err := headerCodec.Encode(header)
+	require.NoError(t, err)
+
+	// 与proto/frame.proto中的ResumeAckHeader一致
+	e := &codec.PBEncoder{}
+	e.WriteUint(1, uint64(codec.ResumeOK))
+	e.WriteString(2, "id")
+	e.WriteInt(3, -1)
+	e.WriteBytes(4, []byte("next"))
+	e.WriteUint(5, 3)
+	assert.Equal(t, e.Bytes(), data)
+
+	decoded, err := headerCodec.Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, header, decoded)
+}
+
+func TestStructPBCodecShouldRoundTripWhenFieldsTagged(t *testing.T) {
+	headerCodec := codec.MustStructPBCodec(&taggedHeader{})
+	header := &taggedHeader{Small: -3, Status: 404, Offset: -1 << 40, Count: 7, Done: true, Name: "name", Key: []byte{1, 2}, Rest: []byte("rest")}
+	data, err := headerCodec.Encode(header)
+	require.NoError(t, err)
+	decoded, err := headerCodec.Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, header, decoded)
+
+	// 零值的字段不写出，解码为零值
+	data, err = headerCodec.Encode(&taggedHeader{})
+	require.NoError(t, err)
+	assert.Empty(t, data)
+	decoded, err = headerCodec.Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, &taggedHeader{}, decoded)
+
+	// 超出字段类型范围的值被拒绝
+	e := &codec.PBEncoder{}
+	e.WriteUint(2, 70000)
+	_, err = headerCodec.Decode(e.Bytes())
+	assert.Error(t, err)
+}
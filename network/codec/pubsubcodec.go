@@ -0,0 +1,29 @@
+package codec
+
+// SubscribeHeader 客户端订阅或取消订阅一个主题，没有Payload。
+// SUBSCRIBE、UNSUBSCRIBE、SUBSCRIBEACK和PUBLISH的Header由StructCodec按wire标签编解码。
+type SubscribeHeader struct {
+	// CONNACK分配的连接ID
+	Id    string `wire:"lv16"`
+	Topic string `wire:"lv16"`
+}
+
+// SubscribeAckHeader 服务端对SUBSCRIBE和UNSUBSCRIBE的应答
+type SubscribeAckHeader struct {
+	StatusCode uint16 `wire:"fixed"`
+	Topic      string `wire:"lv16"`
+}
+
+// SUBSCRIBEACK 中的状态码
+const (
+	SubscribeOK uint16 = 200
+	// 主题为空
+	SubscribeBadRequest uint16 = 400
+	// 连接ID不属于当前连接
+	SubscribeIdMismatch uint16 = 403
+)
+
+// PublishHeader 服务端发给订阅者的消息，Payload为发布的内容
+type PublishHeader struct {
+	Topic string `wire:"lv16"`
+}
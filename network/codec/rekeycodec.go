@@ -0,0 +1,29 @@
+package codec
+
+// RekeyHeader 客户端在已加密的连接上发起密钥轮换，Payload为新的临时公钥。
+// REKEY和REKEYACK的Header由StructCodec按wire标签编解码。
+type RekeyHeader struct {
+	// CONNACK分配的连接ID
+	Id        string `wire:"lv16"`
+	Timestamp int64  `wire:"fixed"`
+	// 新的临时公钥所在的群，与CONN协商的群相同
+	Group uint8 `wire:"fixed"`
+}
+
+// RekeyAckHeader 服务端的应答，成功时Payload为服务端新的临时公钥
+type RekeyAckHeader struct {
+	StatusCode uint16 `wire:"fixed"`
+	Timestamp  int64  `wire:"fixed"`
+}
+
+// REKEYACK 中的状态码
+const (
+	RekeyOK uint16 = 200
+	// 公钥不合法或群不被允许
+	RekeyBadRequest uint16 = 400
+	// 连接ID不属于当前连接
+	RekeyIdMismatch uint16 = 403
+	// 连接尚未完成CONN握手
+	RekeyNotConnected uint16 = 409
+	RekeyInternal     uint16 = 500
+)
@@ -0,0 +1,35 @@
+package codec
+
+// ResumeHeader 客户端在新的TCP连接上出示票据，Payload为客户端随机数。
+// Binder证明客户端持有恢复秘密，见dh.ResumeBinder，校验通过前票据不会被使用。
+// RESUME和RESUMEACK的Header由StructCodec按wire标签编解码。
+type ResumeHeader struct {
+	Ticket    string `wire:"lv16"`
+	Timestamp int64  `wire:"fixed"`
+	// 客户端支持的压缩算法，在新的TCP连接上重新协商
+	Compressions []byte `wire:"lv16,optional"`
+	// 以恢复秘密计算的客户端随机数和票据的MAC
+	Binder []byte `wire:"lv16,optional"`
+}
+
+// ResumeAckHeader 服务端的应答，成功时Payload为服务端随机数
+type ResumeAckHeader struct {
+	StatusCode uint16 `wire:"fixed"`
+	// 恢复的连接ID
+	Id        string `wire:"lv16"`
+	Timestamp int64  `wire:"fixed"`
+	// 新的票据，使用恢复后服务端的发送密钥加密，见network.SealTicket。旧票据使用后失效
+	SealedTicket []byte `wire:"lv16"`
+	// 服务端选择的压缩算法，0表示不压缩
+	Compression uint8 `wire:"fixed,optional"`
+}
+
+// RESUMEACK 中的状态码
+const (
+	ResumeOK uint16 = 200
+	// 随机数长度不合法
+	ResumeBadRequest uint16 = 400
+	// 票据不存在、已经使用、已经过期或者Binder校验失败，客户端需要重新进行CONN
+	ResumeTicketRejected uint16 = 401
+	ResumeInternal       uint16 = 500
+)
@@ -0,0 +1,322 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// StructCodec 根据结构体字段的wire标签编解码Header，新增命令时只需定义结构体并注册：
+//
+//	type EchoHeader struct {
+//		Id        string `wire:"lv16"`
+//		Timestamp int64  `wire:"fixed"`
+//		Flags     uint32 `wire:"varint,optional"`
+//	}
+//	network.AddStructHeaderCodec(ECHO, &EchoHeader{})
+//
+// AddStructHeaderCodec同时注册由相同标签推导的protobuf编解码器StructPBCodec。
+//
+// 标签取值：
+//   - varint：整数和bool，无符号整数为uvarint，有符号整数为zigzag varint
+//   - fixed：定长整数和bool，按字段类型的大小以大端序写出，不支持int和uint
+//   - lv16、lv32：string或[]byte，前缀为uint16或uint32大端长度
+//   - rest：string或[]byte，占用剩余的全部字节，只能是最后一个字段
+//   - -：不编码该字段
+//
+// 追加optional表示数据在该字段之前结束时保留零值，用于兼容不携带新字段的旧版本，
+// 之后的字段也必须是optional，编码时省略末尾取零值的optional字段。字段按定义顺序编码，
+// 解码时忽略末尾多余的字节。
+type StructCodec struct {
+	typ    reflect.Type
+	fields []structField
+}
+
+type structField struct {
+	index    int
+	name     string
+	encoding string
+	optional bool
+}
+
+const (
+	wireVarint = "varint"
+	wireFixed  = "fixed"
+	wireLV16   = "lv16"
+	wireLV32   = "lv32"
+	wireRest   = "rest"
+)
+
+// NewStructCodec 解析header的结构体类型，header为结构体或结构体指针，标签不合法时返回错误。
+// Decode返回结构体指针，Encode同时接受结构体和结构体指针。
+func NewStructCodec(header interface{}) (*StructCodec, error) {
+	typ := reflect.TypeOf(header)
+	if typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("struct codec requires a struct, got %T", header)
+	}
+
+	codec := &StructCodec{typ: typ}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, exists := field.Tag.Lookup("wire")
+		if !exists {
+			return nil, fmt.Errorf("%s.%s has no wire tag", typ.Name(), field.Name)
+		}
+		if tag == "-" {
+			continue
+		}
+
+		encoding, option, _ := strings.Cut(tag, ",")
+		sf := structField{index: i, name: field.Name, encoding: encoding}
+		switch option {
+		case "":
+		case "optional":
+			sf.optional = true
+		default:
+			return nil, fmt.Errorf("%s.%s has unknown wire option %q", typ.Name(), field.Name, option)
+		}
+		if err := checkWireKind(field.Type, encoding); err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", typ.Name(), field.Name, err)
+		}
+		if n := len(codec.fields); n > 0 {
+			prev := codec.fields[n-1]
+			if prev.encoding == wireRest {
+				return nil, fmt.Errorf("%s.%s follows a rest field", typ.Name(), field.Name)
+			}
+			if prev.optional && !sf.optional {
+				return nil, fmt.Errorf("%s.%s must be optional because it follows an optional field", typ.Name(), field.Name)
+			}
+		}
+		codec.fields = append(codec.fields, sf)
+	}
+	return codec, nil
+}
+
+// MustStructCodec 与NewStructCodec相同，标签不合法时panic，用于init中注册
+func MustStructCodec(header interface{}) *StructCodec {
+	codec, err := NewStructCodec(header)
+	if err != nil {
+		panic(err)
+	}
+	return codec
+}
+
+func checkWireKind(typ reflect.Type, encoding string) error {
+	kind := typ.Kind()
+	switch encoding {
+	case wireVarint:
+		if kind == reflect.Bool || isIntKind(kind) || isUintKind(kind) {
+			return nil
+		}
+	case wireFixed:
+		if kind == reflect.Int || kind == reflect.Uint || kind == reflect.Uintptr {
+			return fmt.Errorf("fixed encoding requires a sized integer, got %s", typ)
+		}
+		if kind == reflect.Bool || isIntKind(kind) || isUintKind(kind) {
+			return nil
+		}
+	case wireLV16, wireLV32, wireRest:
+		if kind == reflect.String || (kind == reflect.Slice && typ.Elem().Kind() == reflect.Uint8) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unknown wire encoding %q", encoding)
+	}
+	return fmt.Errorf("%s encoding does not support %s", encoding, typ)
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Int64
+}
+
+func isUintKind(kind reflect.Kind) bool {
+	return kind >= reflect.Uint && kind <= reflect.Uintptr
+}
+
+func (codec *StructCodec) Encode(header interface{}) ([]byte, error) {
+	value := reflect.ValueOf(header)
+	if value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+	if !value.IsValid() || value.Type() != codec.typ {
+		return nil, fmt.Errorf("invalid header type %T, want *%s", header, codec.typ.Name())
+	}
+
+	buf := make([]byte, 0, 32)
+	// 最后一个需要写出的字段的结束位置
+	end := 0
+	for _, f := range codec.fields {
+		fv := value.Field(f.index)
+		switch f.encoding {
+		case wireVarint:
+			switch {
+			case fv.Kind() == reflect.Bool:
+				buf = binary.AppendUvarint(buf, boolToUint(fv.Bool()))
+			case isIntKind(fv.Kind()):
+				buf = binary.AppendVarint(buf, fv.Int())
+			default:
+				buf = binary.AppendUvarint(buf, fv.Uint())
+			}
+		case wireFixed:
+			var u uint64
+			switch {
+			case fv.Kind() == reflect.Bool:
+				u = boolToUint(fv.Bool())
+			case isIntKind(fv.Kind()):
+				u = uint64(fv.Int())
+			default:
+				u = fv.Uint()
+			}
+			for shift := 8 * (int(fv.Type().Size()) - 1); shift >= 0; shift -= 8 {
+				buf = append(buf, byte(u>>shift))
+			}
+		case wireLV16:
+			data := bytesOf(fv)
+			if len(data) > math.MaxUint16 {
+				return nil, fmt.Errorf("%s.%s too long for lv16: %d", codec.typ.Name(), f.name, len(data))
+			}
+			buf = binary.BigEndian.AppendUint16(buf, uint16(len(data)))
+			buf = append(buf, data...)
+		case wireLV32:
+			data := bytesOf(fv)
+			if uint64(len(data)) > math.MaxUint32 {
+				return nil, fmt.Errorf("%s.%s too long for lv32: %d", codec.typ.Name(), f.name, len(data))
+			}
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+			buf = append(buf, data...)
+		case wireRest:
+			buf = append(buf, bytesOf(fv)...)
+		}
+		if !f.optional || !isZeroField(fv) {
+			end = len(buf)
+		}
+	}
+	return buf[:end], nil
+}
+
+func (codec *StructCodec) Decode(data []byte) (interface{}, error) {
+	header := reflect.New(codec.typ)
+	value := header.Elem()
+	for _, f := range codec.fields {
+		if len(data) == 0 && f.optional {
+			break
+		}
+		fv := value.Field(f.index)
+
+		switch f.encoding {
+		case wireVarint:
+			var n int
+			switch {
+			case fv.Kind() == reflect.Bool:
+				var u uint64
+				u, n = binary.Uvarint(data)
+				fv.SetBool(u != 0)
+			case isIntKind(fv.Kind()):
+				var i int64
+				i, n = binary.Varint(data)
+				if n > 0 && fv.OverflowInt(i) {
+					return nil, fmt.Errorf("%s.%s overflows %s", codec.typ.Name(), f.name, fv.Type())
+				}
+				fv.SetInt(i)
+			default:
+				var u uint64
+				u, n = binary.Uvarint(data)
+				if n > 0 && fv.OverflowUint(u) {
+					return nil, fmt.Errorf("%s.%s overflows %s", codec.typ.Name(), f.name, fv.Type())
+				}
+				fv.SetUint(u)
+			}
+			if n <= 0 {
+				return nil, codec.tooShort(f)
+			}
+			data = data[n:]
+		case wireFixed:
+			size := int(fv.Type().Size())
+			if len(data) < size {
+				return nil, codec.tooShort(f)
+			}
+			var u uint64
+			for _, b := range data[:size] {
+				u = u<<8 | uint64(b)
+			}
+			data = data[size:]
+			switch {
+			case fv.Kind() == reflect.Bool:
+				fv.SetBool(u != 0)
+			case isIntKind(fv.Kind()):
+				// 按字段大小做符号扩展
+				shift := 64 - 8*size
+				fv.SetInt(int64(u<<shift) >> shift)
+			default:
+				fv.SetUint(u)
+			}
+		case wireLV16, wireLV32:
+			prefix := 2
+			if f.encoding == wireLV32 {
+				prefix = 4
+			}
+			if len(data) < prefix {
+				return nil, codec.tooShort(f)
+			}
+			var length uint64
+			if prefix == 2 {
+				length = uint64(binary.BigEndian.Uint16(data))
+			} else {
+				length = uint64(binary.BigEndian.Uint32(data))
+			}
+			data = data[prefix:]
+			if uint64(len(data)) < length {
+				return nil, codec.tooShort(f)
+			}
+			setBytes(fv, data[:length])
+			data = data[length:]
+		case wireRest:
+			setBytes(fv, data)
+			data = nil
+		}
+	}
+	return header.Interface(), nil
+}
+
+func (codec *StructCodec) tooShort(f structField) error {
+	return fmt.Errorf("data too short for decoding %s.%s", codec.typ.Name(), f.name)
+}
+
+func boolToUint(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// isZeroField 空的[]byte与nil一样视为零值
+func isZeroField(fv reflect.Value) bool {
+	if fv.Kind() == reflect.Slice {
+		return fv.Len() == 0
+	}
+	return fv.IsZero()
+}
+
+func bytesOf(fv reflect.Value) []byte {
+	if fv.Kind() == reflect.String {
+		return []byte(fv.String())
+	}
+	return fv.Bytes()
+}
+
+// setBytes 复制data，解码结果不引用输入的缓冲区，空的[]byte解码为nil
+func setBytes(fv reflect.Value, data []byte) {
+	if fv.Kind() == reflect.String {
+		fv.SetString(string(data))
+		return
+	}
+	fv.SetBytes(append([]byte(nil), data...))
+}
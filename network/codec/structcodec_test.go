@@ -0,0 +1,143 @@
+package codec_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"go-networking/network/codec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type taggedHeader struct {
+	Small    int8   `wire:"fixed"`
+	Status   uint16 `wire:"fixed"`
+	Offset   int64  `wire:"varint"`
+	Count    uint32 `wire:"varint"`
+	Done     bool   `wire:"fixed"`
+	Name     string `wire:"lv16"`
+	Key      []byte `wire:"lv32"`
+	internal int
+	Skipped  string `wire:"-"`
+	Rest     []byte `wire:"rest"`
+}
+
+func TestStructCodecShouldRoundTripWhenFieldsTagged(t *testing.T) {
+	headerCodec, err := codec.NewStructCodec(&taggedHeader{})
+	require.NoError(t, err)
+
+	header := &taggedHeader{
+		Small:  -3,
+		Status: 413,
+		Offset: -1 << 40,
+		Count:  300,
+		Done:   true,
+		Name:   "名字",
+		Key:    []byte{1, 2, 3},
+		Rest:   []byte("tail"),
+	}
+	data, err := headerCodec.Encode(header)
+	require.NoError(t, err)
+	decoded, err := headerCodec.Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, header, decoded)
+
+	// 结构体值和指针编码结果相同
+	byValue, err := headerCodec.Encode(*header)
+	require.NoError(t, err)
+	assert.Equal(t, data, byValue)
+}
+
+func TestStructCodecShouldMatchHandWrittenLayoutWhenHeaderConverted(t *testing.T) {
+	buf := new(bytes.Buffer)
+	codec.WriteLvString(buf, "ticket")
+	binary.Write(buf, binary.BigEndian, int64(1700000000))
+
+	data, err := codec.MustStructCodec(&codec.ResumeHeader{}).Encode(&codec.ResumeHeader{Ticket: "ticket", Timestamp: 1700000000})
+	require.NoError(t, err)
+	assert.Equal(t, buf.Bytes(), data)
+
+	buf.Reset()
+	binary.Write(buf, binary.BigEndian, codec.RekeyOK)
+	binary.Write(buf, binary.BigEndian, int64(-1))
+	decoded, err := codec.MustStructCodec(&codec.RekeyAckHeader{}).Decode(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, &codec.RekeyAckHeader{StatusCode: codec.RekeyOK, Timestamp: -1}, decoded)
+}
+
+type optionalHeader struct {
+	Id    string `wire:"lv16"`
+	Group uint8  `wire:"fixed,optional"`
+	Flags uint32 `wire:"varint,optional"`
+}
+
+func TestStructCodecShouldKeepZeroValueWhenOptionalFieldsMissing(t *testing.T) {
+	headerCodec := codec.MustStructCodec(&optionalHeader{})
+
+	decoded, err := headerCodec.Decode([]byte{0x00, 0x02, 'i', 'd'})
+	require.NoError(t, err)
+	assert.Equal(t, &optionalHeader{Id: "id"}, decoded)
+
+	_, err = headerCodec.Decode([]byte{0x00, 0x02, 'i'})
+	assert.Error(t, err)
+
+	// 末尾取零值的optional字段不写出，旧版本得到相同的字节
+	data, err := headerCodec.Encode(&optionalHeader{Id: "id"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x02, 'i', 'd'}, data)
+	data, err = headerCodec.Encode(&optionalHeader{Id: "id", Flags: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x02, 'i', 'd', 0x00, 0x01}, data)
+}
+
+func TestStructCodecShouldReturnErrorWhenTagsInvalid(t *testing.T) {
+	for name, header := range map[string]interface{}{
+		"not struct": "header",
+		"untagged": &struct {
+			Id string
+		}{},
+		"unknown encoding": &struct {
+			Id string `wire:"lv8"`
+		}{},
+		"wrong kind": &struct {
+			Id string `wire:"fixed"`
+		}{},
+		"platform int": &struct {
+			N int `wire:"fixed"`
+		}{},
+		"rest not last": &struct {
+			Data []byte `wire:"rest"`
+			N    uint8  `wire:"fixed"`
+		}{},
+		"required after optional": &struct {
+			A uint8 `wire:"fixed,optional"`
+			B uint8 `wire:"fixed"`
+		}{},
+	} {
+		_, err := codec.NewStructCodec(header)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestStructCodecShouldReturnErrorWhenValueInvalid(t *testing.T) {
+	headerCodec := codec.MustStructCodec(&codec.ResumeHeader{})
+
+	_, err := headerCodec.Encode(&codec.RekeyHeader{})
+	assert.Error(t, err)
+	_, err = headerCodec.Encode(nil)
+	assert.Error(t, err)
+	_, err = headerCodec.Encode(&codec.ResumeHeader{Ticket: strings.Repeat("t", 1<<16)})
+	assert.Error(t, err)
+
+	// 长度前缀超出剩余数据
+	_, err = headerCodec.Decode([]byte{0x00, 0x10, 't'})
+	assert.Error(t, err)
+
+	overflow := codec.MustStructCodec(&struct {
+		N uint8 `wire:"varint"`
+	}{})
+	_, err = overflow.Decode(binary.AppendUvarint(nil, 300))
+	assert.Error(t, err)
+}
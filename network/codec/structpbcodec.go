@@ -0,0 +1,95 @@
+package codec
+
+import (
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// StructPBCodec 根据与StructCodec相同的wire标签推导Header的protobuf编码，新增命令时不需要手写protobuf编解码器。
+// 编码的字段按定义顺序依次使用字段号1、2、3……，标签为-的字段不占用字段号；
+// 整数和bool编码为varint，有符号整数与proto3的int32、int64相同，string和[]byte编码为bytes。
+// wire标签中的编码方式和optional只影响LV格式。消息定义在proto/frame.proto中时字段顺序必须与之一致。
+type StructPBCodec struct {
+	// 解析后的字段，只使用其中的类型和字段列表
+	layout *StructCodec
+}
+
+func NewStructPBCodec(header interface{}) (*StructPBCodec, error) {
+	layout, err := NewStructCodec(header)
+	if err != nil {
+		return nil, err
+	}
+	return &StructPBCodec{layout: layout}, nil
+}
+
+// MustStructPBCodec 与NewStructPBCodec相同，标签不合法时panic，用于init中注册
+func MustStructPBCodec(header interface{}) *StructPBCodec {
+	codec, err := NewStructPBCodec(header)
+	if err != nil {
+		panic(err)
+	}
+	return codec
+}
+
+func (codec *StructPBCodec) Encode(header interface{}) ([]byte, error) {
+	value := reflect.ValueOf(header)
+	if value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+	if !value.IsValid() || value.Type() != codec.layout.typ {
+		return nil, fmt.Errorf("invalid header type %T, want *%s", header, codec.layout.typ.Name())
+	}
+
+	e := &PBEncoder{}
+	for i, f := range codec.layout.fields {
+		num := protowire.Number(i + 1)
+		fv := value.Field(f.index)
+		switch {
+		case fv.Kind() == reflect.Bool:
+			e.WriteBool(num, fv.Bool())
+		case isIntKind(fv.Kind()):
+			e.WriteInt(num, fv.Int())
+		case isUintKind(fv.Kind()):
+			e.WriteUint(num, fv.Uint())
+		default:
+			e.WriteBytes(num, bytesOf(fv))
+		}
+	}
+	return e.Bytes(), nil
+}
+
+func (codec *StructPBCodec) Decode(data []byte) (interface{}, error) {
+	m, err := ParsePBMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	header := reflect.New(codec.layout.typ)
+	value := header.Elem()
+	for i, f := range codec.layout.fields {
+		num := protowire.Number(i + 1)
+		fv := value.Field(f.index)
+		switch {
+		case fv.Kind() == reflect.Bool:
+			fv.SetBool(m.ReadBool(num))
+		case isIntKind(fv.Kind()):
+			v := m.ReadInt64(num)
+			if fv.OverflowInt(v) {
+				return nil, fmt.Errorf("%s.%s overflows %s", codec.layout.typ.Name(), f.name, fv.Type())
+			}
+			fv.SetInt(v)
+		case isUintKind(fv.Kind()):
+			v := m.ReadUint64(num)
+			if fv.OverflowUint(v) {
+				return nil, fmt.Errorf("%s.%s overflows %s", codec.layout.typ.Name(), f.name, fv.Type())
+			}
+			fv.SetUint(v)
+		default:
+			// 没有写出的空字段解码为nil，与StructCodec一致
+			setBytes(fv, m.ReadBytes(num))
+		}
+	}
+	return header.Interface(), nil
+}
@@ -0,0 +1,107 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+var Err_Unsupported_Version = errors.New("unsupported protocol version")
+
+// DefaultCodecs 未配置Codec时使用的注册表，VERSION_2为LV格式，VERSION_2_CHECKSUM为带校验和的LV格式。
+// 没有Flags的VERSION_1和VERSION_CHECKSUM与之不兼容，不再注册
+var DefaultCodecs = newDefaultCodecs()
+
+func newDefaultCodecs() *CodecRegistry {
+	registry := NewCodecRegistry()
+	registry.Register(VERSION_2, NewLVCodec())
+	registry.Register(VERSION_2_CHECKSUM, NewChecksumLVCodec())
+	return registry
+}
+
+// CodecRegistry 按协议版本选择线上格式，编码时使用帧的Version，解码时读取帧开头的Version。
+// 同一服务端可以同时接受多种格式的连接，注册表本身也实现了Codec。
+type CodecRegistry struct {
+	mu     sync.RWMutex
+	codecs map[VersionType]Codec
+}
+
+func NewCodecRegistry() *CodecRegistry {
+	return &CodecRegistry{
+		codecs: make(map[VersionType]Codec),
+	}
+}
+
+// Register 注册version对应的编解码器，已经注册的版本会被替换
+func (r *CodecRegistry) Register(version VersionType, codec Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codecs[version] = codec
+}
+
+// Lookup 查找version对应的编解码器，未注册时返回Err_Unsupported_Version
+func (r *CodecRegistry) Lookup(version VersionType) (Codec, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	codec, exists := r.codecs[version]
+	if !exists {
+		return nil, fmt.Errorf("%w: %d", Err_Unsupported_Version, version)
+	}
+	return codec, nil
+}
+
+func (r *CodecRegistry) Encode(frame *Frame) ([]byte, error) {
+	return r.WithCrypto(nil).Encode(frame)
+}
+
+func (r *CodecRegistry) Decode(data []byte) (*Frame, error) {
+	return r.WithCrypto(nil).Decode(data)
+}
+
+func (r *CodecRegistry) WithCrypto(crypto CryptoAlg) Codec {
+	return &registryCodec{registry: r, crypto: crypto}
+}
+
+// registryCodec 绑定了连接加密算法的注册表，每个帧按版本选择编解码器
+type registryCodec struct {
+	registry *CodecRegistry
+	crypto   CryptoAlg
+}
+
+func (c *registryCodec) Encode(frame *Frame) ([]byte, error) {
+	codec, err := c.registry.Lookup(frame.Version)
+	if err != nil {
+		return nil, err
+	}
+	return codec.WithCrypto(c.crypto).Encode(frame)
+}
+
+func (c *registryCodec) Decode(data []byte) (*Frame, error) {
+	version, err := peekVersion(data)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := c.registry.Lookup(version)
+	if err != nil {
+		return nil, err
+	}
+	return codec.WithCrypto(c.crypto).Decode(data)
+}
+
+func (c *registryCodec) WithCrypto(crypto CryptoAlg) Codec {
+	return &registryCodec{registry: c.registry, crypto: crypto}
+}
+
+// peekVersion 读取帧开头的Version，不消耗数据
+func peekVersion(data []byte) (VersionType, error) {
+	version, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, errors.New("failed to decode version, invalid bytes")
+	}
+	if version > math.MaxUint16 {
+		return 0, fmt.Errorf("%w: %d", Err_Unsupported_Version, version)
+	}
+	return VersionType(version), nil
+}
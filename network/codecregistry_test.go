@@ -0,0 +1,163 @@
+package network_test
+
+import (
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingCodec 记录经过的帧数，用于确认注册表选择了哪个编解码器
+type countingCodec struct {
+	network.Codec
+	encoded *atomic.Int32
+	decoded *atomic.Int32
+}
+
+func newCountingCodec() *countingCodec {
+	return &countingCodec{
+		Codec:   network.NewLVCodec(),
+		encoded: &atomic.Int32{},
+		decoded: &atomic.Int32{},
+	}
+}
+
+func (c *countingCodec) Encode(frame *network.Frame) ([]byte, error) {
+	c.encoded.Add(1)
+	return c.Codec.Encode(frame)
+}
+
+func (c *countingCodec) Decode(data []byte) (*network.Frame, error) {
+	c.decoded.Add(1)
+	return c.Codec.Decode(data)
+}
+
+func (c *countingCodec) WithCrypto(crypto network.CryptoAlg) network.Codec {
+	return &countingCodec{Codec: c.Codec.WithCrypto(crypto), encoded: c.encoded, decoded: c.decoded}
+}
+
+func newVersionedCodecs(codecs map[network.VersionType]network.Codec) *network.CodecRegistry {
+	registry := network.NewCodecRegistry()
+	for version, versionCodec := range codecs {
+		registry.Register(version, versionCodec)
+	}
+	return registry
+}
+
+func TestCodecRegistryShouldSelectCodecByFrameVersion(t *testing.T) {
+	v2 := newCountingCodec()
+	registry := newVersionedCodecs(map[network.VersionType]network.Codec{
+		network.VERSION_2: network.NewLVCodec(),
+		2:                 v2,
+	})
+
+	frame := newListDirFrame(1, []byte("v2"))
+	frame.Version = 2
+	data, err := registry.Encode(frame)
+	require.NoError(t, err)
+	decoded, err := registry.Decode(data[1:])
+	require.NoError(t, err)
+	assert.Equal(t, network.VersionType(2), decoded.Version)
+	assert.Equal(t, "v2", string(decoded.Payload))
+	assert.Equal(t, int32(1), v2.encoded.Load())
+	assert.Equal(t, int32(1), v2.decoded.Load())
+
+	data, err = registry.Encode(newListDirFrame(2, []byte("v1")))
+	require.NoError(t, err)
+	_, err = registry.Decode(data[1:])
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), v2.encoded.Load())
+
+	frame.Version = 3
+	_, err = registry.Encode(frame)
+	assert.ErrorIs(t, err, network.Err_Unsupported_Version)
+	_, err = network.NewCodecRegistry().Decode(data[1:])
+	assert.ErrorIs(t, err, network.Err_Unsupported_Version)
+}
+
+func TestCodecRegistryShouldIsolateHeaderCodecsWhenCommandsSeparate(t *testing.T) {
+	registry := network.NewCodecRegistry()
+	registry.Register(network.VERSION_2, network.NewLVCodecWithCommands(network.NewCommandFactory()))
+
+	// 独立的命令表中没有注册LISTDIR
+	_, err := registry.Encode(newListDirFrame(1, nil))
+	assert.Error(t, err)
+	_, err = network.Encode(newListDirFrame(1, nil))
+	assert.NoError(t, err)
+}
+
+func StartVersionedFileTcpClient(version network.VersionType, clientCodec network.Codec) *network.TcpClient {
+	tcpClient := network.NewTcpClient(&network.TcpClientConfig{
+		Network: "tcp",
+		Timeout: 5 * time.Second,
+		Codec:   clientCodec,
+		Version: version,
+	})
+	tcpClient.Init()
+	tcpClient.Start()
+	return tcpClient
+}
+
+func TestTcpServerShouldReplyInClientVersionWhenSeveralCodecsRegistered(t *testing.T) {
+	log.InitLogger()
+	v2 := newCountingCodec()
+	tcpSrv := StartConfiguredFileTcpServer(t.TempDir(), &fakeFileRecorder{}, func(config *network.TcpServerConfig) {
+		config.Codec = newVersionedCodecs(map[network.VersionType]network.Codec{
+			network.VERSION_2: network.NewLVCodec(),
+			2:                 v2,
+		})
+	})
+	defer tcpSrv.Stop()
+
+	// 客户端只支持版本2，响应必须使用版本2编码
+	v2Client := StartVersionedFileTcpClient(2, newVersionedCodecs(map[network.VersionType]network.Codec{
+		2: network.NewLVCodec(),
+	}))
+	defer v2Client.Stop()
+	_, err := v2Client.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), v2.decoded.Load())
+	assert.Equal(t, int32(1), v2.encoded.Load())
+
+	// 同一服务端仍然接受默认版本VERSION_2的连接
+	v1Client := StartFileTcpClient()
+	defer v1Client.Stop()
+	_, err = v1Client.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), v2.encoded.Load())
+}
+
+func TestTcpServerShouldCloseConnectionWhenVersionUnsupported(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+
+	tcpClient := StartVersionedFileTcpClient(9, newVersionedCodecs(map[network.VersionType]network.Codec{
+		network.VERSION_2: network.NewLVCodec(),
+		9:                 network.NewLVCodec(),
+	}))
+	defer tcpClient.Stop()
+	_, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	assert.ErrorIs(t, err, network.Err_Unsupported_Version)
+}
+
+func TestDefaultCodecsShouldRejectLayoutsWithoutFlags(t *testing.T) {
+	// 旧的VERSION_1帧Seq之后直接是HLen，按VERSION_2解析会把HLen当作Flags
+	legacy := []byte{0x01, 0x00, 0x01, 0x03, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	_, err := network.DefaultCodecs.Decode(legacy)
+	assert.ErrorIs(t, err, network.Err_Unsupported_Version)
+
+	legacy[0] = byte(network.VERSION_CHECKSUM)
+	_, err = network.DefaultCodecs.Decode(legacy)
+	assert.ErrorIs(t, err, network.Err_Unsupported_Version)
+
+	frame := network.NewFrame(network.LISTDIR, &codec.ListDirHeader{Id: "id"}, nil)
+	frame.Version = network.VERSION_1
+	_, err = network.DefaultCodecs.Encode(frame)
+	assert.ErrorIs(t, err, network.Err_Unsupported_Version)
+}
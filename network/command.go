@@ -16,4 +16,19 @@ const (
 	FILETRANSFER                           // 客户端向服务器发送，以协商文件传输。
 	FILETRANSFERACK                        // 对于FILETRANSFER的响应，包含文件传输细节。
 	TRANSFER                               // 用于实际传输文件数据。
+	TRANSFERACK                            // 对于TRANSFER的累计确认，服务端据此推进发送窗口，客户端据此记录断点。
+	FILEUPLOAD                             // 客户端向服务器发送，以协商文件上传，之后由客户端发送TRANSFER帧。
+	FILEUPLOADACK                          // 对于FILEUPLOAD的响应，协商时回复文件ID，全部数据落盘后再回复一次最终结果。
+	REKEY                                  // 客户端在已加密的连接上发送新的临时公钥，轮换会话密钥。
+	REKEYACK                               // 对于REKEY的响应，服务端回复新的临时公钥，发送后改用新的密钥。
+	RESUME                                 // TCP断开后客户端在新连接上出示会话恢复票据，不重新进行DH交换。
+	RESUMEACK                              // 对于RESUME的响应，恢复原连接ID并下发新的票据。
+	ERROR                                  // 服务端无法处理请求时的响应，Header中带有原请求的Seq、错误码和说明。
+	NOTIFY                                 // 服务端主动推送的通知，例如文件变化或即将停机，Seq为0，客户端不回复。
+	SUBSCRIBE                              // 客户端订阅一个主题，之后接收该主题的PUBLISH。
+	UNSUBSCRIBE                            // 客户端取消订阅一个主题。
+	SUBSCRIBEACK                           // 对于SUBSCRIBE和UNSUBSCRIBE的响应。
+	PUBLISH                                // 服务端发给主题订阅者的消息，Seq为0，客户端不回复。
+	AUTH                                   // 客户端在加密的连接上发送认证令牌，服务端记录令牌所属的用户。
+	AUTHACK                                // 对于AUTH的响应，包含认证的用户ID。
 )
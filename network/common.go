@@ -1,6 +1,13 @@
 package network
 
-import "github.com/cloudwego/netpoll"
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
 
 type Addr struct {
 	Host string
@@ -9,17 +16,195 @@ type Addr struct {
 
 type Conn struct {
 	Connection netpoll.Connection
+	// 写锁，保证同一连接上的帧按完整帧串行写出
+	wmu sync.Mutex
+	// 保护closing、pending、crypto、version、compressor和userId，保证开始关闭后不会再登记新的待发送任务
+	mu      sync.Mutex
+	closing bool
+	// CONN握手完成后加解密帧负载，握手前为nil
+	crypto CryptoAlg
+	// 对端第一个帧使用的协议版本，之后发送的帧都使用该版本
+	version VersionType
+	// CONN或RESUME协商压缩后压缩和解压帧负载，未协商时为nil
+	compressor *payloadCompression
+	// 尚未发送完成的异步任务数，例如文件下载的TRANSFER帧
+	pending int
+	// WaitDrained等待时创建，pending归零时关闭
+	drained chan struct{}
+	// 配置了MaxConcurrentRequests时并发处理请求，否则为nil
+	dispatcher *requestDispatcher
+	// AUTH认证的用户ID，RESUME时从票据恢复，0表示匿名连接
+	userId uint32
+}
+
+// SetUserId AUTH或RESUME时记录连接认证的用户ID
+func (c *Conn) SetUserId(userId uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.userId = userId
+}
+
+// UserId 连接认证的用户ID，匿名连接返回0
+func (c *Conn) UserId() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userId
+}
+
+// SetCrypto 握手完成后设置连接的加密算法，之后收发的帧都需要加密
+func (c *Conn) SetCrypto(crypto CryptoAlg) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.crypto = crypto
+}
+
+// Crypto 连接当前的加密算法，握手前为nil
+func (c *Conn) Crypto() CryptoAlg {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.crypto
+}
+
+// negotiate 以对端第一个帧的版本作为连接的协议版本
+func (c *Conn) negotiate(version VersionType) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.version == 0 {
+		c.version = version
+	}
+}
+
+// Version 连接协商的协议版本，收到第一个帧之前为0
+func (c *Conn) Version() VersionType {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+func (c *Conn) setCompressor(compressor *payloadCompression) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compressor = compressor
+}
+
+func (c *Conn) payloadCompressor() *payloadCompression {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.compressor
+}
+
+// Compression 连接协商的压缩算法，未协商时为CompressionNone
+func (c *Conn) Compression() CompressionType {
+	if compressor := c.payloadCompressor(); compressor != nil {
+		return compressor.algorithm
+	}
+	return CompressionNone
+}
+
+// RotateKeys 轮换连接的会话密钥，连接未加密或加密算法不支持轮换时返回错误
+func (c *Conn) RotateKeys(sendKey []byte, recvKey []byte) error {
+	rotator, ok := c.Crypto().(KeyRotator)
+	if !ok {
+		return Err_Rekey_Unsupported
+	}
+	rotator.Rotate(sendKey, recvKey)
+	return nil
 }
 
+// Acquire 登记一个异步发送任务，连接正在关闭时返回false，任务结束后需要调用Release
+func (c *Conn) Acquire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return false
+	}
+	c.pending++
+	return true
+}
+
+// Release 结束Acquire登记的异步发送任务
+func (c *Conn) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending--
+	if c.pending == 0 && c.drained != nil {
+		close(c.drained)
+		c.drained = nil
+	}
+}
+
+// Closing 连接是否已经收到CLOSE
+func (c *Conn) Closing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closing
+}
+
+// BeginClose 标记连接开始关闭，之后不再接受新的请求，重复调用返回false
+func (c *Conn) BeginClose() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return false
+	}
+	c.closing = true
+	return true
+}
+
+// WaitDrained 等待所有登记的异步发送任务结束，超时返回false
+func (c *Conn) WaitDrained(timeout time.Duration) bool {
+	c.mu.Lock()
+	if c.pending == 0 {
+		c.mu.Unlock()
+		return true
+	}
+	if c.drained == nil {
+		c.drained = make(chan struct{})
+	}
+	drained := c.drained
+	c.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// ConnCtx 连接的上下文，所有读取者共享同一实例，可变字段原子地原地更新
 type ConnCtx struct {
 	// real connection
 	Conn *Conn
+	// 客户端和服务端的加密密钥，轮换时一起替换，读取者不会读到只更新了一半的密钥
+	keys atomic.Pointer[connKeys]
+	// last ping time, update by ping command
+	LastPingTime atomic.Int64
+}
+
+type connKeys struct {
 	// client encrypt key
-	CKey []byte
+	cKey []byte
 	// server encrypt key
-	SKey []byte
-	// last ping time, update by ping command
-	LastPingTime int64
+	sKey []byte
+}
+
+// Keys 返回同一次轮换的客户端和服务端密钥
+func (ctx *ConnCtx) Keys() (cKey []byte, sKey []byte) {
+	keys := ctx.keys.Load()
+	return keys.cKey, keys.sKey
+}
+
+// CKey 客户端发往服务端数据的密钥，替代原来导出的CKey字段；需要两个方向的密钥时使用Keys
+func (ctx *ConnCtx) CKey() []byte {
+	return ctx.keys.Load().cKey
+}
+
+// SKey 服务端发往客户端数据的密钥，替代原来导出的SKey字段；需要两个方向的密钥时使用Keys
+func (ctx *ConnCtx) SKey() []byte {
+	return ctx.keys.Load().sKey
 }
 
 type RequestInterceptor interface {
@@ -43,11 +228,14 @@ type Server interface {
 	AddInterceptor(requestInterceptor RequestInterceptor)
 }
 
+// Client 客户端按服务端地址发送请求，TcpClient实现该接口
 type Client interface {
 	Lifecycle
-	SendSync(addr *Addr, packet *Frame) (*Frame, error)
-	SendAsync(addr *Addr, packet *Frame) error
-	SendOnce(addr *Addr, packet *Frame) error
+	SendSync(serverAddr string, frame *Frame, timeout time.Duration) (*Frame, error)
+	SendSyncContext(ctx context.Context, serverAddr string, frame *Frame) (*Frame, error)
+	SendAsync(serverAddr string, frame *Frame) error
+	SendAsyncContext(ctx context.Context, serverAddr string, frame *Frame) error
+	SendOnce(serverAddr string, frame *Frame) error
 	AddProcessor(commandType CommandType, processor Processor)
 	AddInterceptor(requestInterceptor RequestInterceptor)
 }
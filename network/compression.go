@@ -0,0 +1,287 @@
+package network
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionType 负载压缩算法，在CONN或RESUME中协商
+type CompressionType uint8
+
+const (
+	CompressionNone CompressionType = iota
+	CompressionGzip
+	CompressionSnappy
+	CompressionZstd
+)
+
+// 默认的压缩阈值，更小的负载压缩收益不大，直接发送
+const DefaultCompressThreshold = 512
+
+var (
+	Err_Unsupported_Compression    = errors.New("unsupported compression")
+	Err_Invalid_Compressed_Payload = errors.New("invalid compressed payload")
+)
+
+// CompressionPolicy 本端支持的压缩算法，Algorithms按优先级排列，为空时不压缩
+type CompressionPolicy struct {
+	Algorithms []CompressionType
+	// 负载小于该长度时不压缩，为0时使用DefaultCompressThreshold
+	Threshold int
+}
+
+// Offer 写入CONN或RESUME中的算法列表
+func (p CompressionPolicy) Offer() []uint8 {
+	offered := make([]uint8, 0, len(p.Algorithms))
+	for _, algorithm := range p.Algorithms {
+		offered = append(offered, uint8(algorithm))
+	}
+	return offered
+}
+
+// Negotiate 按本端的优先级选择对端也支持的算法，没有共同的算法时返回CompressionNone
+func (p CompressionPolicy) Negotiate(offered []uint8) CompressionType {
+	for _, algorithm := range p.Algorithms {
+		if algorithm == CompressionNone {
+			continue
+		}
+		for _, o := range offered {
+			if CompressionType(o) == algorithm {
+				return algorithm
+			}
+		}
+	}
+	return CompressionNone
+}
+
+func (p CompressionPolicy) threshold() int {
+	if p.Threshold == 0 {
+		return DefaultCompressThreshold
+	}
+	return p.Threshold
+}
+
+// Compressor 压缩算法的实现
+type Compressor interface {
+	Compress(src []byte) ([]byte, error)
+	// Decompress 解压后的长度超过limit时返回Err_Invalid_Compressed_Payload
+	Decompress(src []byte, limit int) ([]byte, error)
+}
+
+func NewCompressor(algorithm CompressionType) (Compressor, error) {
+	switch algorithm {
+	case CompressionGzip:
+		return gzipCompressor{}, nil
+	case CompressionSnappy:
+		return snappyCompressor{}, nil
+	case CompressionZstd:
+		return zstdCompressor{}, nil
+	}
+	return nil, fmt.Errorf("%w: %d", Err_Unsupported_Compression, algorithm)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(src []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write(src); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(src []byte, limit int) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", Err_Invalid_Compressed_Payload, err)
+	}
+	defer reader.Close()
+	return readLimited(reader, limit)
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Compress(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCompressor) Decompress(src []byte, limit int) ([]byte, error) {
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", Err_Invalid_Compressed_Payload, err)
+	}
+	if n > limit {
+		return nil, fmt.Errorf("%w: decompressed size %d exceeds %d", Err_Invalid_Compressed_Payload, n, limit)
+	}
+	plain, err := snappy.Decode(nil, src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", Err_Invalid_Compressed_Payload, err)
+	}
+	return plain, nil
+}
+
+// zstd的编码器可以并发使用，所有连接共用一个
+var zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+})
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Compress(src []byte) ([]byte, error) {
+	encoder, err := zstdEncoder()
+	if err != nil {
+		return nil, err
+	}
+	return encoder.EncodeAll(src, nil), nil
+}
+
+func (zstdCompressor) Decompress(src []byte, limit int) ([]byte, error) {
+	// 流式解码并限制输出长度，不信任帧头中声明的长度
+	decoder, err := zstd.NewReader(bytes.NewReader(src), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	return readLimited(decoder, limit)
+}
+
+// readLimited 读取全部数据，超过limit时返回错误，防止解压炸弹
+func readLimited(reader io.Reader, limit int) ([]byte, error) {
+	plain, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", Err_Invalid_Compressed_Payload, err)
+	}
+	if len(plain) > limit {
+		return nil, fmt.Errorf("%w: decompressed size exceeds %d", Err_Invalid_Compressed_Payload, limit)
+	}
+	return plain, nil
+}
+
+// payloadCompression 连接协商的压缩算法。协商后除握手帧外，压缩过的负载设置FlagCompressed，
+// 小于阈值或压缩后没有变小的负载不压缩。TRANSFER的数据块位于负载中，同样可以压缩。
+type payloadCompression struct {
+	algorithm  CompressionType
+	compressor Compressor
+	threshold  int
+	// 解压后负载的长度上限
+	limit int
+}
+
+func newPayloadCompression(algorithm CompressionType, policy CompressionPolicy, limits FrameLimits) (*payloadCompression, error) {
+	if algorithm == CompressionNone {
+		return nil, nil
+	}
+	compressor, err := NewCompressor(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &payloadCompression{
+		algorithm:  algorithm,
+		compressor: compressor,
+		threshold:  policy.threshold(),
+		limit:      int(limits.maxFrameSize()),
+	}, nil
+}
+
+// compressible 协商压缩的握手帧和空负载不压缩
+func compressible(frame *Frame) bool {
+	switch frame.CmdType {
+	case CONN, CONNACK, RESUME, RESUMEACK:
+		return false
+	}
+	return len(frame.Payload) != 0
+}
+
+// compress 压缩后变小时返回设置了FlagCompressed的帧副本，否则返回frame本身，不修改frame
+func (pc *payloadCompression) compress(frame *Frame) (*Frame, error) {
+	if pc == nil || !compressible(frame) || len(frame.Payload) < pc.threshold {
+		return frame, nil
+	}
+
+	compressed, err := pc.compressor.Compress(frame.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed) >= len(frame.Payload) {
+		return frame, nil
+	}
+	wire := *frame
+	wire.Flags |= FlagCompressed
+	wire.Payload = compressed
+	return &wire, nil
+}
+
+// decompress 解压带FlagCompressed的负载并清除该标志，直接修改frame。
+// 没有协商压缩或握手帧带有该标志时返回Err_Invalid_Compressed_Payload
+func (pc *payloadCompression) decompress(frame *Frame) error {
+	if frame.Flags&FlagCompressed == 0 {
+		return nil
+	}
+	if pc == nil || !compressible(frame) {
+		return fmt.Errorf("%w: compression not negotiated for cmd type %d", Err_Invalid_Compressed_Payload, frame.CmdType)
+	}
+	plain, err := pc.compressor.Decompress(frame.Payload, pc.limit)
+	if err != nil {
+		return err
+	}
+	frame.Flags &^= FlagCompressed
+	frame.Payload = plain
+	return nil
+}
+
+// CompressionObserver 拦截器可以同时实现该接口，观察协商压缩后每个帧负载的原始长度和线上长度
+type CompressionObserver interface {
+	OnCompression(remoteAddr string, frame *Frame, rawLen int, wireLen int, inbound bool)
+}
+
+// CompressionStats 统计压缩率的拦截器，通过TcpServer.AddInterceptor注册
+type CompressionStats struct {
+	rawIn, wireIn   atomic.Int64
+	rawOut, wireOut atomic.Int64
+}
+
+func (s *CompressionStats) OnRequest(remoteAddr string, request *Frame) {}
+
+func (s *CompressionStats) OnResponse(remoteAddr string, request *Frame, response *Frame) {}
+
+func (s *CompressionStats) OnCompression(remoteAddr string, frame *Frame, rawLen int, wireLen int, inbound bool) {
+	if inbound {
+		s.rawIn.Add(int64(rawLen))
+		s.wireIn.Add(int64(wireLen))
+	} else {
+		s.rawOut.Add(int64(rawLen))
+		s.wireOut.Add(int64(wireLen))
+	}
+}
+
+// Inbound 收到的负载解压后和线上的总字节数
+func (s *CompressionStats) Inbound() (rawBytes int64, wireBytes int64) {
+	return s.rawIn.Load(), s.wireIn.Load()
+}
+
+// Outbound 发出的负载压缩前和线上的总字节数
+func (s *CompressionStats) Outbound() (rawBytes int64, wireBytes int64) {
+	return s.rawOut.Load(), s.wireOut.Load()
+}
+
+// Ratio 原始字节数与线上字节数之比，没有数据时为1
+func (s *CompressionStats) Ratio() float64 {
+	raw := s.rawIn.Load() + s.rawOut.Load()
+	wire := s.wireIn.Load() + s.wireOut.Load()
+	if wire == 0 {
+		return 1
+	}
+	return float64(raw) / float64(wire)
+}
@@ -0,0 +1,191 @@
+package network_test
+
+import (
+	"bytes"
+	"fmt"
+	"go-networking/crypto/dh"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func StartCompressingFileTcpClient(policy network.CompressionPolicy) *network.TcpClient {
+	tcpClient := network.NewTcpClient(&network.TcpClientConfig{
+		Network:     "tcp",
+		Timeout:     5 * time.Second,
+		Compression: policy,
+	})
+	tcpClient.Init()
+	tcpClient.Start()
+	return tcpClient
+}
+
+func TestCompressorShouldRoundTripWhenAlgorithmSupported(t *testing.T) {
+	plain := bytes.Repeat([]byte("compressible payload "), 200)
+	for _, algorithm := range []network.CompressionType{network.CompressionGzip, network.CompressionSnappy, network.CompressionZstd} {
+		compressor, err := network.NewCompressor(algorithm)
+		require.NoError(t, err)
+		compressed, err := compressor.Compress(plain)
+		require.NoError(t, err)
+		assert.Less(t, len(compressed), len(plain), "algorithm %d", algorithm)
+
+		decompressed, err := compressor.Decompress(compressed, len(plain))
+		require.NoError(t, err)
+		assert.Equal(t, plain, decompressed)
+
+		// 解压后超过上限的数据被拒绝，防止解压炸弹
+		_, err = compressor.Decompress(compressed, len(plain)-1)
+		assert.ErrorIs(t, err, network.Err_Invalid_Compressed_Payload, "algorithm %d", algorithm)
+		_, err = compressor.Decompress([]byte("not compressed"), len(plain))
+		assert.Error(t, err, "algorithm %d", algorithm)
+	}
+
+	_, err := network.NewCompressor(network.CompressionNone)
+	assert.ErrorIs(t, err, network.Err_Unsupported_Compression)
+}
+
+func TestCompressionPolicyShouldFollowLocalPriorityWhenNegotiating(t *testing.T) {
+	policy := network.CompressionPolicy{Algorithms: []network.CompressionType{network.CompressionZstd, network.CompressionSnappy}}
+
+	assert.Equal(t, network.CompressionZstd, policy.Negotiate([]uint8{uint8(network.CompressionSnappy), uint8(network.CompressionZstd)}))
+	assert.Equal(t, network.CompressionSnappy, policy.Negotiate([]uint8{uint8(network.CompressionGzip), uint8(network.CompressionSnappy)}))
+	assert.Equal(t, network.CompressionNone, policy.Negotiate([]uint8{uint8(network.CompressionGzip)}))
+	assert.Equal(t, network.CompressionNone, policy.Negotiate(nil))
+	assert.Equal(t, network.CompressionNone, network.CompressionPolicy{}.Negotiate([]uint8{uint8(network.CompressionZstd)}))
+}
+
+func TestConnectShouldCompressPayloadsWhenBothSidesSupportCompression(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("%s-%02d.txt", strings.Repeat("report", 10), i)
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte("x"), 0644))
+	}
+
+	stats := &network.CompressionStats{}
+	tcpSrv := StartConfiguredFileTcpServer(root, &fakeFileRecorder{}, func(config *network.TcpServerConfig) {
+		config.Compression = network.CompressionPolicy{Algorithms: []network.CompressionType{network.CompressionZstd, network.CompressionSnappy}}
+	})
+	defer tcpSrv.Stop()
+	tcpSrv.AddInterceptor(stats)
+
+	tcpClient := StartCompressingFileTcpClient(network.CompressionPolicy{
+		Algorithms: []network.CompressionType{network.CompressionSnappy, network.CompressionZstd},
+	})
+	defer tcpClient.Stop()
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+
+	// 服务端按自己的优先级选择
+	connID := tcpClient.ConnID(fileServerAddr)
+	conn, exists := tcpSrv.CManager.Load(connID)
+	require.True(t, exists)
+	assert.Equal(t, network.CompressionZstd, conn.Compression())
+
+	ack, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+	assert.Len(t, ack.Entries, 50)
+
+	// 请求的负载小于阈值，原样发送；目录列表的负载经过压缩
+	rawIn, wireIn := stats.Inbound()
+	assert.Equal(t, rawIn, wireIn)
+	rawOut, wireOut := stats.Outbound()
+	assert.Less(t, wireOut, rawOut)
+	assert.Greater(t, stats.Ratio(), 1.0)
+
+	// 会话恢复后在新的TCP连接上重新协商
+	dropConnection(t, tcpSrv, tcpClient, connID)
+	require.NoError(t, tcpClient.Resume(fileServerAddr))
+	conn, exists = tcpSrv.CManager.Load(connID)
+	require.True(t, exists)
+	assert.Equal(t, network.CompressionZstd, conn.Compression())
+	ack, err = tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+	assert.Len(t, ack.Entries, 50)
+}
+
+func TestConnectShouldNotCompressWhenClientOffersNoCompression(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644))
+
+	stats := &network.CompressionStats{}
+	tcpSrv := StartConfiguredFileTcpServer(root, &fakeFileRecorder{}, func(config *network.TcpServerConfig) {
+		config.Compression = network.CompressionPolicy{Algorithms: []network.CompressionType{network.CompressionGzip}}
+	})
+	defer tcpSrv.Stop()
+	tcpSrv.AddInterceptor(stats)
+
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	conn, exists := tcpSrv.CManager.Load(tcpClient.ConnID(fileServerAddr))
+	require.True(t, exists)
+	assert.Equal(t, network.CompressionNone, conn.Compression())
+
+	_, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+	rawOut, wireOut := stats.Outbound()
+	assert.Zero(t, rawOut)
+	assert.Zero(t, wireOut)
+	assert.Equal(t, 1.0, stats.Ratio())
+}
+
+// transferCompression 记录服务端发出的TRANSFER帧压缩前后的长度
+type transferCompression struct {
+	network.CompressionStats
+	mu            sync.Mutex
+	raw, wire     int
+	transferCount int
+}
+
+func (tc *transferCompression) OnCompression(remoteAddr string, frame *network.Frame, rawLen int, wireLen int, inbound bool) {
+	if inbound || frame.CmdType != network.TRANSFER {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.raw += rawLen
+	tc.wire += wireLen
+	tc.transferCount++
+}
+
+func TestDownloadShouldCompressTransferBlocksWhenCompressionNegotiated(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	content := bytes.Repeat([]byte("compressible file block "), 8*1024)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data.txt"), content, 0644))
+
+	observer := &transferCompression{}
+	tcpSrv := StartConfiguredFileTcpServer(root, &fakeFileRecorder{}, func(config *network.TcpServerConfig) {
+		config.Compression = network.CompressionPolicy{Algorithms: []network.CompressionType{network.CompressionSnappy}}
+	})
+	defer tcpSrv.Stop()
+	tcpSrv.AddInterceptor(observer)
+
+	tcpClient := StartCompressingFileTcpClient(network.CompressionPolicy{Algorithms: []network.CompressionType{network.CompressionSnappy}})
+	defer tcpClient.Stop()
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+
+	destPath := filepath.Join(t.TempDir(), "data.txt")
+	_, err := tcpClient.DownloadFile(fileServerAddr, "/data.txt", destPath)
+	require.NoError(t, err)
+	downloaded, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, downloaded), "downloaded file should equal the source file")
+
+	// 数据块位于负载中，随负载一起压缩
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	assert.Greater(t, observer.transferCount, 0)
+	assert.Equal(t, len(content), observer.raw)
+	assert.Less(t, observer.wire*4, observer.raw)
+}
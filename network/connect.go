@@ -0,0 +1,199 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-networking/crypto/dh"
+	"go-networking/crypto/identity"
+	"go-networking/network/codec"
+	"time"
+)
+
+var (
+	Err_Server_Auth_Failed = errors.New("server authentication failed")
+	Err_Auth_Rejected      = errors.New("server rejected the authentication token")
+)
+
+// Connect 与serverAddr进行CONN握手，在group中交换临时公钥并派生两个方向的会话密钥。
+// 服务端分配的连接ID会在之后的CLOSE等命令中使用，握手之后帧的Header和负载使用AES-GCM加密。
+// 配置了ServerIdentity时，服务端签名不能通过校验则关闭连接并返回Err_Server_Auth_Failed。
+// 握手之前建立的连接池连接没有加密，握手后关闭，之后按需重新建立并同样握手。
+// 配置了TcpClientConfig.Token时，握手完成后在加密的连接上发送AUTH，令牌被拒绝时关闭连接并返回Err_Auth_Rejected。
+func (c *TcpClient) Connect(serverAddr string, group dh.Group) error {
+	hostConn, err := c.getOrCreateConnection(c.config.Network, serverAddr, c.config.Timeout)
+	if err != nil {
+		return err
+	}
+	session, err := c.handshake(hostConn, group)
+	if err != nil {
+		return err
+	}
+
+	c.mux.Lock()
+	if c.hostConnTable[serverAddr] != hostConn {
+		c.mux.Unlock()
+		return Err_Conn_Closed
+	}
+	if session != nil {
+		c.sessions[serverAddr] = session
+	} else {
+		delete(c.sessions, serverAddr)
+	}
+	c.mux.Unlock()
+
+	c.retirePlainMembers(serverAddr)
+	return nil
+}
+
+// handshake 在hostConn上进行CONN握手，服务端签发票据时返回可以恢复的会话
+func (c *TcpClient) handshake(hostConn *HostConn, group dh.Group) (*resumableSession, error) {
+	keyPair, err := dh.NewKeyPair(group)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := NewFrame(CONN, &codec.ConnHeader{
+		Timestamp:    time.Now().Unix(),
+		Group:        uint8(group),
+		Compressions: c.config.Compression.Offer(),
+	}, keyPair.PublicKey())
+	ctx, cancel := context.WithTimeout(context.Background(), c.responseTimeout())
+	defer cancel()
+	respFrame, err := c.sendSyncOn(ctx, hostConn, frame)
+	if err != nil {
+		return nil, err
+	}
+
+	header, ok := respFrame.Header.(*codec.ConnAckHeader)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response for conn, cmd type: %d", respFrame.CmdType)
+	}
+	if dh.Group(header.Group) != group {
+		return nil, fmt.Errorf("server negotiated a different key exchange group: %d", header.Group)
+	}
+	if err := c.verifyServerIdentity(keyPair.PublicKey(), respFrame.Payload, header); err != nil {
+		c.abortHandshake(hostConn)
+		return nil, err
+	}
+	compressor, err := c.acceptCompression(header.Compression)
+	if err != nil {
+		c.abortHandshake(hostConn)
+		return nil, err
+	}
+
+	secret, err := keyPair.SharedSecret(respFrame.Payload)
+	if err != nil {
+		return nil, err
+	}
+	cKey, sKey, err := dh.DeriveSessionKeys(secret, keyPair.PublicKey(), respFrame.Payload)
+	if err != nil {
+		return nil, err
+	}
+	var session *resumableSession
+	if len(header.Ticket) != 0 {
+		resumptionSecret, err := dh.DeriveResumptionSecret(secret, keyPair.PublicKey(), respFrame.Payload)
+		if err != nil {
+			return nil, err
+		}
+		session = &resumableSession{ticket: header.Ticket, secret: resumptionSecret, group: group}
+	}
+
+	c.mux.Lock()
+	c.establish(hostConn, header.Id, group, cKey, sKey, compressor)
+	c.mux.Unlock()
+
+	if err := c.authenticate(hostConn, header.Id); err != nil {
+		c.abortHandshake(hostConn)
+		return nil, err
+	}
+	return session, nil
+}
+
+// authenticate 握手完成后发送AUTH，此时帧已经使用会话密钥加密，未配置令牌时保持匿名连接
+func (c *TcpClient) authenticate(hostConn *HostConn, id string) error {
+	if len(c.config.Token) == 0 {
+		return nil
+	}
+	frame := NewFrame(AUTH, &codec.AuthHeader{
+		Id:        id,
+		Timestamp: time.Now().Unix(),
+		Token:     c.config.Token,
+	}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), c.responseTimeout())
+	defer cancel()
+	respFrame, err := c.sendSyncOn(ctx, hostConn, frame)
+	if err != nil {
+		return err
+	}
+	header, ok := respFrame.Header.(*codec.AuthAckHeader)
+	if !ok {
+		return fmt.Errorf("unexpected response for auth, cmd type: %d", respFrame.CmdType)
+	}
+	if header.StatusCode != codec.AuthOK {
+		return fmt.Errorf("%w, status code: %d", Err_Auth_Rejected, header.StatusCode)
+	}
+	return nil
+}
+
+// establish 握手或会话恢复完成后记录连接ID、会话密钥和协商的压缩算法，调用方需要持有c.mux
+func (c *TcpClient) establish(hostConn *HostConn, id string, group dh.Group, cKey []byte, sKey []byte, compressor *payloadCompression) {
+	hostConn.id = id
+	hostConn.setKeys(cKey, sKey)
+	hostConn.rekey.reset(group)
+	// 之后的帧使用会话密钥加密，cKey加密发出的帧，sKey解密收到的帧
+	hostConn.setCrypto(NewGcmCrypto(cKey, sKey))
+	hostConn.setCompressor(compressor)
+}
+
+// acceptCompression 服务端选择的压缩算法必须是本端提供的算法之一
+func (c *TcpClient) acceptCompression(chosen uint8) (*payloadCompression, error) {
+	algorithm := CompressionType(chosen)
+	if algorithm != CompressionNone && c.config.Compression.Negotiate([]uint8{chosen}) != algorithm {
+		return nil, fmt.Errorf("%w: server chose %d", Err_Unsupported_Compression, chosen)
+	}
+	return newPayloadCompression(algorithm, c.config.Compression, c.config.Limits)
+}
+
+// verifyServerIdentity 配置了服务端身份时，校验CONNACK中的身份公钥和握手签名
+func (c *TcpClient) verifyServerIdentity(clientPublicKey []byte, serverPublicKey []byte, header *codec.ConnAckHeader) error {
+	pin := c.config.ServerIdentity
+	if !pin.Enabled() {
+		return nil
+	}
+	if len(header.Signature) == 0 {
+		return Err_Server_Auth_Failed
+	}
+	if err := pin.Match(header.IdentityKey); err != nil {
+		return fmt.Errorf("%w: %v", Err_Server_Auth_Failed, err)
+	}
+	transcript := identity.Transcript(header.Group, clientPublicKey, serverPublicKey, header.Id, header.Timestamp)
+	if err := identity.Verify(header.IdentityKey, transcript, header.Signature); err != nil {
+		return fmt.Errorf("%w: %v", Err_Server_Auth_Failed, err)
+	}
+	return nil
+}
+
+// abortHandshake 服务端认证失败时关闭连接，不再使用该会话
+func (c *TcpClient) abortHandshake(hostConn *HostConn) {
+	c.mux.Lock()
+	if c.hostConnTable[hostConn.addr] == hostConn {
+		delete(c.hostConnTable, hostConn.addr)
+	}
+	c.pools[hostConn.addr].remove(hostConn.conn.LocalAddr().String())
+	c.mux.Unlock()
+
+	// 关闭回调会获取c.mux，不能在持有锁时关闭连接
+	hostConn.closing.Store(true)
+	hostConn.conn.Close()
+}
+
+// ConnID 返回与serverAddr握手后服务端分配的连接ID，未握手时返回空字符串
+func (c *TcpClient) ConnID(serverAddr string) string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if hostConn, exists := c.hostConnTable[serverAddr]; exists {
+		return hostConn.id
+	}
+	return ""
+}
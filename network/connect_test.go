@@ -0,0 +1,136 @@
+package network_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"go-networking/crypto/dh"
+	"go-networking/crypto/identity"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectShouldStoreSessionKeysWhenGivenSupportedGroup(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+
+	for _, group := range []dh.Group{dh.GroupX25519, dh.GroupMODP2048} {
+		tcpClient := StartFileTcpClient()
+		require.NoError(t, tcpClient.Connect(fileServerAddr, group))
+
+		connID := tcpClient.ConnID(fileServerAddr)
+		require.NotEmpty(t, connID)
+		connCtx, exists := tcpSrv.CManager.LoadCtx(connID)
+		require.True(t, exists)
+		cKey, sKey := connCtx.Keys()
+		assert.Len(t, cKey, 32)
+		assert.Len(t, sKey, 32)
+		assert.NotEqual(t, cKey, sKey)
+
+		require.NoError(t, tcpClient.Close(fileServerAddr))
+		_, exists = tcpSrv.CManager.LoadCtx(connID)
+		assert.False(t, exists)
+		tcpClient.Stop()
+	}
+}
+
+func StartPinnedFileTcpClient(pin *identity.Pin) *network.TcpClient {
+	tcpClient := network.NewTcpClient(&network.TcpClientConfig{
+		Network:        "tcp",
+		Timeout:        5 * time.Second,
+		ServerIdentity: pin,
+	})
+	tcpClient.Init()
+	tcpClient.Start()
+	return tcpClient
+}
+
+func TestConnectShouldSucceedWhenServerIdentityMatchesPin(t *testing.T) {
+	log.InitLogger()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tcpSrv := StartSignedFileTcpServer(t.TempDir(), privateKey)
+	defer tcpSrv.Stop()
+
+	for _, pin := range []*identity.Pin{
+		{PublicKey: publicKey},
+		{Fingerprint: identity.Fingerprint(publicKey)},
+	} {
+		tcpClient := StartPinnedFileTcpClient(pin)
+		require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+		assert.NotEmpty(t, tcpClient.ConnID(fileServerAddr))
+		require.NoError(t, tcpClient.Close(fileServerAddr))
+		tcpClient.Stop()
+	}
+}
+
+func TestConnectShouldRefuseSessionWhenServerIdentityNotPinned(t *testing.T) {
+	log.InitLogger()
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pinnedKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	// 服务端使用其他身份签名
+	tcpSrv := StartSignedFileTcpServer(t.TempDir(), privateKey)
+	tcpClient := StartPinnedFileTcpClient(&identity.Pin{PublicKey: pinnedKey})
+	err = tcpClient.Connect(fileServerAddr, dh.GroupX25519)
+	assert.ErrorIs(t, err, network.Err_Server_Auth_Failed)
+	assert.Empty(t, tcpClient.ConnID(fileServerAddr))
+	tcpClient.Stop()
+	tcpSrv.Stop()
+
+	// 服务端没有配置身份，不签名CONNACK
+	tcpSrv = StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	tcpClient = StartPinnedFileTcpClient(&identity.Pin{Fingerprint: identity.Fingerprint(pinnedKey)})
+	defer tcpClient.Stop()
+	err = tcpClient.Connect(fileServerAddr, dh.GroupX25519)
+	assert.ErrorIs(t, err, network.Err_Server_Auth_Failed)
+}
+
+func TestAuthShouldBindUserOnlyAfterHandshake(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	tcpClient := StartAuthenticatedFileTcpClient("user-7")
+	defer tcpClient.Stop()
+
+	// 握手之前的AUTH会以明文发送令牌，服务端不接受
+	respFrame, err := tcpClient.SendSync(fileServerAddr, network.NewFrame(network.AUTH, &codec.AuthHeader{Token: "user-7"}, nil), 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, codec.AuthNotConnected, respFrame.Header.(*codec.AuthAckHeader).StatusCode)
+
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	conn, exists := tcpSrv.CManager.Load(tcpClient.ConnID(fileServerAddr))
+	require.True(t, exists)
+	assert.Equal(t, uint32(7), conn.UserId())
+
+	// 已经认证的连接不能切换为其他用户
+	respFrame, err = tcpClient.SendSync(fileServerAddr, network.NewFrame(network.AUTH, &codec.AuthHeader{
+		Id:    tcpClient.ConnID(fileServerAddr),
+		Token: "user-8",
+	}, nil), 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, codec.AuthForbidden, respFrame.Header.(*codec.AuthAckHeader).StatusCode)
+	assert.Equal(t, uint32(7), conn.UserId())
+}
+
+func TestAuthShouldNotSendTokenInPlaintext(t *testing.T) {
+	client, _ := newGcmPair(t)
+	token := []byte("user-7-secret-token")
+
+	data, err := network.NewCryptoLVCodec(client).Encode(network.NewFrame(network.AUTH, &codec.AuthHeader{Id: "id", Token: string(token)}, nil))
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(data, token), "token should be sealed with the session keys")
+}
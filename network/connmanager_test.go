@@ -3,6 +3,7 @@ package network_test
 import (
 	"go-networking/network"
 	"go-networking/network/util"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,7 +15,7 @@ func TestStore_ShouldAddConnection_WhenIdAndConnAreValid(t *testing.T) {
 	testConn := &network.Conn{
 		Connection: nil,
 	}
-	manager.Store("testID", testConn)
+	manager.Store("testID", testConn, nil)
 	conn, exists := manager.Load("testID")
 
 	assert.True(t, exists, "Connection should exist")
@@ -26,7 +27,7 @@ func TestLoad_ShouldReturnConnection_WhenIdExists(t *testing.T) {
 	testConn := &network.Conn{
 		Connection: nil,
 	}
-	manager.Store("testID", testConn)
+	manager.Store("testID", testConn, nil)
 
 	conn, exists := manager.Load("testID")
 
@@ -49,9 +50,9 @@ func TestCleanup_ShouldRemoveIdleConnections_WhenTimeoutIsReached(t *testing.T)
 	testConn := &network.Conn{
 		Connection: nil,
 	}
-	manager.Store("testID", testConn)
+	manager.Store("testID", testConn, nil)
 
-	// 等待超时，然后调用 cleanupNoActiveConn
+	// 等待超时，连接在最后一次PING之后超过超时时间被清理
 	time.Sleep(60 * time.Second)
 
 	_, exists := manager.Load("testID")
@@ -65,15 +66,18 @@ func TestCleanup_ShouldNotRemoveIdleConnections_WhenPingedInTimeoutIntervalI(t *
 	testConn := &network.Conn{
 		Connection: nil,
 	}
-	manager.Store("testID", testConn)
+	manager.Store("testID", testConn, nil)
 
+	// 每15秒PING一次，最后一次PING之后没有超过超时时间
 	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
 	go func() {
-		<-ticker.C
-		manager.Ping("1111", time.Now().Unix())
+		for range ticker.C {
+			manager.Ping("testID", time.Now().Unix())
+		}
 	}()
 
-	// 等待超时，然后调用 cleanupNoActiveConn
+	// 等待超过超时时间，连接仍然活跃
 	time.Sleep(60 * time.Second)
 
 	_, exists := manager.Load("testID")
@@ -85,6 +89,107 @@ func BenchmarkStore(b *testing.B) {
 	manager := network.NewConnManager()
 	for i := 0; i < b.N; i++ {
 		testConn := &network.Conn{Connection: nil}
-		manager.Store(util.GetUUIDNoDash(), testConn)
+		manager.Store(util.GetUUIDNoDash(), testConn, nil)
 	}
 }
+
+// acceptTicket 不校验恢复秘密，只测试票据本身的状态
+func acceptTicket(*network.ResumptionState) bool {
+	return true
+}
+
+func TestRedeemTicket_ShouldReturnStateOnlyOnce_WhenTicketValid(t *testing.T) {
+	manager := network.NewConnManager()
+	ticket, err := manager.IssueTicket("testID", 0, []byte("secret"), time.Minute)
+	assert.NoError(t, err)
+
+	state, ok := manager.RedeemTicket(ticket, acceptTicket)
+	assert.True(t, ok, "Ticket should be redeemed")
+	assert.Equal(t, "testID", state.Id)
+	assert.Equal(t, []byte("secret"), state.Secret)
+
+	_, ok = manager.RedeemTicket(ticket, acceptTicket)
+	assert.False(t, ok, "Ticket should not be redeemed twice")
+}
+
+func TestRedeemTicket_ShouldKeepTicket_WhenVerifyFails(t *testing.T) {
+	manager := network.NewConnManager()
+	ticket, err := manager.IssueTicket("testID", 0, []byte("secret"), time.Minute)
+	assert.NoError(t, err)
+
+	// 不持有恢复秘密的一方不能使票据失效
+	_, ok := manager.RedeemTicket(ticket, func(state *network.ResumptionState) bool {
+		return false
+	})
+	assert.False(t, ok, "Ticket should be rejected when verify fails")
+	state, ok := manager.RedeemTicket(ticket, acceptTicket)
+	assert.True(t, ok, "Ticket should still be redeemable")
+	assert.Equal(t, "testID", state.Id)
+}
+
+func TestRedeemTicket_ShouldFail_WhenTicketExpiredOrRevoked(t *testing.T) {
+	manager := network.NewConnManager()
+	expired, err := manager.IssueTicket("testID", 0, []byte("secret"), -time.Second)
+	assert.NoError(t, err)
+	_, ok := manager.RedeemTicket(expired, acceptTicket)
+	assert.False(t, ok, "Expired ticket should be rejected")
+
+	revoked, err := manager.IssueTicket("testID", 0, []byte("secret"), time.Minute)
+	assert.NoError(t, err)
+	manager.RevokeTickets("testID")
+	_, ok = manager.RedeemTicket(revoked, acceptTicket)
+	assert.False(t, ok, "Revoked ticket should be rejected")
+}
+
+func TestPing_ShouldNotBeLost_WhenKeysRotatedConcurrently(t *testing.T) {
+	manager := network.NewConnManager()
+	manager.Store("testID", &network.Conn{}, []byte("key"))
+	connCtx, exists := manager.LoadCtx("testID")
+	assert.True(t, exists)
+	connCtx.LastPingTime.Store(0)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			manager.StoreRotatedKeys("testID", []byte{byte(i)}, []byte{byte(i + 1)})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			assert.NoError(t, manager.Ping("testID", time.Now().Unix()))
+		}
+	}()
+	wg.Wait()
+
+	// 轮换直接修改共享的ConnCtx，之前加载的ConnCtx能看到最新的PING和密钥
+	latest, exists := manager.LoadCtx("testID")
+	assert.True(t, exists)
+	assert.Same(t, connCtx, latest)
+	assert.NotZero(t, connCtx.LastPingTime.Load(), "Ping should not be lost")
+	cKey, sKey := connCtx.Keys()
+	assert.Equal(t, []byte{199}, cKey)
+	assert.Equal(t, []byte{200}, sKey)
+	assert.Equal(t, cKey, connCtx.CKey())
+	assert.Equal(t, sKey, connCtx.SKey())
+}
+
+func TestRevokeTickets_ShouldKeepTicketsOfOtherConnections(t *testing.T) {
+	manager := network.NewConnManager()
+	revoked, err := manager.IssueTicket("revokedID", 0, []byte("secret"), time.Minute)
+	assert.NoError(t, err)
+	kept, err := manager.IssueTicket("keptID", 0, []byte("secret"), time.Minute)
+	assert.NoError(t, err)
+
+	manager.RevokeTickets("revokedID")
+	_, ok := manager.RedeemTicket(revoked, acceptTicket)
+	assert.False(t, ok, "Revoked ticket should be rejected")
+	state, ok := manager.RedeemTicket(kept, acceptTicket)
+	assert.True(t, ok, "Ticket of another connection should be kept")
+	assert.Equal(t, "keptID", state.Id)
+
+	// 票据兑换后不再属于该连接，再次撤销不受影响
+	manager.RevokeTickets("keptID")
+}
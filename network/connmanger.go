@@ -1,10 +1,11 @@
 package network
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"go-networking/network/codec"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -12,29 +13,56 @@ import (
 // conn: 表示连接的Conn对象。
 // 返回值: 初始化后的ConnCtx指针。
 func newConnCtx(conn *Conn, key []byte) *ConnCtx {
-	return &ConnCtx{
-		Conn:         conn,
-		CKey:         key,
-		SKey:         key,
-		LastPingTime: time.Now().Unix(),
+	ctx := &ConnCtx{Conn: conn}
+	ctx.keys.Store(&connKeys{cKey: key, sKey: key})
+	ctx.updatePing()
+	return ctx
+}
+
+// updateKeys 以update的结果替换密钥，与并发的更新互不覆盖。
+func (ctx *ConnCtx) updateKeys(update func(keys connKeys) connKeys) {
+	for {
+		old := ctx.keys.Load()
+		updated := update(*old)
+		if ctx.keys.CompareAndSwap(old, &updated) {
+			return
+		}
 	}
 }
 
-// updateCKey 更新ConnCtx实例的CKey字段。
+// updateCKey 更新ConnCtx实例的客户端密钥。
 // ckey: 要更新的CKey字符串。
 func (ctx *ConnCtx) updateCKey(ckey []byte) {
-	ctx.CKey = ckey
+	ctx.updateKeys(func(keys connKeys) connKeys {
+		keys.cKey = ckey
+		return keys
+	})
 }
 
-// updateSKey 更新ConnCtx实例的SKey字段。
+// updateSKey 更新ConnCtx实例的服务端密钥。
 // skey: 要更新的SKey字符串。
 func (ctx *ConnCtx) updateSKey(skey []byte) {
-	ctx.SKey = skey
+	ctx.updateKeys(func(keys connKeys) connKeys {
+		keys.sKey = skey
+		return keys
+	})
 }
 
 // updatePing 更新ConnCtx实例的LastPingTime字段为当前时间。
 func (ctx *ConnCtx) updatePing() {
-	ctx.LastPingTime = time.Now().Unix()
+	ctx.LastPingTime.Store(time.Now().Unix())
+}
+
+// ResumptionState 会话恢复票据对应的服务端状态。
+type ResumptionState struct {
+	// 票据所属的连接ID
+	Id string
+	// 连接认证的用户ID，恢复的连接沿用该用户
+	UserId uint32
+	// 派生恢复后会话密钥的秘密
+	Secret []byte
+	// 票据过期时间
+	Expiry time.Time
 }
 
 // ConnManager 是用于管理连接的结构体。
@@ -43,50 +71,88 @@ type ConnManager struct {
 	// key: 设备UID。
 	// value: ConnCtx实例，包含连接及相关密钥信息。
 	deviceConnMap *sync.Map
-	isStopped     atomic.Uint32
-	timeout       time.Duration // 连接超时时间
-	timer         *time.Ticker  // 定时器，用于定期清理无活跃连接
+	// tickets 会话恢复票据，由ticketMu保护。
+	// key: 票据。
+	tickets map[string]*ResumptionState
+	// connTickets 按连接索引的票据，撤销时不需要遍历所有票据。
+	// key: 设备UID。
+	connTickets map[string]map[string]struct{}
+	ticketMu    sync.Mutex
+	// subs 连接订阅的主题，随连接一起删除。
+	subs    *subscriptions
+	timeout time.Duration // 连接超时时间
+	// wheel 按每个连接最后一次PING的时间清理无活跃连接，以及清理过期的票据。
+	wheel *TimingWheel
+	// evictions 连接的清理任务，连接删除或重新存储时取消。
+	// key: 设备UID。
+	evictions map[string]*eviction
+	mu        sync.Mutex
 }
 
 // NewConnManager 创建并初始化一个新的ConnManager实例。
 func NewConnManager() *ConnManager {
 	cm := &ConnManager{
 		deviceConnMap: &sync.Map{},
+		tickets:       make(map[string]*ResumptionState),
+		connTickets:   make(map[string]map[string]struct{}),
+		subs:          newSubscriptions(),
 		timeout:       30 * time.Second,
-		timer:         time.NewTicker(30 * time.Second),
+		wheel:         NewTimingWheel(100*time.Millisecond, 512),
+		evictions:     make(map[string]*eviction),
 	}
 
-	cm.isStopped.Store(0)
-	go cm.cleanupNoActiveConn()
+	cm.wheel.Start()
 	return cm
 }
 
 // Store 将连接存储到设备连接映射中。
 func (cm *ConnManager) Store(id string, conn *Conn, key []byte) {
 	cm.deviceConnMap.Store(id, newConnCtx(conn, key))
+	cm.scheduleEviction(id, cm.timeout)
+}
+
+// StoreKeys 将连接存储到设备连接映射中，两个方向使用不同的密钥。
+// cKey: 客户端发往服务端数据的密钥。
+// sKey: 服务端发往客户端数据的密钥。
+func (cm *ConnManager) StoreKeys(id string, conn *Conn, cKey []byte, sKey []byte) {
+	ctx := newConnCtx(conn, cKey)
+	ctx.updateSKey(sKey)
+	cm.deviceConnMap.Store(id, ctx)
+	cm.scheduleEviction(id, cm.timeout)
 }
 
 // StoreCKey 更新指定设备的CKey。
 func (cm *ConnManager) StoreCKey(id string, cKey []byte) {
-	if value, ok := cm.deviceConnMap.Load(id); ok {
-		ctx := value.(*ConnCtx)
+	if ctx, ok := cm.LoadCtx(id); ok {
 		ctx.updateCKey(cKey)
 	}
 }
 
 // StoreSKey 更新指定设备的SKey。
 func (cm *ConnManager) StoreSKey(id string, sKey []byte) {
-	if value, ok := cm.deviceConnMap.Load(id); ok {
-		ctx := value.(*ConnCtx)
+	if ctx, ok := cm.LoadCtx(id); ok {
 		ctx.updateSKey(sKey)
 	}
 }
 
+// StoreRotatedKeys 密钥轮换后同时更新两个方向的密钥，Keys不会读到只更新了一半的密钥。
+// 返回值: 设备不存在时返回false。
+func (cm *ConnManager) StoreRotatedKeys(id string, cKey []byte, sKey []byte) bool {
+	ctx, ok := cm.LoadCtx(id)
+	if !ok {
+		return false
+	}
+	ctx.keys.Store(&connKeys{cKey: cKey, sKey: sKey})
+	return true
+}
+
 // Delete 从设备连接映射中删除指定的设备连接。
 // 返回值: 被删除的ConnCtx实例，如果未找到则返回nil。
 func (cm *ConnManager) Delete(id string) *ConnCtx {
 	if value, ok := cm.deviceConnMap.Load(id); ok {
 		cm.deviceConnMap.Delete(id)
+		cm.subs.remove(id)
+		cm.cancelEviction(id)
 		return value.(*ConnCtx)
 	}
 
@@ -103,6 +169,98 @@ func (cm *ConnManager) Load(id string) (*Conn, bool) {
 	return nil, false
 }
 
+// LoadCtx 根据设备UID加载连接上下文，包含连接的密钥。
+func (cm *ConnManager) LoadCtx(id string) (*ConnCtx, bool) {
+	if value, ok := cm.deviceConnMap.Load(id); ok {
+		return value.(*ConnCtx), true
+	}
+
+	return nil, false
+}
+
+// IssueTicket 为连接签发会话恢复票据，票据在lifetime后过期。
+// userId: 连接认证的用户ID，匿名连接为0。
+func (cm *ConnManager) IssueTicket(id string, userId uint32, secret []byte, lifetime time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	ticket := hex.EncodeToString(buf)
+	state := &ResumptionState{
+		Id:     id,
+		UserId: userId,
+		Secret: secret,
+		Expiry: time.Now().Add(lifetime),
+	}
+	cm.ticketMu.Lock()
+	cm.tickets[ticket] = state
+	if cm.connTickets[id] == nil {
+		cm.connTickets[id] = make(map[string]struct{})
+	}
+	cm.connTickets[id][ticket] = struct{}{}
+	cm.ticketMu.Unlock()
+
+	cm.wheel.AfterFunc(lifetime, func() {
+		cm.ticketMu.Lock()
+		defer cm.ticketMu.Unlock()
+		if cm.tickets[ticket] == state {
+			cm.deleteTicketLocked(ticket, state)
+		}
+	})
+	return ticket, nil
+}
+
+// RedeemTicket 使用票据，票据只能使用一次。
+// verify: 校验对端持有票据的恢复秘密，返回false时票据保留，不会被使用。
+// 返回值: 票据不存在、已经过期或者校验失败时返回false。
+func (cm *ConnManager) RedeemTicket(ticket string, verify func(state *ResumptionState) bool) (*ResumptionState, bool) {
+	cm.ticketMu.Lock()
+	defer cm.ticketMu.Unlock()
+	state, ok := cm.tickets[ticket]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(state.Expiry) {
+		cm.deleteTicketLocked(ticket, state)
+		return nil, false
+	}
+	if !verify(state) {
+		return nil, false
+	}
+	cm.deleteTicketLocked(ticket, state)
+	return state, true
+}
+
+// AuthenticateTickets 连接通过AUTH认证后更新其票据中的用户ID，恢复的连接沿用该用户。
+func (cm *ConnManager) AuthenticateTickets(id string, userId uint32) {
+	cm.ticketMu.Lock()
+	defer cm.ticketMu.Unlock()
+	for ticket := range cm.connTickets[id] {
+		cm.tickets[ticket].UserId = userId
+	}
+}
+
+// RevokeTickets 删除连接的所有票据，连接正常关闭后不能再恢复。
+func (cm *ConnManager) RevokeTickets(id string) {
+	cm.ticketMu.Lock()
+	defer cm.ticketMu.Unlock()
+	for ticket := range cm.connTickets[id] {
+		delete(cm.tickets, ticket)
+	}
+	delete(cm.connTickets, id)
+}
+
+// deleteTicketLocked 删除票据及其索引，调用方需要持有ticketMu。
+func (cm *ConnManager) deleteTicketLocked(ticket string, state *ResumptionState) {
+	delete(cm.tickets, ticket)
+	if tickets, exists := cm.connTickets[state.Id]; exists {
+		delete(tickets, ticket)
+		if len(tickets) == 0 {
+			delete(cm.connTickets, state.Id)
+		}
+	}
+}
+
 // Ping 根据设备UID标记该设备连接为活跃。
 func (cm *ConnManager) Ping(id string, ts int64) error {
 	if time.Now().Unix()-ts > int64(cm.timeout/time.Second) {
@@ -121,27 +279,65 @@ func (cm *ConnManager) Ping(id string, ts int64) error {
 
 // Stop 停止ConnManager的定时清理任务。
 func (cm *ConnManager) Stop() {
-	cm.isStopped.Store(1)
-	cm.timer.Stop()
+	cm.wheel.Stop()
+}
+
+// eviction 连接的一次清理任务，在登记定时器之前创建，回调只通过它识别自己是否仍是当前任务。
+type eviction struct {
+	// 由cm.mu保护
+	timer *WheelTimer
+}
+
+// scheduleEviction 在delay之后检查连接是否活跃，替换该连接之前的清理任务。
+func (cm *ConnManager) scheduleEviction(id string, delay time.Duration) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if current, exists := cm.evictions[id]; exists {
+		current.timer.Stop()
+	}
+	// 回调可能在AfterFunc返回之前执行，只捕获已经创建好的task，不读取之后才赋值的定时器
+	task := &eviction{}
+	task.timer = cm.wheel.AfterFunc(delay, func() {
+		cm.evictIfIdle(id, task)
+	})
+	cm.evictions[id] = task
 }
 
-// cleanupNoActiveConn 启动一个goroutine定期清理长时间未活跃的连接。
-func (cm *ConnManager) cleanupNoActiveConn() {
+// cancelEviction 取消连接的清理任务。
+func (cm *ConnManager) cancelEviction(id string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if current, exists := cm.evictions[id]; exists {
+		current.timer.Stop()
+		delete(cm.evictions, id)
+	}
+}
+
+// evictIfIdle 删除超过timeout没有PING的连接，PING过的连接按最后一次PING的时间重新登记清理任务。
+// task不是该连接当前的清理任务时说明连接已经被删除或重新存储，不做处理。
+func (cm *ConnManager) evictIfIdle(id string, task *eviction) {
+	cm.mu.Lock()
+	if cm.evictions[id] != task {
+		cm.mu.Unlock()
+		return
+	}
+	delete(cm.evictions, id)
+	cm.mu.Unlock()
+
 	for {
-		select {
-		case <-cm.timer.C:
-			if cm.isStopped.Load() == 0 {
-				cm.deviceConnMap.Range(func(k, v interface{}) bool {
-					now := time.Now().Unix()
-					if connctx, ok := v.(*ConnCtx); ok {
-						if now-connctx.LastPingTime > int64(cm.timeout/time.Second) {
-							cm.deviceConnMap.Delete(k)
-						}
-					}
-
-					return true
-				})
-			}
+		value, ok := cm.deviceConnMap.Load(id)
+		if !ok {
+			return
+		}
+		deadline := time.Unix(value.(*ConnCtx).LastPingTime.Load(), 0).Add(cm.timeout)
+		if remaining := time.Until(deadline); remaining > 0 {
+			cm.scheduleEviction(id, remaining)
+			return
+		}
+		// 连接可能刚被Store重新存储，重新检查新的ConnCtx
+		if cm.deviceConnMap.CompareAndDelete(id, value) {
+			cm.subs.remove(id)
+			return
 		}
 	}
 }
@@ -0,0 +1,359 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-networking/log"
+	"go-networking/network/codec"
+	"sync"
+	"time"
+)
+
+var Err_Health_Check_Failed = errors.New("connection health check failed")
+
+// PoolSelection 从连接池中为普通请求选择连接的方式
+type PoolSelection int
+
+const (
+	// RoundRobin 依次使用池中的连接
+	RoundRobin PoolSelection = iota
+	// LeastLoaded 使用等待响应的请求最少的连接
+	LeastLoaded
+)
+
+// PoolConfig 每个服务端地址的连接池。
+// 与服务端建立的第一个连接是主连接，CONN、RESUME、REKEY、CLOSE、PING和订阅只在主连接上发送，
+// 其余请求按Selection分配到池中的连接。主连接完成握手后，新建的连接同样进行CONN握手。
+type PoolConfig struct {
+	// 每个地址保持的连接数下限，包括主连接
+	MinSize int
+	// 每个地址的连接数上限，包括主连接，不大于1时只使用主连接。
+	// 所有连接都有等待响应的请求时在后台增加连接
+	MaxSize   int
+	Selection PoolSelection
+	// 向一段时间内没有收到数据的连接发送PING的间隔，超过Timeout没有回复的连接被关闭，为0时不检查
+	HealthCheckInterval time.Duration
+	// 文件传输使用单独的连接，大文件不会阻塞其他请求，该连接不计入MaxSize
+	DedicatedTransfer bool
+}
+
+// hostPool 同一服务端地址除主连接以外的连接，由TcpClient.mux保护
+type hostPool struct {
+	members []*HostConn
+	// DedicatedTransfer时文件传输使用的连接
+	transfer *HostConn
+	// 正在建立的连接数，避免超过MaxSize
+	dialing int
+	// 轮询的位置
+	next int
+	// 保证同时只建立一个传输连接，不由TcpClient.mux保护
+	transferMu sync.Mutex
+}
+
+// conns 池中所有的连接，包括传输连接
+func (p *hostPool) conns() []*HostConn {
+	if p == nil {
+		return nil
+	}
+	conns := make([]*HostConn, 0, len(p.members)+1)
+	conns = append(conns, p.members...)
+	if p.transfer != nil {
+		conns = append(conns, p.transfer)
+	}
+	return conns
+}
+
+// remove 删除本端地址为localAddr的连接，返回被删除的连接
+func (p *hostPool) remove(localAddr string) *HostConn {
+	if p == nil {
+		return nil
+	}
+	if p.transfer != nil && p.transfer.conn.LocalAddr().String() == localAddr {
+		removed := p.transfer
+		p.transfer = nil
+		return removed
+	}
+	for i, member := range p.members {
+		if member.conn.LocalAddr().String() == localAddr {
+			p.members = append(p.members[:i], p.members[i+1:]...)
+			return member
+		}
+	}
+	return nil
+}
+
+// isSessionCommand 与连接ID或会话状态相关的命令，只在主连接上发送
+func isSessionCommand(cmdType CommandType) bool {
+	switch cmdType {
+	case CONN, PING, CLOSE, REKEY, RESUME, SUBSCRIBE, UNSUBSCRIBE:
+		return true
+	}
+	return false
+}
+
+// isTransferCommand 文件传输的帧，同一次传输的帧必须在同一个连接上发送
+func isTransferCommand(cmdType CommandType) bool {
+	switch cmdType {
+	case FILETRANSFER, TRANSFER, TRANSFERACK, FILEUPLOAD:
+		return true
+	}
+	return false
+}
+
+// route 选择发送frame的连接：会话命令使用主连接，文件传输在DedicatedTransfer时使用传输连接，
+// 其余请求按PoolConfig.Selection选择。主连接正在关闭时只使用已有的连接
+func (c *TcpClient) route(serverAddr string, frame *Frame) (*HostConn, error) {
+	primary, err := c.getOrCreateConnection(c.config.Network, serverAddr, c.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case isTransferCommand(frame.CmdType):
+		if c.config.Pool.DedicatedTransfer {
+			return c.transferConn(serverAddr, primary)
+		}
+		return primary, nil
+	case primary.closing.Load(), isSessionCommand(frame.CmdType), c.config.Pool.MaxSize <= 1:
+		return primary, nil
+	default:
+		return c.selectConn(serverAddr, primary), nil
+	}
+}
+
+// selectConn 在主连接和池中可用的连接中选择一个，所有连接都有等待响应的请求且未达到MaxSize时在后台增加连接
+func (c *TcpClient) selectConn(serverAddr string, primary *HostConn) *HostConn {
+	c.mux.Lock()
+	pool := c.poolLocked(serverAddr)
+	candidates := make([]*HostConn, 0, len(pool.members)+1)
+	candidates = append(candidates, primary)
+	for _, member := range pool.members {
+		if member.conn.IsActive() && !member.closing.Load() {
+			candidates = append(candidates, member)
+		}
+	}
+
+	var chosen *HostConn
+	idle := false
+	loads := make([]int, len(candidates))
+	for i, candidate := range candidates {
+		loads[i] = c.promiseM.Pending(candidate.key)
+		idle = idle || loads[i] == 0
+	}
+	switch c.config.Pool.Selection {
+	case LeastLoaded:
+		best := 0
+		for i := range candidates {
+			if loads[i] < loads[best] {
+				best = i
+			}
+		}
+		chosen = candidates[best]
+	default:
+		chosen = candidates[pool.next%len(candidates)]
+		pool.next++
+	}
+
+	grow := !idle && 1+len(pool.members)+pool.dialing < c.config.Pool.MaxSize
+	if grow {
+		pool.dialing++
+	}
+	c.mux.Unlock()
+
+	if grow {
+		go c.addMember(serverAddr, pool)
+	}
+	return chosen
+}
+
+// transferConn DedicatedTransfer时文件传输使用的连接，不存在或已经断开时重新建立
+func (c *TcpClient) transferConn(serverAddr string, primary *HostConn) (*HostConn, error) {
+	c.mux.Lock()
+	pool := c.poolLocked(serverAddr)
+	transfer := pool.transfer
+	c.mux.Unlock()
+	// 连接正在关闭时，进行中传输的后续帧仍然在原来的连接上发送
+	if transfer != nil && (transfer.closing.Load() || transfer.conn.IsActive()) {
+		return transfer, nil
+	}
+	if primary.closing.Load() {
+		return primary, nil
+	}
+
+	pool.transferMu.Lock()
+	defer pool.transferMu.Unlock()
+	c.mux.Lock()
+	transfer = pool.transfer
+	c.mux.Unlock()
+	if transfer != nil && transfer.conn.IsActive() {
+		return transfer, nil
+	}
+
+	transfer, err := c.dialMember(serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	c.mux.Lock()
+	attached := c.pools[serverAddr] == pool
+	if attached {
+		pool.transfer = transfer
+	}
+	c.mux.Unlock()
+	if !attached {
+		transfer.closing.Store(true)
+		transfer.conn.Close()
+		return nil, Err_Conn_Closed
+	}
+	return transfer, nil
+}
+
+// addMember 建立一个连接加入pool，pool已经被Close删除时关闭该连接
+func (c *TcpClient) addMember(serverAddr string, pool *hostPool) {
+	member, err := c.dialMember(serverAddr)
+	c.mux.Lock()
+	pool.dialing--
+	// 建立过程中主连接完成了握手时，没有加密的连接不再加入
+	primary := c.hostConnTable[serverAddr]
+	attached := err == nil && c.pools[serverAddr] == pool &&
+		(primary == nil || primary.cryptoAlg() == nil || member.cryptoAlg() != nil)
+	if attached {
+		pool.members = append(pool.members, member)
+	}
+	c.mux.Unlock()
+
+	if err != nil {
+		log.Errorf("[%s] add pooled connection failed: %v", serverAddr, err)
+		return
+	}
+	if !attached {
+		member.closing.Store(true)
+		member.conn.Close()
+	}
+}
+
+// dialMember 建立主连接以外的连接，主连接已经握手时使用同一个群进行CONN握手
+func (c *TcpClient) dialMember(serverAddr string) (*HostConn, error) {
+	conn, err := c.createConnection(c.config.Network, serverAddr, c.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// 以本端地址区分同一服务端地址上的连接
+	member := c.newHostConn(serverAddr, fmt.Sprintf("%s#%s", serverAddr, conn.LocalAddr()), conn, c.config.Timeout)
+	c.mux.Lock()
+	primary := c.hostConnTable[serverAddr]
+	c.mux.Unlock()
+
+	if primary == nil || primary.cryptoAlg() == nil {
+		return member, nil
+	}
+	primary.rekey.mu.Lock()
+	group := primary.rekey.group
+	primary.rekey.mu.Unlock()
+	if _, err := c.handshake(member, group); err != nil {
+		member.closing.Store(true)
+		conn.Close()
+		return nil, err
+	}
+	return member, nil
+}
+
+// fillPoolLocked 在后台建立连接，使连接数达到MinSize，调用方需要持有c.mux
+func (c *TcpClient) fillPoolLocked(serverAddr string) {
+	pool := c.poolLocked(serverAddr)
+	for n := 1 + len(pool.members) + pool.dialing; n < c.config.Pool.MinSize && n < c.config.Pool.MaxSize; n++ {
+		pool.dialing++
+		go c.addMember(serverAddr, pool)
+	}
+}
+
+func (c *TcpClient) poolLocked(serverAddr string) *hostPool {
+	pool, exists := c.pools[serverAddr]
+	if !exists {
+		pool = &hostPool{}
+		c.pools[serverAddr] = pool
+	}
+	return pool
+}
+
+// retirePlainMembers 主连接完成握手后关闭池中没有加密的连接，重新建立的连接同样进行握手
+func (c *TcpClient) retirePlainMembers(serverAddr string) {
+	c.mux.Lock()
+	pool := c.pools[serverAddr]
+	var retired []*HostConn
+	for _, member := range pool.conns() {
+		if member.cryptoAlg() == nil && member.closing.CompareAndSwap(false, true) {
+			pool.remove(member.conn.LocalAddr().String())
+			retired = append(retired, member)
+		}
+	}
+	if pool != nil {
+		c.fillPoolLocked(serverAddr)
+	}
+	c.mux.Unlock()
+
+	for _, member := range retired {
+		go func(member *HostConn) {
+			if err := c.closeHostConn(member); err != nil {
+				log.Errorf("[%s] close plaintext pooled connection failed: %v", member.key, err)
+			}
+		}(member)
+	}
+}
+
+// scheduleHealthCheck 每隔HealthCheckInterval检查一次所有连接
+func (c *TcpClient) scheduleHealthCheck() {
+	interval := c.config.Pool.HealthCheckInterval
+	if interval <= 0 {
+		return
+	}
+	c.wheel.AfterFunc(interval, func() {
+		go c.checkHealth(interval)
+		c.scheduleHealthCheck()
+	})
+}
+
+// checkHealth 向超过interval没有收到数据的连接发送PING，没有回复的连接被关闭，之后补足MinSize
+func (c *TcpClient) checkHealth(interval time.Duration) {
+	c.mux.Lock()
+	var conns []*HostConn
+	for serverAddr, primary := range c.hostConnTable {
+		conns = append(conns, primary)
+		conns = append(conns, c.pools[serverAddr].conns()...)
+	}
+	c.mux.Unlock()
+
+	var wg sync.WaitGroup
+	for _, hostConn := range conns {
+		if hostConn.closing.Load() || time.Since(time.Unix(0, hostConn.lastRead.Load())) < interval {
+			continue
+		}
+		wg.Add(1)
+		go func(hostConn *HostConn) {
+			defer wg.Done()
+			c.ping(hostConn)
+		}(hostConn)
+	}
+	wg.Wait()
+
+	c.mux.Lock()
+	for serverAddr, primary := range c.hostConnTable {
+		if !primary.closing.Load() {
+			c.fillPoolLocked(serverAddr)
+		}
+	}
+	c.mux.Unlock()
+}
+
+// ping 服务端回复PONG或ERROR都说明连接可用，超时或连接断开时关闭连接
+func (c *TcpClient) ping(hostConn *HostConn) {
+	frame := NewFrame(PING, &codec.PingHeader{Timestamp: time.Now().Unix(), Id: hostConn.id}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), c.responseTimeout())
+	defer cancel()
+	_, err := c.sendSyncOn(ctx, hostConn, frame)
+	var protocolErr *ProtocolError
+	if err == nil || errors.As(err, &protocolErr) || hostConn.closing.Load() {
+		return
+	}
+	c.abort(hostConn, fmt.Errorf("%w: %v", Err_Health_Check_Failed, err))
+}
@@ -0,0 +1,115 @@
+package network_test
+
+import (
+	"go-networking/log"
+	"go-networking/network"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func StartPooledFileTcpClient(pool network.PoolConfig) *network.TcpClient {
+	tcpClient := network.NewTcpClient(&network.TcpClientConfig{
+		Network: "tcp",
+		Timeout: 5 * time.Second,
+		Pool:    pool,
+	})
+	tcpClient.Init()
+	tcpClient.Start()
+	return tcpClient
+}
+
+// fastFrame 服务端收到后立即回复
+func fastFrame() *network.Frame {
+	return blockingFrame(0)
+}
+
+func TestPooledClientShouldUseIdleConnectionWhenPrimaryIsBusy(t *testing.T) {
+	log.InitLogger()
+	// 服务端依次处理同一连接上的请求，慢请求会阻塞所在的连接
+	tcpSrv, processor := startDispatchingServer(t, 0, false)
+	defer tcpSrv.Stop()
+	defer close(processor.release)
+	tcpClient := StartPooledFileTcpClient(network.PoolConfig{MinSize: 2, MaxSize: 2, Selection: network.LeastLoaded})
+	defer tcpClient.Stop()
+
+	require.NoError(t, tcpClient.SendAsyncWithCallback(fileServerAddr, slowFrame(), func(*network.Frame, error) {}))
+	<-processor.entered
+
+	// 池中的连接建立后，请求发往没有等待响应的连接
+	require.Eventually(t, func() bool {
+		_, err := tcpClient.SendSync(fileServerAddr, fastFrame(), 200*time.Millisecond)
+		return err == nil
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestPooledClientShouldDownloadOnDedicatedConnectionWhenPrimaryIsBusy(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data.bin"), []byte("pooled transfer"), 0644))
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	processor := newBlockingProcessor()
+	tcpSrv.AddProcessor(blockingCmd, processor)
+	defer close(processor.release)
+	tcpClient := StartPooledFileTcpClient(network.PoolConfig{DedicatedTransfer: true})
+	defer tcpClient.Stop()
+
+	require.NoError(t, tcpClient.SendAsyncWithCallback(fileServerAddr, slowFrame(), func(*network.Frame, error) {}))
+	<-processor.entered
+
+	destPath := filepath.Join(t.TempDir(), "data.bin")
+	_, err := tcpClient.DownloadFile(fileServerAddr, "/data.bin", destPath)
+	require.NoError(t, err)
+	downloaded, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, "pooled transfer", string(downloaded))
+}
+
+// remoteAddrRecorder 记录请求所在连接的对端地址后立即回复
+type remoteAddrRecorder struct {
+	mu    sync.Mutex
+	addrs map[string]struct{}
+}
+
+func (r *remoteAddrRecorder) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	r.mu.Lock()
+	r.addrs[conn.Connection.RemoteAddr().String()] = struct{}{}
+	r.mu.Unlock()
+	resp := blockingFrame(0)
+	resp.Seq = frame.Seq
+	return resp, nil
+}
+
+func TestTcpClientShouldDialOnceWhenFirstRequestsToAddressAreConcurrent(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	recorder := &remoteAddrRecorder{addrs: make(map[string]struct{})}
+	tcpSrv.AddProcessor(blockingCmd, recorder)
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	// 同时发出的第一批请求等待同一次拨号，共用一个主连接
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := tcpClient.SendSync(fileServerAddr, fastFrame(), 5*time.Second)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	assert.Len(t, recorder.addrs, 1)
+}
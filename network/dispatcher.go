@@ -0,0 +1,63 @@
+package network
+
+import "sync"
+
+// requestDispatcher 在连接上并发处理请求，同时执行的请求不超过slots的容量。
+// dispatch只在该连接的netpoll回调中调用，回调本身按帧到达的顺序串行执行。
+type requestDispatcher struct {
+	slots chan struct{}
+	// 已经分发但尚未发送响应的请求
+	inflight sync.WaitGroup
+	// 是否按请求到达的顺序发送响应
+	ordered bool
+	// 上一个请求的响应发送后关闭，ordered时使用
+	tail chan struct{}
+}
+
+func newRequestDispatcher(concurrency int, ordered bool) *requestDispatcher {
+	tail := make(chan struct{})
+	close(tail)
+	return &requestDispatcher{
+		slots:   make(chan struct{}, concurrency),
+		ordered: ordered,
+		tail:    tail,
+	}
+}
+
+// dispatch 在新的goroutine中执行process并用send发送结果。
+// 同时执行的请求达到上限时阻塞，连接上的后续帧暂不读取，形成背压。
+// ordered时请求仍然并发执行，但每个响应要等前一个请求的响应发送之后才发送。
+func (d *requestDispatcher) dispatch(process func() *Frame, send func(*Frame)) {
+	d.slots <- struct{}{}
+	d.inflight.Add(1)
+
+	prev, next := d.tail, make(chan struct{})
+	d.tail = next
+	go func() {
+		defer d.inflight.Done()
+		defer func() { <-d.slots }()
+		defer close(next)
+
+		resp := process()
+		if d.ordered {
+			<-prev
+		}
+		send(resp)
+	}()
+}
+
+// wait 等待已经分发的请求全部发送响应，改变连接状态的命令在此之后才执行
+func (d *requestDispatcher) wait() {
+	d.inflight.Wait()
+}
+
+// isSerialCommand 修改连接状态或依赖帧顺序的命令，在netpoll回调中逐个执行。
+// CONN、RESUME和REKEY切换的密钥用于解码下一帧，AUTH记录的用户用于之后的请求，
+// CLOSE要求之前的请求都已回复，TRANSFER和TRANSFERACK属于按顺序推进的传输窗口。
+func isSerialCommand(cmdType CommandType) bool {
+	switch cmdType {
+	case CONN, RESUME, REKEY, AUTH, CLOSE, TRANSFER, TRANSFERACK:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package network_test
+
+import (
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 测试用的请求命令，由blockingProcessor处理，请求和响应共用同一Header
+const blockingCmd network.CommandType = 241
+
+type blockingHeader struct {
+	// 请求的编号，slowRequest表示需要阻塞的请求
+	Kind uint16 `wire:"varint"`
+}
+
+// 标记需要阻塞的请求
+const slowRequest uint16 = 1
+
+func init() {
+	network.AddStructHeaderCodec(blockingCmd, &blockingHeader{})
+}
+
+// blockingFrame kind为slowRequest时服务端阻塞到blockingProcessor.release关闭
+func blockingFrame(kind uint16) *network.Frame {
+	return network.NewFrame(blockingCmd, &blockingHeader{Kind: kind}, nil)
+}
+
+// blockingProcessor Kind为slowRequest的请求阻塞到release关闭，其余请求立即回复
+type blockingProcessor struct {
+	entered   chan uint64
+	processed chan uint64
+	release   chan struct{}
+}
+
+func newBlockingProcessor() *blockingProcessor {
+	return &blockingProcessor{
+		entered:   make(chan uint64, 16),
+		processed: make(chan uint64, 16),
+		release:   make(chan struct{}),
+	}
+}
+
+func (p *blockingProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	p.entered <- frame.Seq
+	kind := frame.Header.(*blockingHeader).Kind
+	if kind == slowRequest {
+		<-p.release
+	}
+	p.processed <- frame.Seq
+	resp := blockingFrame(kind)
+	resp.Seq = frame.Seq
+	return resp, nil
+}
+
+func startDispatchingServer(t *testing.T, concurrency int, ordered bool) (*network.TcpServer, *blockingProcessor) {
+	tcpSrv := StartConfiguredFileTcpServer(t.TempDir(), &fakeFileRecorder{}, func(config *network.TcpServerConfig) {
+		config.MaxConcurrentRequests = concurrency
+		config.OrderedResponses = ordered
+	})
+	processor := newBlockingProcessor()
+	tcpSrv.AddProcessor(blockingCmd, processor)
+	return tcpSrv, processor
+}
+
+// sendInBackground 在新的goroutine中发送请求，返回的通道在收到响应后关闭
+func sendInBackground(t *testing.T, tcpClient *network.TcpClient, kind uint16, order *[]uint16, mu *sync.Mutex) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := tcpClient.SendSync(fileServerAddr, blockingFrame(kind), 5*time.Second)
+		assert.NoError(t, err)
+		mu.Lock()
+		*order = append(*order, kind)
+		mu.Unlock()
+	}()
+	return done
+}
+
+func TestTcpServerShouldProcessRequestsConcurrentlyWhenConcurrencyConfigured(t *testing.T) {
+	log.InitLogger()
+	tcpSrv, processor := startDispatchingServer(t, 2, false)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	var mu sync.Mutex
+	var order []uint16
+	slow := sendInBackground(t, tcpClient, slowRequest, &order, &mu)
+	<-processor.entered
+
+	// 慢请求占用一个并发名额，之后的请求不必等待它
+	fast := sendInBackground(t, tcpClient, 2, &order, &mu)
+	select {
+	case <-fast:
+	case <-time.After(3 * time.Second):
+		t.Fatal("fast request should not wait for the slow one")
+	}
+	<-processor.entered
+	<-processor.processed
+
+	// 两个慢请求占满并发名额后，连接上的后续帧暂不处理
+	slow2 := sendInBackground(t, tcpClient, slowRequest, &order, &mu)
+	<-processor.entered
+	blocked := sendInBackground(t, tcpClient, 3, &order, &mu)
+	select {
+	case <-processor.entered:
+		t.Fatal("request should wait for a free slot")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(processor.release)
+	for _, done := range []chan struct{}{slow, slow2, blocked} {
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("request should complete after release")
+		}
+	}
+	assert.Len(t, order, 4)
+}
+
+func TestTcpServerShouldReplyInRequestOrderWhenOrderedResponsesEnabled(t *testing.T) {
+	log.InitLogger()
+	tcpSrv, processor := startDispatchingServer(t, 4, true)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	var mu sync.Mutex
+	var order []uint16
+	slow := sendInBackground(t, tcpClient, slowRequest, &order, &mu)
+	<-processor.entered
+	fast := sendInBackground(t, tcpClient, 2, &order, &mu)
+
+	// 后到的请求已经处理完，但响应要排在慢请求之后
+	<-processor.entered
+	<-processor.processed
+	select {
+	case <-fast:
+		t.Fatal("response should wait for the earlier request")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(processor.release)
+	<-slow
+	<-fast
+	assert.Len(t, order, 2)
+}
+
+func TestTcpServerShouldRunSerialCommandAfterInflightRequestsWhenConcurrent(t *testing.T) {
+	log.InitLogger()
+	tcpSrv, processor := startDispatchingServer(t, 4, false)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+	_, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var order []uint16
+	slow := sendInBackground(t, tcpClient, slowRequest, &order, &mu)
+	<-processor.entered
+
+	// CLOSE在之前的请求回复后才执行，慢请求不会因为连接关闭而失败
+	closed := make(chan error, 1)
+	go func() {
+		closed <- tcpClient.Close(fileServerAddr)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(processor.release)
+	<-slow
+	require.NoError(t, <-closed)
+	assert.Equal(t, []uint16{slowRequest}, order)
+}
@@ -0,0 +1,321 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"go-networking/network/codec"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// 连续这么长时间没有收到任何文件数据，则认为下载失败
+const transferIdleTimeout = 30 * time.Second
+
+var (
+	Err_File_Transfer_Changed = errors.New("file changed since last transfer, restart the download")
+)
+
+// FileTransferState 记录一次下载的进度，连接中断后可以凭它调用ResumeDownload继续下载。
+type FileTransferState struct {
+	FilePath  string
+	FileID    uint32
+	FileLen   uint64
+	Checksum  uint32
+	BlockSize uint32
+	// 下一个待接收的块序号，之前的块均已写入临时文件并向服务端确认
+	NextBlock uint32
+}
+
+func (state *FileTransferState) totalBlocks() uint32 {
+	return uint32((state.FileLen + uint64(state.BlockSize) - 1) / uint64(state.BlockSize))
+}
+
+// transferReceiver 接收沿用同一请求Seq的文件传输帧，下载和上传各有一个实现
+type transferReceiver interface {
+	onFrame(frame *Frame)
+	finish(err error)
+}
+
+// fileReceiver 接收同一个FILETRANSFER请求的FILETRANSFERACK和TRANSFER帧，并将数据块写入临时文件。
+type fileReceiver struct {
+	mu       sync.Mutex
+	destPath string
+	state    FileTransferState
+	file     *os.File
+	sendAck  func(ack *codec.TransferAck) error
+	activity chan struct{}
+	done     chan error
+	once     sync.Once
+}
+
+func newFileReceiver(state *FileTransferState, destPath string) *fileReceiver {
+	return &fileReceiver{
+		destPath: destPath,
+		state:    *state,
+		activity: make(chan struct{}, 1),
+		done:     make(chan error, 1),
+	}
+}
+
+// DownloadFile 向服务端请求filePath指定的文件，重组后写入destPath并校验校验和。
+// 失败时返回已经确认的下载进度，可以用于ResumeDownload。
+func (c *TcpClient) DownloadFile(serverAddr string, filePath string, destPath string) (*FileTransferState, error) {
+	return c.ResumeDownload(serverAddr, &FileTransferState{FilePath: filePath}, destPath)
+}
+
+// ResumeDownload 从state.NextBlock开始继续下载，destPath的临时文件中已有的块不会重新传输。
+// 服务端发现文件自上次下载后已变化时返回Err_File_Transfer_Changed，并删除临时文件。
+func (c *TcpClient) ResumeDownload(serverAddr string, state *FileTransferState, destPath string) (*FileTransferState, error) {
+	frame := NewFrame(FILETRANSFER, &codec.FileTransfer{
+		Length:     uint32(len(state.FilePath)),
+		FilePath:   state.FilePath,
+		FileID:     state.FileID,
+		Checksum:   state.Checksum,
+		StartBlock: state.NextBlock,
+	}, nil)
+	frame.Seq = uint64(c.seqIncr.Increment())
+
+	// 先登记接收者再发送请求，避免FILETRANSFERACK先于登记到达
+	receiver := newFileReceiver(state, destPath)
+	receiver.sendAck = func(ack *codec.TransferAck) error {
+		ackFrame := NewFrame(TRANSFERACK, ack, nil)
+		ackFrame.Seq = frame.Seq
+		return c.doSendAsync(serverAddr, ackFrame)
+	}
+	c.addReceiver(frame.Seq, receiver)
+	defer c.delReceiver(frame.Seq)
+
+	if err := c.doSendAsync(serverAddr, frame); err != nil {
+		return receiver.snapshot(), err
+	}
+
+	err := receiver.wait()
+	return receiver.snapshot(), err
+}
+
+func (c *TcpClient) addReceiver(seq uint64, receiver transferReceiver) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.receivers[seq] = receiver
+}
+
+func (c *TcpClient) delReceiver(seq uint64) {
+	c.mux.Lock()
+	receiver, exists := c.receivers[seq]
+	delete(c.receivers, seq)
+	c.mux.Unlock()
+
+	if exists {
+		receiver.finish(errors.New("file transfer cancelled"))
+	}
+}
+
+// failTransfer 服务端以ERROR拒绝了文件传输请求时立即结束对应的接收者，返回是否存在该接收者
+func (c *TcpClient) failTransfer(seq uint64, err *ProtocolError) bool {
+	c.mux.Lock()
+	receiver, exists := c.receivers[seq]
+	c.mux.Unlock()
+	if !exists {
+		return false
+	}
+
+	receiver.finish(err)
+	return true
+}
+
+// dispatchTransfer 将文件传输相关的帧交给对应的接收者，返回该帧是否已被处理。
+func (c *TcpClient) dispatchTransfer(frame *Frame) bool {
+	switch frame.CmdType {
+	case FILETRANSFERACK, TRANSFER, FILEUPLOADACK, TRANSFERACK:
+	default:
+		return false
+	}
+
+	c.mux.Lock()
+	receiver, exists := c.receivers[frame.Seq]
+	c.mux.Unlock()
+	if !exists {
+		return false
+	}
+
+	receiver.onFrame(frame)
+	return true
+}
+
+func (r *fileReceiver) onFrame(frame *Frame) {
+	r.mu.Lock()
+	var finished bool
+	var err error
+	switch header := frame.Header.(type) {
+	case *codec.FileTransferAck:
+		finished, err = r.onAck(header)
+	case *codec.Transfer:
+		finished, err = r.onTransfer(header, frame.Payload)
+	default:
+		err = fmt.Errorf("unexpected header type for file transfer, cmd type: %d", frame.CmdType)
+	}
+	r.mu.Unlock()
+
+	if finished || err != nil {
+		r.finish(err)
+	}
+}
+
+func (r *fileReceiver) onAck(ack *codec.FileTransferAck) (bool, error) {
+	if r.file != nil {
+		return false, errors.New("duplicated file transfer ack")
+	}
+	if ack.ErrorCode == codec.FileTransferChanged {
+		os.Remove(r.partPath())
+		r.state = FileTransferState{FilePath: r.state.FilePath}
+		return false, Err_File_Transfer_Changed
+	}
+	if ack.ErrorCode != codec.FileTransferOK {
+		return false, fmt.Errorf("file transfer rejected by server, error code: %d", ack.ErrorCode)
+	}
+	if ack.BlockSize == 0 {
+		return false, errors.New("file transfer ack with zero block size")
+	}
+	if r.state.NextBlock != 0 && ack.BlockSize != r.state.BlockSize {
+		return false, errors.New("block size changed while resuming file transfer")
+	}
+
+	// 续传时保留临时文件中已经确认的块
+	flag := os.O_RDWR | os.O_CREATE
+	if r.state.NextBlock == 0 {
+		flag |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(r.partPath(), flag, 0644)
+	if err != nil {
+		return false, err
+	}
+	r.file = file
+	r.state.FileID = ack.FileID
+	r.state.FileLen = ack.FileLen
+	r.state.Checksum = ack.Checksum
+	r.state.BlockSize = ack.BlockSize
+
+	if r.state.NextBlock >= r.state.totalBlocks() {
+		return true, r.complete()
+	}
+	return false, nil
+}
+
+func (r *fileReceiver) onTransfer(transfer *codec.Transfer, block []byte) (bool, error) {
+	if r.file == nil {
+		return false, errors.New("transfer block received before file transfer ack")
+	}
+	if transfer.FileID != r.state.FileID {
+		return false, fmt.Errorf("transfer block for unknown file id: %d", transfer.FileID)
+	}
+	if transfer.Seq != r.state.NextBlock {
+		return false, fmt.Errorf("transfer block out of order, expected: %d, actual: %d", r.state.NextBlock, transfer.Seq)
+	}
+
+	offset := int64(transfer.Seq) * int64(r.state.BlockSize)
+	if _, err := r.file.WriteAt(block, offset); err != nil {
+		return false, err
+	}
+	r.state.NextBlock++
+
+	select {
+	case r.activity <- struct{}{}:
+	default:
+	}
+
+	// 块落盘之后才确认，续传时不会丢失已确认的数据
+	if err := r.sendAck(&codec.TransferAck{FileID: transfer.FileID, Seq: transfer.Seq}); err != nil {
+		return false, err
+	}
+
+	if r.state.NextBlock >= r.state.totalBlocks() {
+		return true, r.complete()
+	}
+	return false, nil
+}
+
+// complete 所有数据块到达后校验整个文件的校验和，通过后移动到目标路径
+func (r *fileReceiver) complete() error {
+	if err := r.file.Truncate(int64(r.state.FileLen)); err != nil {
+		return err
+	}
+
+	checksum, err := r.checksum()
+	if err != nil {
+		return err
+	}
+	if expected := r.state.Checksum; checksum != expected {
+		r.file.Close()
+		r.file = nil
+		os.Remove(r.partPath())
+		r.state = FileTransferState{FilePath: r.state.FilePath}
+		return fmt.Errorf("file checksum mismatch, expected: %d, actual: %d", expected, checksum)
+	}
+
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+	return os.Rename(r.partPath(), r.destPath)
+}
+
+func (r *fileReceiver) checksum() (uint32, error) {
+	if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, r.file); err != nil {
+		return 0, err
+	}
+
+	return hash.Sum32(), nil
+}
+
+func (r *fileReceiver) partPath() string {
+	return r.destPath + ".part"
+}
+
+func (r *fileReceiver) snapshot() *FileTransferState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := r.state
+	return &state
+}
+
+// finish 结束下载，临时文件保留下来用于断点续传
+func (r *fileReceiver) finish(err error) {
+	r.once.Do(func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.file != nil {
+			r.file.Close()
+			r.file = nil
+		}
+		r.done <- err
+	})
+}
+
+// wait 等待下载结束，只要持续收到数据块就不会超时
+func (r *fileReceiver) wait() error {
+	timer := time.NewTimer(transferIdleTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case err := <-r.done:
+			return err
+		case <-r.activity:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(transferIdleTimeout)
+		case <-timer.C:
+			r.finish(errors.New("waiting for file transfer timeout"))
+			return <-r.done
+		}
+	}
+}
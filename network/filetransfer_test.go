@@ -0,0 +1,418 @@
+package network_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"go-networking/crypto/dh"
+	"go-networking/ginh/file"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"go-networking/network/processor"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quintans/toolkit/latch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	fileServerAddr = "127.0.0.1:8083"
+)
+
+// fakeFileRecorder 代替数据库记录上传完成的文件
+type fakeFileRecorder struct {
+	mu    sync.Mutex
+	files []*file.FileInfo
+}
+
+func (r *fakeFileRecorder) UploadFile(cmd *file.UploadFileCmd, userId uint) (*file.FileInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info := &file.FileInfo{
+		UserId:      userId,
+		ParentId:    cmd.ParentId,
+		Filename:    fmt.Sprintf("stored-%d", len(r.files)+1),
+		OrgFilename: cmd.Filename,
+	}
+	r.files = append(r.files, info)
+	return info, nil
+}
+
+// fakeAuthenticate 代替JWT校验，令牌"user-<id>"属于用户id
+func fakeAuthenticate(token string) (uint32, error) {
+	var userId uint32
+	if _, err := fmt.Sscanf(token, "user-%d", &userId); err != nil {
+		return 0, err
+	}
+	return userId, nil
+}
+
+func StartFileTcpServer(root string) *network.TcpServer {
+	return StartFileUploadTcpServer(root, &fakeFileRecorder{})
+}
+
+func StartFileUploadTcpServer(root string, recorder processor.FileRecorder) *network.TcpServer {
+	return StartConfiguredFileTcpServer(root, recorder, nil)
+}
+
+// StartSignedFileTcpServer 启动使用identityKey签名CONNACK的文件服务
+func StartSignedFileTcpServer(root string, identityKey ed25519.PrivateKey) *network.TcpServer {
+	return StartConfiguredFileTcpServer(root, &fakeFileRecorder{}, func(config *network.TcpServerConfig) {
+		config.IdentityKey = identityKey
+	})
+}
+
+// StartConfiguredFileTcpServer 启动文件服务，configure用于修改默认的服务端配置
+func StartConfiguredFileTcpServer(root string, recorder processor.FileRecorder, configure func(config *network.TcpServerConfig)) *network.TcpServer {
+	countdownLatch := latch.NewCountDownLatch()
+	countdownLatch.Add(1)
+	tcpServerConfig := &network.TcpServerConfig{
+		Network: "tcp",
+		Addr: network.Addr{
+			Host: "127.0.0.1",
+			Port: "8083",
+		},
+		TicketLifetime: time.Minute,
+		Authenticate:   fakeAuthenticate,
+	}
+	if configure != nil {
+		configure(tcpServerConfig)
+	}
+
+	tcpServer, err := network.NewTcpServer(tcpServerConfig)
+	if err != nil {
+		fmt.Println("failed to create tcp server")
+		return nil
+	}
+	tcpServer.Init()
+	fileTransferProcs := processor.NewFileTransferProcs(tcpServer, root, 0)
+	tcpServer.AddProcessor(network.FILETRANSFER, fileTransferProcs)
+	tcpServer.AddProcessor(network.TRANSFERACK, fileTransferProcs)
+	fileUploadProcs := processor.NewFileUploadProcs(tcpServer, root, recorder, 0)
+	tcpServer.AddProcessor(network.FILEUPLOAD, fileUploadProcs)
+	tcpServer.AddProcessor(network.TRANSFER, fileUploadProcs)
+	tcpServer.AddProcessor(network.LISTDIR, processor.NewListdireProcs(tcpServer, root))
+	tcpServer.AddProcessor(network.CLOSE, processor.NewCloseProcs(tcpServer))
+	tcpServer.AddProcessor(network.CONN, processor.NewConnProcs(tcpServer, false))
+	tcpServer.AddProcessor(network.REKEY, processor.NewRekeyProcs(tcpServer, false))
+	tcpServer.AddProcessor(network.RESUME, processor.NewResumeProcs(tcpServer))
+	tcpServer.AddProcessor(network.AUTH, processor.NewAuthProcs(tcpServer))
+	subscribeProcs := processor.NewSubscribeProcs(tcpServer)
+	tcpServer.AddProcessor(network.SUBSCRIBE, subscribeProcs)
+	tcpServer.AddProcessor(network.UNSUBSCRIBE, subscribeProcs)
+	go func() {
+		countdownLatch.Done()
+		tcpServer.Start()
+	}()
+
+	countdownLatch.Wait()
+	defer countdownLatch.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	return tcpServer
+}
+
+func StartFileTcpClient() *network.TcpClient {
+	return StartAuthenticatedFileTcpClient("")
+}
+
+// StartAuthenticatedFileTcpClient 启动握手后以token发送AUTH的客户端，token为空时是匿名连接
+func StartAuthenticatedFileTcpClient(token string) *network.TcpClient {
+	tcpClient := network.NewTcpClient(&network.TcpClientConfig{
+		Network: "tcp",
+		Timeout: 5 * time.Second,
+		Token:   token,
+	})
+	tcpClient.Init()
+	tcpClient.Start()
+	return tcpClient
+}
+
+func TestDownloadFileShouldReassembleFileWhenFileExistsUnderStoreRoot(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	content := make([]byte, 100*1024+17)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "docs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "data.bin"), content, 0644))
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	destPath := filepath.Join(t.TempDir(), "data.bin")
+	state, err := tcpClient.DownloadFile(fileServerAddr, "/docs/data.bin", destPath)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(content)), state.FileLen)
+	assert.Equal(t, uint32(4), state.NextBlock)
+
+	downloaded, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, downloaded), "downloaded file should equal the source file")
+}
+
+func TestDownloadFileShouldReturnErrorWhenPathEscapesStoreRoot(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	destPath := filepath.Join(t.TempDir(), "passwd")
+	_, err := tcpClient.DownloadFile(fileServerAddr, "../../../../etc/passwd", destPath)
+	assert.Error(t, err)
+
+	_, statErr := os.Stat(destPath)
+	assert.True(t, os.IsNotExist(statErr))
+}
+
+// rejectingProcessor 文件不存在时以ERROR拒绝下载请求
+type rejectingProcessor struct {
+	root string
+}
+
+func (p *rejectingProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	header := frame.Header.(*codec.FileTransfer)
+	if _, err := os.Stat(filepath.Join(p.root, header.FilePath)); err != nil {
+		return nil, &network.ProtocolError{Code: codec.ErrorBadRequest, Message: "file not found"}
+	}
+	return nil, nil
+}
+
+func TestDownloadFileShouldReturnProtocolErrorWhenServerRejectsRequest(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpSrv.AddProcessor(network.FILETRANSFER, &rejectingProcessor{root: root})
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	// ERROR交给下载的接收者，不需要等待传输的空闲超时
+	start := time.Now()
+	_, err := tcpClient.DownloadFile(fileServerAddr, "/missing.bin", filepath.Join(t.TempDir(), "missing.bin"))
+	var protocolErr *network.ProtocolError
+	require.ErrorAs(t, err, &protocolErr)
+	assert.Equal(t, codec.ErrorBadRequest, protocolErr.Code)
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
+
+func TestResumeDownloadShouldOnlyTransferRemainingBlocksWhenPartFileExists(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	content := make([]byte, 100*1024+17)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data.bin"), content, 0644))
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	// 先完整下载一次获取文件ID和校验和
+	state, err := tcpClient.DownloadFile(fileServerAddr, "data.bin", filepath.Join(t.TempDir(), "data.bin"))
+	require.NoError(t, err)
+
+	// 模拟中断：临时文件里只有前两个块
+	destPath := filepath.Join(t.TempDir(), "data.bin")
+	blockSize := int(state.BlockSize)
+	require.NoError(t, os.WriteFile(destPath+".part", content[:2*blockSize], 0644))
+	state.NextBlock = 2
+
+	state, err = tcpClient.ResumeDownload(fileServerAddr, state, destPath)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(4), state.NextBlock)
+
+	downloaded, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, downloaded), "resumed file should equal the source file")
+}
+
+func TestResumeDownloadShouldReturnFileChangedWhenChecksumDiffers(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data.bin"), []byte("version 1"), 0644))
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	state, err := tcpClient.DownloadFile(fileServerAddr, "data.bin", filepath.Join(t.TempDir(), "data.bin"))
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data.bin"), []byte("version 2 is longer"), 0644))
+	state.NextBlock = 1
+
+	_, err = tcpClient.ResumeDownload(fileServerAddr, state, filepath.Join(t.TempDir(), "data.bin"))
+	assert.ErrorIs(t, err, network.Err_File_Transfer_Changed)
+}
+
+func TestUploadFileShouldStoreAndRecordFileWhenUploadCompletes(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	content := make([]byte, 600*1024+5)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	srcPath := filepath.Join(t.TempDir(), "report.bin")
+	require.NoError(t, os.WriteFile(srcPath, content, 0644))
+
+	recorder := &fakeFileRecorder{}
+	tcpSrv := StartFileUploadTcpServer(root, recorder)
+	defer tcpSrv.Stop()
+	tcpClient := StartAuthenticatedFileTcpClient("user-7")
+	defer tcpClient.Stop()
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+
+	fileID, err := tcpClient.UploadFile(fileServerAddr, &network.FileUploadRequest{
+		SrcPath:  srcPath,
+		FileName: "report.bin",
+		UserId:   7,
+		ParentId: 3,
+	})
+	require.NoError(t, err)
+	assert.NotZero(t, fileID)
+
+	require.Len(t, recorder.files, 1)
+	info := recorder.files[0]
+	assert.Equal(t, uint(7), info.UserId)
+	assert.Equal(t, uint(3), info.ParentId)
+	assert.Equal(t, "report.bin", info.OrgFilename)
+
+	stored, err := os.ReadFile(filepath.Join(root, info.Filename))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, stored), "stored file should equal the uploaded file")
+}
+
+func TestUploadFileShouldUseConnectionUserWhenRequestUserDiffers(t *testing.T) {
+	log.InitLogger()
+	srcPath := filepath.Join(t.TempDir(), "report.bin")
+	require.NoError(t, os.WriteFile(srcPath, []byte("report"), 0644))
+	recorder := &fakeFileRecorder{}
+	tcpSrv := StartFileUploadTcpServer(t.TempDir(), recorder)
+	defer tcpSrv.Stop()
+
+	// 匿名连接不能上传
+	anonymous := StartFileTcpClient()
+	defer anonymous.Stop()
+	require.NoError(t, anonymous.Connect(fileServerAddr, dh.GroupX25519))
+	_, err := anonymous.UploadFile(fileServerAddr, &network.FileUploadRequest{SrcPath: srcPath, FileName: "report.bin", UserId: 7})
+	assert.ErrorIs(t, err, network.Err_File_Upload_Forbidden)
+
+	// 无效的令牌在AUTH时被拒绝，连接被关闭
+	forged := StartAuthenticatedFileTcpClient("forged")
+	defer forged.Stop()
+	err = forged.Connect(fileServerAddr, dh.GroupX25519)
+	assert.ErrorIs(t, err, network.Err_Auth_Rejected)
+	assert.Empty(t, forged.ConnID(fileServerAddr))
+
+	tcpClient := StartAuthenticatedFileTcpClient("user-7")
+	defer tcpClient.Stop()
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	_, err = tcpClient.UploadFile(fileServerAddr, &network.FileUploadRequest{SrcPath: srcPath, FileName: "report.bin", UserId: 8})
+	assert.ErrorIs(t, err, network.Err_File_Upload_Forbidden)
+	assert.Empty(t, recorder.files)
+
+	// UserId为0时使用连接认证的用户，RESUME恢复的连接沿用该用户
+	dropConnection(t, tcpSrv, tcpClient, tcpClient.ConnID(fileServerAddr))
+	require.NoError(t, tcpClient.Resume(fileServerAddr))
+	_, err = tcpClient.UploadFile(fileServerAddr, &network.FileUploadRequest{SrcPath: srcPath, FileName: "report.bin"})
+	require.NoError(t, err)
+	require.Len(t, recorder.files, 1)
+	assert.Equal(t, uint(7), recorder.files[0].UserId)
+}
+
+func TestFileUploadProcessorShouldRejectBlocksWhenLengthInvalid(t *testing.T) {
+	const blockSize = 32 * 1024
+	uploadProcs := processor.NewFileUploadProcs(nil, t.TempDir(), &fakeFileRecorder{}, 4*blockSize)
+	conn := &network.Conn{}
+	conn.SetUserId(7)
+
+	upload := func(seq uint64, fileLen uint64) *codec.FileUploadAck {
+		frame := network.NewFrame(network.FILEUPLOAD, &codec.FileUpload{FileName: "a.bin", FileLen: fileLen}, nil)
+		frame.Seq = seq
+		resp, err := uploadProcs.Process(conn, frame)
+		require.NoError(t, err)
+		return resp.Header.(*codec.FileUploadAck)
+	}
+	block := func(seq uint64, fileID uint32, blockSeq uint32, length int) *network.Frame {
+		frame := network.NewFrame(network.TRANSFER, &codec.Transfer{FileID: fileID, Seq: blockSeq}, make([]byte, length))
+		frame.Seq = seq
+		resp, err := uploadProcs.Process(conn, frame)
+		require.NoError(t, err)
+		return resp
+	}
+	assertRejected := func(resp *network.Frame) {
+		ack, ok := resp.Header.(*codec.FileUploadAck)
+		require.True(t, ok)
+		assert.Equal(t, codec.FileTransferInvalidRange, ack.ErrorCode)
+		assert.True(t, ack.Done)
+	}
+
+	// 超过上限的文件在协商时被拒绝
+	assert.Equal(t, codec.FileTransferTooLarge, upload(1, 4*blockSize+1).ErrorCode)
+
+	// 中间的块短于块大小
+	ack := upload(2, 2*blockSize+5)
+	require.Equal(t, codec.FileTransferOK, ack.ErrorCode)
+	assertRejected(block(2, ack.FileID, 0, blockSize-1))
+
+	// 块长于块大小
+	ack = upload(3, 2*blockSize+5)
+	assertRejected(block(3, ack.FileID, 0, blockSize+1))
+
+	// 最后一块超出FileLen
+	ack = upload(4, 2*blockSize+5)
+	assert.IsType(t, &codec.TransferAck{}, block(4, ack.FileID, 0, blockSize).Header)
+	assert.IsType(t, &codec.TransferAck{}, block(4, ack.FileID, 1, blockSize).Header)
+	assertRejected(block(4, ack.FileID, 2, 6))
+}
+
+func TestResumeDownloadShouldReturnFileChangedWhenFileEvictedFromCache(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte("content of "+name), 0644))
+	}
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	// 只缓存两个文件的元数据
+	fileTransferProcs := processor.NewFileTransferProcs(tcpSrv, root, 2)
+	tcpSrv.AddProcessor(network.FILETRANSFER, fileTransferProcs)
+	tcpSrv.AddProcessor(network.TRANSFERACK, fileTransferProcs)
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	states := make(map[string]*network.FileTransferState)
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		state, err := tcpClient.DownloadFile(fileServerAddr, name, filepath.Join(t.TempDir(), name))
+		require.NoError(t, err)
+		states[name] = state
+	}
+
+	// a.bin最久没有使用，文件ID已经被淘汰
+	states["a.bin"].NextBlock = 0
+	_, err := tcpClient.ResumeDownload(fileServerAddr, states["a.bin"], filepath.Join(t.TempDir(), "a.bin"))
+	assert.ErrorIs(t, err, network.Err_File_Transfer_Changed)
+
+	states["c.bin"].NextBlock = 0
+	destPath := filepath.Join(t.TempDir(), "c.bin")
+	_, err = tcpClient.ResumeDownload(fileServerAddr, states["c.bin"], destPath)
+	require.NoError(t, err)
+	downloaded, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, "content of c.bin", string(downloaded))
+}
@@ -0,0 +1,222 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"go-networking/network/codec"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// 已发送但尚未被TRANSFERACK确认的最大块数
+const uploadWindow = 16
+
+var (
+	Err_File_Upload_Checksum_Mismatch = errors.New("uploaded file checksum mismatch")
+	Err_File_Upload_Forbidden         = errors.New("file upload requires the authenticated user of the connection")
+	Err_File_Upload_Too_Large         = errors.New("file exceeds the upload size limit of the server")
+)
+
+// FileUploadRequest 描述一次上传，ParentId会写入服务端记录的FileInfo。
+// 文件记录在AUTH认证的用户名下，需要先以TcpClientConfig.Token完成握手
+type FileUploadRequest struct {
+	SrcPath  string
+	FileName string
+	// 为0或者与连接认证的用户相同，否则服务端拒绝上传
+	UserId   uint32
+	ParentId uint32
+}
+
+// fileUploader 接收同一个FILEUPLOAD请求的FILEUPLOADACK和TRANSFERACK帧
+type fileUploader struct {
+	mu     sync.Mutex
+	ready  chan *codec.FileUploadAck
+	ackCh  chan struct{}
+	doneCh chan struct{}
+	acked  uint32
+	err    error
+	once   sync.Once
+}
+
+func newFileUploader() *fileUploader {
+	return &fileUploader{
+		ready:  make(chan *codec.FileUploadAck, 1),
+		ackCh:  make(chan struct{}, 1),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// UploadFile 将本地文件上传到服务端的存储目录，返回服务端分配的文件ID。
+// 服务端在全部块落盘、校验通过并记录FileInfo后才回复完成。
+func (c *TcpClient) UploadFile(serverAddr string, req *FileUploadRequest) (uint32, error) {
+	file, err := os.Open(req.SrcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, file); err != nil {
+		return 0, err
+	}
+
+	frame := NewFrame(FILEUPLOAD, &codec.FileUpload{
+		FileName: req.FileName,
+		FileLen:  uint64(stat.Size()),
+		Checksum: hash.Sum32(),
+		UserId:   req.UserId,
+		ParentId: req.ParentId,
+	}, nil)
+	frame.Seq = uint64(c.seqIncr.Increment())
+
+	// 先登记再发送请求，避免FILEUPLOADACK先于登记到达
+	uploader := newFileUploader()
+	c.addReceiver(frame.Seq, uploader)
+	defer c.delReceiver(frame.Seq)
+
+	if err := c.doSendAsync(serverAddr, frame); err != nil {
+		return 0, err
+	}
+
+	var ack *codec.FileUploadAck
+	select {
+	case ack = <-uploader.ready:
+	case <-uploader.doneCh:
+		return 0, uploader.err
+	case <-time.After(transferIdleTimeout):
+		return 0, errors.New("waiting for file upload ack timeout")
+	}
+
+	block := make([]byte, ack.BlockSize)
+	totalBlocks := uint32((uint64(stat.Size()) + uint64(ack.BlockSize) - 1) / uint64(ack.BlockSize))
+	for blockSeq := uint32(0); blockSeq < totalBlocks; blockSeq++ {
+		if blockSeq >= uploadWindow {
+			if err := uploader.waitAcked(blockSeq - uploadWindow + 1); err != nil {
+				return ack.FileID, err
+			}
+		}
+
+		n, err := file.ReadAt(block, int64(blockSeq)*int64(ack.BlockSize))
+		if err != nil && err != io.EOF {
+			return ack.FileID, err
+		}
+
+		transferFrame := NewFrame(TRANSFER, &codec.Transfer{
+			FileID: ack.FileID,
+			Seq:    blockSeq,
+		}, block[:n])
+		transferFrame.Seq = frame.Seq
+		if err := c.doSendAsync(serverAddr, transferFrame); err != nil {
+			return ack.FileID, err
+		}
+	}
+
+	return ack.FileID, uploader.wait()
+}
+
+func (u *fileUploader) onFrame(frame *Frame) {
+	switch header := frame.Header.(type) {
+	case *codec.FileUploadAck:
+		u.onUploadAck(header)
+	case *codec.TransferAck:
+		u.mu.Lock()
+		if header.Seq >= u.acked {
+			u.acked = header.Seq + 1
+		}
+		u.mu.Unlock()
+
+		select {
+		case u.ackCh <- struct{}{}:
+		default:
+		}
+	default:
+		u.finish(fmt.Errorf("unexpected header type for file upload, cmd type: %d", frame.CmdType))
+	}
+}
+
+func (u *fileUploader) onUploadAck(ack *codec.FileUploadAck) {
+	switch {
+	case ack.ErrorCode == codec.FileTransferChecksumMismatch:
+		u.finish(Err_File_Upload_Checksum_Mismatch)
+	case ack.ErrorCode == codec.FileTransferForbidden:
+		u.finish(Err_File_Upload_Forbidden)
+	case ack.ErrorCode == codec.FileTransferTooLarge:
+		u.finish(Err_File_Upload_Too_Large)
+	case ack.ErrorCode != codec.FileTransferOK:
+		u.finish(fmt.Errorf("file upload rejected by server, error code: %d", ack.ErrorCode))
+	case ack.Done:
+		u.finish(nil)
+	case ack.BlockSize == 0:
+		u.finish(errors.New("file upload ack with zero block size"))
+	default:
+		select {
+		case u.ready <- ack:
+		default:
+		}
+	}
+}
+
+// waitAcked 等待直到target之前的块均被确认，只要持续收到确认就不会超时
+func (u *fileUploader) waitAcked(target uint32) error {
+	timer := time.NewTimer(transferIdleTimeout)
+	defer timer.Stop()
+
+	for {
+		u.mu.Lock()
+		acked := u.acked
+		u.mu.Unlock()
+		if acked >= target {
+			return nil
+		}
+
+		select {
+		case <-u.ackCh:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(transferIdleTimeout)
+		case <-u.doneCh:
+			if u.err != nil {
+				return u.err
+			}
+			return errors.New("file upload finished before all blocks were sent")
+		case <-timer.C:
+			return errors.New("waiting for transfer ack timeout")
+		}
+	}
+}
+
+// wait 等待服务端回复上传完成
+func (u *fileUploader) wait() error {
+	timer := time.NewTimer(transferIdleTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-u.doneCh:
+			return u.err
+		case <-u.ackCh:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(transferIdleTimeout)
+		case <-timer.C:
+			u.finish(errors.New("waiting for file upload timeout"))
+			return u.err
+		}
+	}
+}
+
+func (u *fileUploader) finish(err error) {
+	u.once.Do(func() {
+		u.err = err
+		close(u.doneCh)
+	})
+}
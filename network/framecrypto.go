@@ -0,0 +1,133 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"go-networking/crypto/aes"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// 密文前携带的显式计数器长度
+	counterLen = 8
+	// AES-GCM的nonce长度，前4字节固定为0，后8字节为计数器。帧的计数器从1开始，计数器0保留给SealTicket
+	gcmNonceLen = 12
+	// 密钥轮换后仍接受旧密钥加密的帧的时间，用于接收轮换前已经发出的帧
+	rekeyGraceWindow = 10 * time.Second
+)
+
+var (
+	Err_Frame_Auth_Failed = errors.New("frame authentication failed")
+	Err_Counter_Exhausted = errors.New("frame counter exhausted, rekey required")
+	Err_Rekey_Unsupported = errors.New("connection crypto does not support rekey")
+)
+
+// KeyRotator 支持在连接上轮换会话密钥的加密算法
+type KeyRotator interface {
+	Rotate(sendKey []byte, recvKey []byte)
+}
+
+// gcmKey 一个方向上的密钥和该密钥下最后使用的计数器
+type gcmKey struct {
+	key     []byte
+	counter uint64
+}
+
+// GcmCrypto 使用AES-GCM加密一个连接上的帧。
+// 两个方向使用不同的密钥，每个方向的计数器从1开始递增并作为nonce，
+// 计数器明文放在密文之前，接收方要求计数器严格递增，重放或乱序的帧会被拒绝。
+// 轮换密钥后计数器重新从1开始，旧的接收密钥在宽限期内仍然有效，
+// 直到收到第一个使用新密钥的帧。
+type GcmCrypto struct {
+	mu   sync.Mutex
+	send gcmKey
+	recv gcmKey
+	// 轮换前的接收密钥，宽限期结束或对端改用新密钥后丢弃
+	prevRecv       *gcmKey
+	prevRecvExpiry time.Time
+}
+
+// NewGcmCrypto sendKey用于加密本端发出的帧，recvKey用于解密对端发来的帧
+func NewGcmCrypto(sendKey []byte, recvKey []byte) *GcmCrypto {
+	return &GcmCrypto{
+		send: gcmKey{key: sendKey},
+		recv: gcmKey{key: recvKey},
+	}
+}
+
+// Encrypt 返回计数器和密文，aad为帧开头的Version、CmdType、Seq和Flags，只认证不加密
+func (gc *GcmCrypto) Encrypt(plain []byte, aad []byte) ([]byte, error) {
+	gc.mu.Lock()
+	if gc.send.counter == math.MaxUint64 {
+		gc.mu.Unlock()
+		return nil, Err_Counter_Exhausted
+	}
+	gc.send.counter++
+	counter := gc.send.counter
+	key := gc.send.key
+	gc.mu.Unlock()
+
+	sealed, err := aes.AesEncryptWithAAD(plain, key, gcmNonce(counter), aad)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, counterLen, counterLen+len(sealed))
+	binary.BigEndian.PutUint64(encrypted, counter)
+	return append(encrypted, sealed...), nil
+}
+
+// Decrypt 校验计数器和认证标签，失败时返回Err_Frame_Auth_Failed
+func (gc *GcmCrypto) Decrypt(encrypted []byte, aad []byte) ([]byte, error) {
+	if len(encrypted) < counterLen {
+		return nil, Err_Frame_Auth_Failed
+	}
+
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if plain, ok := open(&gc.recv, encrypted, aad); ok {
+		// 对端已经改用新密钥，之后不会再有旧密钥加密的帧
+		gc.prevRecv = nil
+		return plain, nil
+	}
+	if gc.prevRecv != nil && time.Now().Before(gc.prevRecvExpiry) {
+		if plain, ok := open(gc.prevRecv, encrypted, aad); ok {
+			return plain, nil
+		}
+	}
+	return nil, Err_Frame_Auth_Failed
+}
+
+// Rotate 改用新的会话密钥，发送立即使用新密钥，旧的接收密钥保留rekeyGraceWindow
+func (gc *GcmCrypto) Rotate(sendKey []byte, recvKey []byte) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	prevRecv := gc.recv
+	gc.prevRecv = &prevRecv
+	gc.prevRecvExpiry = time.Now().Add(rekeyGraceWindow)
+	gc.send = gcmKey{key: sendKey}
+	gc.recv = gcmKey{key: recvKey}
+}
+
+// open 使用k解密，认证通过后才推进计数器，伪造的帧不能影响之后的合法帧
+func open(k *gcmKey, encrypted []byte, aad []byte) ([]byte, bool) {
+	counter := binary.BigEndian.Uint64(encrypted[:counterLen])
+	if counter <= k.counter {
+		return nil, false
+	}
+
+	plain, err := aes.AesDecryptWithAAD(encrypted[counterLen:], gcmNonce(counter), k.key, aad)
+	if err != nil {
+		return nil, false
+	}
+	k.counter = counter
+	return plain, true
+}
+
+func gcmNonce(counter uint64) []byte {
+	nonce := make([]byte, gcmNonceLen)
+	binary.BigEndian.PutUint64(nonce[gcmNonceLen-counterLen:], counter)
+	return nonce
+}
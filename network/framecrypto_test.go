@@ -0,0 +1,195 @@
+package network_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"go-networking/crypto/dh"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newGcmPair(t *testing.T) (*network.GcmCrypto, *network.GcmCrypto) {
+	cKey := make([]byte, 32)
+	sKey := make([]byte, 32)
+	_, err := rand.Read(cKey)
+	require.NoError(t, err)
+	_, err = rand.Read(sKey)
+	require.NoError(t, err)
+	return network.NewGcmCrypto(cKey, sKey), network.NewGcmCrypto(sKey, cKey)
+}
+
+func newListDirFrame(seq uint64, payload []byte) *network.Frame {
+	frame := network.NewFrame(network.LISTDIR, &codec.ListDirHeader{Id: strings.Repeat("a", 32), Timestamp: 1}, payload)
+	frame.Seq = seq
+	return frame
+}
+
+func TestCryptoLVCodecShouldRoundTripWhenKeysMatch(t *testing.T) {
+	client, server := newGcmPair(t)
+	payload := []byte("payload that must not appear on the wire")
+
+	for i := 0; i < 3; i++ {
+		data, err := network.NewCryptoLVCodec(client).Encode(newListDirFrame(uint64(i+1), payload))
+		require.NoError(t, err)
+		assert.False(t, bytes.Contains(data, payload), "payload should be encrypted")
+
+		frame, err := network.NewCryptoLVCodec(server).Decode(data[1:])
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i+1), frame.Seq)
+		assert.Equal(t, payload, frame.Payload)
+	}
+}
+
+func TestCryptoLVCodecShouldRejectFrameWhenReplayed(t *testing.T) {
+	client, server := newGcmPair(t)
+
+	data, err := network.NewCryptoLVCodec(client).Encode(newListDirFrame(1, []byte("once")))
+	require.NoError(t, err)
+	_, err = network.NewCryptoLVCodec(server).Decode(data[1:])
+	require.NoError(t, err)
+
+	_, err = network.NewCryptoLVCodec(server).Decode(data[1:])
+	assert.ErrorIs(t, err, network.Err_Frame_Auth_Failed)
+}
+
+func TestCryptoLVCodecShouldRejectFrameWhenTampered(t *testing.T) {
+	payload := []byte("tamper me")
+	// Seq不同的两个明文帧只在Seq处不同，用它定位帧头中的字节
+	plain5, err := network.NewLVCodec().Encode(newListDirFrame(5, payload))
+	require.NoError(t, err)
+	plain6, err := network.NewLVCodec().Encode(newListDirFrame(6, payload))
+	require.NoError(t, err)
+	seqIdx := 0
+	for plain5[seqIdx] == plain6[seqIdx] {
+		seqIdx++
+	}
+
+	for name, idx := range map[string]int{"header": seqIdx, "payload": -1} {
+		client, server := newGcmPair(t)
+		data, err := network.NewCryptoLVCodec(client).Encode(newListDirFrame(5, payload))
+		require.NoError(t, err)
+		if idx < 0 {
+			idx = len(data) - 1
+		}
+		data[idx] ^= 0x01
+
+		_, err = network.NewCryptoLVCodec(server).Decode(data[1:])
+		assert.ErrorIs(t, err, network.Err_Frame_Auth_Failed, name)
+	}
+}
+
+func TestCryptoCodecsShouldEncryptHeaderWhenHandshakeCompleted(t *testing.T) {
+	block := []byte("file block bytes that must not appear on the wire")
+	filePath := "/secret/report.pdf"
+	for name, newCodec := range map[string]func(network.CryptoAlg) network.Codec{
+		"lv":       func(crypto network.CryptoAlg) network.Codec { return network.NewCryptoLVCodec(crypto) },
+		"protobuf": func(crypto network.CryptoAlg) network.Codec { return network.NewProtobufCodec().WithCrypto(crypto) },
+	} {
+		client, server := newGcmPair(t)
+		transfer := network.NewFrame(network.TRANSFER, &codec.Transfer{FileID: 7, Seq: 3}, block)
+		request := network.NewFrame(network.FILETRANSFER, &codec.FileTransfer{FilePath: filePath}, nil)
+
+		for _, frame := range []*network.Frame{transfer, request} {
+			data, err := newCodec(client).Encode(frame)
+			require.NoError(t, err, name)
+			assert.False(t, bytes.Contains(data, block), "%s: block should be encrypted", name)
+			assert.False(t, bytes.Contains(data, []byte(filePath)), "%s: file path should be encrypted", name)
+
+			decoded, err := newCodec(server).Decode(stripLength(t, data))
+			require.NoError(t, err, name)
+			assert.Equal(t, frame.Header, decoded.Header, name)
+			assert.True(t, bytes.Equal(frame.Payload, decoded.Payload), name)
+			assert.NotZero(t, decoded.Flags&network.FlagEncrypted, name)
+		}
+	}
+}
+
+func TestCryptoLVCodecShouldRejectPlaintextFrameWhenHandshakeCompleted(t *testing.T) {
+	_, server := newGcmPair(t)
+	// 去掉FlagEncrypted的明文帧不能绕过认证
+	data, err := network.NewLVCodec().Encode(newListDirFrame(1, []byte("plain")))
+	require.NoError(t, err)
+	_, err = network.NewCryptoLVCodec(server).Decode(data[1:])
+	assert.ErrorIs(t, err, network.Err_Frame_Auth_Failed)
+
+	// 握手应答仍然以明文传输
+	data, err = network.NewLVCodec().Encode(network.NewFrame(network.CONNACK, &codec.ConnAckHeader{Timestamp: 1}, []byte("public key")))
+	require.NoError(t, err)
+	frame, err := network.NewCryptoLVCodec(server).Decode(data[1:])
+	require.NoError(t, err)
+	assert.Equal(t, []byte("public key"), frame.Payload)
+
+	// 已有会话时再次握手会覆盖会话密钥，CONN和RESUME都被拒绝
+	for _, handshake := range []*network.Frame{
+		network.NewFrame(network.CONN, &codec.ConnHeader{Timestamp: 1}, []byte("public key")),
+		network.NewFrame(network.RESUME, &codec.ResumeHeader{Ticket: "ticket"}, make([]byte, 32)),
+	} {
+		data, err = network.NewLVCodec().Encode(handshake)
+		require.NoError(t, err)
+		_, err = network.NewCryptoLVCodec(server).Decode(data[1:])
+		assert.ErrorIs(t, err, network.Err_Frame_Auth_Failed)
+	}
+}
+
+func TestConnectShouldCloseConnectionWhenHandshakeRepeatedOnSession(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	connID := tcpClient.ConnID(fileServerAddr)
+	connCtx, exists := tcpSrv.CManager.LoadCtx(connID)
+	require.True(t, exists)
+	cKey, sKey := connCtx.Keys()
+
+	// 同一连接上的第二个明文CONN不能替换会话密钥，服务端关闭连接
+	err := tcpClient.Connect(fileServerAddr, dh.GroupX25519)
+	assert.ErrorIs(t, err, network.Err_Frame_Auth_Failed)
+	afterC, afterS := connCtx.Keys()
+	assert.Equal(t, cKey, afterC)
+	assert.Equal(t, sKey, afterS)
+	assert.Eventually(t, func() bool {
+		return tcpClient.ConnID(fileServerAddr) == ""
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestConnectShouldEncryptFramesWhenHandshakeCompleted(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	content := make([]byte, 256*1024+7)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data.bin"), content, 0644))
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+
+	ack, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+	require.Len(t, ack.Entries, 1)
+	assert.Equal(t, "data.bin", ack.Entries[0].Name)
+
+	destPath := filepath.Join(t.TempDir(), "data.bin")
+	_, err = tcpClient.DownloadFile(fileServerAddr, "/data.bin", destPath)
+	require.NoError(t, err)
+	downloaded, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, downloaded), "downloaded file should equal the source file")
+
+	require.NoError(t, tcpClient.Close(fileServerAddr))
+}
@@ -0,0 +1,229 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/cloudwego/netpoll"
+)
+
+const (
+	// 默认的最大帧长度，不含长度前缀，需要容纳一页LISTDIRACK
+	DefaultMaxFrameSize uint32 = 4 << 20
+	// 默认的最大Header长度，为协议允许的最大值
+	DefaultMaxHeaderLen uint16 = math.MaxUint16
+)
+
+var (
+	Err_Frame_Too_Large  = errors.New("frame exceeds the maximum frame size")
+	Err_Header_Too_Large = errors.New("frame header exceeds the maximum header length")
+	Err_Invalid_Length   = errors.New("invalid frame length prefix")
+)
+
+// FrameLimits 解码时允许的帧大小，为0的字段使用默认值
+type FrameLimits struct {
+	// 长度前缀之后的帧长度上限
+	MaxFrameSize uint32
+	// Header长度上限
+	MaxHeaderLen uint16
+}
+
+func (l FrameLimits) maxFrameSize() uint32 {
+	if l.MaxFrameSize == 0 {
+		return DefaultMaxFrameSize
+	}
+	return l.MaxFrameSize
+}
+
+func (l FrameLimits) maxHeaderLen() uint16 {
+	if l.MaxHeaderLen == 0 {
+		return DefaultMaxHeaderLen
+	}
+	return l.MaxHeaderLen
+}
+
+// checkFrameLen 在分配帧的内存之前校验长度前缀
+func (l FrameLimits) checkFrameLen(frameLen uint64) error {
+	if frameLen == 0 {
+		return Err_Invalid_Length
+	}
+	if frameLen > uint64(l.maxFrameSize()) {
+		return fmt.Errorf("%w: %d > %d", Err_Frame_Too_Large, frameLen, l.maxFrameSize())
+	}
+	return nil
+}
+
+// decode 解码一个不含长度前缀的帧并校验Header长度，整个帧的长度已经受MaxFrameSize限制
+func (l FrameLimits) decode(data []byte, codec Codec) (*Frame, error) {
+	if codec == nil {
+		codec = DefaultCodecs
+	}
+	frame, err := codec.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if frame.HLen > l.maxHeaderLen() {
+		return nil, fmt.Errorf("%w: %d > %d", Err_Header_Too_Large, frame.HLen, l.maxHeaderLen())
+	}
+	return frame, nil
+}
+
+// ReadFrame 从netpoll连接读取一个完整的帧，codec需要已经绑定连接的加密算法，为nil时使用DefaultCodecs。
+// 数据不足时阻塞等待，长度前缀超过MaxFrameSize时在读取帧内容之前返回Err_Frame_Too_Large。
+func ReadFrame(reader netpoll.Reader, limits FrameLimits, codec Codec) (*Frame, error) {
+	frameLen, prefixLen, err := peekFrameLen(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := limits.checkFrameLen(frameLen); err != nil {
+		return nil, err
+	}
+
+	if err := reader.Skip(prefixLen); err != nil {
+		return nil, err
+	}
+	data, err := reader.Next(int(frameLen))
+	if err != nil {
+		return nil, err
+	}
+	// Decode会复制Header和Payload，解码后即可释放读缓冲
+	defer reader.Release()
+	return limits.decode(data, codec)
+}
+
+// peekFrameLen 逐字节查看长度前缀，不会越过前缀读取帧内容
+func peekFrameLen(reader netpoll.Reader) (uint64, int, error) {
+	for n := 1; n <= binary.MaxVarintLen32; n++ {
+		prefix, err := reader.Peek(n)
+		if err != nil {
+			return 0, 0, err
+		}
+		if prefix[n-1] < 0x80 {
+			frameLen, _ := binary.Uvarint(prefix)
+			return frameLen, n, nil
+		}
+	}
+	return 0, 0, Err_Invalid_Length
+}
+
+// StreamDecoder 有状态的流式解码器，用于io.Reader或自行收取的字节流。
+// 数据可以分多次以任意长度写入，解码器只缓存当前帧，
+// 长度前缀超过MaxFrameSize时立即返回Err_Frame_Too_Large，不会为该帧分配内存。
+type StreamDecoder struct {
+	limits FrameLimits
+	codec  Codec
+	crypto CryptoAlg
+	// 尚未组成完整帧的数据
+	buf []byte
+	// 当前帧的长度，未读到完整的长度前缀时为0
+	frameLen int
+	// 出现协议错误后字节流无法再同步，之后的调用都返回该错误
+	err error
+}
+
+func NewStreamDecoder(limits FrameLimits, crypto CryptoAlg) *StreamDecoder {
+	return &StreamDecoder{
+		limits: limits,
+		codec:  DefaultCodecs,
+		crypto: crypto,
+	}
+}
+
+// SetCodec 使用指定的线上格式，默认为DefaultCodecs
+func (d *StreamDecoder) SetCodec(codec Codec) {
+	d.codec = codec
+}
+
+// SetCrypto 握手完成后设置加密算法，之后解码的帧需要解密
+func (d *StreamDecoder) SetCrypto(crypto CryptoAlg) {
+	d.crypto = crypto
+}
+
+// Feed 追加收到的数据，返回其中所有完整的帧，剩余的数据留到下一次
+func (d *StreamDecoder) Feed(data []byte) ([]*Frame, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	var frames []*Frame
+	for len(data) > 0 {
+		if d.frameLen == 0 {
+			// 长度前缀最多5字节，逐字节缓存
+			d.buf = append(d.buf, data[0])
+			data = data[1:]
+			if d.buf[len(d.buf)-1] >= 0x80 {
+				if len(d.buf) >= binary.MaxVarintLen32 {
+					return frames, d.fail(Err_Invalid_Length)
+				}
+				continue
+			}
+			frameLen, _ := binary.Uvarint(d.buf)
+			if err := d.limits.checkFrameLen(frameLen); err != nil {
+				return frames, d.fail(err)
+			}
+			d.frameLen = int(frameLen)
+			d.buf = make([]byte, 0, d.frameLen)
+			continue
+		}
+
+		n := d.frameLen - len(d.buf)
+		if n > len(data) {
+			n = len(data)
+		}
+		d.buf = append(d.buf, data[:n]...)
+		data = data[n:]
+		if len(d.buf) < d.frameLen {
+			continue
+		}
+
+		frame, err := d.limits.decode(d.buf, d.codec.WithCrypto(d.crypto))
+		if err != nil {
+			return frames, d.fail(err)
+		}
+		frames = append(frames, frame)
+		d.buf = nil
+		d.frameLen = 0
+	}
+	return frames, nil
+}
+
+// ReadFrame 从reader读取直到得到一个完整的帧。
+// 每次只读取当前帧剩余的长度，不会读到下一帧的数据。
+func (d *StreamDecoder) ReadFrame(reader io.Reader) (*Frame, error) {
+	one := make([]byte, 1)
+	for {
+		var chunk []byte
+		if d.frameLen == 0 {
+			chunk = one
+		} else {
+			chunk = make([]byte, d.frameLen-len(d.buf))
+		}
+
+		n, err := io.ReadAtLeast(reader, chunk, 1)
+		if n > 0 {
+			frames, feedErr := d.Feed(chunk[:n])
+			if feedErr != nil {
+				return nil, feedErr
+			}
+			// 每次最多读到当前帧的末尾，只会得到一个帧
+			if len(frames) != 0 {
+				return frames[0], nil
+			}
+		}
+		if err != nil {
+			if err == io.EOF && (d.frameLen != 0 || len(d.buf) != 0) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+func (d *StreamDecoder) fail(err error) error {
+	d.err = err
+	d.buf = nil
+	return err
+}
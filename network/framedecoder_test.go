@@ -0,0 +1,108 @@
+package network_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeListDirFrames(t *testing.T, payloads ...string) []byte {
+	var stream []byte
+	for i, payload := range payloads {
+		data, err := network.NewLVCodec().Encode(newListDirFrame(uint64(i+1), []byte(payload)))
+		require.NoError(t, err)
+		stream = append(stream, data...)
+	}
+	return stream
+}
+
+func TestStreamDecoderShouldDecodeFramesWhenFedInPieces(t *testing.T) {
+	payloads := []string{"first", "", strings.Repeat("x", 300)}
+	stream := encodeListDirFrames(t, payloads...)
+
+	for _, chunkSize := range []int{1, 7, len(stream)} {
+		decoder := network.NewStreamDecoder(network.FrameLimits{}, nil)
+		var frames []*network.Frame
+		for start := 0; start < len(stream); start += chunkSize {
+			end := start + chunkSize
+			if end > len(stream) {
+				end = len(stream)
+			}
+			decoded, err := decoder.Feed(stream[start:end])
+			require.NoError(t, err)
+			frames = append(frames, decoded...)
+		}
+
+		require.Len(t, frames, len(payloads), "chunk size %d", chunkSize)
+		for i, frame := range frames {
+			assert.Equal(t, uint64(i+1), frame.Seq)
+			assert.Equal(t, payloads[i], string(frame.Payload))
+		}
+	}
+}
+
+func TestStreamDecoderShouldReadFramesWhenReaderReturnsOneByte(t *testing.T) {
+	stream := encodeListDirFrames(t, "a", "bc")
+	decoder := network.NewStreamDecoder(network.FrameLimits{}, nil)
+	reader := iotest.OneByteReader(bytes.NewReader(stream))
+
+	for _, payload := range []string{"a", "bc"} {
+		frame, err := decoder.ReadFrame(reader)
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(frame.Payload))
+	}
+}
+
+func TestStreamDecoderShouldRejectFrameWhenLengthPrefixExceedsLimit(t *testing.T) {
+	// 只发送长度前缀，解码器不能等待帧内容
+	prefix := binary.AppendUvarint(nil, 1<<31)
+	decoder := network.NewStreamDecoder(network.FrameLimits{MaxFrameSize: 1024}, nil)
+	_, err := decoder.Feed(prefix)
+	assert.ErrorIs(t, err, network.Err_Frame_Too_Large)
+
+	// 出错后字节流无法再同步
+	_, err = decoder.Feed(encodeListDirFrames(t, "a"))
+	assert.ErrorIs(t, err, network.Err_Frame_Too_Large)
+
+	// 超过5字节仍未结束的长度前缀
+	decoder = network.NewStreamDecoder(network.FrameLimits{}, nil)
+	_, err = decoder.Feed(bytes.Repeat([]byte{0xFF}, 6))
+	assert.ErrorIs(t, err, network.Err_Invalid_Length)
+}
+
+func TestStreamDecoderShouldRejectFrameWhenHeaderExceedsLimit(t *testing.T) {
+	stream := encodeListDirFrames(t, "a")
+	decoder := network.NewStreamDecoder(network.FrameLimits{MaxHeaderLen: 8}, nil)
+	_, err := decoder.Feed(stream)
+	assert.ErrorIs(t, err, network.Err_Header_Too_Large)
+}
+
+func TestTcpServerShouldCloseConnectionWhenFrameExceedsLimit(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartConfiguredFileTcpServer(t.TempDir(), &fakeFileRecorder{}, func(config *network.TcpServerConfig) {
+		config.Limits = network.FrameLimits{MaxFrameSize: 1024}
+	})
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	frame := network.NewFrame(network.LISTDIR, &codec.ListDirHeader{
+		Id:        strings.Repeat("a", 32),
+		Timestamp: time.Now().Unix(),
+	}, make([]byte, 4096))
+	_, err := tcpClient.SendSync(fileServerAddr, frame, 5*time.Second)
+	assert.ErrorIs(t, err, network.Err_Frame_Too_Large)
+
+	// 之后的请求使用新的连接
+	_, err = tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,55 @@
+package network
+
+import "go-networking/network/codec"
+
+// 注册内置命令的Header编解码器，服务端和客户端共用。
+func init() {
+	AddHeaderCodec(CONN, &codec.ConnHeaderCodec{})
+	AddHeaderCodec(CONNACK, &codec.ConnAckHeaderCodec{})
+	AddHeaderCodec(PING, &codec.PingHeaderCodec{})
+	AddHeaderCodec(PONG, &codec.PongHeaderCodec{})
+	AddHeaderCodec(CLOSE, &codec.CloseHeaderCodec{})
+	AddHeaderCodec(CLOSEACK, &codec.CloseAckHeaderCodec{})
+	AddHeaderCodec(FILETRANSFER, &codec.FileTransferCodec{})
+	AddHeaderCodec(FILETRANSFERACK, &codec.FileTransferAckCodec{})
+	AddHeaderCodec(TRANSFER, &codec.TransferCodec{})
+	AddHeaderCodec(TRANSFERACK, &codec.TransferAckCodec{})
+	AddHeaderCodec(FILEUPLOAD, &codec.FileUploadCodec{})
+	AddHeaderCodec(FILEUPLOADACK, &codec.FileUploadAckCodec{})
+	AddHeaderCodec(LISTDIR, &codec.ListDirHeaderCodec{})
+	AddHeaderCodec(LISTDIRACK, &codec.ListDirAckHeaderCodec{})
+
+	AddProtobufHeaderCodec(CONN, &codec.ConnHeaderPBCodec{})
+	AddProtobufHeaderCodec(CONNACK, &codec.ConnAckHeaderPBCodec{})
+	AddProtobufHeaderCodec(PING, &codec.PingHeaderPBCodec{})
+	AddProtobufHeaderCodec(PONG, &codec.PongHeaderPBCodec{})
+	AddProtobufHeaderCodec(CLOSE, &codec.CloseHeaderPBCodec{})
+	AddProtobufHeaderCodec(CLOSEACK, &codec.CloseAckHeaderPBCodec{})
+	AddProtobufHeaderCodec(FILETRANSFER, &codec.FileTransferPBCodec{})
+	AddProtobufHeaderCodec(FILETRANSFERACK, &codec.FileTransferAckPBCodec{})
+	AddProtobufHeaderCodec(TRANSFER, &codec.TransferPBCodec{})
+	AddProtobufHeaderCodec(TRANSFERACK, &codec.TransferAckPBCodec{})
+	AddProtobufHeaderCodec(FILEUPLOAD, &codec.FileUploadPBCodec{})
+	AddProtobufHeaderCodec(FILEUPLOADACK, &codec.FileUploadAckPBCodec{})
+	AddProtobufHeaderCodec(LISTDIR, &codec.ListDirHeaderPBCodec{})
+	AddProtobufHeaderCodec(LISTDIRACK, &codec.ListDirAckHeaderPBCodec{})
+
+	AddStructHeaderCodec(REKEY, &codec.RekeyHeader{})
+	AddStructHeaderCodec(REKEYACK, &codec.RekeyAckHeader{})
+	AddStructHeaderCodec(RESUME, &codec.ResumeHeader{})
+	AddStructHeaderCodec(RESUMEACK, &codec.ResumeAckHeader{})
+	AddStructHeaderCodec(ERROR, &codec.ErrorHeader{})
+	AddStructHeaderCodec(NOTIFY, &codec.NotifyHeader{})
+	AddStructHeaderCodec(SUBSCRIBE, &codec.SubscribeHeader{})
+	AddStructHeaderCodec(UNSUBSCRIBE, &codec.SubscribeHeader{})
+	AddStructHeaderCodec(SUBSCRIBEACK, &codec.SubscribeAckHeader{})
+	AddStructHeaderCodec(PUBLISH, &codec.PublishHeader{})
+	AddStructHeaderCodec(AUTH, &codec.AuthHeader{})
+	AddStructHeaderCodec(AUTHACK, &codec.AuthAckHeader{})
+}
+
+// AddStructHeaderCodec 按header的wire标签同时注册LV和protobuf两种格式的编解码器，标签不合法时panic
+func AddStructHeaderCodec(cmdType CommandType, header interface{}) {
+	AddHeaderCodec(cmdType, codec.MustStructCodec(header))
+	AddProtobufHeaderCodec(cmdType, codec.MustStructPBCodec(header))
+}
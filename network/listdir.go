@@ -0,0 +1,85 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-networking/network/codec"
+	"time"
+)
+
+var (
+	Err_List_Dir_Cursor_Expired = errors.New("list dir cursor expired, restart from the first page")
+)
+
+// ListDir 列出服务端存储目录下dirPath中的一页，cursor为空时从第一页开始。
+// 返回的NextCursor为空表示目录已经全部列出。
+func (c *TcpClient) ListDir(serverAddr string, req *codec.ListDirPayload, timeout time.Duration) (*codec.ListDirAckPayload, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	frame := NewFrame(LISTDIR, &codec.ListDirHeader{
+		Id:        hex.EncodeToString(id),
+		Timestamp: time.Now().Unix(),
+	}, nil)
+	if c.config.Version != 0 {
+		frame.Version = c.config.Version
+	}
+	payload, err := EncodeListDirPayload(frame.Version, req)
+	if err != nil {
+		return nil, err
+	}
+	frame.Payload = payload
+	respFrame, err := c.SendSync(serverAddr, frame, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	header, ok := respFrame.Header.(*codec.ListDirAckHeader)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response for list dir, cmd type: %d", respFrame.CmdType)
+	}
+	if header.StatusCode == codec.ListDirCursorExpired {
+		return nil, Err_List_Dir_Cursor_Expired
+	}
+	if header.StatusCode != codec.ListDirOK {
+		return nil, fmt.Errorf("list dir failed, status code: %d", header.StatusCode)
+	}
+
+	return DecodeListDirAckPayload(respFrame.Version, respFrame.Payload)
+}
+
+// EncodeListDirPayload VERSION_PROTOBUF的帧按proto/frame.proto中的ListDirPayload消息编码，其余版本使用二进制编码
+func EncodeListDirPayload(version VersionType, req *codec.ListDirPayload) ([]byte, error) {
+	if version == VERSION_PROTOBUF {
+		return req.EncodeProtobuf()
+	}
+	return req.Encode()
+}
+
+func DecodeListDirPayload(version VersionType, data []byte) (*codec.ListDirPayload, error) {
+	req := &codec.ListDirPayload{}
+	if version == VERSION_PROTOBUF {
+		return req, req.DecodeProtobuf(data)
+	}
+	return req, req.Decode(data)
+}
+
+// EncodeListDirAckPayload 与EncodeListDirPayload相同，按帧的版本选择ListDirAckPayload的编码
+func EncodeListDirAckPayload(version VersionType, ack *codec.ListDirAckPayload) ([]byte, error) {
+	if version == VERSION_PROTOBUF {
+		return ack.EncodeProtobuf()
+	}
+	return ack.Encode()
+}
+
+func DecodeListDirAckPayload(version VersionType, data []byte) (*codec.ListDirAckPayload, error) {
+	ack := &codec.ListDirAckPayload{}
+	if version == VERSION_PROTOBUF {
+		return ack, ack.DecodeProtobuf(data)
+	}
+	return ack, ack.Decode(data)
+}
@@ -0,0 +1,111 @@
+package network_test
+
+import (
+	"fmt"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListDirShouldReturnAllEntriesWhenPagingWithCursor(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "docs", "sub"), 0755))
+	for i := 0; i < 25; i++ {
+		name := filepath.Join(root, "docs", fmt.Sprintf("file-%02d.txt", i))
+		require.NoError(t, os.WriteFile(name, []byte("hello"), 0644))
+	}
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	entries := make(map[string]codec.FileEntry)
+	req := &codec.ListDirPayload{DirPath: "/docs", Limit: 10}
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 10)
+		ack, err := tcpClient.ListDir(fileServerAddr, req, 5*time.Second)
+		require.NoError(t, err)
+		for _, entry := range ack.Entries {
+			entries[entry.Name] = entry
+		}
+		if len(ack.NextCursor) == 0 {
+			break
+		}
+		req.Cursor = ack.NextCursor
+	}
+
+	assert.Len(t, entries, 26)
+	assert.True(t, entries["sub"].IsDir)
+	assert.Equal(t, int64(5), entries["file-00.txt"].Size)
+	assert.False(t, entries["file-00.txt"].IsDir)
+	assert.NotZero(t, entries["file-00.txt"].ModTime)
+}
+
+func TestListDirShouldReturnErrorWhenSymlinkPointsOutsideStoreRoot(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	require.NoError(t, os.Symlink(t.TempDir(), filepath.Join(root, "outside")))
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	_, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/outside"}, 5*time.Second)
+	assert.Error(t, err)
+
+	ack, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+	require.Len(t, ack.Entries, 1)
+	assert.NotZero(t, os.FileMode(ack.Entries[0].Mode)&os.ModeSymlink)
+	assert.Empty(t, ack.NextCursor)
+}
+
+// openFds 当前进程打开的文件描述符数
+func openFds(t *testing.T) int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("/proc/self/fd is not available")
+	}
+	return len(fds)
+}
+
+func TestListDirShouldCloseCursorsWhenConnectionCloses(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(root, fmt.Sprintf("file-%d.txt", i)), []byte("hello"), 0644))
+	}
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	other := StartFileTcpClient()
+	defer other.Stop()
+	_, err := other.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+	baseline := openFds(t)
+
+	tcpClient := StartFileTcpClient()
+	ack, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/", Limit: 2}, 5*time.Second)
+	require.NoError(t, err)
+	require.NotEmpty(t, ack.NextCursor)
+
+	// 游标只能在分配它的连接上使用
+	_, err = other.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/", Limit: 2, Cursor: ack.NextCursor}, 5*time.Second)
+	assert.ErrorIs(t, err, network.Err_List_Dir_Cursor_Expired)
+
+	// 连接关闭后游标持有的目录随之关闭，不必等到空闲超时
+	tcpClient.Stop()
+	assert.Eventually(t, func() bool {
+		return openFds(t) <= baseline
+	}, 3*time.Second, 20*time.Millisecond)
+}
@@ -0,0 +1,62 @@
+package processor
+
+import (
+	"errors"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"time"
+)
+
+type AuthProcessor struct {
+	tcpSrv *network.TcpServer
+}
+
+func NewAuthProcs(tcpSrv *network.TcpServer) *AuthProcessor {
+	return &AuthProcessor{
+		tcpSrv: tcpSrv,
+	}
+}
+
+// 实现连接认证
+// AUTH只在CONN握手之后处理，此时帧已经使用会话密钥加密，令牌不会以明文传输
+// 校验连接ID属于当前连接，使用TcpServerConfig.Authenticate校验令牌
+// 将用户ID记录在连接和连接的会话恢复票据上，之后的上传记录在该用户名下
+// 已经认证的连接不能切换为其他用户
+func (ap *AuthProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	header, ok := frame.Header.(*codec.AuthHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for AUTH")
+	}
+
+	if conn.Crypto() == nil {
+		return ap.newAckFrame(frame, codec.AuthNotConnected, 0), nil
+	}
+	if stored, exists := ap.tcpSrv.CManager.Load(header.Id); !exists || stored != conn {
+		return ap.newAckFrame(frame, codec.AuthForbidden, 0), nil
+	}
+
+	userId, err := ap.tcpSrv.Authenticate(header.Token)
+	if err != nil {
+		log.Errorf("authenticate connection %s failed: %v", header.Id, err)
+		return ap.newAckFrame(frame, codec.AuthUnauthorized, 0), nil
+	}
+	if current := conn.UserId(); current != 0 && current != userId {
+		return ap.newAckFrame(frame, codec.AuthForbidden, 0), nil
+	}
+
+	conn.SetUserId(userId)
+	ap.tcpSrv.CManager.AuthenticateTickets(header.Id, userId)
+	log.Infof("connection %s authenticated as user %d", header.Id, userId)
+	return ap.newAckFrame(frame, codec.AuthOK, userId), nil
+}
+
+func (ap *AuthProcessor) newAckFrame(frame *network.Frame, statusCode uint16, userId uint32) *network.Frame {
+	ackFrame := network.NewFrame(network.AUTHACK, &codec.AuthAckHeader{
+		StatusCode: statusCode,
+		Timestamp:  time.Now().Unix(),
+		UserId:     userId,
+	}, nil)
+	ackFrame.Seq = frame.Seq
+	return ackFrame
+}
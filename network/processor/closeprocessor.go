@@ -0,0 +1,71 @@
+package processor
+
+import (
+	"errors"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"time"
+)
+
+// 等待待发送帧发送完毕的最长时间
+const closeDrainTimeout = 30 * time.Second
+
+type CloseProcessor struct {
+	tcpSrv *network.TcpServer
+}
+
+func NewCloseProcs(tcpSrv *network.TcpServer) *CloseProcessor {
+	return &CloseProcessor{
+		tcpSrv: tcpSrv,
+	}
+}
+
+// 实现连接关闭
+// 标记连接正在关闭，之后该连接上的新请求会被丢弃
+// 异步等待待发送的帧发送完毕，期间仍然可以接收传输确认
+// 从ConnManager删除连接ID，回复CLOSEACK后关闭连接
+func (cp *CloseProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	header, ok := frame.Header.(*codec.CloseHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for CLOSE")
+	}
+
+	if !conn.BeginClose() {
+		log.Infof("connection is already closing, ignore close frame, seq: %d", frame.Seq)
+		return nil, nil
+	}
+
+	log.Infof("close connection %s, reason: %s", header.Id, header.Reason)
+	go cp.close(conn, frame.Seq, header)
+	return nil, nil
+}
+
+func (cp *CloseProcessor) close(conn *network.Conn, seq uint64, header *codec.CloseHeader) {
+	ackHeader := &codec.CloseAckHeader{StatusCode: codec.CloseOK}
+	if !conn.WaitDrained(closeDrainTimeout) {
+		ackHeader.StatusCode = codec.CloseDrainTimeout
+		ackHeader.Details = "pending frames not flushed before timeout"
+	}
+
+	if len(header.Id) != 0 {
+		if stored, exists := cp.tcpSrv.CManager.Load(header.Id); exists && stored != conn {
+			ackHeader.StatusCode = codec.CloseIdMismatch
+			ackHeader.Details = "connection id belongs to another connection"
+		} else {
+			cp.tcpSrv.CManager.Delete(header.Id)
+			// 正常关闭的会话不能再恢复
+			cp.tcpSrv.CManager.RevokeTickets(header.Id)
+		}
+	}
+
+	ackFrame := network.NewFrame(network.CLOSEACK, ackHeader, nil)
+	ackFrame.Seq = seq
+	if err := cp.tcpSrv.Send(conn, ackFrame); err != nil {
+		log.Errorf("send close ack failed: %v", err)
+	}
+
+	if err := conn.Connection.Close(); err != nil {
+		log.Errorf("close connection failed: %v", err)
+	}
+}
@@ -1,62 +1,121 @@
 package processor
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"go-networking/crypto/dh"
+	"go-networking/crypto/identity"
+	"go-networking/log"
 	"go-networking/network"
 	"go-networking/network/codec"
 	"go-networking/network/util"
-	"math/big"
 	"time"
 )
 
+var (
+	Err_Insecure_Group_Not_Allowed = errors.New("insecure key exchange group is not allowed")
+)
+
 type ConnProcessor struct {
 	tcpSrv *network.TcpServer
+	// 是否允许p=23的演示群，只用于测试
+	allowInsecureGroup bool
 }
 
-func NewConnProcs(tcpSrv *network.TcpServer) *ConnProcessor {
-	return &ConnProcessor{tcpSrv: tcpSrv}
+func NewConnProcs(tcpSrv *network.TcpServer, allowInsecureGroup bool) *ConnProcessor {
+	return &ConnProcessor{
+		tcpSrv:             tcpSrv,
+		allowInsecureGroup: allowInsecureGroup,
+	}
 }
 
 // 实现连接建立
-// 获取DH公钥
-// 获取自己的私钥后计算出AES加密KEY
+// 按ConnHeader中协商的群生成临时密钥对，未指定时使用X25519
+// 使用客户端公钥计算共享秘密，通过HKDF派生两个方向的AES密钥
 // 生成一个连接ID
-// 将连接添加到connTable
-// 回复连接ID,连接ID写入到ConnHeader的Id字段中
-// 获取自己的公钥回复给客户端，公钥写入到ConnPayload的PublicKey字段中
-// 使用FastGenDHKP生成DH密钥对
-// 使用FastGenDHSharedKey计算共享密钥
+// 将连接和密钥添加到ConnManager，之后连接上帧的Header和负载使用AES-GCM加密
+// 回复连接ID,连接ID写入到ConnAckHeader的Id字段中
+// 获取自己的公钥回复给客户端，公钥写入到CONNACK的Payload中
+// 配置了身份私钥时，在ConnAckHeader中附带身份公钥和对握手内容的签名
+// 配置了票据有效期时，在ConnAckHeader中附带会话恢复票据
+// 从客户端提供的压缩算法中选择一个写入ConnAckHeader，之后的帧负载按协商结果压缩
+// 握手不携带认证令牌，连接在AUTH之前是匿名的
 func (cp *ConnProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
-	// 解析客户端DH公钥
-	clientDHKey := new(big.Int).SetBytes(frame.Payload)
+	header, ok := frame.Header.(*codec.ConnHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for CONN")
+	}
+
+	group := dh.Group(header.Group)
+	if group == 0 {
+		group = dh.GroupX25519
+	}
+	if group == dh.GroupInsecure && !cp.allowInsecureGroup {
+		return nil, Err_Insecure_Group_Not_Allowed
+	}
+
+	keyPair, err := dh.NewKeyPair(group)
+	if err != nil {
+		return nil, err
+	}
 
-	var srvPrivateKey, srvPublicKey, _ = dh.FastGenDHKP()
-	// 计算共享秘钥
-	sharedKey := dh.FastGenDHSharedKey(clientDHKey, srvPrivateKey)
+	// 计算共享秘密，客户端公钥不合法时拒绝连接
+	secret, err := keyPair.SharedSecret(frame.Payload)
+	if err != nil {
+		log.Errorf("compute shared secret failed: %v", err)
+		return nil, err
+	}
 
-	// 从共享秘钥生成AES密钥
-	aesKey := dh.GenAESKeyFromDHKey(sharedKey)
+	// 从共享秘密派生两个方向的AES密钥
+	cKey, sKey, err := dh.DeriveSessionKeys(secret, frame.Payload, keyPair.PublicKey())
+	if err != nil {
+		return nil, err
+	}
 
 	// 生成UUID
 	connID := util.GetUUIDNoDash()
 
-	// 记录aesKey到连接上下文中，用于之后数据的加解密
-	// Store 将连接存储到设备连接映射中。
-	cp.tcpSrv.CManager.Store(connID, conn, aesKey)
+	// 记录密钥到连接上下文中，用于之后数据的加解密
+	cp.tcpSrv.CManager.StoreKeys(connID, conn, cKey, sKey)
+	// 之后的帧使用会话密钥加密，CONNACK本身以明文发送
+	conn.SetCrypto(network.NewGcmCrypto(sKey, cKey))
+	compression, err := cp.tcpSrv.NegotiateCompression(conn, header.Compressions)
+	if err != nil {
+		return nil, err
+	}
 
 	// 准备回复客户端的数据包
 	respHeader := &codec.ConnAckHeader{
-		// 此处需要将connID从uint64转换为字符串，如使用strconv.Itoa
-		Id:        connID,
-		Timestamp: time.Now().Unix(),
+		Id:          connID,
+		Timestamp:   time.Now().Unix(),
+		Group:       uint8(group),
+		Compression: compression,
+	}
+
+	// 签发会话恢复票据，TCP断开后客户端可以凭票据恢复连接ID
+	if lifetime := cp.tcpSrv.TicketLifetime(); lifetime > 0 {
+		resumptionSecret, err := dh.DeriveResumptionSecret(secret, frame.Payload, keyPair.PublicKey())
+		if err != nil {
+			return nil, err
+		}
+		if respHeader.Ticket, err = cp.tcpSrv.CManager.IssueTicket(connID, 0, resumptionSecret, lifetime); err != nil {
+			return nil, err
+		}
+	}
+
+	// 配置了身份私钥时签名握手内容，客户端据此确认没有中间人
+	if identityKey := cp.tcpSrv.IdentityKey(); identityKey != nil {
+		transcript := identity.Transcript(respHeader.Group, frame.Payload, keyPair.PublicKey(), respHeader.Id, respHeader.Timestamp)
+		respHeader.IdentityKey = identityKey.Public().(ed25519.PublicKey)
+		respHeader.Signature = identity.Sign(identityKey, transcript)
 	}
 
 	responseFrame := &network.Frame{
-		Version: network.VERSION_1,
+		Version: network.VERSION_2,
 		CmdType: network.CONNACK,
 		Seq:     frame.Seq,
 		Header:  respHeader,
-		Payload: srvPublicKey.Bytes(),
+		Payload: keyPair.PublicKey(),
 	}
 
 	return responseFrame, nil
@@ -0,0 +1,387 @@
+package processor
+
+import (
+	"container/list"
+	"errors"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 每个TRANSFER帧携带的数据块大小，块放在负载中，需小于最大帧长度
+	defaultBlockSize = 32 * 1024
+	// 已发送但尚未被TRANSFERACK确认的最大块数
+	transferWindow = 16
+	// 超过该时间没有收到新的TRANSFERACK则放弃本次传输
+	transferAckTimeout = 30 * time.Second
+	// 默认缓存的文件元数据条数
+	DefaultMaxCachedFiles = 1024
+)
+
+// transferFile 记录分配过文件ID的文件，断点续传时据此判断文件是否发生变化
+type transferFile struct {
+	fileID   uint32
+	path     string
+	size     int64
+	modTime  time.Time
+	checksum uint32
+	// 在最近使用列表中的位置，文件变化后新的元数据沿用该位置
+	elem *list.Element
+}
+
+type transferSessionKey struct {
+	conn *network.Conn
+	seq  uint64
+}
+
+// transferSession 一次下载的发送状态，acked是下一个尚未被确认的块序号
+type transferSession struct {
+	file      *transferFile
+	blockSize uint32
+	start     uint32
+	end       uint32
+	acked     atomic.Uint32
+	ackCh     chan struct{}
+}
+
+type FileTransferProcessor struct {
+	tcpSrv     *network.TcpServer
+	root       string
+	fileIdIncr *network.SafeIncrementer32
+	mu         sync.Mutex
+	// 文件元数据按最近使用淘汰，最多maxFiles条，正在下载的文件不会被淘汰
+	files    map[string]*transferFile
+	fileIds  map[uint32]*transferFile
+	recent   *list.List
+	maxFiles int
+	// 每个文件ID正在进行的下载数
+	fileRefs map[uint32]int
+	sessions map[transferSessionKey]*transferSession
+}
+
+// NewFileTransferProcs maxFiles为0时使用DefaultMaxCachedFiles
+func NewFileTransferProcs(tcpSrv *network.TcpServer, root string, maxFiles int) *FileTransferProcessor {
+	if maxFiles <= 0 {
+		maxFiles = DefaultMaxCachedFiles
+	}
+	return &FileTransferProcessor{
+		tcpSrv:     tcpSrv,
+		root:       root,
+		fileIdIncr: network.NewSafeIncrementer(),
+		files:      make(map[string]*transferFile),
+		fileIds:    make(map[uint32]*transferFile),
+		recent:     list.New(),
+		maxFiles:   maxFiles,
+		fileRefs:   make(map[uint32]int),
+		sessions:   make(map[transferSessionKey]*transferSession),
+	}
+}
+
+// 同时处理FILETRANSFER和TRANSFERACK两个命令
+func (fp *FileTransferProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	if frame.CmdType == network.TRANSFERACK {
+		return fp.processAck(conn, frame)
+	}
+
+	return fp.processTransfer(conn, frame)
+}
+
+// 实现文件下载
+// 续传请求优先使用文件ID找到文件，否则将请求路径解析到存储根目录下
+// 计算文件长度和校验和，续传时校验和必须与上次FILETRANSFERACK一致
+// 回复FILETRANSFERACK后异步按窗口发送请求范围内的TRANSFER帧，TRANSFER帧沿用请求的Seq，
+// 最后一帧设置FlagEndOfStream，客户端可以用SendStream接收整个下载
+func (fp *FileTransferProcessor) processTransfer(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	header, ok := frame.Header.(*codec.FileTransfer)
+	if !ok {
+		return nil, errors.New("invalid header type for FILETRANSFER")
+	}
+
+	path, err := fp.lookupPath(header)
+	if err != nil {
+		log.Errorf("resolve file transfer path %s failed: %v", header.FilePath, err)
+		return fp.newAckFrame(frame, &codec.FileTransferAck{ErrorCode: codec.FileTransferInvalidPath}), nil
+	}
+
+	tf, errorCode := fp.loadFile(path)
+	if errorCode != codec.FileTransferOK {
+		return fp.newAckFrame(frame, &codec.FileTransferAck{ErrorCode: errorCode}), nil
+	}
+
+	if header.FileID != 0 && (header.FileID != tf.fileID || header.Checksum != tf.checksum) {
+		log.Infof("file %s changed since last transfer, file id: %d", path, header.FileID)
+		return fp.newAckFrame(frame, &codec.FileTransferAck{ErrorCode: codec.FileTransferChanged}), nil
+	}
+
+	totalBlocks := uint32((tf.size + defaultBlockSize - 1) / defaultBlockSize)
+	if header.StartBlock > totalBlocks {
+		return fp.newAckFrame(frame, &codec.FileTransferAck{ErrorCode: codec.FileTransferInvalidRange}), nil
+	}
+	end := totalBlocks
+	if header.BlockCount != 0 && uint64(header.StartBlock)+uint64(header.BlockCount) < uint64(totalBlocks) {
+		end = header.StartBlock + header.BlockCount
+	}
+
+	ack := &codec.FileTransferAck{
+		FileID:    tf.fileID,
+		FileLen:   uint64(tf.size),
+		Checksum:  tf.checksum,
+		BlockSize: defaultBlockSize,
+		ErrorCode: codec.FileTransferOK,
+	}
+
+	session := &transferSession{
+		file:      tf,
+		blockSize: defaultBlockSize,
+		start:     header.StartBlock,
+		end:       end,
+		ackCh:     make(chan struct{}, 1),
+	}
+	session.acked.Store(header.StartBlock)
+
+	// 登记为连接上待发送的任务，CLOSE会等待传输结束后再回复CLOSEACK
+	if !conn.Acquire() {
+		return nil, errors.New("connection is closing")
+	}
+	key := transferSessionKey{conn: conn, seq: frame.Seq}
+	fp.addSession(key, session)
+
+	ackFrame := fp.newAckFrame(frame, ack)
+	// 没有需要发送的块时FILETRANSFERACK就是最后一个响应
+	if session.start == session.end {
+		ackFrame.Flags |= network.FlagEndOfStream
+	}
+	if err := fp.tcpSrv.Send(conn, ackFrame); err != nil {
+		fp.delSession(key)
+		conn.Release()
+		return nil, err
+	}
+
+	go func() {
+		defer conn.Release()
+		fp.stream(conn, key, session)
+	}()
+	return nil, nil
+}
+
+// processAck 推进发送窗口，TRANSFERACK不需要回复
+func (fp *FileTransferProcessor) processAck(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	header, ok := frame.Header.(*codec.TransferAck)
+	if !ok {
+		return nil, errors.New("invalid header type for TRANSFERACK")
+	}
+
+	fp.mu.Lock()
+	session, exists := fp.sessions[transferSessionKey{conn: conn, seq: frame.Seq}]
+	fp.mu.Unlock()
+	if !exists || session.file.fileID != header.FileID {
+		log.Infof("ignore transfer ack for unknown session, file id: %d, seq: %d", header.FileID, frame.Seq)
+		return nil, nil
+	}
+
+	for {
+		acked := session.acked.Load()
+		if header.Seq < acked || session.acked.CompareAndSwap(acked, header.Seq+1) {
+			break
+		}
+	}
+
+	select {
+	case session.ackCh <- struct{}{}:
+	default:
+	}
+	return nil, nil
+}
+
+func (fp *FileTransferProcessor) newAckFrame(frame *network.Frame, ack *codec.FileTransferAck) *network.Frame {
+	ackFrame := network.NewFrame(network.FILETRANSFERACK, ack, nil)
+	ackFrame.Seq = frame.Seq
+	return ackFrame
+}
+
+// lookupPath 续传请求且文件ID已知时沿用原来的路径
+func (fp *FileTransferProcessor) lookupPath(header *codec.FileTransfer) (string, error) {
+	if header.FileID != 0 {
+		fp.mu.Lock()
+		tf, exists := fp.fileIds[header.FileID]
+		fp.mu.Unlock()
+		if exists {
+			return tf.path, nil
+		}
+	}
+
+	return resolveStorePath(fp.root, header.FilePath)
+}
+
+// loadFile 获取文件的元数据，文件长度和修改时间没有变化时复用缓存的校验和
+// 同一路径在缓存中时始终使用同一个文件ID，被淘汰后重新分配，之前的文件ID续传时按文件已变化处理
+func (fp *FileTransferProcessor) loadFile(path string) (*transferFile, uint32) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, codec.FileTransferNotFound
+		}
+		return nil, codec.FileTransferInternal
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, codec.FileTransferInternal
+	}
+	if stat.IsDir() {
+		return nil, codec.FileTransferInvalidPath
+	}
+
+	fp.mu.Lock()
+	cached, exists := fp.files[path]
+	if exists && cached.size == stat.Size() && cached.modTime.Equal(stat.ModTime()) {
+		fp.recent.MoveToFront(cached.elem)
+		fp.mu.Unlock()
+		return cached, codec.FileTransferOK
+	}
+	fp.mu.Unlock()
+
+	checksum, err := fileChecksum(file)
+	if err != nil {
+		return nil, codec.FileTransferInternal
+	}
+
+	tf := &transferFile{
+		path:     path,
+		size:     stat.Size(),
+		modTime:  stat.ModTime(),
+		checksum: checksum,
+	}
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	if cached, exists = fp.files[path]; exists {
+		tf.fileID = cached.fileID
+		tf.elem = cached.elem
+		tf.elem.Value = tf
+		fp.recent.MoveToFront(tf.elem)
+	} else {
+		tf.fileID = uint32(fp.fileIdIncr.Increment())
+		tf.elem = fp.recent.PushFront(tf)
+	}
+	fp.files[path] = tf
+	fp.fileIds[tf.fileID] = tf
+	fp.evictFilesLocked()
+	return tf, codec.FileTransferOK
+}
+
+// evictFilesLocked 从最久未使用的文件开始淘汰，直到不超过maxFiles条，跳过正在下载的文件
+func (fp *FileTransferProcessor) evictFilesLocked() {
+	for elem := fp.recent.Back(); elem != nil && fp.recent.Len() > fp.maxFiles; {
+		prev := elem.Prev()
+		tf := elem.Value.(*transferFile)
+		if fp.fileRefs[tf.fileID] == 0 {
+			fp.recent.Remove(elem)
+			delete(fp.files, tf.path)
+			delete(fp.fileIds, tf.fileID)
+		}
+		elem = prev
+	}
+}
+
+func (fp *FileTransferProcessor) addSession(key transferSessionKey, session *transferSession) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.sessions[key] = session
+	fp.fileRefs[session.file.fileID]++
+}
+
+func (fp *FileTransferProcessor) delSession(key transferSessionKey) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	session, exists := fp.sessions[key]
+	if !exists {
+		return
+	}
+	delete(fp.sessions, key)
+	if fp.fileRefs[session.file.fileID]--; fp.fileRefs[session.file.fileID] <= 0 {
+		delete(fp.fileRefs, session.file.fileID)
+		fp.evictFilesLocked()
+	}
+}
+
+// stream 按窗口逐块发送TRANSFER帧，全部块被确认或确认超时后结束会话
+func (fp *FileTransferProcessor) stream(conn *network.Conn, key transferSessionKey, session *transferSession) {
+	defer fp.delSession(key)
+
+	file, err := os.Open(session.file.path)
+	if err != nil {
+		log.Errorf("open file %s for transfer failed: %v", session.file.path, err)
+		return
+	}
+	defer file.Close()
+
+	block := make([]byte, session.blockSize)
+	for blockSeq := session.start; blockSeq < session.end; blockSeq++ {
+		if blockSeq >= session.start+transferWindow {
+			if err := session.waitAcked(blockSeq - transferWindow + 1); err != nil {
+				log.Errorf("transfer file %s aborted: %v", session.file.path, err)
+				return
+			}
+		}
+
+		n, err := file.ReadAt(block, int64(blockSeq)*int64(session.blockSize))
+		if err != nil && err != io.EOF {
+			log.Errorf("read file %s failed: %v", session.file.path, err)
+			return
+		}
+
+		transferFrame := network.NewFrame(network.TRANSFER, &codec.Transfer{
+			FileID: session.file.fileID,
+			Seq:    blockSeq,
+		}, block[:n])
+		transferFrame.Seq = key.seq
+		if blockSeq == session.end-1 {
+			transferFrame.Flags |= network.FlagEndOfStream
+		}
+		if err := fp.tcpSrv.Send(conn, transferFrame); err != nil {
+			log.Errorf("send transfer block failed: %v", err)
+			return
+		}
+	}
+
+	if err := session.waitAcked(session.end); err != nil {
+		log.Errorf("transfer file %s not fully acknowledged: %v", session.file.path, err)
+	}
+}
+
+// waitAcked 等待直到target之前的块均被确认
+func (s *transferSession) waitAcked(target uint32) error {
+	timer := time.NewTimer(transferAckTimeout)
+	defer timer.Stop()
+	for s.acked.Load() < target {
+		select {
+		case <-s.ackCh:
+		case <-timer.C:
+			return errors.New("waiting for transfer ack timeout")
+		}
+	}
+	return nil
+}
+
+// fileChecksum 计算整个文件的CRC32校验和
+func fileChecksum(file *os.File) (uint32, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, file); err != nil {
+		return 0, err
+	}
+
+	return hash.Sum32(), nil
+}
@@ -0,0 +1,294 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"go-networking/ginh/file"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// 超过该时间没有收到新的数据块，上传会话在下一次FILEUPLOAD时被清理
+	uploadIdleTimeout = 30 * time.Second
+	// 未配置上限时允许上传的最大文件长度
+	DefaultMaxUploadSize uint64 = 1 << 30
+)
+
+// FileRecorder 记录上传完成的文件，使其出现在HTTP的/file/list接口中，由file.FileService实现
+type FileRecorder interface {
+	UploadFile(cmd *file.UploadFileCmd, userId uint) (*file.FileInfo, error)
+}
+
+// uploadSession 一次上传的接收状态
+type uploadSession struct {
+	fileID uint32
+	// 连接认证的用户，文件记录在该用户名下
+	userId     uint32
+	header     *codec.FileUpload
+	partPath   string
+	file       *os.File
+	nextBlock  uint32
+	lastActive time.Time
+}
+
+type FileUploadProcessor struct {
+	tcpSrv     *network.TcpServer
+	root       string
+	recorder   FileRecorder
+	fileIdIncr *network.SafeIncrementer32
+	// FILEUPLOAD中允许的最大文件长度
+	maxFileLen uint64
+	mu         sync.Mutex
+	sessions   map[transferSessionKey]*uploadSession
+}
+
+// NewFileUploadProcs maxFileLen为0时使用DefaultMaxUploadSize
+func NewFileUploadProcs(tcpSrv *network.TcpServer, root string, recorder FileRecorder, maxFileLen uint64) *FileUploadProcessor {
+	if maxFileLen == 0 {
+		maxFileLen = DefaultMaxUploadSize
+	}
+	return &FileUploadProcessor{
+		tcpSrv:     tcpSrv,
+		root:       root,
+		recorder:   recorder,
+		fileIdIncr: network.NewSafeIncrementer(),
+		maxFileLen: maxFileLen,
+		sessions:   make(map[transferSessionKey]*uploadSession),
+	}
+}
+
+// 同时处理FILEUPLOAD和客户端发来的TRANSFER两个命令
+func (up *FileUploadProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	if frame.CmdType == network.TRANSFER {
+		return up.processBlock(conn, frame)
+	}
+
+	return up.processUpload(conn, frame)
+}
+
+// 实现文件上传协商
+// 文件记录在连接认证的用户名下，匿名连接或者UserId不是认证的用户时拒绝上传
+// 文件长度超过maxFileLen时拒绝上传
+// 分配文件ID，在存储根目录下创建临时文件
+// 回复FILEUPLOADACK，之后客户端沿用请求的Seq发送TRANSFER帧
+func (up *FileUploadProcessor) processUpload(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	header, ok := frame.Header.(*codec.FileUpload)
+	if !ok {
+		return nil, errors.New("invalid header type for FILEUPLOAD")
+	}
+
+	up.cleanupIdleSessions()
+
+	if len(up.root) == 0 {
+		return up.newAckFrame(frame.Seq, &codec.FileUploadAck{ErrorCode: codec.FileTransferInvalidPath}), nil
+	}
+
+	userId := conn.UserId()
+	if userId == 0 || (header.UserId != 0 && header.UserId != userId) {
+		log.Errorf("reject upload file %s, connection user: %d, request user: %d", header.FileName, userId, header.UserId)
+		return up.newAckFrame(frame.Seq, &codec.FileUploadAck{ErrorCode: codec.FileTransferForbidden}), nil
+	}
+	if header.FileLen > up.maxFileLen {
+		log.Errorf("reject upload file %s, length %d exceeds %d", header.FileName, header.FileLen, up.maxFileLen)
+		return up.newAckFrame(frame.Seq, &codec.FileUploadAck{ErrorCode: codec.FileTransferTooLarge}), nil
+	}
+
+	fileID := uint32(up.fileIdIncr.Increment())
+	partPath := filepath.Join(up.root, fmt.Sprintf("upload-%d-%d.part", time.Now().UnixNano(), fileID))
+	partFile, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Errorf("create upload file %s failed: %v", partPath, err)
+		return up.newAckFrame(frame.Seq, &codec.FileUploadAck{ErrorCode: codec.FileTransferInternal}), nil
+	}
+
+	session := &uploadSession{
+		fileID:     fileID,
+		userId:     userId,
+		header:     header,
+		partPath:   partPath,
+		file:       partFile,
+		lastActive: time.Now(),
+	}
+
+	ack := &codec.FileUploadAck{
+		FileID:    fileID,
+		BlockSize: defaultBlockSize,
+		ErrorCode: codec.FileTransferOK,
+	}
+
+	// 空文件不需要传输数据块，直接完成
+	if header.FileLen == 0 {
+		if err := up.tcpSrv.Send(conn, up.newAckFrame(frame.Seq, ack)); err != nil {
+			session.abort()
+			return nil, err
+		}
+		return up.newAckFrame(frame.Seq, up.complete(session)), nil
+	}
+
+	up.mu.Lock()
+	up.sessions[transferSessionKey{conn: conn, seq: frame.Seq}] = session
+	up.mu.Unlock()
+
+	return up.newAckFrame(frame.Seq, ack), nil
+}
+
+// processBlock 写入数据块并回复TRANSFERACK，最后一块写入后校验并记录文件。
+// 除最后一块外每块都必须是defaultBlockSize，最后一块正好到FileLen为止，否则放弃本次上传
+func (up *FileUploadProcessor) processBlock(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	header, ok := frame.Header.(*codec.Transfer)
+	if !ok {
+		return nil, errors.New("invalid header type for TRANSFER")
+	}
+
+	key := transferSessionKey{conn: conn, seq: frame.Seq}
+	up.mu.Lock()
+	session, exists := up.sessions[key]
+	up.mu.Unlock()
+	if !exists || session.fileID != header.FileID {
+		return nil, fmt.Errorf("upload session not found, file id: %d, seq: %d", header.FileID, frame.Seq)
+	}
+
+	if header.Seq != session.nextBlock || len(frame.Payload) != session.blockLen(header.Seq) {
+		log.Errorf("reject upload block %d of file %d, length: %d", header.Seq, session.fileID, len(frame.Payload))
+		up.delSession(key)
+		session.abort()
+		return up.newAckFrame(frame.Seq, &codec.FileUploadAck{
+			FileID:    session.fileID,
+			ErrorCode: codec.FileTransferInvalidRange,
+			Done:      true,
+		}), nil
+	}
+
+	offset := int64(header.Seq) * defaultBlockSize
+	if _, err := session.file.WriteAt(frame.Payload, offset); err != nil {
+		up.delSession(key)
+		session.abort()
+		return nil, err
+	}
+	session.nextBlock++
+	up.mu.Lock()
+	session.lastActive = time.Now()
+	up.mu.Unlock()
+
+	blockAck := network.NewFrame(network.TRANSFERACK, &codec.TransferAck{
+		FileID: session.fileID,
+		Seq:    header.Seq,
+	}, nil)
+	blockAck.Seq = frame.Seq
+
+	if session.nextBlock < session.totalBlocks() {
+		return blockAck, nil
+	}
+
+	if err := up.tcpSrv.Send(conn, blockAck); err != nil {
+		up.delSession(key)
+		session.abort()
+		return nil, err
+	}
+
+	up.delSession(key)
+	return up.newAckFrame(frame.Seq, up.complete(session)), nil
+}
+
+// complete 校验整个文件，记录FileInfo后将临时文件改名为记录中的文件名
+func (up *FileUploadProcessor) complete(session *uploadSession) *codec.FileUploadAck {
+	result := &codec.FileUploadAck{
+		FileID: session.fileID,
+		Done:   true,
+	}
+
+	if err := session.file.Truncate(int64(session.header.FileLen)); err != nil {
+		session.abort()
+		result.ErrorCode = codec.FileTransferInternal
+		return result
+	}
+
+	checksum, err := fileChecksum(session.file)
+	if err != nil {
+		session.abort()
+		result.ErrorCode = codec.FileTransferInternal
+		return result
+	}
+	if checksum != session.header.Checksum {
+		log.Errorf("upload file %s checksum mismatch, expected: %d, actual: %d", session.header.FileName, session.header.Checksum, checksum)
+		session.abort()
+		result.ErrorCode = codec.FileTransferChecksumMismatch
+		return result
+	}
+	session.file.Close()
+
+	info, err := up.recorder.UploadFile(&file.UploadFileCmd{
+		UserId:      uint(session.userId),
+		ParentId:    uint(session.header.ParentId),
+		Filename:    session.header.FileName,
+		OrgFilename: session.header.FileName,
+	}, uint(session.userId))
+	if err != nil {
+		log.Errorf("record upload file %s failed: %v", session.header.FileName, err)
+		os.Remove(session.partPath)
+		result.ErrorCode = codec.FileTransferInternal
+		return result
+	}
+
+	if err := os.Rename(session.partPath, filepath.Join(up.root, info.Filename)); err != nil {
+		log.Errorf("rename upload file %s failed: %v", session.partPath, err)
+		os.Remove(session.partPath)
+		result.ErrorCode = codec.FileTransferInternal
+		return result
+	}
+
+	result.ErrorCode = codec.FileTransferOK
+	return result
+}
+
+func (up *FileUploadProcessor) newAckFrame(seq uint64, ack *codec.FileUploadAck) *network.Frame {
+	ackFrame := network.NewFrame(network.FILEUPLOADACK, ack, nil)
+	ackFrame.Seq = seq
+	return ackFrame
+}
+
+func (up *FileUploadProcessor) delSession(key transferSessionKey) {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	delete(up.sessions, key)
+}
+
+// cleanupIdleSessions 清理客户端中断后遗留的上传会话
+func (up *FileUploadProcessor) cleanupIdleSessions() {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	now := time.Now()
+	for key, session := range up.sessions {
+		if now.Sub(session.lastActive) > uploadIdleTimeout {
+			delete(up.sessions, key)
+			session.abort()
+		}
+	}
+}
+
+// totalBlocks 按defaultBlockSize划分文件的块数
+func (s *uploadSession) totalBlocks() uint32 {
+	return uint32((s.header.FileLen + defaultBlockSize - 1) / defaultBlockSize)
+}
+
+// blockLen 第seq块应有的长度，超出文件范围的块为-1
+func (s *uploadSession) blockLen(seq uint32) int {
+	offset := uint64(seq) * defaultBlockSize
+	if offset >= s.header.FileLen {
+		return -1
+	}
+	return int(min(s.header.FileLen-offset, defaultBlockSize))
+}
+
+// abort 关闭并删除临时文件
+func (s *uploadSession) abort() {
+	s.file.Close()
+	os.Remove(s.partPath)
+}
@@ -1,19 +1,273 @@
 package processor
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"go-networking/log"
 	"go-networking/network"
+	"go-networking/network/codec"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+const (
+	// 请求未指定Limit时每页返回的项数
+	defaultListDirLimit = 1000
+	// 每页最多返回的项数
+	maxListDirLimit = 10000
+	// 游标持有打开的目录，超过该时间未继续翻页则关闭
+	dirCursorIdleTimeout = 60 * time.Second
+	// 同时打开的游标上限，避免耗尽文件句柄
+	maxDirCursors = 1024
 )
 
+// dirCursor 分页列目录的游标，持有打开的目录，下一页从上次读取的位置继续
+type dirCursor struct {
+	// 同一游标的读取和关闭需要串行
+	mu         sync.Mutex
+	path       string
+	dir        *os.File
+	lastActive time.Time
+}
+
 type ListdireProcessor struct {
 	tcpSrv *network.TcpServer
+	root   string
+	mu     sync.Mutex
+	// 游标属于分配它的连接，其他连接不能使用，连接关闭时一起关闭并删除该连接的条目
+	cursors map[*network.Conn]map[string]*dirCursor
+	// 所有连接打开的游标数
+	cursorCount int
 }
 
-func NewListdireProcs(tcpSrv *network.TcpServer) *ListdireProcessor {
+func NewListdireProcs(tcpSrv *network.TcpServer, root string) *ListdireProcessor {
 	return &ListdireProcessor{
-		tcpSrv: tcpSrv,
+		tcpSrv:  tcpSrv,
+		root:    root,
+		cursors: make(map[*network.Conn]map[string]*dirCursor),
 	}
 }
 
+// 实现目录列表
+// 目录路径解析到存储根目录下，越出根目录的路径和符号链接返回403
+// 首页打开目录并分配游标，之后凭游标按目录顺序继续读取，避免大目录每页都重新读取和排序
+// 游标只能在分配它的连接上使用，连接关闭后游标失效
 func (lp *ListdireProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
-	return nil, nil
+	if _, ok := frame.Header.(*codec.ListDirHeader); !ok {
+		return nil, errors.New("invalid header type for LISTDIR")
+	}
+
+	req, err := network.DecodeListDirPayload(frame.Version, frame.Payload)
+	if err != nil {
+		return lp.newAckFrame(frame, codec.ListDirBadRequest, nil), nil
+	}
+
+	limit := int(req.Limit)
+	if limit == 0 {
+		limit = defaultListDirLimit
+	}
+	if limit > maxListDirLimit {
+		limit = maxListDirLimit
+	}
+
+	lp.cleanupIdleCursors()
+
+	cursorId := req.Cursor
+	cursor, statusCode := lp.lookupCursor(conn, req)
+	if statusCode != codec.ListDirOK {
+		return lp.newAckFrame(frame, statusCode, nil), nil
+	}
+	if len(cursorId) == 0 {
+		cursorId, statusCode = lp.addCursor(conn, cursor)
+		if statusCode != codec.ListDirOK {
+			cursor.dir.Close()
+			return lp.newAckFrame(frame, statusCode, nil), nil
+		}
+	}
+
+	cursor.mu.Lock()
+	dirEntries, err := cursor.dir.ReadDir(limit)
+	cursor.mu.Unlock()
+	if err != nil && err != io.EOF {
+		log.Errorf("read dir %s failed: %v", cursor.path, err)
+		lp.delCursor(conn, cursorId)
+		return lp.newAckFrame(frame, codec.ListDirInternal, nil), nil
+	}
+
+	entries := make([]codec.FileEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		// Info不跟随符号链接，读取期间被删除的项直接跳过
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, codec.FileEntry{
+			Name:    info.Name(),
+			Size:    info.Size(),
+			Mode:    uint32(info.Mode()),
+			ModTime: info.ModTime().Unix(),
+			IsDir:   info.IsDir(),
+		})
+	}
+
+	ack := &codec.ListDirAckPayload{Entries: entries}
+	if len(dirEntries) < limit {
+		lp.delCursor(conn, cursorId)
+	} else {
+		ack.NextCursor = cursorId
+	}
+	return lp.newAckFrame(frame, codec.ListDirOK, ack), nil
+}
+
+// lookupCursor 首页打开请求的目录，后续页在该连接的游标中找到之前分配的游标
+func (lp *ListdireProcessor) lookupCursor(conn *network.Conn, req *codec.ListDirPayload) (*dirCursor, uint16) {
+	if len(req.Cursor) != 0 {
+		lp.mu.Lock()
+		defer lp.mu.Unlock()
+		cursor, exists := lp.cursors[conn][req.Cursor]
+		if !exists || cursor.path != req.DirPath {
+			return nil, codec.ListDirCursorExpired
+		}
+		cursor.lastActive = time.Now()
+		return cursor, codec.ListDirOK
+	}
+
+	path, err := resolveStorePath(lp.root, req.DirPath)
+	if err != nil {
+		log.Errorf("resolve list dir path %s failed: %v", req.DirPath, err)
+		if errors.Is(err, Err_Path_Escapes_Store) {
+			return nil, codec.ListDirForbidden
+		}
+		return nil, codec.ListDirInternal
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, codec.ListDirNotFound
+		}
+		return nil, codec.ListDirInternal
+	}
+	stat, err := dir.Stat()
+	if err != nil || !stat.IsDir() {
+		dir.Close()
+		return nil, codec.ListDirBadRequest
+	}
+
+	return &dirCursor{
+		path:       req.DirPath,
+		dir:        dir,
+		lastActive: time.Now(),
+	}, codec.ListDirOK
+}
+
+// addCursor 在连接上登记游标，连接第一次登记游标时注册关闭回调
+func (lp *ListdireProcessor) addCursor(conn *network.Conn, cursor *dirCursor) (string, uint16) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", codec.ListDirInternal
+	}
+	cursorId := hex.EncodeToString(id)
+
+	lp.mu.Lock()
+	if lp.cursorCount >= maxDirCursors {
+		lp.mu.Unlock()
+		return "", codec.ListDirBusy
+	}
+	connCursors, exists := lp.cursors[conn]
+	if !exists {
+		connCursors = make(map[string]*dirCursor)
+		lp.cursors[conn] = connCursors
+	}
+	connCursors[cursorId] = cursor
+	lp.cursorCount++
+	lp.mu.Unlock()
+
+	if !exists && conn.Connection != nil {
+		conn.Connection.AddCloseCallback(func(netpoll.Connection) error {
+			lp.dropConn(conn)
+			return nil
+		})
+		// 连接已经关闭时回调不会再执行
+		if !conn.Connection.IsActive() {
+			lp.dropConn(conn)
+		}
+	}
+	return cursorId, codec.ListDirOK
+}
+
+func (lp *ListdireProcessor) delCursor(conn *network.Conn, cursorId string) {
+	lp.mu.Lock()
+	cursor, exists := lp.cursors[conn][cursorId]
+	if exists {
+		lp.removeLocked(conn, cursorId)
+	}
+	lp.mu.Unlock()
+
+	if exists {
+		cursor.close()
+	}
+}
+
+// dropConn 连接关闭后关闭它的所有游标
+func (lp *ListdireProcessor) dropConn(conn *network.Conn) {
+	lp.mu.Lock()
+	connCursors := lp.cursors[conn]
+	delete(lp.cursors, conn)
+	lp.cursorCount -= len(connCursors)
+	lp.mu.Unlock()
+
+	for _, cursor := range connCursors {
+		cursor.close()
+	}
+}
+
+// removeLocked 删除连接的游标，连接的条目保留到连接关闭，每个连接只注册一次关闭回调
+func (lp *ListdireProcessor) removeLocked(conn *network.Conn, cursorId string) {
+	delete(lp.cursors[conn], cursorId)
+	lp.cursorCount--
+}
+
+// cleanupIdleCursors 关闭客户端不再翻页的游标
+func (lp *ListdireProcessor) cleanupIdleCursors() {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+
+	now := time.Now()
+	for conn, connCursors := range lp.cursors {
+		for cursorId, cursor := range connCursors {
+			if now.Sub(cursor.lastActive) > dirCursorIdleTimeout {
+				lp.removeLocked(conn, cursorId)
+				go cursor.close()
+			}
+		}
+	}
+}
+
+func (lp *ListdireProcessor) newAckFrame(frame *network.Frame, statusCode uint16, ack *codec.ListDirAckPayload) *network.Frame {
+	var payload []byte
+	if ack != nil {
+		encoded, err := network.EncodeListDirAckPayload(frame.Version, ack)
+		if err != nil {
+			log.Errorf("encode list dir ack failed: %v", err)
+			statusCode = codec.ListDirInternal
+		} else {
+			payload = encoded
+		}
+	}
+
+	ackFrame := network.NewFrame(network.LISTDIRACK, &codec.ListDirAckHeader{StatusCode: statusCode}, payload)
+	ackFrame.Seq = frame.Seq
+	return ackFrame
+}
+
+func (c *dirCursor) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dir.Close()
 }
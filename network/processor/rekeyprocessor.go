@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"errors"
+	"go-networking/crypto/dh"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"time"
+)
+
+type RekeyProcessor struct {
+	tcpSrv *network.TcpServer
+	// 是否允许p=23的演示群，只用于测试
+	allowInsecureGroup bool
+}
+
+func NewRekeyProcs(tcpSrv *network.TcpServer, allowInsecureGroup bool) *RekeyProcessor {
+	return &RekeyProcessor{
+		tcpSrv:             tcpSrv,
+		allowInsecureGroup: allowInsecureGroup,
+	}
+}
+
+// 实现会话密钥轮换
+// 校验连接ID属于当前连接，在同一个群中生成新的临时密钥对并派生新的会话密钥
+// 使用旧密钥回复REKEYACK后改用新密钥，旧的接收密钥在宽限期内仍然有效，
+// 客户端收到REKEYACK之前发出的帧不会被拒绝
+// 新密钥同时写入ConnManager
+func (rp *RekeyProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	header, ok := frame.Header.(*codec.RekeyHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for REKEY")
+	}
+
+	if conn.Crypto() == nil {
+		return rp.newAckFrame(frame, codec.RekeyNotConnected, nil), nil
+	}
+	if stored, exists := rp.tcpSrv.CManager.Load(header.Id); !exists || stored != conn {
+		return rp.newAckFrame(frame, codec.RekeyIdMismatch, nil), nil
+	}
+
+	group := dh.Group(header.Group)
+	if group == dh.GroupInsecure && !rp.allowInsecureGroup {
+		return rp.newAckFrame(frame, codec.RekeyBadRequest, nil), nil
+	}
+	keyPair, err := dh.NewKeyPair(group)
+	if err != nil {
+		return rp.newAckFrame(frame, codec.RekeyBadRequest, nil), nil
+	}
+	secret, err := keyPair.SharedSecret(frame.Payload)
+	if err != nil {
+		log.Errorf("compute rekey shared secret failed: %v", err)
+		return rp.newAckFrame(frame, codec.RekeyBadRequest, nil), nil
+	}
+	cKey, sKey, err := dh.DeriveSessionKeys(secret, frame.Payload, keyPair.PublicKey())
+	if err != nil {
+		return rp.newAckFrame(frame, codec.RekeyInternal, nil), nil
+	}
+
+	// REKEYACK必须使用旧密钥加密，客户端收到后才会切换
+	if err := rp.tcpSrv.Send(conn, rp.newAckFrame(frame, codec.RekeyOK, keyPair.PublicKey())); err != nil {
+		return nil, err
+	}
+	if err := conn.RotateKeys(sKey, cKey); err != nil {
+		return nil, err
+	}
+	rp.tcpSrv.CManager.StoreRotatedKeys(header.Id, cKey, sKey)
+	log.Infof("rotated session keys of connection %s", header.Id)
+	return nil, nil
+}
+
+func (rp *RekeyProcessor) newAckFrame(frame *network.Frame, statusCode uint16, publicKey []byte) *network.Frame {
+	ackFrame := network.NewFrame(network.REKEYACK, &codec.RekeyAckHeader{
+		StatusCode: statusCode,
+		Timestamp:  time.Now().Unix(),
+	}, publicKey)
+	ackFrame.Seq = frame.Seq
+	return ackFrame
+}
@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"crypto/rand"
+	"errors"
+	"go-networking/crypto/dh"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"time"
+)
+
+// 会话恢复时双方随机数的长度
+const resumeNonceLen = 32
+
+type ResumeProcessor struct {
+	tcpSrv *network.TcpServer
+}
+
+func NewResumeProcs(tcpSrv *network.TcpServer) *ResumeProcessor {
+	return &ResumeProcessor{
+		tcpSrv: tcpSrv,
+	}
+}
+
+// 实现会话恢复
+// 票据只能使用一次，不存在、已经过期或者Binder校验失败时回复401，客户端需要重新进行CONN
+// Binder证明客户端持有恢复秘密，校验失败时票据保留，截获票据的一方不能使其失效
+// 使用票据中的恢复秘密和双方的随机数派生新的会话密钥，不重新进行DH交换
+// 新的连接接管原连接ID，并签发新的票据用于下次恢复，新票据使用新的服务端发送密钥加密
+// 在新的TCP连接上重新协商压缩算法
+// 服务端随机数写入到RESUMEACK的Payload中，RESUMEACK本身以明文发送
+// 已有会话的连接上的RESUME在解码时被拒绝
+func (rp *ResumeProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	header, ok := frame.Header.(*codec.ResumeHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for RESUME")
+	}
+	if len(frame.Payload) != resumeNonceLen {
+		return rp.newAckFrame(frame, &codec.ResumeAckHeader{StatusCode: codec.ResumeBadRequest}, nil), nil
+	}
+
+	state, ok := rp.tcpSrv.CManager.RedeemTicket(header.Ticket, func(state *network.ResumptionState) bool {
+		return dh.VerifyResumeBinder(state.Secret, frame.Payload, []byte(header.Ticket), header.Binder)
+	})
+	if !ok {
+		return rp.newAckFrame(frame, &codec.ResumeAckHeader{StatusCode: codec.ResumeTicketRejected}, nil), nil
+	}
+
+	serverNonce, err := newNonce()
+	if err != nil {
+		return rp.newAckFrame(frame, &codec.ResumeAckHeader{StatusCode: codec.ResumeInternal}, nil), nil
+	}
+	cKey, sKey, err := dh.DeriveSessionKeys(state.Secret, frame.Payload, serverNonce)
+	if err != nil {
+		return rp.newAckFrame(frame, &codec.ResumeAckHeader{StatusCode: codec.ResumeInternal}, nil), nil
+	}
+	nextSecret, err := dh.DeriveResumptionSecret(state.Secret, frame.Payload, serverNonce)
+	if err != nil {
+		return rp.newAckFrame(frame, &codec.ResumeAckHeader{StatusCode: codec.ResumeInternal}, nil), nil
+	}
+	ticket, err := rp.tcpSrv.CManager.IssueTicket(state.Id, state.UserId, nextSecret, rp.tcpSrv.TicketLifetime())
+	if err != nil {
+		return rp.newAckFrame(frame, &codec.ResumeAckHeader{StatusCode: codec.ResumeInternal}, nil), nil
+	}
+
+	sealedTicket, err := network.SealTicket(sKey, ticket, state.Id)
+	if err != nil {
+		return rp.newAckFrame(frame, &codec.ResumeAckHeader{StatusCode: codec.ResumeInternal}, nil), nil
+	}
+
+	compression, err := rp.tcpSrv.NegotiateCompression(conn, header.Compressions)
+	if err != nil {
+		return rp.newAckFrame(frame, &codec.ResumeAckHeader{StatusCode: codec.ResumeInternal}, nil), nil
+	}
+
+	rp.tcpSrv.CManager.StoreKeys(state.Id, conn, cKey, sKey)
+	conn.SetCrypto(network.NewGcmCrypto(sKey, cKey))
+	conn.SetUserId(state.UserId)
+	log.Infof("resumed connection %s", state.Id)
+
+	return rp.newAckFrame(frame, &codec.ResumeAckHeader{
+		StatusCode:   codec.ResumeOK,
+		Id:           state.Id,
+		SealedTicket: sealedTicket,
+		Compression:  compression,
+	}, serverNonce), nil
+}
+
+func (rp *ResumeProcessor) newAckFrame(frame *network.Frame, header *codec.ResumeAckHeader, serverNonce []byte) *network.Frame {
+	header.Timestamp = time.Now().Unix()
+	ackFrame := network.NewFrame(network.RESUMEACK, header, serverNonce)
+	ackFrame.Seq = frame.Seq
+	return ackFrame
+}
+
+func newNonce() ([]byte, error) {
+	nonce := make([]byte, resumeNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
@@ -0,0 +1,54 @@
+package processor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	Err_Store_Path_Not_Configured = errors.New("store path is not configured")
+	Err_Path_Escapes_Store        = errors.New("path escapes store root")
+)
+
+// resolveStorePath 将客户端请求的路径解析为存储根目录下的绝对路径。
+// 请求路径一律视为相对于根目录，越出根目录的路径以及指向根目录之外的符号链接返回错误。
+func resolveStorePath(root string, reqPath string) (string, error) {
+	if len(root) == 0 {
+		return "", Err_Store_Path_Not_Configured
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	resolved := filepath.Join(absRoot, filepath.Clean(string(filepath.Separator)+reqPath))
+	if !withinRoot(absRoot, resolved) {
+		return "", Err_Path_Escapes_Store
+	}
+
+	// 路径不存在时交给调用方按不存在处理
+	realPath, err := filepath.EvalSymlinks(resolved)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return resolved, nil
+		}
+		return "", err
+	}
+	realRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		return "", err
+	}
+	if !withinRoot(realRoot, realPath) {
+		return "", Err_Path_Escapes_Store
+	}
+
+	return resolved, nil
+}
+
+func withinRoot(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,54 @@
+package processor
+
+import (
+	"errors"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+)
+
+type SubscribeProcessor struct {
+	tcpSrv *network.TcpServer
+}
+
+func NewSubscribeProcs(tcpSrv *network.TcpServer) *SubscribeProcessor {
+	return &SubscribeProcessor{
+		tcpSrv: tcpSrv,
+	}
+}
+
+// 处理SUBSCRIBE和UNSUBSCRIBE
+// 校验连接ID属于当前连接，订阅记录在ConnManager中，随连接一起删除
+func (sp *SubscribeProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	header, ok := frame.Header.(*codec.SubscribeHeader)
+	if !ok {
+		return nil, errors.New("invalid header type for SUBSCRIBE")
+	}
+
+	if stored, exists := sp.tcpSrv.CManager.Load(header.Id); !exists || stored != conn {
+		return sp.newAckFrame(frame, codec.SubscribeIdMismatch, header.Topic), nil
+	}
+	if header.Topic == "" {
+		return sp.newAckFrame(frame, codec.SubscribeBadRequest, header.Topic), nil
+	}
+
+	if frame.CmdType == network.UNSUBSCRIBE {
+		sp.tcpSrv.Unsubscribe(header.Id, header.Topic)
+		log.Infof("connection %s unsubscribed topic %s", header.Id, header.Topic)
+		return sp.newAckFrame(frame, codec.SubscribeOK, header.Topic), nil
+	}
+	if err := sp.tcpSrv.Subscribe(header.Id, header.Topic); err != nil {
+		return nil, err
+	}
+	log.Infof("connection %s subscribed topic %s", header.Id, header.Topic)
+	return sp.newAckFrame(frame, codec.SubscribeOK, header.Topic), nil
+}
+
+func (sp *SubscribeProcessor) newAckFrame(frame *network.Frame, statusCode uint16, topic string) *network.Frame {
+	ackFrame := network.NewFrame(network.SUBSCRIBEACK, &codec.SubscribeAckHeader{
+		StatusCode: statusCode,
+		Topic:      topic,
+	}, nil)
+	ackFrame.Seq = frame.Seq
+	return ackFrame
+}
@@ -6,33 +6,52 @@ import (
 	"time"
 )
 
+// timeoutPromise promise没有收到响应时保留的时间，没有实现该接口的promise保留30秒
+type timeoutPromise interface {
+	Timeout() time.Duration
+}
+
 type PromiseM struct {
 	rpTable map[uint64]ResponsePromise
-	mux     sync.Mutex
+	// 记录promise对应的服务端地址，关闭连接时据此结束该地址上的promise
+	addrTable map[uint64]string
+	// 每个地址等待响应的promise数，连接池据此选择负载最低的连接
+	pending map[string]int
+	// promise的过期任务，promise删除时取消
+	timers map[uint64]*WheelTimer
+	wheel  *TimingWheel
+	mux    sync.Mutex
 }
 
-func NewPromiseM() *PromiseM {
+// NewPromiseM 创建PromiseM，promise在wheel上按各自的超时时间过期
+func NewPromiseM(wheel *TimingWheel) *PromiseM {
 	return &PromiseM{
-		rpTable: make(map[uint64]ResponsePromise),
+		rpTable:   make(map[uint64]ResponsePromise),
+		addrTable: make(map[uint64]string),
+		pending:   make(map[string]int),
+		timers:    make(map[uint64]*WheelTimer),
+		wheel:     wheel,
 	}
 }
 
-func (p *PromiseM) AddResp(frame *Frame) {
+// AddResp 将响应交给等待该Seq的promise，没有匹配的promise时返回false。
+// promise在锁外接收响应，回调式promise可以在回调中发送新的请求
+func (p *PromiseM) AddResp(frame *Frame) bool {
 	p.mux.Lock()
-	defer p.mux.Unlock()
-
-	if _, exists := p.rpTable[frame.Seq]; exists {
-		// 尝试添加frame到promise中，这里假设Add方法可以抛出异常
-		defer func() {
-			if err := recover(); err != nil {
-				log.Errorf("Recovered in AddResp: %v", err)
-			}
-		}()
-		rp := p.rpTable[frame.Seq]
-		rp.Add(frame)
-	} else {
-		log.Infof("what's wrong? frame sequence not matched with sequence no.: %d", frame.Seq)
+	rp, exists := p.rpTable[frame.Seq]
+	p.mux.Unlock()
+	if !exists {
+		return false
 	}
+
+	// 尝试添加frame到promise中，这里假设Add方法可以抛出异常
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("Recovered in AddResp: %v", err)
+		}
+	}()
+	rp.Add(frame)
+	return true
 }
 
 func (p *PromiseM) AddSeqPromise(seq uint64, rp ResponsePromise) {
@@ -40,13 +59,58 @@ func (p *PromiseM) AddSeqPromise(seq uint64, rp ResponsePromise) {
 	defer p.mux.Unlock()
 
 	p.rpTable[seq] = rp
+	p.scheduleLocked(seq, rp, promiseExpiry(rp))
 }
 
-func (p *PromiseM) DelSeqPromise(seq uint64) {
+// AddAddrPromise 添加发往addr的请求的promise
+func (p *PromiseM) AddAddrPromise(addr string, seq uint64, rp ResponsePromise) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
-	if future, exists := p.rpTable[seq]; exists {
+	p.rpTable[seq] = rp
+	p.addrTable[seq] = addr
+	p.pending[addr]++
+	p.scheduleLocked(seq, rp, promiseExpiry(rp))
+}
+
+// FailAddrPromises 以err结束所有发往addr且尚未收到响应的promise
+func (p *PromiseM) FailAddrPromises(addr string, err error) {
+	p.mux.Lock()
+	var failed []ResponsePromise
+	for seq, promiseAddr := range p.addrTable {
+		if promiseAddr != addr {
+			continue
+		}
+		if future, exists := p.removeLocked(seq); exists {
+			failed = append(failed, future)
+		}
+	}
+	p.mux.Unlock()
+
+	for _, future := range failed {
+		future.Fail(err)
+	}
+}
+
+// FailSeqPromise 以err结束seq对应的promise，例如收到了该请求的ERROR
+func (p *PromiseM) FailSeqPromise(seq uint64, err error) {
+	p.mux.Lock()
+	future, exists := p.removeLocked(seq)
+	p.mux.Unlock()
+
+	if exists {
+		future.Fail(err)
+	} else {
+		log.Infof("no promise waiting for failed sequence no.: %d", seq)
+	}
+}
+
+func (p *PromiseM) DelSeqPromise(seq uint64) {
+	p.mux.Lock()
+	future, exists := p.removeLocked(seq)
+	p.mux.Unlock()
+
+	if exists {
 		// 关闭promise，这里假设Close方法可以抛出异常
 		defer func() {
 			if err := recover(); err != nil {
@@ -54,42 +118,85 @@ func (p *PromiseM) DelSeqPromise(seq uint64) {
 			}
 		}()
 		future.Close()
-		delete(p.rpTable, seq)
 	}
 }
 
+// CloseRespPromis 客户端停止时以Err_Client_Closed结束所有等待中的promise
 func (p *PromiseM) CloseRespPromis() {
 	p.mux.Lock()
-	defer p.mux.Unlock()
+	closed := make([]ResponsePromise, 0, len(p.rpTable))
+	for seq := range p.rpTable {
+		future, _ := p.removeLocked(seq)
+		closed = append(closed, future)
+	}
+	p.mux.Unlock()
 
-	for seq, future := range p.rpTable {
-		delete(p.rpTable, seq)
-		// 关闭CountDownLatch，这里假设Close方法可以抛出异常
-		defer func() {
-			if err := recover(); err != nil {
-				log.Errorf("Recovered in CloseRespPromis: %v", err)
-			}
-		}()
-		future.Close()
+	// 结束CountDownLatch，这里假设Fail方法可以抛出异常
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("Recovered in CloseRespPromis: %v", err)
+		}
+	}()
+	for _, future := range closed {
+		future.Fail(Err_Client_Closed)
 	}
 }
 
-func (p *PromiseM) CleanupRespPromise() {
-	now := time.Now()
+// Pending 发往addr且尚未结束的promise数
+func (p *PromiseM) Pending(addr string) int {
 	p.mux.Lock()
 	defer p.mux.Unlock()
+	return p.pending[addr]
+}
 
-	for seq, future := range p.rpTable {
-		if now.Sub(future.Timestamp()) > 30*time.Second {
-			// 如果ResponseFuture超过30秒钟
-			// 从respTable删除，这里假设Close方法可以抛出异常
-			defer func() {
-				if err := recover(); err != nil {
-					log.Errorf("Recovered in CleanupRespPromise: %v", err)
-				}
-			}()
-			delete(p.rpTable, seq)
-			future.Close()
+// removeLocked 删除seq对应的promise并取消其过期任务
+func (p *PromiseM) removeLocked(seq uint64) (ResponsePromise, bool) {
+	if addr, exists := p.addrTable[seq]; exists {
+		if p.pending[addr]--; p.pending[addr] <= 0 {
+			delete(p.pending, addr)
 		}
+		delete(p.addrTable, seq)
+	}
+	if timer, exists := p.timers[seq]; exists {
+		timer.Stop()
+		delete(p.timers, seq)
+	}
+	future, exists := p.rpTable[seq]
+	delete(p.rpTable, seq)
+	return future, exists
+}
+
+func (p *PromiseM) scheduleLocked(seq uint64, rp ResponsePromise, delay time.Duration) {
+	if timer, exists := p.timers[seq]; exists {
+		timer.Stop()
+	}
+	p.timers[seq] = p.wheel.AfterFunc(delay, func() {
+		p.expire(seq, rp)
+	})
+}
+
+// expire 以超时错误结束超过Timestamp加超时时间仍没有结束的promise。
+// 流式响应收到帧后Timestamp会更新，此时按剩余的时间重新登记过期任务
+func (p *PromiseM) expire(seq uint64, rp ResponsePromise) {
+	p.mux.Lock()
+	if p.rpTable[seq] != rp {
+		p.mux.Unlock()
+		return
+	}
+	if remaining := time.Until(rp.Timestamp().Add(promiseExpiry(rp))); remaining > 0 {
+		p.scheduleLocked(seq, rp, remaining)
+		p.mux.Unlock()
+		return
+	}
+	p.removeLocked(seq)
+	p.mux.Unlock()
+
+	rp.Fail(responseTimeoutError(seq))
+}
+
+func promiseExpiry(rp ResponsePromise) time.Duration {
+	if t, ok := rp.(timeoutPromise); ok && t.Timeout() > 0 {
+		return t.Timeout()
 	}
+	return defaultResponseTimeout
 }
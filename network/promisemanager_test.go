@@ -0,0 +1,48 @@
+package network_test
+
+import (
+	"context"
+	"go-networking/network"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromiseMShouldFailPromiseWhenTimeoutExpires(t *testing.T) {
+	wheel := network.NewTimingWheel(5*time.Millisecond, 64)
+	wheel.Start()
+	defer wheel.Stop()
+	promiseM := network.NewPromiseM(wheel)
+
+	start := time.Now()
+	rp := network.NewResponsePromise(1, 100*time.Millisecond)
+	promiseM.AddAddrPromise(serverAddr, 1, rp)
+	_, err := rp.WaitContext(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// 过期的promise已经删除，之后的响应没有promise接收
+	assert.False(t, promiseM.AddResp(&network.Frame{Seq: 1}))
+}
+
+func TestPromiseMShouldKeepResponseWhenPromiseRemovedBeforeExpiry(t *testing.T) {
+	wheel := network.NewTimingWheel(5*time.Millisecond, 64)
+	wheel.Start()
+	defer wheel.Stop()
+	promiseM := network.NewPromiseM(wheel)
+
+	rp := network.NewResponsePromise(1, 50*time.Millisecond)
+	promiseM.AddSeqPromise(1, rp)
+	frame := &network.Frame{Seq: 1}
+	require.True(t, promiseM.AddResp(frame))
+	promiseM.DelSeqPromise(1)
+
+	// 过期时间之后仍然返回已经收到的响应
+	time.Sleep(100 * time.Millisecond)
+	resp, err := rp.WaitContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, frame, resp)
+}
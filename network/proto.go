@@ -3,13 +3,36 @@ package network
 type VersionType uint16
 
 const (
+	// 最初的LV格式，Seq之后没有Flags。已不再注册，收到时按未注册的版本关闭连接
 	VERSION_1 VersionType = iota + 1
+	// 以protobuf编码的帧，见ProtobufCodec
+	VERSION_PROTOBUF
+	// 带CRC32C校验和的最初LV格式，同样没有Flags，已不再注册
+	VERSION_CHECKSUM
+	// Seq之后带有帧标志Flags的LV格式，见LVCodec
+	VERSION_2
+	// 带CRC32C校验和的VERSION_2，见NewChecksumLVCodec
+	VERSION_2_CHECKSUM
+)
+
+// FrameFlags 帧级别的标志，在Seq之后编码，不加密但受认证保护
+type FrameFlags uint8
+
+const (
+	// FlagEncrypted Header和负载使用连接的会话密钥加密，由编解码器设置
+	FlagEncrypted FrameFlags = 1 << iota
+	// FlagCompressed 负载使用连接协商的算法压缩，在加密之前处理
+	FlagCompressed
+	// FlagEndOfStream 同一Seq的最后一个响应帧。processor返回的响应由TcpServer设置，
+	// 自行发送多个响应的processor在最后一帧上设置，例如下载的最后一个TRANSFER
+	FlagEndOfStream
 )
 
 type Frame struct {
 	Version VersionType
 	CmdType CommandType
 	Seq     uint64
+	Flags   FrameFlags
 	HLen    uint16
 	Header  interface{}
 	Payload []byte
@@ -17,41 +40,19 @@ type Frame struct {
 
 func NewFrame(cmdType CommandType, h interface{}, payload []byte) *Frame {
 	return &Frame{
-		Version: VERSION_1,
+		Version: VERSION_2,
 		CmdType: cmdType,
 		Header:  h,
 		Payload: payload,
 	}
 }
 
-const (
-	LVBasedCodec = iota
-)
-
-func Encode(codecChoice int, frame *Frame) ([]byte, error) {
-	if codecChoice == LVBasedCodec {
-		codec := NewLVCodec()
-		data, err := codec.Encode(frame)
-		if err != nil {
-			return nil, err
-		}
-
-		return data, nil
-	} else {
-		return nil, nil
-	}
+// Encode 使用DefaultCodecs按frame.Version编码帧，结果包含长度前缀
+func Encode(frame *Frame) ([]byte, error) {
+	return DefaultCodecs.Encode(frame)
 }
 
-func Decode(codecChoice int, data []byte) (*Frame, error) {
-	if codecChoice == LVBasedCodec {
-		codec := NewLVCodec()
-		frame, err := codec.Decode(data)
-		if err != nil {
-			return nil, err
-		}
-
-		return frame, nil
-	} else {
-		return nil, nil
-	}
+// Decode 使用DefaultCodecs解码不含长度前缀的帧，未注册的版本返回Err_Unsupported_Version
+func Decode(data []byte) (*Frame, error) {
+	return DefaultCodecs.Decode(data)
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"go-networking/network"
+	"strings"
 	"testing"
 )
 
@@ -52,52 +53,80 @@ func (codec ConnCodec) Decode(data []byte) (interface{}, error) {
 	return conn, nil
 }
 
+// newProtoCodecs 使用独立的注册表，CommandA不会与其他测试注册的Header编解码器冲突
+func newProtoCodecs() *network.CodecRegistry {
+	commands := network.NewCommandFactory()
+	commands.AddCmdCodec(CommandA, &ConnCodec{})
+	codecs := network.NewCodecRegistry()
+	codecs.Register(network.VERSION_2, network.NewLVCodecWithCommands(commands))
+	return codecs
+}
+
 func TestEncodeShouldReturnBytesWhenEncodeSuccess(t *testing.T) {
-	network.AddHeaderCodec(CommandA, &ConnCodec{})
+	codecs := newProtoCodecs()
 
 	givenConn := Conn{
 		KeyLen: uint32(len("ABC")),
 		Key:    "ABC",
 	}
 	frame := &network.Frame{
-		Version: 1,
+		Version: network.VERSION_2,
 		CmdType: CommandA,
 		Seq:     1,
 		Header:  givenConn,
 		Payload: []byte{0x04, 0x05, 0x06},
 	}
 
-	data, err := network.Encode(network.LVBasedCodec, frame)
+	data, err := codecs.Encode(frame)
 	if err != nil {
 		t.Fatalf("Expected nil error, got %v", err)
 	}
 
-	expectedData := []byte{0x01, 0x00, 0x01, 0x04, 0x03, 0x41, 0x42, 0x43, 0x04, 0x05, 0x06}
+	expectedData := []byte{
+		0x0c,                   // 长度前缀
+		0x04,                   // Version：VERSION_2
+		0x00,                   // CmdType
+		0x01,                   // Seq
+		0x00,                   // Flags
+		0x04,                   // HLen
+		0x03, 0x41, 0x42, 0x43, // Header：KeyLen和Key
+		0x04, 0x05, 0x06, // Payload
+	}
 	if !bytes.Equal(data, expectedData) {
 		t.Errorf("Expected %v, got %v", expectedData, data)
 	}
+
+	// 去掉长度前缀后可以解码出原来的帧
+	decoded, err := codecs.Decode(data[1:])
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if decoded.Seq != frame.Seq || !bytes.Equal(decoded.Payload, frame.Payload) {
+		t.Errorf("Expected seq %d and payload %v, got %d and %v", frame.Seq, frame.Payload, decoded.Seq, decoded.Payload)
+	}
 }
 
 func TestEncodeShouldReturnErrorWhenHeaderEncodeFails(t *testing.T) {
-	network.AddHeaderCodec(CommandA, &ConnCodec{})
+	codecs := newProtoCodecs()
 	frame := &network.Frame{}
-	_, err := network.Encode(network.LVBasedCodec, frame)
+	_, err := codecs.Encode(frame)
 	if err == nil {
 		t.Error("Expected error, got nil")
 	}
 }
 
 func TestDecodeShouldReturnFrameWhenDecodeSuccess(t *testing.T) {
-	network.AddHeaderCodec(CommandA, &ConnCodec{})
+	codecs := newProtoCodecs()
 
-	frame := []byte{0x01, 0x00, 0x01, 0x03, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
-	proto, err := network.Decode(network.LVBasedCodec, frame)
+	// Version、CmdType、Seq、Flags、HLen、Header和Payload
+	frame := []byte{0x04, 0x00, 0x01, 0x00, 0x03, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	proto, err := codecs.Decode(frame)
 	if err != nil {
 		t.Fatalf("Expected nil error, got %v", err)
 	}
 
-	if proto.Version != 0x0001 {
-		t.Errorf("Expected %v, got %v", 0x0001, proto.Version)
+	if proto.Version != network.VERSION_2 {
+		t.Errorf("Expected %v, got %v", network.VERSION_2, proto.Version)
 	}
 
 	if proto.CmdType != CommandA {
@@ -111,19 +140,28 @@ func TestDecodeShouldReturnFrameWhenDecodeSuccess(t *testing.T) {
 }
 
 func TestDecodeShouldReturnErrorWhenFrameTooShort(t *testing.T) {
-	shortData := []byte{0x01, 0x02}
+	codecs := newProtoCodecs()
+	shortData := []byte{0x04, 0x02}
 
-	_, err := network.Decode(network.LVBasedCodec, shortData)
+	_, err := codecs.Decode(shortData)
 	if err == nil {
 		t.Error("Expected error, got nil")
 	}
 }
 
 func TestDecodeShouldReturnErrorWhenSubheaderLengthReadFails(t *testing.T) {
-	// 创建一个只包含版本、命令和长度字段的帧，但长度字段的长度超出了剩余的帧大小
-	frame := []byte{0x00, 0x01, 0x00, 0x01, 0x00, 0x04}
-	_, err := network.Decode(network.LVBasedCodec, frame)
-	if err == nil {
-		t.Error("Expected error, got nil")
+	codecs := newProtoCodecs()
+	// Version、CmdType、Seq和Flags都合法，HLen是多字节varint但在第一个字节后被截断
+	frame := []byte{0x04, 0x00, 0x01, 0x00, 0x80}
+	_, err := codecs.Decode(frame)
+	if err == nil || !strings.Contains(err.Error(), "header length") {
+		t.Errorf("Expected header length error, got %v", err)
+	}
+
+	// HLen可以读出，但超出了剩余的帧大小
+	frame = []byte{0x04, 0x00, 0x01, 0x00, 0x04, 0x01}
+	_, err = codecs.Decode(frame)
+	if err == nil || !strings.Contains(err.Error(), "subheader length") {
+		t.Errorf("Expected subheader length error, got %v", err)
 	}
 }
@@ -0,0 +1,208 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go-networking/network/codec"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Frame消息的字段号，定义见network/codec/proto/frame.proto
+const (
+	pbFieldCmdType protowire.Number = 1
+	pbFieldSeq     protowire.Number = 2
+	pbFieldHeader  protowire.Number = 3
+	pbFieldPayload protowire.Number = 4
+	pbFieldFlags   protowire.Number = 5
+	pbFieldSealed  protowire.Number = 6
+)
+
+var pbCmdFactory = NewCommandFactory()
+
+// AddProtobufHeaderCodec 向ProtobufCodec默认的Header编解码器表注册命令
+func AddProtobufHeaderCodec(cmdType CommandType, headerCodec HeaderCodec) {
+	pbCmdFactory.AddCmdCodec(cmdType, headerCodec)
+}
+
+// ProtobufCodec 以protobuf编码帧和Header，供无法实现LV格式的其他语言客户端使用。
+// 线上格式为 uvarint(长度) | uvarint(Version) | Frame消息，加密规则与LVCodec相同：
+// 加密的帧中header和payload字段编码后一起加密，放在最后的sealed字段中，
+// 附加认证数据为sealed字段之前的全部字节。
+type ProtobufCodec struct {
+	commands *CommandFactory
+	crypto   CryptoAlg
+}
+
+// NewProtobufCodec 使用AddProtobufHeaderCodec注册的Header编解码器
+func NewProtobufCodec() *ProtobufCodec {
+	return &ProtobufCodec{commands: pbCmdFactory}
+}
+
+// NewProtobufCodecWithCommands 使用独立的Header编解码器表
+func NewProtobufCodecWithCommands(commands *CommandFactory) *ProtobufCodec {
+	return &ProtobufCodec{commands: commands}
+}
+
+func (pc *ProtobufCodec) WithCrypto(crypto CryptoAlg) Codec {
+	return &ProtobufCodec{commands: pc.commands, crypto: crypto}
+}
+
+func (pc *ProtobufCodec) Encode(frame *Frame) ([]byte, error) {
+	headerCodec, err := pc.commands.GetCmdCodec(frame.CmdType)
+	if err != nil {
+		return nil, err
+	}
+	header, err := headerCodec.Encode(frame.Header)
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: %d", Err_Header_Too_Large, len(header))
+	}
+	frame.HLen = uint16(len(header))
+	frame.Flags &^= FlagEncrypted
+	if encryptsFrame(pc.crypto, frame) {
+		frame.Flags |= FlagEncrypted
+	}
+
+	buf := protowire.AppendVarint(nil, uint64(frame.Version))
+	e := &codec.PBEncoder{}
+	e.WriteUint(pbFieldCmdType, uint64(frame.CmdType))
+	e.WriteUint(pbFieldSeq, frame.Seq)
+	e.WriteUint(pbFieldFlags, uint64(frame.Flags))
+	buf = append(buf, e.Bytes()...)
+
+	body := &codec.PBEncoder{}
+	body.WriteBytes(pbFieldHeader, header)
+	body.WriteBytes(pbFieldPayload, frame.Payload)
+	if frame.Flags&FlagEncrypted != 0 {
+		sealed, err := pc.crypto.Encrypt(body.Bytes(), buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = protowire.AppendTag(buf, pbFieldSealed, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, sealed)
+	} else {
+		buf = append(buf, body.Bytes()...)
+	}
+
+	data := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen32+len(buf)), uint64(len(buf)))
+	return append(data, buf...), nil
+}
+
+func (pc *ProtobufCodec) Decode(data []byte) (*Frame, error) {
+	version, n := protowire.ConsumeVarint(data)
+	if n < 0 {
+		return nil, errors.New("failed to decode version, invalid bytes")
+	}
+	if version > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: %d", Err_Unsupported_Version, version)
+	}
+	frame := &Frame{Version: VersionType(version)}
+
+	fields, err := consumeFrameFields(data, n, frame)
+	if err != nil {
+		return nil, err
+	}
+	if fields.sealed != nil {
+		if frame.Flags&FlagEncrypted == 0 {
+			return nil, errors.New("sealed field without encrypted flag")
+		}
+		plain, err := decryptBody(pc.crypto, fields.sealed, data[:fields.aadLen])
+		if err != nil {
+			return nil, err
+		}
+		// 解密后的内容只包含header和payload字段
+		if fields, err = consumeFrameFields(plain, 0, &Frame{}); err != nil {
+			return nil, err
+		}
+		if fields.sealed != nil {
+			return nil, errors.New("nested sealed field")
+		}
+	} else if frame.Flags&FlagEncrypted != 0 {
+		return nil, fmt.Errorf("%w: encrypted frame without sealed field", Err_Frame_Auth_Failed)
+	}
+
+	if len(fields.header) > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: %d", Err_Header_Too_Large, len(fields.header))
+	}
+	frame.HLen = uint16(len(fields.header))
+	headerCodec, err := pc.commands.GetCmdCodec(frame.CmdType)
+	if err != nil {
+		return nil, unknownCommandError(frame, err)
+	}
+	// data可能是连接的读缓冲，解码后会被释放，Header和Payload都需要复制
+	decoded, err := headerCodec.Decode(append([]byte(nil), fields.header...))
+	if err != nil {
+		return nil, badHeaderError(frame, err)
+	}
+	frame.Header = decoded
+	frame.Payload = append([]byte{}, fields.payload...)
+
+	if err := checkPlaintext(pc.crypto, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// pbFrameFields Frame消息中的字节字段，sealed不为nil时header和payload位于其中
+type pbFrameFields struct {
+	header, payload, sealed []byte
+	// sealed字段之前的字节数，即附加认证数据的长度
+	aadLen int
+}
+
+// consumeFrameFields 从offset开始解析Frame消息的字段，cmd_type、seq和flags写入frame
+func consumeFrameFields(data []byte, offset int, frame *Frame) (*pbFrameFields, error) {
+	fields := &pbFrameFields{}
+	for offset < len(data) {
+		if fields.sealed != nil {
+			return nil, errors.New("sealed must be the last field of the frame")
+		}
+		num, typ, n := protowire.ConsumeTag(data[offset:])
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		fieldStart := offset
+		offset += n
+
+		switch {
+		case num == pbFieldCmdType && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(data[offset:])
+			if v > math.MaxUint32 {
+				return nil, fmt.Errorf("command type out of range: %d", v)
+			}
+			frame.CmdType = CommandType(v)
+		case num == pbFieldSeq && typ == protowire.VarintType:
+			frame.Seq, n = protowire.ConsumeVarint(data[offset:])
+		case num == pbFieldFlags && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(data[offset:])
+			if v > math.MaxUint8 {
+				return nil, fmt.Errorf("unknown frame flags: 0x%x", v)
+			}
+			frame.Flags = FrameFlags(v)
+		case num == pbFieldHeader && typ == protowire.BytesType:
+			fields.header, n = protowire.ConsumeBytes(data[offset:])
+		case num == pbFieldPayload && typ == protowire.BytesType:
+			fields.payload, n = protowire.ConsumeBytes(data[offset:])
+		case num == pbFieldSealed && typ == protowire.BytesType:
+			fields.sealed, n = protowire.ConsumeBytes(data[offset:])
+			fields.aadLen = fieldStart
+			if fields.sealed == nil {
+				fields.sealed = []byte{}
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data[offset:])
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		offset += n
+	}
+	return fields, nil
+}
@@ -0,0 +1,179 @@
+package network_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"go-networking/crypto/dh"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stripLength 去掉Encode结果中的长度前缀，得到Decode的输入
+func stripLength(t *testing.T, data []byte) []byte {
+	frameLen, n := binary.Uvarint(data)
+	require.Greater(t, n, 0)
+	require.Equal(t, int(frameLen), len(data)-n)
+	return data[n:]
+}
+
+func TestProtobufCodecShouldRoundTripLikeLVCodecWhenHeadersValid(t *testing.T) {
+	id := strings.Repeat("a", 32)
+	cases := []struct {
+		cmdType network.CommandType
+		header  interface{}
+		// LV格式没有注册PING和PONG
+		lv bool
+	}{
+		{network.CONN, &codec.ConnHeader{Timestamp: 1700000000, Group: 2}, true},
+		{network.CONNACK, &codec.ConnAckHeader{Id: id, Timestamp: 1700000001, Group: 1, IdentityKey: []byte("key"), Signature: []byte("sig"), Ticket: "ticket"}, true},
+		{network.PING, &codec.PingHeader{Timestamp: 1700000002, Id: id}, false},
+		{network.PONG, &codec.PongHeader{Timestamp: 1700000003}, false},
+		{network.CLOSE, &codec.CloseHeader{Id: id, Reason: "bye"}, true},
+		{network.CLOSEACK, &codec.CloseAckHeader{StatusCode: codec.CloseDrainTimeout, Details: "slow"}, true},
+		{network.LISTDIR, &codec.ListDirHeader{Id: id, Timestamp: 1700000004}, true},
+		{network.LISTDIRACK, &codec.ListDirAckHeader{StatusCode: codec.ListDirNotFound}, true},
+		{network.FILETRANSFER, &codec.FileTransfer{Length: 9, FilePath: "/docs/a.bin", FileID: 3, Checksum: 4, StartBlock: 5, BlockCount: 6}, true},
+		{network.FILETRANSFERACK, &codec.FileTransferAck{FileID: 3, FileLen: 1 << 40, Checksum: 4, BlockSize: 32 * 1024, ErrorCode: codec.FileTransferChanged}, true},
+		{network.TRANSFER, &codec.Transfer{FileID: 3, Seq: 7}, true},
+		{network.TRANSFERACK, &codec.TransferAck{FileID: 3, Seq: 7}, true},
+		{network.FILEUPLOAD, &codec.FileUpload{FileName: "a.bin", FileLen: 1 << 33, Checksum: 4, UserId: 8, ParentId: 9}, true},
+		{network.FILEUPLOADACK, &codec.FileUploadAck{FileID: 3, BlockSize: 1024, ErrorCode: codec.FileTransferChecksumMismatch, Done: true}, true},
+		{network.REKEY, &codec.RekeyHeader{Id: id, Timestamp: 1700000005, Group: 1}, true},
+		{network.REKEYACK, &codec.RekeyAckHeader{StatusCode: codec.RekeyOK, Timestamp: 1700000006}, true},
+		{network.RESUME, &codec.ResumeHeader{Ticket: "ticket", Timestamp: 1700000007, Binder: []byte("binder")}, true},
+		{network.RESUMEACK, &codec.ResumeAckHeader{StatusCode: codec.ResumeOK, Id: id, Timestamp: 1700000008, SealedTicket: []byte("next")}, true},
+		{network.ERROR, &codec.ErrorHeader{Seq: 41, Code: codec.ErrorInternal, Message: "boom"}, true},
+		{network.NOTIFY, &codec.NotifyHeader{Event: codec.NotifyFileChanged, Subject: "/a.txt"}, true},
+		{network.SUBSCRIBE, &codec.SubscribeHeader{Id: id, Topic: "files"}, true},
+		{network.SUBSCRIBEACK, &codec.SubscribeAckHeader{StatusCode: codec.SubscribeOK, Topic: "files"}, true},
+		{network.PUBLISH, &codec.PublishHeader{Topic: "files"}, true},
+		{network.AUTH, &codec.AuthHeader{Id: id, Timestamp: 1700000009, Token: "token"}, true},
+		{network.AUTHACK, &codec.AuthAckHeader{StatusCode: codec.AuthOK, Timestamp: 1700000010, UserId: 7}, true},
+	}
+
+	for _, c := range cases {
+		frame := network.NewFrame(c.cmdType, c.header, []byte("payload"))
+		frame.Seq = 42
+		frame.Version = network.VERSION_PROTOBUF
+		data, err := network.NewProtobufCodec().Encode(frame)
+		require.NoError(t, err, "cmd type %d", c.cmdType)
+		decoded, err := network.NewProtobufCodec().Decode(stripLength(t, data))
+		require.NoError(t, err, "cmd type %d", c.cmdType)
+		assert.Equal(t, network.VERSION_PROTOBUF, decoded.Version)
+		assert.Equal(t, c.cmdType, decoded.CmdType)
+		assert.Equal(t, uint64(42), decoded.Seq)
+		assert.Equal(t, c.header, decoded.Header, "cmd type %d", c.cmdType)
+		assert.Equal(t, []byte("payload"), decoded.Payload)
+
+		if !c.lv {
+			continue
+		}
+		lvData, err := network.NewLVCodec().Encode(network.NewFrame(c.cmdType, c.header, []byte("payload")))
+		require.NoError(t, err, "cmd type %d", c.cmdType)
+		lvFrame, err := network.NewLVCodec().Decode(stripLength(t, lvData))
+		require.NoError(t, err, "cmd type %d", c.cmdType)
+		assert.Equal(t, lvFrame.Header, decoded.Header, "cmd type %d", c.cmdType)
+		assert.Equal(t, lvFrame.Payload, decoded.Payload)
+	}
+}
+
+func TestProtobufCodecShouldEncodeFrameAsProtobufMessage(t *testing.T) {
+	frame := network.NewFrame(network.CONN, &codec.ConnHeader{Timestamp: 1, Group: 2}, []byte("k"))
+	frame.Version = network.VERSION_PROTOBUF
+	frame.Seq = 3
+	data, err := network.NewProtobufCodec().Encode(frame)
+	require.NoError(t, err)
+
+	expected := []byte{
+		0x0e,       // 长度
+		0x02,       // Version
+		0x08, 0x01, // cmd_type = CONN
+		0x10, 0x03, // seq
+		0x1a, 0x04, // header
+		0x08, 0x01, 0x10, 0x02, // ConnHeader{timestamp: 1, group: 2}
+		0x22, 0x01, 'k', // payload
+	}
+	assert.Equal(t, expected, data)
+}
+
+func TestProtobufCodecShouldRejectFrameWhenTampered(t *testing.T) {
+	client, server := newGcmPair(t)
+	payload := []byte("payload that must not appear on the wire")
+	frame := newListDirFrame(5, payload)
+	frame.Version = network.VERSION_PROTOBUF
+
+	data, err := network.NewProtobufCodec().WithCrypto(client).Encode(frame)
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(data, payload), "payload should be encrypted")
+	decoded, err := network.NewProtobufCodec().WithCrypto(server).Decode(stripLength(t, data))
+	require.NoError(t, err)
+	assert.Equal(t, payload, decoded.Payload)
+
+	// 修改Seq，帧头属于附加认证数据
+	client, server = newGcmPair(t)
+	data, err = network.NewProtobufCodec().WithCrypto(client).Encode(frame)
+	require.NoError(t, err)
+	seqIdx := bytes.Index(data, []byte{0x10, 0x05}) + 1
+	data[seqIdx] = 0x06
+	_, err = network.NewProtobufCodec().WithCrypto(server).Decode(stripLength(t, data))
+	assert.ErrorIs(t, err, network.Err_Frame_Auth_Failed)
+}
+
+func TestProtobufClientShouldConnectAndDownloadWhenServerAcceptsBothFormats(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	content := make([]byte, 100*1024+3)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data.bin"), content, 0644))
+
+	tcpSrv := StartConfiguredFileTcpServer(root, &fakeFileRecorder{}, func(config *network.TcpServerConfig) {
+		config.Codec = newVersionedCodecs(map[network.VersionType]network.Codec{
+			network.VERSION_2:        network.NewLVCodec(),
+			network.VERSION_PROTOBUF: network.NewProtobufCodec(),
+		})
+	})
+	defer tcpSrv.Stop()
+
+	pbClient := StartVersionedFileTcpClient(network.VERSION_PROTOBUF, network.NewProtobufCodec())
+	defer pbClient.Stop()
+	require.NoError(t, pbClient.Connect(fileServerAddr, dh.GroupX25519))
+	ack, err := pbClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+	require.Len(t, ack.Entries, 1)
+	assert.Equal(t, "data.bin", ack.Entries[0].Name)
+
+	// VERSION_PROTOBUF的目录列表负载是ListDirPayload和ListDirAckPayload消息
+	payload, err := (&codec.ListDirPayload{DirPath: "/"}).EncodeProtobuf()
+	require.NoError(t, err)
+	resp, err := pbClient.SendSync(fileServerAddr, network.NewFrame(network.LISTDIR,
+		&codec.ListDirHeader{Id: strings.Repeat("a", 32), Timestamp: time.Now().Unix()}, payload), 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, &codec.ListDirAckHeader{StatusCode: codec.ListDirOK}, resp.Header)
+	pbAck := &codec.ListDirAckPayload{}
+	require.NoError(t, pbAck.DecodeProtobuf(resp.Payload))
+	assert.Equal(t, ack, pbAck)
+
+	destPath := filepath.Join(t.TempDir(), "data.bin")
+	_, err = pbClient.DownloadFile(fileServerAddr, "/data.bin", destPath)
+	require.NoError(t, err)
+	downloaded, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, downloaded), "downloaded file should equal the source file")
+
+	lvClient := StartFileTcpClient()
+	defer lvClient.Stop()
+	require.NoError(t, lvClient.Connect(fileServerAddr, dh.GroupX25519))
+	_, err = lvClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+}
@@ -0,0 +1,42 @@
+package network
+
+import (
+	"fmt"
+	"go-networking/network/codec"
+)
+
+// ProtocolError 服务端以ERROR帧拒绝的请求，Code取值见codec.ErrorHeader的错误码。
+// 服务端解码请求失败时也使用该类型，以便按Seq回复ERROR。
+type ProtocolError struct {
+	// 失败的请求的Seq
+	Seq     uint64
+	Code    uint16
+	Message string
+	// 失败的请求使用的协议版本，用于在握手前回复ERROR
+	version VersionType
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("protocol error %d, seq: %d: %s", e.Code, e.Seq, e.Message)
+}
+
+// newErrorFrame 回复ERROR帧，Seq与原请求相同
+func newErrorFrame(e *ProtocolError) *Frame {
+	frame := NewFrame(ERROR, &codec.ErrorHeader{
+		Seq:     e.Seq,
+		Code:    e.Code,
+		Message: e.Message,
+	}, nil)
+	frame.Seq = e.Seq
+	return frame
+}
+
+// unknownCommandError 收到的帧已经解析出Seq，但没有该命令的Header编解码器
+func unknownCommandError(frame *Frame, err error) *ProtocolError {
+	return &ProtocolError{Seq: frame.Seq, Code: codec.ErrorUnknownCommand, Message: err.Error(), version: frame.Version}
+}
+
+// badHeaderError 收到的帧已经解析出Seq，但Header无法解码
+func badHeaderError(frame *Frame, err error) *ProtocolError {
+	return &ProtocolError{Seq: frame.Seq, Code: codec.ErrorBadRequest, Message: err.Error(), version: frame.Version}
+}
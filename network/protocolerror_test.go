@@ -0,0 +1,90 @@
+package network_test
+
+import (
+	"errors"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingProcessor 处理请求时返回固定的错误
+type failingProcessor struct {
+	err error
+}
+
+func (p *failingProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	return nil, p.err
+}
+
+// rawHeaderCodec 原样写出Header字节，用于构造服务端无法解码的Header
+type rawHeaderCodec struct{}
+
+func (rawHeaderCodec) Encode(header interface{}) ([]byte, error) {
+	return header.([]byte), nil
+}
+
+func (rawHeaderCodec) Decode(data []byte) (interface{}, error) {
+	return data, nil
+}
+
+// requireProtocolError SendSync在收到ERROR后立即返回，不等待超时
+func requireProtocolError(t *testing.T, tcpClient *network.TcpClient, frame *network.Frame, code uint16) *network.ProtocolError {
+	start := time.Now()
+	_, err := tcpClient.SendSync(fileServerAddr, frame, 5*time.Second)
+	var protocolErr *network.ProtocolError
+	require.ErrorAs(t, err, &protocolErr)
+	assert.Equal(t, code, protocolErr.Code)
+	assert.Equal(t, frame.Seq, protocolErr.Seq)
+	assert.NotEmpty(t, protocolErr.Message)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	return protocolErr
+}
+
+func TestSendSyncShouldReturnProtocolErrorWhenServerCannotDecodeHeader(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+
+	// 客户端的命令表包含服务端没有注册的命令，以及服务端无法解码的LISTDIR Header
+	commands := network.NewCommandFactory()
+	commands.AddCmdCodec(network.LISTDIR, rawHeaderCodec{})
+	commands.AddCmdCodec(200, rawHeaderCodec{})
+	commands.AddCmdCodec(network.ERROR, codec.MustStructCodec(&codec.ErrorHeader{}))
+	tcpClient := StartVersionedFileTcpClient(network.VERSION_2, network.NewLVCodecWithCommands(commands))
+	defer tcpClient.Stop()
+
+	requireProtocolError(t, tcpClient, network.NewFrame(200, []byte("x"), nil), codec.ErrorUnknownCommand)
+	requireProtocolError(t, tcpClient, network.NewFrame(network.LISTDIR, []byte{0x01}, nil), codec.ErrorBadRequest)
+	// 帧已经完整读取，连接仍然可用
+	requireProtocolError(t, tcpClient, network.NewFrame(200, []byte("y"), nil), codec.ErrorUnknownCommand)
+}
+
+func TestSendSyncShouldReturnProtocolErrorWhenProcessorFails(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	tcpSrv.AddProcessor(network.REKEYACK, &failingProcessor{err: errors.New("disk failure")})
+	tcpSrv.AddProcessor(network.RESUMEACK, &failingProcessor{err: &network.ProtocolError{Code: 409, Message: "conflict"}})
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	// processor返回普通错误时回复500，返回*ProtocolError时保留其错误码
+	protocolErr := requireProtocolError(t, tcpClient, network.NewFrame(network.REKEYACK, &codec.RekeyAckHeader{}, nil), codec.ErrorInternal)
+	assert.Contains(t, protocolErr.Message, "disk failure")
+	protocolErr = requireProtocolError(t, tcpClient, network.NewFrame(network.RESUMEACK, &codec.ResumeAckHeader{}, nil), 409)
+	assert.Equal(t, "conflict", protocolErr.Message)
+
+	// 服务端能解码CLOSEACK，但没有处理它的processor
+	frame := network.NewFrame(network.CLOSEACK, &codec.CloseAckHeader{StatusCode: codec.CloseOK}, nil)
+	protocolErr = requireProtocolError(t, tcpClient, frame, codec.ErrorUnknownCommand)
+	assert.True(t, strings.Contains(protocolErr.Message, "processor"))
+
+	_, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+}
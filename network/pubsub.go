@@ -0,0 +1,298 @@
+package network
+
+import (
+	"fmt"
+	"go-networking/log"
+	"go-networking/network/codec"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPublishQueueSize 每个订阅连接默认最多缓存的待发送消息数
+const DefaultPublishQueueSize = 64
+
+// subscriber 一个连接订阅的主题和待发送的PUBLISH帧。
+// 每个连接有独立的发送队列，队列满时丢弃发往该连接的新消息，
+// 慢的连接不会阻塞发布方和其他订阅者。
+type subscriber struct {
+	id     string
+	topics map[string]struct{}
+	queue  chan *Frame
+	// 连接不再订阅任何主题时关闭，发送goroutine随之退出
+	done chan struct{}
+}
+
+// offer 将frame放入发送队列，队列已满或订阅已经结束时返回false
+func (sub *subscriber) offer(frame *Frame) bool {
+	select {
+	case <-sub.done:
+		return false
+	default:
+	}
+	select {
+	case sub.queue <- frame:
+		return true
+	default:
+		return false
+	}
+}
+
+// subscriptions 主题的订阅连接，按ConnManager中的连接ID记录。
+// 连接从ConnManager删除时一并删除，RESUME恢复的连接ID保留原有的订阅。
+type subscriptions struct {
+	mu sync.Mutex
+	// key: 主题，value: 订阅该主题的连接
+	topics map[string]map[string]*subscriber
+	// key: 连接ID
+	subscribers map[string]*subscriber
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{
+		topics:      make(map[string]map[string]*subscriber),
+		subscribers: make(map[string]*subscriber),
+	}
+}
+
+// subscribe 将id加入topic的订阅者，连接第一次订阅时created为true。
+// connected在持有锁时检查连接是否仍在ConnManager中，连接已经删除时返回false，
+// 删除连接时的remove在其后执行，不会留下没有连接的订阅者。
+func (subs *subscriptions) subscribe(id string, topic string, queueSize int, connected func() bool) (sub *subscriber, created bool, ok bool) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	if !connected() {
+		return nil, false, false
+	}
+	sub, created = subs.subscriberLocked(id, queueSize)
+	sub.topics[topic] = struct{}{}
+	if subs.topics[topic] == nil {
+		subs.topics[topic] = make(map[string]*subscriber)
+	}
+	subs.topics[topic][id] = sub
+	return sub, created, true
+}
+
+// queueOf id的发送队列，连接没有订阅时创建一个不订阅任何主题的队列，第一次创建时created为true
+func (subs *subscriptions) queueOf(id string, queueSize int, connected func() bool) (sub *subscriber, created bool, ok bool) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	if !connected() {
+		return nil, false, false
+	}
+	sub, created = subs.subscriberLocked(id, queueSize)
+	return sub, created, true
+}
+
+// subscriberLocked 取得或创建id的subscriber，调用方需要持有mu
+func (subs *subscriptions) subscriberLocked(id string, queueSize int) (sub *subscriber, created bool) {
+	if sub, exists := subs.subscribers[id]; exists {
+		return sub, false
+	}
+	sub = &subscriber{
+		id:     id,
+		topics: make(map[string]struct{}),
+		queue:  make(chan *Frame, queueSize),
+		done:   make(chan struct{}),
+	}
+	subs.subscribers[id] = sub
+	return sub, true
+}
+
+// unsubscribe 从topic的订阅者中删除id，连接不再订阅任何主题时结束其发送队列
+func (subs *subscriptions) unsubscribe(id string, topic string) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	sub, exists := subs.subscribers[id]
+	if !exists {
+		return
+	}
+	subs.deleteTopic(sub, topic)
+	if len(sub.topics) == 0 {
+		delete(subs.subscribers, id)
+		close(sub.done)
+	}
+}
+
+// remove 删除id的所有订阅，连接从ConnManager删除时调用
+func (subs *subscriptions) remove(id string) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	sub, exists := subs.subscribers[id]
+	if !exists {
+		return
+	}
+	for topic := range sub.topics {
+		subs.deleteTopic(sub, topic)
+	}
+	delete(subs.subscribers, id)
+	close(sub.done)
+}
+
+func (subs *subscriptions) deleteTopic(sub *subscriber, topic string) {
+	delete(sub.topics, topic)
+	if members, exists := subs.topics[topic]; exists {
+		delete(members, sub.id)
+		if len(members) == 0 {
+			delete(subs.topics, topic)
+		}
+	}
+}
+
+// subscribersOf topic当前的订阅者
+func (subs *subscriptions) subscribersOf(topic string) []*subscriber {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	members := make([]*subscriber, 0, len(subs.topics[topic]))
+	for _, sub := range subs.topics[topic] {
+		members = append(members, sub)
+	}
+	return members
+}
+
+// topicsOf id订阅的主题，按字典序排列
+func (subs *subscriptions) topicsOf(id string) []string {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	sub, exists := subs.subscribers[id]
+	if !exists {
+		return nil
+	}
+	topics := make([]string, 0, len(sub.topics))
+	for topic := range sub.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Subscribe 将connID对应的连接加入topic的订阅者，SUBSCRIBE的processor调用。
+// 连接第一次订阅时启动该连接的发送goroutine，并发的订阅只有创建订阅者的一方启动。
+func (s *TcpServer) Subscribe(connID string, topic string) error {
+	sub, created, ok := s.CManager.subs.subscribe(connID, topic, s.publishQueueSize(), s.connected(connID))
+	if !ok {
+		return Err_Conn_Not_Found
+	}
+	if created {
+		go s.deliver(sub)
+	}
+	return nil
+}
+
+func (s *TcpServer) connected(connID string) func() bool {
+	return func() bool {
+		_, ok := s.CManager.Load(connID)
+		return ok
+	}
+}
+
+// Unsubscribe 从topic的订阅者中删除connID对应的连接
+func (s *TcpServer) Unsubscribe(connID string, topic string) {
+	s.CManager.subs.unsubscribe(connID, topic)
+}
+
+// Publish 向topic的所有订阅者发送payload，返回放入发送队列的订阅者数量。
+// Publish不等待消息写出，发送队列已满的连接丢弃这条消息。
+func (s *TcpServer) Publish(topic string, payload []byte) int {
+	queued := 0
+	for _, sub := range s.CManager.subs.subscribersOf(topic) {
+		if sub.offer(NewFrame(PUBLISH, &codec.PublishHeader{Topic: topic}, payload)) {
+			queued++
+		} else {
+			log.Infof("publish queue of connection %s is full, drop message of topic %s", sub.id, topic)
+		}
+	}
+	return queued
+}
+
+// Broadcast 向所有连接发送topic的payload，不论连接是否订阅了topic，返回放入发送队列的连接数量。
+// 与Publish共用每个连接的发送队列，发送队列已满的连接丢弃这条消息。
+func (s *TcpServer) Broadcast(topic string, payload []byte) int {
+	var ids []string
+	s.CManager.deviceConnMap.Range(func(key, value interface{}) bool {
+		ids = append(ids, key.(string))
+		return true
+	})
+
+	queued := 0
+	for _, id := range ids {
+		sub, created, ok := s.CManager.subs.queueOf(id, s.publishQueueSize(), s.connected(id))
+		if !ok {
+			continue
+		}
+		if created {
+			go s.deliver(sub)
+		}
+		if sub.offer(NewFrame(PUBLISH, &codec.PublishHeader{Topic: topic}, payload)) {
+			queued++
+		} else {
+			log.Infof("publish queue of connection %s is full, drop broadcast of topic %s", id, topic)
+		}
+	}
+	return queued
+}
+
+// deliver 按顺序写出发往sub的PUBLISH帧，订阅结束时退出。
+// 连接断开等待RESUME期间写出失败的消息被丢弃。
+func (s *TcpServer) deliver(sub *subscriber) {
+	for {
+		select {
+		case <-sub.done:
+			return
+		case frame := <-sub.queue:
+			if err := s.Push(sub.id, frame); err != nil {
+				log.Infof("publish to connection %s failed: %v", sub.id, err)
+			}
+		}
+	}
+}
+
+func (s *TcpServer) publishQueueSize() int {
+	if s.config.PublishQueueSize > 0 {
+		return s.config.PublishQueueSize
+	}
+	return DefaultPublishQueueSize
+}
+
+// Topics 连接ID订阅的主题，按字典序排列
+func (cm *ConnManager) Topics(id string) []string {
+	return cm.subs.topicsOf(id)
+}
+
+// Subscribe 订阅serverAddr上的topic，需要先完成CONN握手。
+// 服务端发布的消息以PUBLISH帧送达，交给AddProcessor为PUBLISH注册的processor。
+func (c *TcpClient) Subscribe(serverAddr string, topic string, timeout time.Duration) error {
+	return c.subscription(serverAddr, SUBSCRIBE, topic, timeout)
+}
+
+// Unsubscribe 取消订阅serverAddr上的topic
+func (c *TcpClient) Unsubscribe(serverAddr string, topic string, timeout time.Duration) error {
+	return c.subscription(serverAddr, UNSUBSCRIBE, topic, timeout)
+}
+
+func (c *TcpClient) subscription(serverAddr string, cmdType CommandType, topic string, timeout time.Duration) error {
+	id := c.ConnID(serverAddr)
+	if id == "" {
+		return Err_Not_Connected
+	}
+
+	frame := NewFrame(cmdType, &codec.SubscribeHeader{Id: id, Topic: topic}, nil)
+	respFrame, err := c.SendSync(serverAddr, frame, timeout)
+	if err != nil {
+		return err
+	}
+	header, ok := respFrame.Header.(*codec.SubscribeAckHeader)
+	if !ok {
+		return fmt.Errorf("unexpected response for subscribe, cmd type: %d", respFrame.CmdType)
+	}
+	if header.StatusCode != codec.SubscribeOK {
+		return fmt.Errorf("subscribe %s failed, status code: %d", topic, header.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package network_test
+
+import (
+	"bytes"
+	"fmt"
+	"go-networking/crypto/dh"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// publishRecorder 记录客户端收到的PUBLISH，block不为nil时阻塞读取回调
+type publishRecorder struct {
+	frames chan *network.Frame
+	block  chan struct{}
+}
+
+func (r *publishRecorder) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	if r.block != nil {
+		<-r.block
+	}
+	select {
+	case r.frames <- frame:
+	default:
+	}
+	return nil, nil
+}
+
+func startSubscriber(t *testing.T, recorder *publishRecorder, topics ...string) *network.TcpClient {
+	tcpClient := StartFileTcpClient()
+	tcpClient.AddProcessor(network.PUBLISH, recorder)
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	for _, topic := range topics {
+		require.NoError(t, tcpClient.Subscribe(fileServerAddr, topic, 5*time.Second))
+	}
+	return tcpClient
+}
+
+func requirePublish(t *testing.T, recorder *publishRecorder, topic string, payload []byte) {
+	select {
+	case frame := <-recorder.frames:
+		assert.Equal(t, &codec.PublishHeader{Topic: topic}, frame.Header)
+		assert.Equal(t, payload, frame.Payload)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("subscriber should receive message of topic %s", topic)
+	}
+}
+
+func TestPublishShouldDeliverToSubscribersOfTopicOnly(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+
+	files := &publishRecorder{frames: make(chan *network.Frame, 4)}
+	filesClient := startSubscriber(t, files, "files", "alerts")
+	defer filesClient.Stop()
+	alerts := &publishRecorder{frames: make(chan *network.Frame, 4)}
+	alertsClient := startSubscriber(t, alerts, "alerts")
+	defer alertsClient.Stop()
+	assert.Equal(t, []string{"alerts", "files"}, tcpSrv.CManager.Topics(filesClient.ConnID(fileServerAddr)))
+
+	assert.Equal(t, 1, tcpSrv.Publish("files", []byte("a.txt changed")))
+	requirePublish(t, files, "files", []byte("a.txt changed"))
+	assert.Equal(t, 2, tcpSrv.Publish("alerts", []byte("shutdown")))
+	requirePublish(t, files, "alerts", []byte("shutdown"))
+	requirePublish(t, alerts, "alerts", []byte("shutdown"))
+	assert.Equal(t, 0, tcpSrv.Publish("nobody", []byte("x")))
+
+	require.NoError(t, alertsClient.Unsubscribe(fileServerAddr, "alerts", 5*time.Second))
+	assert.Equal(t, 1, tcpSrv.Publish("alerts", []byte("again")))
+	requirePublish(t, files, "alerts", []byte("again"))
+	select {
+	case <-alerts.frames:
+		t.Fatal("unsubscribed client should not receive messages")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSubscribeShouldFailWhenNotConnected(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	assert.ErrorIs(t, tcpClient.Subscribe(fileServerAddr, "files", 5*time.Second), network.Err_Not_Connected)
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	assert.Error(t, tcpClient.Subscribe(fileServerAddr, "", 5*time.Second))
+}
+
+func TestSubscriptionsShouldBeRemovedWhenConnectionClosed(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	recorder := &publishRecorder{frames: make(chan *network.Frame, 1)}
+	tcpClient := startSubscriber(t, recorder, "files")
+	defer tcpClient.Stop()
+	connID := tcpClient.ConnID(fileServerAddr)
+	require.Equal(t, []string{"files"}, tcpSrv.CManager.Topics(connID))
+
+	require.NoError(t, tcpClient.Close(fileServerAddr))
+	assert.Empty(t, tcpSrv.CManager.Topics(connID))
+	assert.Equal(t, 0, tcpSrv.Publish("files", []byte("x")))
+}
+
+func TestPublishShouldNotWaitForSlowSubscriber(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartConfiguredFileTcpServer(t.TempDir(), &fakeFileRecorder{}, func(config *network.TcpServerConfig) {
+		config.PublishQueueSize = 4
+	})
+	defer tcpSrv.Stop()
+
+	// 慢的订阅者不读取连接，服务端的写操作最终阻塞
+	slow := &publishRecorder{frames: make(chan *network.Frame, 1), block: make(chan struct{})}
+	slowClient := startSubscriber(t, slow, "files")
+	defer slowClient.Stop()
+	defer close(slow.block)
+	fast := &publishRecorder{frames: make(chan *network.Frame, 512)}
+	fastClient := startSubscriber(t, fast, "files")
+	defer fastClient.Stop()
+
+	payload := bytes.Repeat([]byte("x"), 256*1024)
+	start := time.Now()
+	queued := 0
+	for i := 0; i < 200; i++ {
+		queued += tcpSrv.Publish("files", payload)
+	}
+	assert.Less(t, time.Since(start), 3*time.Second)
+	assert.Less(t, queued, 400, "messages to the slow subscriber should be dropped when its queue is full")
+
+	// 只有慢的订阅者接受消息时Publish也返回大于0，重复发布直到快的订阅者收到
+	last := []byte("last")
+	require.Eventually(t, func() bool {
+		tcpSrv.Publish("files", last)
+		for {
+			select {
+			case frame := <-fast.frames:
+				if bytes.Equal(last, frame.Payload) {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	}, 5*time.Second, 10*time.Millisecond, "fast subscriber should keep receiving messages")
+}
+
+func TestBroadcastShouldDeliverToAllConnections(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+
+	subscribed := &publishRecorder{frames: make(chan *network.Frame, 4)}
+	subscribedClient := startSubscriber(t, subscribed, "files")
+	defer subscribedClient.Stop()
+	idle := &publishRecorder{frames: make(chan *network.Frame, 4)}
+	idleClient := startSubscriber(t, idle)
+	defer idleClient.Stop()
+
+	assert.Equal(t, 2, tcpSrv.Broadcast("maintenance", []byte("restart")))
+	requirePublish(t, subscribed, "maintenance", []byte("restart"))
+	requirePublish(t, idle, "maintenance", []byte("restart"))
+
+	// 广播不改变订阅
+	assert.Empty(t, tcpSrv.CManager.Topics(idleClient.ConnID(fileServerAddr)))
+	assert.Equal(t, 1, tcpSrv.Publish("files", []byte("a.txt changed")))
+	requirePublish(t, subscribed, "files", []byte("a.txt changed"))
+}
+
+func TestSubscribeShouldFailWhenConnectionRemoved(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	connID := tcpClient.ConnID(fileServerAddr)
+
+	// 并发的订阅共用同一个发送队列
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, tcpSrv.Subscribe(connID, fmt.Sprintf("topic-%d", i%4)))
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, []string{"topic-0", "topic-1", "topic-2", "topic-3"}, tcpSrv.CManager.Topics(connID))
+
+	tcpSrv.CManager.Delete(connID)
+	assert.ErrorIs(t, tcpSrv.Subscribe(connID, "files"), network.Err_Conn_Not_Found)
+	assert.Empty(t, tcpSrv.CManager.Topics(connID))
+	assert.Equal(t, 0, tcpSrv.Publish("files", []byte("x")))
+}
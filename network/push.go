@@ -0,0 +1,49 @@
+package network
+
+import (
+	"errors"
+	"go-networking/log"
+)
+
+var Err_Conn_Not_Found = errors.New("connection not found")
+
+// Push 向connID对应的客户端主动发送frame，例如文件变化或停机通知。
+// 推送帧的Seq为0，客户端的请求Seq从1开始，不会与等待中的请求混淆。
+// 连接已经收到CLOSE时返回Err_Conn_Closing，推送在CLOSEACK之前发送完成。
+func (s *TcpServer) Push(connID string, frame *Frame) error {
+	conn, ok := s.CManager.Load(connID)
+	if !ok {
+		return Err_Conn_Not_Found
+	}
+	if !conn.Acquire() {
+		return Err_Conn_Closing
+	}
+	defer conn.Release()
+
+	frame.Seq = 0
+	return s.Send(conn, frame)
+}
+
+// dispatchPush 将服务端推送的帧(Seq为0)交给AddProcessor注册的processor。
+// processor在该连接的读取回调中执行，返回的帧发回服务端。
+func (c *TcpClient) dispatchPush(hostConn *HostConn, frame *Frame) {
+	c.mux.Lock()
+	processor, ok := c.procs[frame.CmdType]
+	c.mux.Unlock()
+	if !ok {
+		log.Infof("[%s] no request or processor for frame, cmd: %d, sequence no.: %d", hostConn.addr, frame.CmdType, frame.Seq)
+		return
+	}
+
+	resp, err := processor.Process(&Conn{Connection: hostConn.conn, version: hostConn.version}, frame)
+	if err != nil {
+		log.Errorf("[%s] process pushed frame failed, cmd: %d, err: %v", hostConn.addr, frame.CmdType, err)
+		return
+	}
+	if resp == nil {
+		return
+	}
+	if err := hostConn.write(resp); err != nil {
+		log.Errorf("[%s] reply pushed frame failed: %v", hostConn.addr, err)
+	}
+}
@@ -0,0 +1,92 @@
+package network_test
+
+import (
+	"go-networking/crypto/dh"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// notifyRecorder 记录客户端收到的推送
+type notifyRecorder struct {
+	frames chan *network.Frame
+}
+
+func (r *notifyRecorder) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	r.frames <- frame
+	return nil, nil
+}
+
+// assertNothingDispatched 等待wait时间，确认没有帧交给processor
+func (r *notifyRecorder) assertNothingDispatched(t *testing.T, wait time.Duration) {
+	select {
+	case frame := <-r.frames:
+		t.Errorf("unexpected frame dispatched to processor, cmd: %d, seq: %d", frame.CmdType, frame.Seq)
+	case <-time.After(wait):
+	}
+}
+
+func TestTcpClientShouldDispatchPushedFrameToProcessorWhenServerPushes(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+	recorder := &notifyRecorder{frames: make(chan *network.Frame, 1)}
+	tcpClient.AddProcessor(network.NOTIFY, recorder)
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+
+	header := &codec.NotifyHeader{Event: codec.NotifyFileChanged, Subject: "/docs/a.txt"}
+	err := tcpSrv.Push(tcpClient.ConnID(fileServerAddr), network.NewFrame(network.NOTIFY, header, []byte("modified")))
+	require.NoError(t, err)
+
+	select {
+	case frame := <-recorder.frames:
+		assert.Equal(t, uint64(0), frame.Seq)
+		assert.Equal(t, header, frame.Header)
+		assert.Equal(t, []byte("modified"), frame.Payload)
+	case <-time.After(3 * time.Second):
+		t.Fatal("client should receive the pushed frame")
+	}
+
+	// 推送不影响连接上的请求
+	_, err = tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+}
+
+func TestTcpClientShouldDropPushedFrameWhenNoProcessorRegistered(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+
+	header := &codec.NotifyHeader{Event: codec.NotifyShutdown}
+	require.NoError(t, tcpSrv.Push(tcpClient.ConnID(fileServerAddr), network.NewFrame(network.NOTIFY, header, nil)))
+
+	_, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+}
+
+func TestTcpServerShouldReturnErrConnNotFoundWhenPushingToUnknownConn(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	connID := tcpClient.ConnID(fileServerAddr)
+
+	frame := network.NewFrame(network.NOTIFY, &codec.NotifyHeader{Event: codec.NotifyShutdown}, nil)
+	assert.ErrorIs(t, tcpSrv.Push("unknown", frame), network.Err_Conn_Not_Found)
+
+	// CLOSE之后连接从ConnManager中删除
+	require.NoError(t, tcpClient.Close(fileServerAddr))
+	assert.ErrorIs(t, tcpSrv.Push(connID, frame), network.Err_Conn_Not_Found)
+}
@@ -0,0 +1,205 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"go-networking/crypto/dh"
+	"go-networking/log"
+	"go-networking/network/codec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	Err_Not_Connected      = errors.New("connection has not completed the CONN handshake")
+	Err_Rekey_In_Progress  = errors.New("rekey is already in progress")
+	Err_Rekey_Not_Accepted = errors.New("server did not accept rekey")
+)
+
+// RekeyPolicy 自动轮换会话密钥的条件，任一条件满足时在后台发起REKEY，为0的条件不生效。
+// 条件在收发帧时检查，空闲的连接不会轮换。
+type RekeyPolicy struct {
+	// 使用当前密钥收发的字节数
+	Bytes uint64
+	// 使用当前密钥收发的帧数
+	Frames uint64
+	// 当前密钥的使用时长
+	Interval time.Duration
+}
+
+// pendingRekey 已经发出REKEY，等待REKEYACK
+type pendingRekey struct {
+	keyPair *dh.KeyPair
+	// 收到REKEYACK并完成切换后写入结果
+	done chan error
+}
+
+// rekeyState 一个连接上的密钥轮换状态和当前密钥的使用量
+type rekeyState struct {
+	mu      sync.Mutex
+	group   dh.Group
+	pending *pendingRekey
+	// 同一连接同时只进行一次轮换
+	running atomic.Bool
+	bytes   atomic.Uint64
+	frames  atomic.Uint64
+	since   atomic.Int64
+}
+
+// reset 握手或轮换完成后重新统计新密钥的使用量
+func (rs *rekeyState) reset(group dh.Group) {
+	rs.mu.Lock()
+	rs.group = group
+	rs.mu.Unlock()
+	rs.bytes.Store(0)
+	rs.frames.Store(0)
+	rs.since.Store(time.Now().UnixNano())
+}
+
+func (rs *rekeyState) count(n int) {
+	rs.bytes.Add(uint64(n))
+	rs.frames.Add(1)
+}
+
+func (rs *rekeyState) due(policy *RekeyPolicy) bool {
+	if policy.Bytes != 0 && rs.bytes.Load() >= policy.Bytes {
+		return true
+	}
+	if policy.Frames != 0 && rs.frames.Load() >= policy.Frames {
+		return true
+	}
+	return policy.Interval != 0 && time.Since(time.Unix(0, rs.since.Load())) >= policy.Interval
+}
+
+// Rekey 在与serverAddr的加密连接上轮换会话密钥。
+// 在CONN协商的群中交换新的临时公钥，收到REKEYACK后两个方向都改用新密钥；
+// 服务端在宽限期内仍然接受旧密钥加密的帧，轮换期间不需要暂停发送。
+func (c *TcpClient) Rekey(serverAddr string) error {
+	c.mux.Lock()
+	hostConn, exists := c.hostConnTable[serverAddr]
+	c.mux.Unlock()
+	if !exists {
+		return Err_Conn_Closed
+	}
+
+	if !hostConn.rekey.running.CompareAndSwap(false, true) {
+		return Err_Rekey_In_Progress
+	}
+	defer hostConn.rekey.running.Store(false)
+	return c.doRekey(hostConn)
+}
+
+// maybeRekey 当前密钥的使用量达到RekeyPolicy时在后台轮换
+func (c *TcpClient) maybeRekey(hostConn *HostConn) {
+	policy := c.config.Rekey
+	if policy == nil || hostConn.cryptoAlg() == nil || hostConn.closing.Load() || !hostConn.rekey.due(policy) {
+		return
+	}
+	if !hostConn.rekey.running.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer hostConn.rekey.running.Store(false)
+		if err := c.doRekey(hostConn); err != nil {
+			log.Errorf("[%s] automatic rekey failed: %v", hostConn.addr, err)
+		}
+	}()
+}
+
+func (c *TcpClient) doRekey(hostConn *HostConn) error {
+	if hostConn.cryptoAlg() == nil {
+		return Err_Not_Connected
+	}
+	if hostConn.closing.Load() {
+		return Err_Conn_Closing
+	}
+
+	hostConn.rekey.mu.Lock()
+	group := hostConn.rekey.group
+	hostConn.rekey.mu.Unlock()
+	keyPair, err := dh.NewKeyPair(group)
+	if err != nil {
+		return err
+	}
+
+	pending := &pendingRekey{
+		keyPair: keyPair,
+		done:    make(chan error, 1),
+	}
+	hostConn.rekey.mu.Lock()
+	hostConn.rekey.pending = pending
+	hostConn.rekey.mu.Unlock()
+	defer func() {
+		hostConn.rekey.mu.Lock()
+		if hostConn.rekey.pending == pending {
+			hostConn.rekey.pending = nil
+		}
+		hostConn.rekey.mu.Unlock()
+	}()
+
+	frame := NewFrame(REKEY, &codec.RekeyHeader{
+		Id:        hostConn.id,
+		Timestamp: time.Now().Unix(),
+		Group:     uint8(group),
+	}, keyPair.PublicKey())
+	frame.Seq = uint64(c.seqIncr.Increment())
+	rp := NewResponsePromise(frame.Seq, c.config.Timeout)
+	defer rp.Close()
+	c.promiseM.AddAddrPromise(hostConn.key, frame.Seq, rp)
+	defer c.promiseM.DelSeqPromise(frame.Seq)
+
+	if err := hostConn.write(frame); err != nil {
+		return err
+	}
+	if _, err := rp.Wait(); err != nil {
+		return err
+	}
+
+	// REKEYACK在读取下一帧之前已经由completeRekey处理
+	return <-pending.done
+}
+
+// completeRekey 收到REKEYACK后立即切换密钥，之后读取的帧可能已经使用新密钥加密
+func (c *TcpClient) completeRekey(hostConn *HostConn, frame *Frame) {
+	hostConn.rekey.mu.Lock()
+	pending := hostConn.rekey.pending
+	hostConn.rekey.pending = nil
+	group := hostConn.rekey.group
+	hostConn.rekey.mu.Unlock()
+	if pending == nil {
+		return
+	}
+
+	pending.done <- c.rotateKeys(hostConn, pending, group, frame)
+}
+
+func (c *TcpClient) rotateKeys(hostConn *HostConn, pending *pendingRekey, group dh.Group, frame *Frame) error {
+	header, ok := frame.Header.(*codec.RekeyAckHeader)
+	if !ok {
+		return fmt.Errorf("unexpected response for rekey, cmd type: %d", frame.CmdType)
+	}
+	if header.StatusCode != codec.RekeyOK {
+		return fmt.Errorf("%w, status code: %d", Err_Rekey_Not_Accepted, header.StatusCode)
+	}
+
+	secret, err := pending.keyPair.SharedSecret(frame.Payload)
+	if err != nil {
+		return err
+	}
+	cKey, sKey, err := dh.DeriveSessionKeys(secret, pending.keyPair.PublicKey(), frame.Payload)
+	if err != nil {
+		return err
+	}
+
+	rotator, ok := hostConn.cryptoAlg().(KeyRotator)
+	if !ok {
+		return Err_Rekey_Unsupported
+	}
+	rotator.Rotate(cKey, sKey)
+	hostConn.setKeys(cKey, sKey)
+	hostConn.rekey.reset(group)
+	log.Infof("[%s] rotated session keys", hostConn.addr)
+	return nil
+}
@@ -0,0 +1,113 @@
+package network_test
+
+import (
+	"go-networking/crypto/dh"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGcmCryptoShouldAcceptOldKeyFramesWhenWithinGraceWindow(t *testing.T) {
+	client, server := newGcmPair(t)
+	newCKey := make([]byte, 32)
+	newSKey := make([]byte, 32)
+	newCKey[0], newSKey[0] = 1, 2
+
+	// 客户端在收到REKEYACK之前发出的帧
+	inflight, err := client.Encrypt([]byte("in flight"), nil)
+	require.NoError(t, err)
+	stale, err := client.Encrypt([]byte("stale"), nil)
+	require.NoError(t, err)
+
+	server.Rotate(newSKey, newCKey)
+	client.Rotate(newCKey, newSKey)
+
+	plain, err := server.Decrypt(inflight, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("in flight"), plain)
+
+	fresh, err := client.Encrypt([]byte("fresh"), nil)
+	require.NoError(t, err)
+	plain, err = server.Decrypt(fresh, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("fresh"), plain)
+
+	// 对端已经改用新密钥，旧密钥加密的帧不再被接受
+	_, err = server.Decrypt(stale, nil)
+	assert.ErrorIs(t, err, network.Err_Frame_Auth_Failed)
+}
+
+func TestRekeyShouldRotateSessionKeysWhenConnected(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644))
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	assert.ErrorIs(t, tcpClient.Rekey(fileServerAddr), network.Err_Conn_Closed)
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	connID := tcpClient.ConnID(fileServerAddr)
+	connCtx, exists := tcpSrv.CManager.LoadCtx(connID)
+	require.True(t, exists)
+	beforeCKey, beforeSKey := connCtx.Keys()
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, tcpClient.Rekey(fileServerAddr))
+		connCtx, exists := tcpSrv.CManager.LoadCtx(connID)
+		require.True(t, exists)
+		afterCKey, afterSKey := connCtx.Keys()
+		assert.NotEqual(t, beforeCKey, afterCKey)
+		assert.NotEqual(t, beforeSKey, afterSKey)
+		beforeCKey, beforeSKey = afterCKey, afterSKey
+
+		ack, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+		require.NoError(t, err)
+		assert.Len(t, ack.Entries, 1)
+	}
+}
+
+func TestRekeyShouldRotateAutomaticallyWhenFrameLimitReached(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := network.NewTcpClient(&network.TcpClientConfig{
+		Network: "tcp",
+		Timeout: 5 * time.Second,
+		Rekey:   &network.RekeyPolicy{Frames: 4},
+	})
+	tcpClient.Init()
+	tcpClient.Start()
+	defer tcpClient.Stop()
+
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	connID := tcpClient.ConnID(fileServerAddr)
+	connCtx, exists := tcpSrv.CManager.LoadCtx(connID)
+	require.True(t, exists)
+	before, _ := connCtx.Keys()
+
+	// 轮换在后台进行，期间的请求不受影响
+	for i := 0; i < 10; i++ {
+		_, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+		require.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		connCtx, exists := tcpSrv.CManager.LoadCtx(connID)
+		if !exists {
+			return false
+		}
+		after, _ := connCtx.Keys()
+		return string(after) != string(before)
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -1,16 +1,23 @@
 package network
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/quintans/toolkit/latch"
 )
 
+// 没有指定超时时间时等待响应的最长时间
+const defaultResponseTimeout = 30 * time.Second
+
 type ResponsePromise interface {
 	Add(frame *Frame)
 	Wait() (*Frame, error)
 	Close()
+	// Fail 以指定错误结束等待，例如连接被关闭
+	Fail(err error)
 	Timestamp() time.Time
 }
 
@@ -18,11 +25,21 @@ type ResponsePromiseI struct {
 	seq        uint64
 	frame      *Frame
 	createTime time.Time
-	countdown  latch.CountDownLatch
+	// Wait等待响应的最长时间
+	timeout   time.Duration
+	countdown latch.CountDownLatch
+	mu        sync.Mutex
+	err       error
 }
 
+// NewResponsePromise 创建等待seq响应的promise，timeout不大于0时Wait最多等待30秒
 func NewResponsePromise(seq uint64, timeout time.Duration) *ResponsePromiseI {
+	if timeout <= 0 {
+		timeout = defaultResponseTimeout
+	}
 	rf := &ResponsePromiseI{
+		seq:        seq,
+		timeout:    timeout,
 		countdown:  *latch.NewCountDownLatch(),
 		createTime: time.Now(),
 	}
@@ -31,22 +48,62 @@ func NewResponsePromise(seq uint64, timeout time.Duration) *ResponsePromiseI {
 }
 
 func (rf *ResponsePromiseI) Add(frame *Frame) {
+	rf.mu.Lock()
 	rf.frame = frame
+	rf.mu.Unlock()
 	rf.countdown.Done()
 }
 
+// Wait 等待响应，超过创建时指定的timeout返回超时错误
 func (rf *ResponsePromiseI) Wait() (*Frame, error) {
-	isTimeout := rf.countdown.WaitWithTimeout(30 * time.Second)
-	if isTimeout || rf.frame == nil {
-		return nil, fmt.Errorf("waiting for response timeout, seq: %d", rf.seq)
+	ctx, cancel := context.WithTimeout(context.Background(), rf.timeout)
+	defer cancel()
+	return rf.WaitContext(ctx)
+}
+
+// WaitContext 等待响应直到ctx结束，ctx超时返回超时错误，取消返回ctx.Err()
+func (rf *ResponsePromiseI) WaitContext(ctx context.Context) (*Frame, error) {
+	select {
+	case <-rf.countdown.Wait():
+	case <-ctx.Done():
+	}
+	rf.mu.Lock()
+	frame, err := rf.frame, rf.err
+	rf.mu.Unlock()
+	// 已经收到的响应优先，之后连接关闭不影响该请求
+	if frame != nil {
+		return frame, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() == context.Canceled {
+		return nil, ctx.Err()
 	}
-	return rf.frame, nil
+	return nil, responseTimeoutError(rf.seq)
+}
+
+// responseTimeoutError 等待seq的响应超时
+func responseTimeoutError(seq uint64) error {
+	return fmt.Errorf("waiting for response timeout, seq: %d: %w", seq, context.DeadlineExceeded)
 }
 
 func (rf *ResponsePromiseI) Close() {
 	rf.countdown.Close()
 }
 
+func (rf *ResponsePromiseI) Fail(err error) {
+	rf.mu.Lock()
+	rf.err = err
+	rf.mu.Unlock()
+	rf.countdown.Close()
+}
+
 func (rf *ResponsePromiseI) Timestamp() time.Time {
 	return rf.createTime
 }
+
+// Timeout 等待响应的最长时间，PromiseM在Timestamp之后经过该时间结束promise
+func (rf *ResponsePromiseI) Timeout() time.Duration {
+	return rf.timeout
+}
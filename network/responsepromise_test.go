@@ -1,6 +1,7 @@
 package network_test
 
 import (
+	"context"
 	"go-networking/network"
 	"sync"
 	"testing"
@@ -80,3 +81,35 @@ func TestResponseFutureShouldSupportMultipleConcurrentWaits(t *testing.T) {
 	assert.Equal(t, frame, resultFrame2, "The frame returned by the second concurrent Wait should be the same as the one added")
 	rf.Close() // 清理资源
 }
+
+// TestResponsePromiseWaitShouldReturnAfterConfiguredTimeout Wait按创建时指定的超时时间返回，而不是固定等待30秒
+func TestResponsePromiseWaitShouldReturnAfterConfiguredTimeout(t *testing.T) {
+	rf := network.NewResponsePromise(7, 50*time.Millisecond)
+	defer rf.Close()
+
+	start := time.Now()
+	_, err := rf.Wait()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// TestResponsePromiseWaitContextShouldReturnCanceledWhenContextCancelled 取消ctx时WaitContext立即返回ctx.Err()
+func TestResponsePromiseWaitContextShouldReturnCanceledWhenContextCancelled(t *testing.T) {
+	rf := network.NewResponsePromise(7, 30*time.Second)
+	defer rf.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	resultFrame, err := rf.WaitContext(ctx)
+	assert.Nil(t, resultFrame)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// 已经收到的响应优先于ctx的状态
+	frame := &network.Frame{Seq: 7}
+	rf = network.NewResponsePromise(7, 30*time.Second)
+	rf.Add(frame)
+	resultFrame, err = rf.WaitContext(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, frame, resultFrame)
+	rf.Close()
+}
@@ -0,0 +1,141 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var Err_Stream_Closed = errors.New("response stream closed")
+
+// ResponseStream 一个请求的多个响应帧，按收到的顺序由Next依次返回，设置了FlagEndOfStream的帧是最后一帧。
+// 作为ResponsePromise登记在PromiseM中，在netpoll回调中添加帧不会阻塞。
+type ResponseStream struct {
+	seq uint64
+	mu  sync.Mutex
+	// 已收到尚未被Next取走的帧
+	frames []*Frame
+	// 流结束的原因，正常结束时为io.EOF
+	err error
+	// 有新的帧时发送信号
+	signal chan struct{}
+	// 流结束时关闭
+	done chan struct{}
+	// 最近收到帧的时间，PromiseM据此清理长时间没有响应的流
+	lastActive time.Time
+}
+
+func newResponseStream(seq uint64) *ResponseStream {
+	return &ResponseStream{
+		seq:        seq,
+		signal:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		lastActive: time.Now(),
+	}
+}
+
+// Add 追加一个响应帧，流已经结束时丢弃
+func (rs *ResponseStream) Add(frame *Frame) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.err != nil {
+		return
+	}
+	rs.frames = append(rs.frames, frame)
+	rs.lastActive = time.Now()
+	if frame.Flags&FlagEndOfStream != 0 {
+		rs.end(io.EOF)
+		return
+	}
+	select {
+	case rs.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Next 返回下一个响应帧，流正常结束后返回io.EOF。
+// 连接关闭、收到ERROR或取消时，先返回已经收到的帧，再返回对应的错误。
+func (rs *ResponseStream) Next() (*Frame, error) {
+	for {
+		rs.mu.Lock()
+		if len(rs.frames) > 0 {
+			frame := rs.frames[0]
+			rs.frames[0] = nil
+			rs.frames = rs.frames[1:]
+			rs.mu.Unlock()
+			return frame, nil
+		}
+		err := rs.err
+		rs.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-rs.signal:
+		case <-rs.done:
+		}
+	}
+}
+
+// Wait 等待下一个响应帧，与Next相同
+func (rs *ResponseStream) Wait() (*Frame, error) {
+	return rs.Next()
+}
+
+// Close 提前结束流，之后服务端发来的帧被丢弃
+func (rs *ResponseStream) Close() {
+	rs.Fail(Err_Stream_Closed)
+}
+
+// Fail 以err结束流，例如连接被关闭或服务端回复了ERROR
+func (rs *ResponseStream) Fail(err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.err == nil {
+		rs.end(err)
+	}
+}
+
+func (rs *ResponseStream) end(err error) {
+	rs.err = err
+	close(rs.done)
+}
+
+func (rs *ResponseStream) Timestamp() time.Time {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.lastActive
+}
+
+// SendStream 发送frame并返回该请求的响应流，服务端可以用同一Seq回复多个帧。
+// ctx取消时流以ctx.Err()结束；流结束后从PromiseM删除，之后同一Seq的帧不再被接收。
+func (c *TcpClient) SendStream(ctx context.Context, serverAddr string, frame *Frame) (*ResponseStream, error) {
+	if frame == nil {
+		return nil, errors.New("frame is nil")
+	}
+	hostConn, err := c.route(serverAddr, frame)
+	if err != nil {
+		return nil, err
+	}
+	frame.Seq = uint64(c.seqIncr.Increment())
+	stream := newResponseStream(frame.Seq)
+	c.promiseM.AddAddrPromise(hostConn.key, frame.Seq, stream)
+
+	if err := c.send(hostConn, frame); err != nil {
+		c.promiseM.DelSeqPromise(frame.Seq)
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-stream.done:
+		case <-ctx.Done():
+			stream.Fail(ctx.Err())
+		}
+		c.promiseM.DelSeqPromise(frame.Seq)
+	}()
+	return stream, nil
+}
@@ -0,0 +1,191 @@
+package network_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 测试用的流式命令，请求和响应共用同一Header
+const streamCmd network.CommandType = 240
+
+type streamChunkHeader struct {
+	// 请求中为响应的帧数，响应中为帧的序号
+	Index uint32 `wire:"varint"`
+}
+
+func init() {
+	network.AddStructHeaderCodec(streamCmd, &streamChunkHeader{})
+}
+
+// streamProcessor 用同一Seq回复Index个帧，最后一帧作为返回值由TcpServer设置FlagEndOfStream，
+// release不为nil时在第一帧之后等待
+type streamProcessor struct {
+	tcpSrv  *network.TcpServer
+	release chan struct{}
+}
+
+func (p *streamProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	count := frame.Header.(*streamChunkHeader).Index
+	for i := uint32(1); i < count; i++ {
+		chunk := network.NewFrame(streamCmd, &streamChunkHeader{Index: i}, []byte{byte(i)})
+		chunk.Seq = frame.Seq
+		if err := p.tcpSrv.Send(conn, chunk); err != nil {
+			return nil, err
+		}
+		if p.release != nil && i == 1 {
+			<-p.release
+		}
+	}
+	last := network.NewFrame(streamCmd, &streamChunkHeader{Index: count}, []byte{byte(count)})
+	last.Seq = frame.Seq
+	return last, nil
+}
+
+func startStreamServer(t *testing.T, release chan struct{}) *network.TcpServer {
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	tcpSrv.AddProcessor(streamCmd, &streamProcessor{tcpSrv: tcpSrv, release: release})
+	return tcpSrv
+}
+
+func TestSendStreamShouldReturnFramesInOrderUntilEndOfStream(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := startStreamServer(t, nil)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	stream, err := tcpClient.SendStream(context.Background(), fileServerAddr, network.NewFrame(streamCmd, &streamChunkHeader{Index: 5}, nil))
+	require.NoError(t, err)
+	for i := 1; i <= 5; i++ {
+		frame, err := stream.Next()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(i), frame.Header.(*streamChunkHeader).Index)
+		assert.Equal(t, []byte{byte(i)}, frame.Payload)
+	}
+	_, err = stream.Next()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// 普通请求的唯一响应也是一个流
+	stream, err = tcpClient.SendStream(context.Background(), fileServerAddr, network.NewFrame(network.REKEYACK, &codec.RekeyAckHeader{}, nil))
+	require.NoError(t, err)
+	_, err = stream.Next()
+	var protocolErr *network.ProtocolError
+	assert.ErrorAs(t, err, &protocolErr)
+}
+
+func TestSendStreamShouldReceiveWholeDownloadWhenServerStreamsTransferBlocks(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	// 不超过发送窗口的块数，服务端不需要等待TRANSFERACK就会发出所有的块
+	content := make([]byte, 3*32*1024+5)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data.bin"), content, 0644))
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	stream, err := tcpClient.SendStream(context.Background(), fileServerAddr,
+		network.NewFrame(network.FILETRANSFER, &codec.FileTransfer{FilePath: "/data.bin"}, nil))
+	require.NoError(t, err)
+	frame, err := stream.Next()
+	require.NoError(t, err)
+	ack, ok := frame.Header.(*codec.FileTransferAck)
+	require.True(t, ok)
+	assert.Equal(t, uint64(len(content)), ack.FileLen)
+	assert.Zero(t, frame.Flags&network.FlagEndOfStream)
+
+	var downloaded []byte
+	for {
+		frame, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		transfer, ok := frame.Header.(*codec.Transfer)
+		require.True(t, ok)
+		assert.Equal(t, ack.FileID, transfer.FileID)
+		downloaded = append(downloaded, frame.Payload...)
+	}
+	assert.True(t, bytes.Equal(content, downloaded), "streamed blocks should equal the source file")
+
+	// LISTDIRACK由processor返回，是唯一的响应
+	payload, err := (&codec.ListDirPayload{DirPath: "/"}).Encode()
+	require.NoError(t, err)
+	stream, err = tcpClient.SendStream(context.Background(), fileServerAddr,
+		network.NewFrame(network.LISTDIR, &codec.ListDirHeader{Id: strings.Repeat("a", 32), Timestamp: time.Now().Unix()}, payload))
+	require.NoError(t, err)
+	frame, err = stream.Next()
+	require.NoError(t, err)
+	assert.IsType(t, &codec.ListDirAckHeader{}, frame.Header)
+	assert.NotZero(t, frame.Flags&network.FlagEndOfStream)
+	_, err = stream.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestSendStreamShouldEndWithContextErrorWhenCancelled(t *testing.T) {
+	log.InitLogger()
+	release := make(chan struct{})
+	tcpSrv := startStreamServer(t, release)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := tcpClient.SendStream(ctx, fileServerAddr, network.NewFrame(streamCmd, &streamChunkHeader{Index: 3}, nil))
+	require.NoError(t, err)
+	frame, err := stream.Next()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), frame.Header.(*streamChunkHeader).Index)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := stream.Next()
+		done <- err
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(3 * time.Second):
+		t.Fatal("cancel should end the stream")
+	}
+
+	// 取消后到达的帧被丢弃，连接仍然可用
+	close(release)
+	_, err = tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+}
+
+func TestResponseStreamShouldEndWithErrStreamClosedWhenClosed(t *testing.T) {
+	log.InitLogger()
+	release := make(chan struct{})
+	tcpSrv := startStreamServer(t, release)
+	defer tcpSrv.Stop()
+	defer close(release)
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	stream, err := tcpClient.SendStream(context.Background(), fileServerAddr, network.NewFrame(streamCmd, &streamChunkHeader{Index: 3}, nil))
+	require.NoError(t, err)
+	_, err = stream.Next()
+	require.NoError(t, err)
+
+	stream.Close()
+	_, err = stream.Next()
+	assert.ErrorIs(t, err, network.Err_Stream_Closed)
+}
@@ -0,0 +1,135 @@
+package network
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"go-networking/crypto/aes"
+	"go-networking/crypto/dh"
+	"go-networking/network/codec"
+	"time"
+)
+
+// 会话恢复时客户端随机数的长度
+const resumeNonceLen = 32
+
+var (
+	Err_No_Resumable_Session = errors.New("no resumable session for server")
+	Err_Resume_Rejected      = errors.New("server rejected the resumption ticket")
+)
+
+// resumableSession CONN或RESUME成功后服务端签发的票据和恢复秘密
+type resumableSession struct {
+	ticket string
+	secret []byte
+	group  dh.Group
+}
+
+// Resume TCP断开后凭上次握手得到的票据恢复会话，不重新进行DH交换。
+// 恢复后连接ID不变，会话密钥由恢复秘密和双方的随机数重新派生；
+// 票据只能使用一次，服务端会下发新的票据。票据被拒绝时返回Err_Resume_Rejected，需要重新Connect。
+// RESUME中附带以恢复秘密计算的Binder，新的票据使用恢复后的会话密钥加密传输，
+// 无法解密时说明对端不持有恢复秘密，关闭连接并返回Err_Server_Auth_Failed。
+func (c *TcpClient) Resume(serverAddr string) error {
+	c.mux.Lock()
+	session, exists := c.sessions[serverAddr]
+	c.mux.Unlock()
+	if !exists {
+		return Err_No_Resumable_Session
+	}
+
+	nonce := make([]byte, resumeNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	binder, err := dh.ResumeBinder(session.secret, nonce, []byte(session.ticket))
+	if err != nil {
+		return err
+	}
+	frame := NewFrame(RESUME, &codec.ResumeHeader{
+		Ticket:       session.ticket,
+		Timestamp:    time.Now().Unix(),
+		Compressions: c.config.Compression.Offer(),
+		Binder:       binder,
+	}, nonce)
+	respFrame, err := c.SendSync(serverAddr, frame, c.config.Timeout)
+	if err != nil {
+		return err
+	}
+
+	header, ok := respFrame.Header.(*codec.ResumeAckHeader)
+	if !ok {
+		return fmt.Errorf("unexpected response for resume, cmd type: %d", respFrame.CmdType)
+	}
+	if header.StatusCode != codec.ResumeOK {
+		c.mux.Lock()
+		if c.sessions[serverAddr] == session {
+			delete(c.sessions, serverAddr)
+		}
+		c.mux.Unlock()
+		return fmt.Errorf("%w, status code: %d", Err_Resume_Rejected, header.StatusCode)
+	}
+	compressor, err := c.acceptCompression(header.Compression)
+	if err != nil {
+		c.abortResume(serverAddr)
+		return err
+	}
+
+	cKey, sKey, err := dh.DeriveSessionKeys(session.secret, nonce, respFrame.Payload)
+	if err != nil {
+		return err
+	}
+	ticket, err := openTicket(sKey, header.SealedTicket, header.Id)
+	if err != nil {
+		c.abortResume(serverAddr)
+		return fmt.Errorf("%w: %v", Err_Server_Auth_Failed, err)
+	}
+	nextSecret, err := dh.DeriveResumptionSecret(session.secret, nonce, respFrame.Payload)
+	if err != nil {
+		return err
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	hostConn, exists := c.hostConnTable[serverAddr]
+	if !exists {
+		return Err_Conn_Closed
+	}
+	c.establish(hostConn, header.Id, session.group, cKey, sKey, compressor)
+	c.sessions[serverAddr] = &resumableSession{ticket: ticket, secret: nextSecret, group: session.group}
+	return nil
+}
+
+// abortResume 会话恢复失败后关闭serverAddr的主连接
+func (c *TcpClient) abortResume(serverAddr string) {
+	c.mux.Lock()
+	hostConn, exists := c.hostConnTable[serverAddr]
+	c.mux.Unlock()
+	if exists {
+		c.abortHandshake(hostConn)
+	}
+}
+
+// SealTicket 使用恢复后服务端的发送密钥加密RESUMEACK中的新票据，连接ID作为附加认证数据。
+// 帧的计数器从1开始，票据固定使用计数器0，同一密钥下nonce不会重复
+func SealTicket(sKey []byte, ticket string, id string) ([]byte, error) {
+	return aes.AesEncryptWithAAD([]byte(ticket), sKey, gcmNonce(0), []byte(id))
+}
+
+// openTicket 解密SealTicket加密的票据
+func openTicket(sKey []byte, sealed []byte, id string) (string, error) {
+	ticket, err := aes.AesDecryptWithAAD(sealed, gcmNonce(0), sKey, []byte(id))
+	if err != nil {
+		return "", err
+	}
+	return string(ticket), nil
+}
+
+// Reconnect 优先恢复上次的会话，没有票据或票据被拒绝时重新进行CONN握手
+func (c *TcpClient) Reconnect(serverAddr string, group dh.Group) error {
+	err := c.Resume(serverAddr)
+	if errors.Is(err, Err_No_Resumable_Session) || errors.Is(err, Err_Resume_Rejected) {
+		return c.Connect(serverAddr, group)
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package network_test
+
+import (
+	"bytes"
+	"go-networking/crypto/dh"
+	"go-networking/log"
+	"go-networking/network"
+	"go-networking/network/codec"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dropConnection 服务端直接断开TCP连接，模拟网络中断
+func dropConnection(t *testing.T, tcpSrv *network.TcpServer, tcpClient *network.TcpClient, connID string) {
+	conn, exists := tcpSrv.CManager.Load(connID)
+	require.True(t, exists)
+	require.NoError(t, conn.Connection.Close())
+	require.Eventually(t, func() bool {
+		return tcpClient.ConnID(fileServerAddr) == ""
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestResumeShouldRestoreConnectionIdWhenTicketValid(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644))
+
+	tcpSrv := StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	assert.ErrorIs(t, tcpClient.Resume(fileServerAddr), network.Err_No_Resumable_Session)
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	connID := tcpClient.ConnID(fileServerAddr)
+	connCtx, exists := tcpSrv.CManager.LoadCtx(connID)
+	require.True(t, exists)
+	before, _ := connCtx.Keys()
+
+	// 每次恢复都会换发票据，可以连续恢复
+	for i := 0; i < 2; i++ {
+		dropConnection(t, tcpSrv, tcpClient, connID)
+
+		require.NoError(t, tcpClient.Resume(fileServerAddr))
+		assert.Equal(t, connID, tcpClient.ConnID(fileServerAddr))
+		connCtx, exists := tcpSrv.CManager.LoadCtx(connID)
+		require.True(t, exists)
+		after, _ := connCtx.Keys()
+		assert.NotEqual(t, before, after, "resumed session should use fresh keys")
+		before = after
+
+		ack, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+		require.NoError(t, err)
+		assert.Len(t, ack.Entries, 1)
+	}
+
+	// 正常关闭后不能再恢复
+	require.NoError(t, tcpClient.Close(fileServerAddr))
+	assert.ErrorIs(t, tcpClient.Resume(fileServerAddr), network.Err_No_Resumable_Session)
+}
+
+func TestReconnectShouldFallBackToConnectWhenTicketRejected(t *testing.T) {
+	log.InitLogger()
+	root := t.TempDir()
+
+	tcpSrv := StartFileTcpServer(root)
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	connID := tcpClient.ConnID(fileServerAddr)
+	dropConnection(t, tcpSrv, tcpClient, connID)
+
+	// 重启后的服务端不认识之前的票据
+	tcpSrv.Stop()
+	tcpSrv = StartFileTcpServer(root)
+	defer tcpSrv.Stop()
+
+	assert.ErrorIs(t, tcpClient.Resume(fileServerAddr), network.Err_Resume_Rejected)
+	require.NoError(t, tcpClient.Reconnect(fileServerAddr, dh.GroupX25519))
+	assert.NotEmpty(t, tcpClient.ConnID(fileServerAddr))
+	assert.NotEqual(t, connID, tcpClient.ConnID(fileServerAddr))
+
+	_, err := tcpClient.ListDir(fileServerAddr, &codec.ListDirPayload{DirPath: "/"}, 5*time.Second)
+	require.NoError(t, err)
+}
+
+// ticketRecorder 记录明文握手中出现的票据，模拟被动监听的一方
+type ticketRecorder struct {
+	mu         sync.Mutex
+	tickets    []string
+	resumeAcks []*codec.ResumeAckHeader
+}
+
+func (r *ticketRecorder) OnRequest(remoteAddr string, request *network.Frame) {
+	if header, ok := request.Header.(*codec.ResumeHeader); ok {
+		r.mu.Lock()
+		r.tickets = append(r.tickets, header.Ticket)
+		r.mu.Unlock()
+	}
+}
+
+func (r *ticketRecorder) OnResponse(remoteAddr string, request *network.Frame, response *network.Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch header := response.Header.(type) {
+	case *codec.ConnAckHeader:
+		r.tickets = append(r.tickets, header.Ticket)
+	case *codec.ResumeAckHeader:
+		r.resumeAcks = append(r.resumeAcks, header)
+	}
+}
+
+func (r *ticketRecorder) snapshot() ([]string, []*codec.ResumeAckHeader) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.tickets...), append([]*codec.ResumeAckHeader(nil), r.resumeAcks...)
+}
+
+func TestResumeShouldRejectTicketWhenBinderMissing(t *testing.T) {
+	log.InitLogger()
+	recorder := &ticketRecorder{}
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	tcpSrv.AddInterceptor(recorder)
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	require.NoError(t, tcpClient.Connect(fileServerAddr, dh.GroupX25519))
+	connID := tcpClient.ConnID(fileServerAddr)
+	dropConnection(t, tcpSrv, tcpClient, connID)
+	tickets, _ := recorder.snapshot()
+	require.Len(t, tickets, 1)
+
+	// 只截获了票据，不知道恢复秘密
+	attacker := StartFileTcpClient()
+	defer attacker.Stop()
+	respFrame, err := attacker.SendSync(fileServerAddr, network.NewFrame(network.RESUME, &codec.ResumeHeader{
+		Ticket:    tickets[0],
+		Timestamp: time.Now().Unix(),
+		Binder:    make([]byte, 32),
+	}, make([]byte, 32)), 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, codec.ResumeTicketRejected, respFrame.Header.(*codec.ResumeAckHeader).StatusCode)
+
+	// 票据没有被使用，持有恢复秘密的客户端仍然可以恢复
+	require.NoError(t, tcpClient.Resume(fileServerAddr))
+	assert.Equal(t, connID, tcpClient.ConnID(fileServerAddr))
+
+	// 新的票据不以明文出现在RESUMEACK中
+	dropConnection(t, tcpSrv, tcpClient, connID)
+	require.NoError(t, tcpClient.Resume(fileServerAddr))
+	tickets, resumeAcks := recorder.snapshot()
+	require.Len(t, resumeAcks, 3)
+	nextTicket := tickets[len(tickets)-1]
+	assert.NotEqual(t, tickets[0], nextTicket)
+	assert.False(t, bytes.Contains(resumeAcks[1].SealedTicket, []byte(nextTicket)), "ticket should be sealed with the session keys")
+}
@@ -2,13 +2,13 @@ package network
 
 import (
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
+	"go-networking/crypto/identity"
 	"go-networking/log"
-	"io"
-	"math/big"
+	"go-networking/network/codec"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudwego/netpoll"
@@ -17,39 +17,104 @@ import (
 type TcpClientConfig struct {
 	Network string
 	Timeout time.Duration
+	// 预先信任的服务端身份，配置后CONNACK必须携带该身份的有效签名
+	ServerIdentity *identity.Pin
+	// 自动轮换会话密钥的条件，为nil时只能调用Rekey手动轮换
+	Rekey *RekeyPolicy
+	// 接收帧的大小限制，超过限制时关闭连接
+	Limits FrameLimits
+	// 帧的线上格式，为nil时使用DefaultCodecs
+	Codec Codec
+	// 发送帧使用的协议版本，为0时保留帧自身的Version
+	Version VersionType
+	// 握手之后在AUTH中发送给服务端的认证令牌，例如HTTP登录签发的JWT，为空时是匿名连接
+	Token string
+	// 客户端支持的负载压缩算法，在CONN和RESUME中提供给服务端选择
+	Compression CompressionPolicy
+	// 执行SendAsyncWithCallback的回调，为nil时在netpoll的读取回调或时间轮的goroutine中执行
+	CallbackExecutor func(task func())
+	// 每个服务端地址的连接池，为零值时每个地址只使用一个连接
+	Pool PoolConfig
 }
 
+var (
+	Err_Conn_Closing = errors.New("connection is closing")
+	Err_Conn_Closed  = errors.New("connection closed")
+	// Stop时仍在等待响应的请求返回该错误
+	Err_Client_Closed = errors.New("tcp client stopped")
+)
+
 type HostConn struct {
-	id        string
-	conn      netpoll.Connection
-	seqIncr   *SafeIncrementer32
-	key       string
-	priKey    big.Int
-	timestamp int64
+	id   string
+	addr string
+	// 等待该连接响应的promise在PromiseM中登记的地址，主连接为服务端地址
+	key  string
+	conn netpoll.Connection
+	// 线上格式和发送帧使用的协议版本，创建连接时确定
+	codec   Codec
+	version VersionType
+	// 已经发送CLOSE，不再发送新的请求，进行中传输的后续帧仍然可以发送
+	closing atomic.Bool
+	// 写锁，保证并发发送时帧不会交错
+	wmu     sync.Mutex
+	seqIncr *SafeIncrementer32
+	// CONN握手派生的密钥，cKey用于发往服务端的数据，sKey用于服务端发来的数据
+	cKey []byte
+	sKey []byte
+	// 保护crypto、cKey、sKey和compressor，握手完成后设置，收发帧时读取
+	mu         sync.Mutex
+	crypto     CryptoAlg
+	compressor *payloadCompression
+	rekey      rekeyState
+	timestamp  int64
+	// 最近一次收到帧的时间，健康检查跳过最近有数据的连接
+	lastRead atomic.Int64
 }
 
 type TcpClient struct {
 	mux           sync.Mutex
 	config        *TcpClientConfig
 	hostConnTable map[string]*HostConn
-	promiseM      *PromiseM
-	ticker        *time.Ticker
-	procs         map[CommandType]Processor
-	interceptors  []RequestInterceptor
-	ctx           context.Context
-	cancel        context.CancelFunc
-	seqIncr       *SafeIncrementer32
+	// 主连接以外的连接，key为服务端地址
+	pools    map[string]*hostPool
+	promiseM *PromiseM
+	// 等待响应的请求的超时
+	wheel        *TimingWheel
+	procs        map[CommandType]Processor
+	interceptors []RequestInterceptor
+	ctx          context.Context
+	cancel       context.CancelFunc
+	seqIncr      *SafeIncrementer32
+	receivers    map[uint64]transferReceiver
+	// 可以恢复的会话，key为服务端地址，TCP断开后仍然保留
+	sessions map[string]*resumableSession
+	// 正在拨号的主连接，key为服务端地址
+	dials map[string]*pendingDial
 }
 
+// pendingDial 正在建立的主连接，同一地址的其他调用等待拨号结束后共用结果
+type pendingDial struct {
+	done     chan struct{}
+	hostConn *HostConn
+	err      error
+}
+
+var _ Client = (*TcpClient)(nil)
+
 func NewTcpClient(config *TcpClientConfig) *TcpClient {
+	wheel := NewTimingWheel(10*time.Millisecond, 512)
 	return &TcpClient{
 		config:        config,
 		hostConnTable: make(map[string]*HostConn),
-		promiseM:      NewPromiseM(),
-		ticker:        time.NewTicker(time.Second * 30),
+		pools:         make(map[string]*hostPool),
+		promiseM:      NewPromiseM(wheel),
+		wheel:         wheel,
 		procs:         make(map[CommandType]Processor, 0),
 		interceptors:  make([]RequestInterceptor, 0),
 		seqIncr:       NewSafeIncrementer(),
+		receivers:     make(map[uint64]transferReceiver),
+		sessions:      make(map[string]*resumableSession),
+		dials:         make(map[string]*pendingDial),
 	}
 }
 
@@ -61,66 +126,265 @@ func (c *TcpClient) Init() error {
 }
 
 func (c *TcpClient) Start() error {
-	c.cleanupResponseFutures()
+	c.wheel.Start()
+	c.scheduleHealthCheck()
 	return nil
 }
 
 func (c *TcpClient) Stop() error {
-	c.doCloseConn()
+	// 先以Err_Client_Closed结束等待中的请求，关闭连接的回调不会再以Err_Conn_Closed结束它们
 	c.promiseM.CloseRespPromis()
+	c.doCloseConn()
 	defer c.cancel()
-	c.ticker.Stop()
+	c.wheel.Stop()
 	return nil
 }
 
+// SendSync 发送frame并等待响应，超过timeout返回超时错误，timeout不大于0时最多等待30秒
 func (c *TcpClient) SendSync(serverAddr string, frame *Frame, timeout time.Duration) (*Frame, error) {
+	if timeout <= 0 {
+		timeout = defaultResponseTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.SendSyncContext(ctx, serverAddr, frame)
+}
+
+// SendSyncContext 发送frame并等待响应直到ctx结束。
+// ctx超时返回超时错误，取消返回ctx.Err()；返回时请求的promise已经从PromiseM删除，之后到达的响应直接丢弃。
+func (c *TcpClient) SendSyncContext(ctx context.Context, serverAddr string, frame *Frame) (*Frame, error) {
 	if frame == nil {
 		return nil, errors.New("frame is nil")
 	}
-	frame.Seq = uint64(c.seqIncr.Increment())
-	log.Infof("frame auto increment sequence no: %d", frame.Seq)
-	rp := NewResponsePromise(frame.Seq, timeout)
-	defer rp.Close()
-	c.promiseM.AddSeqPromise(frame.Seq, rp)
-
-	err := c.doSendAsync(serverAddr, frame)
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-
-	respFrame, err := rp.Wait()
+	hostConn, err := c.route(serverAddr, frame)
 	if err != nil {
 		return nil, err
 	}
+	return c.sendSyncOn(ctx, hostConn, frame)
+}
 
-	c.promiseM.DelSeqPromise(frame.Seq)
-	return respFrame, nil
+// sendSyncOn 在hostConn上发送frame并等待响应直到ctx结束
+func (c *TcpClient) sendSyncOn(ctx context.Context, hostConn *HostConn, frame *Frame) (*Frame, error) {
+	frame.Seq = uint64(c.seqIncr.Increment())
+	log.Infof("frame auto increment sequence no: %d", frame.Seq)
+	rp := NewResponsePromise(frame.Seq, promiseTimeout(ctx))
+	defer rp.Close()
+	c.promiseM.AddAddrPromise(hostConn.key, frame.Seq, rp)
+	defer c.promiseM.DelSeqPromise(frame.Seq)
+
+	if err := c.send(hostConn, frame); err != nil {
+		return nil, err
+	}
+	return rp.WaitContext(ctx)
 }
 
 func (c *TcpClient) SendAsync(serverAddr string, frame *Frame) error {
+	return c.SendAsyncContext(context.Background(), serverAddr, frame)
+}
+
+// SendAsyncContext 发送frame不等待响应，ctx在发送之前已经结束时返回ctx.Err()
+func (c *TcpClient) SendAsyncContext(ctx context.Context, serverAddr string, frame *Frame) error {
+	if frame == nil {
+		return errors.New("frame is nil")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	frame.Seq = uint64(c.seqIncr.Increment())
 	return c.doSendAsync(serverAddr, frame)
 }
 
+// promiseTimeout ctx剩余的时间，ctx没有截止时间时返回0
+func promiseTimeout(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	return time.Until(deadline)
+}
+
+// responseTimeout 客户端内部请求等待响应的时间，未配置Timeout时为30秒
+func (c *TcpClient) responseTimeout() time.Duration {
+	if c.config.Timeout > 0 {
+		return c.config.Timeout
+	}
+	return defaultResponseTimeout
+}
+
 func (c *TcpClient) doSendAsync(serverAddr string, frame *Frame) error {
-	connSeq, err := c.getOrCreateConnection(c.config.Network, serverAddr, c.config.Timeout)
+	hostConn, err := c.route(serverAddr, frame)
+	if err != nil {
+		return err
+	}
+	return c.send(hostConn, frame)
+}
+
+// send 在hostConn上发送frame，连接已经发送CLOSE时只发送进行中传输的后续帧
+func (c *TcpClient) send(hostConn *HostConn, frame *Frame) error {
+	if hostConn.closing.Load() && !isDrainFrame(frame.CmdType) {
+		return Err_Conn_Closing
+	}
+	if err := hostConn.write(frame); err != nil {
+		return err
+	}
+	c.maybeRekey(hostConn)
+	return nil
+}
+
+func (hc *HostConn) write(frame *Frame) error {
+	hc.wmu.Lock()
+	defer hc.wmu.Unlock()
+
+	if hc.version != 0 {
+		frame.Version = hc.version
+	}
+	// 先压缩再加密，在写锁内编码，保证加密计数器按写出的顺序递增
+	wire, err := hc.payloadCompressor().compress(frame)
 	if err != nil {
 		return err
 	}
-	conn := connSeq.conn
-	writer := conn.Writer()
-	// encode frame
-	bytes, err := Encode(LVBasedCodec, frame)
+	bytes, err := hc.codec.WithCrypto(hc.cryptoAlg()).Encode(wire)
 	if err != nil {
 		return err
 	}
 
+	writer := hc.conn.Writer()
 	cnt, err := writer.WriteBinary(bytes)
 	if err != nil || cnt != len(bytes) {
 		return errors.New("send failed")
 	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	hc.rekey.count(len(bytes))
+	return nil
+}
+
+func (hc *HostConn) setCrypto(crypto CryptoAlg) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.crypto = crypto
+}
+
+func (hc *HostConn) setKeys(cKey []byte, sKey []byte) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.cKey = cKey
+	hc.sKey = sKey
+}
+
+func (hc *HostConn) cryptoAlg() CryptoAlg {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.crypto
+}
+
+func (hc *HostConn) setCompressor(compressor *payloadCompression) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.compressor = compressor
+}
+
+func (hc *HostConn) payloadCompressor() *payloadCompression {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.compressor
+}
+
+// Close 与serverAddr进行CLOSE/CLOSEACK握手后关闭连接，连接池中的连接先于主连接关闭。
+// 发送CLOSE后不再发送新的请求，服务端发送完待发的帧后回复CLOSEACK；
+// 连接关闭后仍在等待响应的请求以Err_Conn_Closed结束。
+func (c *TcpClient) Close(serverAddr string) error {
+	c.mux.Lock()
+	hostConn, exists := c.hostConnTable[serverAddr]
+	if !exists || !hostConn.closing.CompareAndSwap(false, true) {
+		c.mux.Unlock()
+		return nil
+	}
+	// 正常关闭的会话不再恢复
+	delete(c.sessions, serverAddr)
+	pool := c.pools[serverAddr]
+	pooled := pool.conns()
+	c.mux.Unlock()
+
+	defer func() {
+		c.mux.Lock()
+		if c.hostConnTable[serverAddr] == hostConn {
+			delete(c.hostConnTable, serverAddr)
+		}
+		if pool != nil && c.pools[serverAddr] == pool {
+			delete(c.pools, serverAddr)
+		}
+		c.mux.Unlock()
+	}()
+
+	// 进行中的文件传输在传输连接的CLOSEACK之前完成
+	var wg sync.WaitGroup
+	for _, member := range pooled {
+		if !member.closing.CompareAndSwap(false, true) {
+			continue
+		}
+		wg.Add(1)
+		go func(member *HostConn) {
+			defer wg.Done()
+			if err := c.closeHostConn(member); err != nil {
+				log.Errorf("[%s] close pooled connection failed: %v", member.key, err)
+			}
+		}(member)
+	}
+	wg.Wait()
+	return c.closeHostConn(hostConn)
+}
+
+// closeHostConn 在已经标记closing的连接上进行CLOSE/CLOSEACK握手后关闭连接
+func (c *TcpClient) closeHostConn(hostConn *HostConn) error {
+	defer func() {
+		hostConn.conn.Close()
+		c.promiseM.FailAddrPromises(hostConn.key, Err_Conn_Closed)
+	}()
+
+	if !hostConn.conn.IsActive() {
+		return nil
+	}
+
+	frame := NewFrame(CLOSE, &codec.CloseHeader{
+		Id:     hostConn.id,
+		Reason: "client close",
+	}, nil)
+	frame.Seq = uint64(c.seqIncr.Increment())
+	rp := NewResponsePromise(frame.Seq, c.config.Timeout)
+	defer rp.Close()
+	c.promiseM.AddSeqPromise(frame.Seq, rp)
+	defer c.promiseM.DelSeqPromise(frame.Seq)
+
+	if err := hostConn.write(frame); err != nil {
+		return err
+	}
+
+	respFrame, err := rp.Wait()
+	if err != nil {
+		return err
+	}
+	header, ok := respFrame.Header.(*codec.CloseAckHeader)
+	if !ok {
+		return fmt.Errorf("unexpected response for close, cmd type: %d", respFrame.CmdType)
+	}
+	if header.StatusCode != codec.CloseOK {
+		return fmt.Errorf("close connection failed, status code: %d, details: %s", header.StatusCode, header.Details)
+	}
+	return nil
+}
 
-	return writer.Flush()
+// codec 客户端使用的线上格式
+func (c *TcpClient) codec() Codec {
+	if c.config.Codec == nil {
+		return DefaultCodecs
+	}
+	return c.config.Codec
 }
 
 func (c *TcpClient) SendOnce(serverAddr string, packet *Frame) error {
@@ -142,53 +406,63 @@ func (c *TcpClient) AddInterceptor(requestInterceptor RequestInterceptor) {
 func (c *TcpClient) getOrCreateConnection(network string, serverAddr string, timeout time.Duration) (*HostConn, error) {
 
 	c.mux.Lock()
-	defer c.mux.Unlock()
-
 	connSeq, exists := c.hostConnTable[serverAddr]
+	if exists && (connSeq.closing.Load() || connSeq.conn.IsActive()) {
+		c.mux.Unlock()
+		return connSeq, nil
+	}
+	// 同一地址同时只拨号一次，其他调用等待拨号的结果
+	if dial, dialing := c.dials[serverAddr]; dialing {
+		c.mux.Unlock()
+		<-dial.done
+		return dial.hostConn, dial.err
+	}
+	dial := &pendingDial{done: make(chan struct{})}
+	c.dials[serverAddr] = dial
 	if exists {
-		return c.recreateConnectionIfNeeded(connSeq, network, serverAddr, timeout)
+		delete(c.hostConnTable, serverAddr)
 	}
+	c.mux.Unlock()
 
-	return c.doCreateConnection(network, serverAddr, timeout)
-
+	// 拨号不持有c.mux，其他地址的请求和读取回调不会被阻塞
+	if exists {
+		c.closeInactiveConnection(connSeq, serverAddr)
+	}
+	dial.hostConn, dial.err = c.doCreateConnection(network, serverAddr, timeout)
+	close(dial.done)
+	return dial.hostConn, dial.err
 }
 
-func (c *TcpClient) recreateConnectionIfNeeded(connSeq *HostConn, network string, serverAddr string, timeout time.Duration) (*HostConn, error) {
-	if connSeq.conn.IsActive() {
-		return connSeq, nil
-	}
+// closeInactiveConnection 关闭已经从表中移除的不活跃连接，关闭回调会获取c.mux，不能在持有锁时调用
+func (c *TcpClient) closeInactiveConnection(connSeq *HostConn, serverAddr string) {
 	log.Infof("Recreating connection to %s", serverAddr)
-	// 关闭不活跃的连接并从表中移除
 	err := connSeq.conn.Close()
 	if err != nil {
 		log.Errorf("Error closing connection: %s", err)
 	}
-	delete(c.hostConnTable, serverAddr)
-
-	return c.doCreateConnection(network, serverAddr, timeout)
 }
 
+// doCreateConnection 在锁外拨号，再在锁内登记新连接
 func (c *TcpClient) doCreateConnection(network string, serverAddr string, timeout time.Duration) (*HostConn, error) {
 	// 尝试创建新连接
 	newConn, err := c.createConnection(network, serverAddr, timeout)
+
+	c.mux.Lock()
+	delete(c.dials, serverAddr)
 	if err != nil {
+		c.mux.Unlock()
 		return nil, err
 	}
-
-	if !newConn.IsActive() {
-		return nil, errors.New("connection has not activated after creation")
-	}
-
-	newConn.SetOnRequest(c.handleRequest)
-	newConn.SetReadTimeout(timeout)
-	newConn.SetWriteTimeout(timeout)
-	newConn.SetIdleTimeout(timeout * 300)
-
-	newConnSeq := &HostConn{
-		conn:    newConn,
-		seqIncr: NewSafeIncrementer(),
+	// 拨号期间表中已经登记了可用的连接时使用已有的连接，新连接在锁外关闭
+	if current, exists := c.hostConnTable[serverAddr]; exists && (current.closing.Load() || current.conn.IsActive()) {
+		c.mux.Unlock()
+		newConn.Close()
+		return current, nil
 	}
+	newConnSeq := c.newHostConn(serverAddr, serverAddr, newConn, timeout)
 	c.hostConnTable[serverAddr] = newConnSeq
+	c.fillPoolLocked(serverAddr)
+	c.mux.Unlock()
 
 	return newConnSeq, nil
 }
@@ -198,75 +472,161 @@ func (c *TcpClient) createConnection(network string, serverAddr string, timeout
 	if err != nil {
 		return nil, err
 	}
+	if !conn.IsActive() {
+		return nil, errors.New("connection has not activated after creation")
+	}
 	conn.AddCloseCallback(c.closeConnectionCallback)
 	return conn, err
 }
 
-func (c *TcpClient) handleRequest(ctx context.Context, conn netpoll.Connection) (err error) {
-	reader := conn.Reader()
-	len, err := binary.ReadUvarint(reader)
+// newHostConn 为新建的连接设置读取回调和超时，promise以key登记
+func (c *TcpClient) newHostConn(serverAddr string, key string, conn netpoll.Connection, timeout time.Duration) *HostConn {
+	hostConn := &HostConn{
+		addr:    serverAddr,
+		key:     key,
+		conn:    conn,
+		codec:   c.codec(),
+		version: c.config.Version,
+		seqIncr: NewSafeIncrementer(),
+	}
+	hostConn.lastRead.Store(time.Now().UnixNano())
+
+	conn.SetOnRequest(func(ctx context.Context, conn netpoll.Connection) error {
+		return c.handleRequest(ctx, hostConn, conn)
+	})
+	conn.SetReadTimeout(timeout)
+	conn.SetWriteTimeout(timeout)
+	conn.SetIdleTimeout(timeout * 300)
+	return hostConn
+}
+
+func (c *TcpClient) handleRequest(ctx context.Context, hostConn *HostConn, conn netpoll.Connection) (err error) {
+	frame, err := ReadFrame(conn.Reader(), c.config.Limits, hostConn.codec.WithCrypto(hostConn.cryptoAlg()))
 	if err != nil {
-		fmt.Printf("%s", err)
-		if err == io.EOF {
-			conn.Close()
-			serevrAddr := ctx.Value("serverAddr")
-			if addr, ok := serevrAddr.(string); ok {
-				delete(c.hostConnTable, addr)
+		log.Errorf("[%s] read frame failed: %v", hostConn.addr, err)
+		switch {
+		case errors.Is(err, Err_Frame_Auth_Failed), errors.Is(err, Err_Frame_Too_Large), errors.Is(err, Err_Header_Too_Large),
+			errors.Is(err, Err_Unsupported_Version), errors.Is(err, Err_Frame_Corrupted):
+			c.abort(hostConn, err)
+		default:
+			// 帧已经完整读取，只是Header无法解析，只结束对应的请求
+			var protocolErr *ProtocolError
+			if errors.As(err, &protocolErr) {
+				if !c.failTransfer(protocolErr.Seq, protocolErr) {
+					c.promiseM.FailSeqPromise(protocolErr.Seq, protocolErr)
+				}
+				return err
 			}
+			// 对端关闭或读取超时，关闭回调会从表中删除该连接
+			conn.Close()
 		}
-
 		return err
 	}
-	data, err := reader.ReadBinary(int(len))
-	if err != nil {
-		fmt.Printf("%s", err)
-		if err == io.EOF {
-			conn.Close()
-			serevrAddr := ctx.Value("serverAddr")
-			if addr, ok := serevrAddr.(string); ok {
-				delete(c.hostConnTable, addr)
-			}
+	log.Infof("client received frame sequence no.: %d", frame.Seq)
+	hostConn.lastRead.Store(time.Now().UnixNano())
+	// 服务端无法处理本端发出的帧，连接即将被关闭
+	if header, ok := frame.Header.(*codec.CloseAckHeader); ok {
+		switch header.StatusCode {
+		case codec.CloseAuthFailed:
+			c.abort(hostConn, Err_Frame_Auth_Failed)
+			return nil
+		case codec.CloseFrameTooLarge:
+			c.abort(hostConn, Err_Frame_Too_Large)
+			return nil
+		case codec.CloseUnsupportedVersion:
+			c.abort(hostConn, Err_Unsupported_Version)
+			return nil
+		case codec.CloseChecksumMismatch:
+			c.abort(hostConn, Err_Frame_Corrupted)
+			return nil
+		case codec.CloseBadPayload:
+			c.abort(hostConn, Err_Invalid_Compressed_Payload)
+			return nil
 		}
 	}
-	frame, err := Decode(LVBasedCodec, data)
-	if err != nil {
-		fmt.Printf("%s", err)
+	hostConn.rekey.count(int(frame.HLen) + len(frame.Payload))
+	if err := hostConn.payloadCompressor().decompress(frame); err != nil {
+		c.abort(hostConn, err)
 		return err
 	}
-	log.Infof("client received frame sequence no.: %d", frame.Seq)
-	c.promiseM.AddResp(frame)
+	// 必须在读取下一帧之前切换密钥
+	if frame.CmdType == REKEYACK {
+		c.completeRekey(hostConn, frame)
+	}
+	c.maybeRekey(hostConn)
+	// 服务端无法处理请求，等待响应的SendSync和文件传输立即返回
+	if header, ok := frame.Header.(*codec.ErrorHeader); ok {
+		protocolErr := &ProtocolError{Seq: header.Seq, Code: header.Code, Message: header.Message}
+		if !c.failTransfer(header.Seq, protocolErr) {
+			c.promiseM.FailSeqPromise(header.Seq, protocolErr)
+		}
+		return nil
+	}
+	if c.dispatchTransfer(frame) {
+		return nil
+	}
+	if c.promiseM.AddResp(frame) {
+		return nil
+	}
+	if frame.Seq != 0 {
+		// 请求已经超时、取消或者结束，迟到的响应直接丢弃
+		log.Infof("[%s] drop response without pending request, cmd: %d, sequence no.: %d", hostConn.addr, frame.CmdType, frame.Seq)
+		return nil
+	}
+	c.dispatchPush(hostConn, frame)
 	return nil
 }
 
+// abort 字节流无法继续解析时关闭连接，等待该地址响应的请求以reason结束
+func (c *TcpClient) abort(hostConn *HostConn, reason error) {
+	log.Errorf("[%s] %v, close connection", hostConn.addr, reason)
+	hostConn.closing.Store(true)
+	hostConn.conn.Close()
+	c.promiseM.FailAddrPromises(hostConn.key, reason)
+}
+
 func (c *TcpClient) closeConnectionCallback(conn netpoll.Connection) error {
 	log.Infof("[Client][%v] connection closed\n", conn.RemoteAddr())
 	addr := conn.RemoteAddr()
 	conn.Close()
-	delete(c.hostConnTable, addr.String())
+	c.mux.Lock()
+	// 只删除当前连接，重新建立的连接保留在表中。
+	// 回调收到的是拨号返回的TCPConnection内嵌的连接，不能直接比较，使用本端地址区分
+	var primary *HostConn
+	if hostConn, exists := c.hostConnTable[addr.String()]; exists && hostConn.conn.LocalAddr().String() == conn.LocalAddr().String() {
+		delete(c.hostConnTable, addr.String())
+		primary = hostConn
+	}
+	member := c.pools[addr.String()].remove(conn.LocalAddr().String())
+	c.mux.Unlock()
+
+	// 连接关闭后，等待该连接响应的请求立即结束，不必等到超时
+	if primary != nil {
+		c.promiseM.FailAddrPromises(primary.key, Err_Conn_Closed)
+	}
+	if member != nil {
+		c.promiseM.FailAddrPromises(member.key, Err_Conn_Closed)
+	}
 	return nil
 }
 
 func (c *TcpClient) doCloseConn() {
 	c.mux.Lock()
-	defer c.mux.Unlock()
+	conns := make([]netpoll.Connection, 0, len(c.hostConnTable))
 	for _, connIncr := range c.hostConnTable {
-		if connIncr.conn.IsActive() {
-			connIncr.conn.Close()
+		conns = append(conns, connIncr.conn)
+	}
+	for _, pool := range c.pools {
+		for _, member := range pool.conns() {
+			conns = append(conns, member.conn)
 		}
 	}
-}
+	c.mux.Unlock()
 
-func (c *TcpClient) cleanupResponseFutures() {
-	// 设置定时器，每30秒触发一次扫描
-	c.ticker = time.NewTicker(30 * time.Second)
-	go func() {
-		for {
-			<-c.ticker.C
-			c.doCleanupRespPromise()
+	// 关闭回调会获取c.mux，不能在持有锁时关闭连接
+	for _, conn := range conns {
+		if conn.IsActive() {
+			conn.Close()
 		}
-	}()
-}
-
-func (c *TcpClient) doCleanupRespPromise() {
-	c.promiseM.CleanupRespPromise()
+	}
 }
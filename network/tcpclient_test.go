@@ -2,6 +2,7 @@ package network_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -15,6 +16,7 @@ import (
 	"github.com/pkg/profile"
 	"github.com/quintans/toolkit/latch"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -42,7 +44,7 @@ func BenchmarkClientServerConnection(b *testing.B) {
 	defer tearDown(tcpServer, tcpClient)
 
 	frame := &network.Frame{
-		Version: 1,
+		Version: network.VERSION_2,
 		CmdType: CommandA,
 		Header: &Conn{
 			KeyLen: uint32(len("ABC")),
@@ -69,7 +71,7 @@ func BenchmarkClientServerConnectionConcurrent(b *testing.B) {
 	defer tearDown(tcpServer, tcpClient)
 
 	frame := &network.Frame{
-		Version: 1,
+		Version: network.VERSION_2,
 		CmdType: CommandA,
 		Header: &Conn{
 			KeyLen: uint32(len("ABC")),
@@ -107,7 +109,7 @@ func TestSendSyncShouldRecvSuccessResponseWhenConnectedServerAndSendFrameWitiHea
 	tcpSrv := StartTcpServer()
 
 	frame := &network.Frame{
-		Version: 1,
+		Version: network.VERSION_2,
 		CmdType: CommandA,
 		Header: &Conn{
 			KeyLen: uint32(len("ABC")),
@@ -144,7 +146,7 @@ func TestHighTrafficStability(t *testing.T) {
 	upperLimit := 25
 	for i := 0; i < upperLimit; i++ {
 		frame := &network.Frame{
-			Version: 1,
+			Version: network.VERSION_2,
 			CmdType: CommandA,
 			Header: &Conn{
 				KeyLen: uint32(len("ABC")),
@@ -187,7 +189,7 @@ func BenchmarkHighTrafficStability(b *testing.B) {
 
 	for i := 0; i < b.N; i++ { // 使用 b.N 作为循环次数
 		frame := &network.Frame{
-			Version: 1,
+			Version: network.VERSION_2,
 			CmdType: CommandA,
 			Header: &Conn{
 				KeyLen: uint32(len("ABC")),
@@ -213,7 +215,7 @@ func BenchmarkHighTrafficConcurrency(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		frame := &network.Frame{
-			Version: 1,
+			Version: network.VERSION_2,
 			CmdType: CommandA,
 			Header: &Conn{
 				KeyLen: uint32(len("ABC")),
@@ -325,7 +327,7 @@ type CommandAProcessor struct{}
 
 func (cmdProcessor CommandAProcessor) Process(conn *network.Conn, packet *network.Frame) (*network.Frame, error) {
 	return &network.Frame{
-		Version: 1,
+		Version: network.VERSION_2,
 		CmdType: CommandA,
 		Seq:     packet.Seq,
 		Header: &Conn{
@@ -335,3 +337,119 @@ func (cmdProcessor CommandAProcessor) Process(conn *network.Conn, packet *networ
 		Payload: []byte("Hello Client"),
 	}, nil
 }
+
+// slowFrame 服务端收到后阻塞到blockingProcessor.release关闭
+func slowFrame() *network.Frame {
+	return blockingFrame(slowRequest)
+}
+
+func TestSendSyncContextShouldReturnWhenContextDeadlineExceeded(t *testing.T) {
+	log.InitLogger()
+	tcpSrv, processor := startDispatchingServer(t, 0, false)
+	defer tcpSrv.Stop()
+	defer close(processor.release)
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := tcpClient.SendSyncContext(ctx, fileServerAddr, slowFrame())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// SendSync同样按传入的timeout返回
+	start = time.Now()
+	_, err = tcpClient.SendSync(fileServerAddr, slowFrame(), 100*time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestSendSyncContextShouldRemovePromiseWhenContextCancelled(t *testing.T) {
+	log.InitLogger()
+	tcpSrv, processor := startDispatchingServer(t, 0, false)
+	defer tcpSrv.Stop()
+	defer close(processor.release)
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err := tcpClient.SendSyncContext(ctx, fileServerAddr, slowFrame())
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// ctx已经结束时不发送请求
+	assert.ErrorIs(t, tcpClient.SendAsyncContext(ctx, fileServerAddr, slowFrame()), context.Canceled)
+	_, err = tcpClient.SendSyncContext(ctx, fileServerAddr, slowFrame())
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSendSyncContextShouldDropLateResponseWhenContextCancelled(t *testing.T) {
+	log.InitLogger()
+	tcpSrv, processor := startDispatchingServer(t, 0, false)
+	defer tcpSrv.Stop()
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+	recorder := &notifyRecorder{frames: make(chan *network.Frame, 1)}
+	tcpClient.AddProcessor(blockingCmd, recorder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	frame := slowFrame()
+	go func() {
+		<-processor.entered
+		cancel()
+	}()
+	_, err := tcpClient.SendSyncContext(ctx, fileServerAddr, frame)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// 取消后服务端才回复，迟到的响应直接丢弃，不会交给客户端的processor
+	close(processor.release)
+	assert.Equal(t, frame.Seq, <-processor.processed)
+	recorder.assertNothingDispatched(t, 500*time.Millisecond)
+
+	// 连接不受影响，之后的请求正常返回
+	resp, err := tcpClient.SendSync(fileServerAddr, fastFrame(), 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, blockingCmd, resp.CmdType)
+	recorder.assertNothingDispatched(t, 100*time.Millisecond)
+}
+
+func TestSendSyncShouldReturnErrClientClosedWhenClientStopped(t *testing.T) {
+	log.InitLogger()
+	tcpSrv, processor := startDispatchingServer(t, 0, false)
+	defer tcpSrv.Stop()
+	defer close(processor.release)
+	tcpClient := StartFileTcpClient()
+
+	time.AfterFunc(100*time.Millisecond, func() {
+		tcpClient.Stop()
+	})
+	start := time.Now()
+	_, err := tcpClient.SendSync(fileServerAddr, slowFrame(), 5*time.Second)
+	assert.ErrorIs(t, err, network.Err_Client_Closed)
+	assert.NotErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
+
+// closingProcessor 收到请求后直接关闭连接，不回复
+type closingProcessor struct{}
+
+func (closingProcessor) Process(conn *network.Conn, frame *network.Frame) (*network.Frame, error) {
+	conn.Connection.Close()
+	return nil, nil
+}
+
+func TestSendSyncShouldReturnErrConnClosedWhenServerClosesPrimaryConnection(t *testing.T) {
+	log.InitLogger()
+	tcpSrv := StartFileTcpServer(t.TempDir())
+	defer tcpSrv.Stop()
+	tcpSrv.AddProcessor(blockingCmd, closingProcessor{})
+	tcpClient := StartFileTcpClient()
+	defer tcpClient.Stop()
+
+	// 主连接关闭后请求立即结束，不等待5秒的超时
+	start := time.Now()
+	_, err := tcpClient.SendSync(fileServerAddr, fastFrame(), 5*time.Second)
+	assert.ErrorIs(t, err, network.Err_Conn_Closed)
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
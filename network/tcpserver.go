@@ -2,10 +2,11 @@ package network
 
 import (
 	"context"
-	"encoding/binary"
+	"crypto/ed25519"
 	"errors"
+	"fmt"
 	"go-networking/log"
-	"io"
+	"go-networking/network/codec"
 	"net"
 	"sync"
 	"time"
@@ -16,6 +17,25 @@ import (
 type TcpServerConfig struct {
 	Network string
 	Addr
+	// 服务端的长期身份私钥，用于签名CONNACK，为nil时握手不做服务端认证
+	IdentityKey ed25519.PrivateKey
+	// 会话恢复票据的有效期，为0时不签发票据
+	TicketLifetime time.Duration
+	// 接收帧的大小限制，超过限制时回复CLOSEACK并关闭连接
+	Limits FrameLimits
+	// 帧的线上格式，为nil时使用DefaultCodecs。使用CodecRegistry时按对端帧的Version选择格式
+	Codec Codec
+	// 服务端支持的负载压缩算法，在CONN和RESUME中与客户端协商
+	Compression CompressionPolicy
+	// 每个连接同时处理的请求数，为0或1时在netpoll回调中逐个处理。
+	// CONN、RESUME、REKEY、AUTH、CLOSE和传输帧始终在之前的请求全部回复后逐个处理。
+	MaxConcurrentRequests int
+	// 并发处理时按请求到达的顺序发送响应，用于依赖响应顺序的客户端
+	OrderedResponses bool
+	// 每个订阅连接最多缓存的待发送PUBLISH帧，队列满时丢弃新的消息，为0时使用DefaultPublishQueueSize
+	PublishQueueSize int
+	// 校验AUTH中的认证令牌，返回令牌所属的用户ID。为nil时所有连接都是匿名的，不能上传文件
+	Authenticate func(token string) (userId uint32, err error)
 }
 
 type TcpServer struct {
@@ -27,7 +47,7 @@ type TcpServer struct {
 	pollerNum    int
 	connKeyTable map[string]*ConnCtx
 	CManager     *ConnManager
-	mu           sync.Mutex // 用于保护map的并发安全
+	mu           sync.Mutex // 保护processors和interceptors，两者都在锁外调用
 }
 
 func NewTcpServer(config *TcpServerConfig) (*TcpServer, error) {
@@ -87,16 +107,72 @@ func (s *TcpServer) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	err := s.eventLoop.Shutdown(ctx)
+	s.CManager.Stop()
 	return err
 }
 
 func (s *TcpServer) AddProcessor(cmdType CommandType, process Processor) {
 	log.Info("Adding processor")
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.processors[cmdType] = process
 }
 
+// Authenticate 校验AUTH中的认证令牌，返回令牌所属的用户ID。
+// 令牌为空或者没有配置Authenticate时返回错误，连接保持匿名。
+func (s *TcpServer) Authenticate(token string) (uint32, error) {
+	if len(token) == 0 {
+		return 0, errors.New("empty token")
+	}
+	if s.config.Authenticate == nil {
+		return 0, errors.New("authentication is not configured")
+	}
+	userId, err := s.config.Authenticate(token)
+	if err != nil {
+		return 0, err
+	}
+	if userId == 0 {
+		return 0, errors.New("token has no user")
+	}
+	return userId, nil
+}
+
+// IdentityKey 服务端的身份私钥，未配置时返回nil
+func (s *TcpServer) IdentityKey() ed25519.PrivateKey {
+	return s.config.IdentityKey
+}
+
+// Codec 服务端使用的线上格式
+func (s *TcpServer) Codec() Codec {
+	if s.config.Codec == nil {
+		return DefaultCodecs
+	}
+	return s.config.Codec
+}
+
+// TicketLifetime 会话恢复票据的有效期，为0时不签发票据
+func (s *TcpServer) TicketLifetime() time.Duration {
+	return s.config.TicketLifetime
+}
+
+// NegotiateCompression 按服务端的优先级选择客户端提供的压缩算法并设置到连接上，
+// 返回写入CONNACK或RESUMEACK的算法，没有共同的算法时为0
+func (s *TcpServer) NegotiateCompression(conn *Conn, offered []uint8) (uint8, error) {
+	algorithm := s.config.Compression.Negotiate(offered)
+	compressor, err := newPayloadCompression(algorithm, s.config.Compression, s.config.Limits)
+	if err != nil {
+		return 0, err
+	}
+	conn.setCompressor(compressor)
+	return uint8(algorithm), nil
+}
+
 func (s *TcpServer) AddInterceptor(requestInterceptor RequestInterceptor) {
-	s.interceptors = append(s.interceptors, requestInterceptor)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 复制后追加，已经取出的快照不受影响
+	interceptors := make([]RequestInterceptor, 0, len(s.interceptors)+1)
+	s.interceptors = append(append(interceptors, s.interceptors...), requestInterceptor)
 }
 
 func (s *TcpServer) prepare(connection netpoll.Connection) context.Context {
@@ -108,84 +184,221 @@ func (s *TcpServer) close(connection netpoll.Connection) error {
 	return nil
 }
 
+type connCtxKey struct{}
+
 func (s *TcpServer) connect(ctx context.Context, connection netpoll.Connection) context.Context {
 	log.Infof("[%v] connection established\n", connection.RemoteAddr())
 
 	connection.AddCloseCallback(s.close)
-	return ctx
+	// 每个物理连接只创建一个Conn，processor异步写帧时共用同一把写锁
+	conn := &Conn{Connection: connection}
+	if s.config.MaxConcurrentRequests > 1 {
+		conn.dispatcher = newRequestDispatcher(s.config.MaxConcurrentRequests, s.config.OrderedResponses)
+	}
+	return context.WithValue(ctx, connCtxKey{}, conn)
 }
 
-func (s *TcpServer) handle(ctx context.Context, connection netpoll.Connection) error {
-	reader, writer := connection.Reader(), connection.Writer()
-	readLen, err := binary.ReadUvarint(reader)
+// Send 编码frame并写入连接，同一连接上的写操作互斥，processor可以用它在响应之外主动发送帧。
+// 帧使用连接协商的协议版本；加密计数器在写锁内分配，保证帧按计数器递增的顺序写出。
+func (s *TcpServer) Send(conn *Conn, frame *Frame) error {
+	conn.wmu.Lock()
+	defer conn.wmu.Unlock()
+
+	if version := conn.Version(); version != 0 {
+		frame.Version = version
+	}
+	// 先压缩再加密，加密后的数据无法压缩
+	wire, err := conn.payloadCompressor().compress(frame)
 	if err != nil {
-		log.Errorf("%s", err)
-		if err == io.EOF {
-			defer reader.Release()
-		}
+		return err
+	}
+	if conn.payloadCompressor() != nil && compressible(frame) {
+		s.observeCompression(conn, frame, len(frame.Payload), len(wire.Payload), false)
+	}
+	data, err := s.Codec().WithCrypto(conn.Crypto()).Encode(wire)
+	if err != nil {
+		return err
+	}
 
+	if !conn.Connection.IsActive() {
+		return errors.New("connection is not active")
+	}
+	writer := conn.Connection.Writer()
+	if _, err = writer.WriteBinary(data); err != nil {
 		return err
 	}
 
-	data, err := reader.ReadBinary(int(readLen))
+	return writer.Flush()
+}
+
+func (s *TcpServer) handle(ctx context.Context, connection netpoll.Connection) error {
+	conn, ok := ctx.Value(connCtxKey{}).(*Conn)
+	if !ok {
+		conn = &Conn{Connection: connection}
+	}
+
+	req, err := ReadFrame(connection.Reader(), s.config.Limits, s.Codec().WithCrypto(conn.Crypto()))
 	if err != nil {
 		log.Errorf("%s", err)
-		if err == io.EOF {
-			defer reader.Release()
+		switch {
+		case errors.Is(err, Err_Frame_Auth_Failed):
+			s.reject(conn, codec.CloseAuthFailed, err)
+		case errors.Is(err, Err_Frame_Too_Large), errors.Is(err, Err_Header_Too_Large):
+			s.reject(conn, codec.CloseFrameTooLarge, err)
+		case errors.Is(err, Err_Unsupported_Version):
+			s.reject(conn, codec.CloseUnsupportedVersion, err)
+		case errors.As(err, new(*ChecksumError)):
+			s.reject(conn, codec.CloseChecksumMismatch, err)
+		default:
+			// 帧已经完整读取，只是Header无法解析，连接仍然可以继续使用
+			var protocolErr *ProtocolError
+			if errors.As(err, &protocolErr) {
+				conn.negotiate(protocolErr.version)
+				s.sendError(conn, protocolErr)
+			}
 		}
 		return err
 	}
+	conn.negotiate(req.Version)
 
-	req, err := Decode(LVBasedCodec, data)
-	if err != nil {
+	compressor := conn.payloadCompressor()
+	wireLen := len(req.Payload)
+	if err := compressor.decompress(req); err != nil {
+		s.reject(conn, codec.CloseBadPayload, err)
 		return err
 	}
+	if compressor != nil && compressible(req) {
+		s.observeCompression(conn, req, len(req.Payload), wireLen, true)
+	}
 
 	log.Infof("server recv frame sequence: %d", req.Seq)
 
-	s.mu.Lock() // 加锁保护map的并发访问
-	if len(s.interceptors) != 0 {
-		for _, interceptor := range s.interceptors {
-			// todo add client address
-			interceptor.OnRequest("", req)
+	for _, interceptor := range s.snapshotInterceptors() {
+		// todo add client address
+		interceptor.OnRequest("", req)
+	}
+
+	// 收到CLOSE后只处理进行中传输的后续帧，不再接受新的请求
+	if conn.Closing() && !isDrainFrame(req.CmdType) {
+		log.Infof("connection is closing, drop frame, cmd type: %d, seq: %d", req.CmdType, req.Seq)
+		s.sendError(conn, &ProtocolError{Seq: req.Seq, Code: codec.ErrorConnClosing, Message: Err_Conn_Closing.Error()})
+		return nil
+	}
+
+	if conn.dispatcher != nil {
+		if !isSerialCommand(req.CmdType) {
+			conn.dispatcher.dispatch(func() *Frame {
+				reply, err := s.process(conn, req)
+				if err != nil {
+					log.Errorf("process frame failed, cmd type: %d, seq: %d: %v", req.CmdType, req.Seq, err)
+				}
+				return reply
+			}, func(reply *Frame) {
+				s.reply(conn, reply)
+			})
+			return nil
 		}
+		// 改变连接状态的命令等之前的请求全部回复后再执行
+		conn.dispatcher.wait()
 	}
+
+	reply, err := s.process(conn, req)
+	s.reply(conn, reply)
+	return err
+}
+
+// process 执行请求对应的processor，返回需要回复的帧，该帧是请求的最后一个响应，设置FlagEndOfStream。
+// 失败时返回ERROR帧和错误，processor已经自行发送了响应时返回nil，例如流式传输文件。
+func (s *TcpServer) process(conn *Conn, req *Frame) (*Frame, error) {
+	s.mu.Lock()
+	processor, ok := s.processors[req.CmdType]
 	s.mu.Unlock()
+	if !ok {
+		err := fmt.Errorf("command processor cannot be found, cmd type: %d", req.CmdType)
+		return s.errorFrame(conn, &ProtocolError{Seq: req.Seq, Code: codec.ErrorUnknownCommand, Message: err.Error()}), err
+	}
 
-	if processor, ok := s.processors[req.CmdType]; ok {
-		resp, err := processor.Process(&Conn{
-			Connection: connection,
-		}, req)
-		if err != nil {
-			return err
+	resp, err := processor.Process(conn, req)
+	if err != nil {
+		// processor可以返回*ProtocolError指定错误码，其余错误按500回复
+		protocolErr := &ProtocolError{Seq: req.Seq, Code: codec.ErrorInternal, Message: err.Error()}
+		var processorErr *ProtocolError
+		if errors.As(err, &processorErr) {
+			protocolErr.Code, protocolErr.Message = processorErr.Code, processorErr.Message
 		}
+		return s.errorFrame(conn, protocolErr), err
+	}
+	if resp == nil {
+		return nil, nil
+	}
+	resp.Flags |= FlagEndOfStream
 
-		s.mu.Lock() // 加锁保护map的并发访问
-		if len(s.interceptors) != 0 {
-			for _, interceptor := range s.interceptors {
-				// todo add client direction
-				interceptor.OnResponse("", req, resp)
-			}
-		}
-		s.mu.Unlock()
+	for _, interceptor := range s.snapshotInterceptors() {
+		// todo add client direction
+		interceptor.OnResponse("", req, resp)
+	}
+	return resp, nil
+}
 
-		respData, err := Encode(LVBasedCodec, resp)
-		if err != nil {
-			return err
-		}
+// reply 发送process返回的帧
+func (s *TcpServer) reply(conn *Conn, frame *Frame) {
+	if frame == nil {
+		return
+	}
+	if err := s.Send(conn, frame); err != nil {
+		log.Errorf("[%v] send reply failed, cmd type: %d, seq: %d: %v", conn.Connection.RemoteAddr(), frame.CmdType, frame.Seq, err)
+	}
+}
 
-		_, err = writer.WriteBinary(respData)
-		if err != nil {
-			return err
-		}
+// snapshotInterceptors 取出拦截器列表，AddInterceptor写时复制，调用拦截器时不持有锁
+func (s *TcpServer) snapshotInterceptors() []RequestInterceptor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.interceptors
+}
+
+// errorFrame 记录失败原因并构造ERROR帧
+func (s *TcpServer) errorFrame(conn *Conn, protocolErr *ProtocolError) *Frame {
+	log.Errorf("[%v] %v", conn.Connection.RemoteAddr(), protocolErr)
+	return newErrorFrame(protocolErr)
+}
 
-		err = writer.Flush()
-		if err != nil {
-			return err
+// sendError 以ERROR帧通知客户端请求失败，客户端的SendSync立即返回*ProtocolError
+func (s *TcpServer) sendError(conn *Conn, protocolErr *ProtocolError) {
+	s.reply(conn, s.errorFrame(conn, protocolErr))
+}
+
+// observeCompression 通知实现了CompressionObserver的拦截器
+func (s *TcpServer) observeCompression(conn *Conn, frame *Frame, rawLen int, wireLen int, inbound bool) {
+	remoteAddr := ""
+	if addr := conn.Connection.RemoteAddr(); addr != nil {
+		remoteAddr = addr.String()
+	}
+	for _, interceptor := range s.snapshotInterceptors() {
+		if observer, ok := interceptor.(CompressionObserver); ok {
+			observer.OnCompression(remoteAddr, frame, rawLen, wireLen, inbound)
 		}
-	} else {
-		return errors.New("command processor cannot be found")
 	}
+}
 
-	return nil
+// reject 收到无法处理的帧时以CLOSEACK通知对端原因，然后关闭连接。
+// 字节流已经无法继续解析，认证失败时CLOSEACK以明文发送。
+func (s *TcpServer) reject(conn *Conn, statusCode uint16, reason error) {
+	log.Errorf("[%v] %v, close connection", conn.Connection.RemoteAddr(), reason)
+	conn.BeginClose()
+	ackFrame := NewFrame(CLOSEACK, &codec.CloseAckHeader{
+		StatusCode: statusCode,
+		Details:    reason.Error(),
+	}, nil)
+	if err := s.Send(conn, ackFrame); err != nil {
+		log.Errorf("send close ack failed: %v", err)
+	}
+	if err := conn.Connection.Close(); err != nil {
+		log.Errorf("close connection failed: %v", err)
+	}
+}
+
+// isDrainFrame 连接关闭过程中仍需收发的帧，用于让进行中的传输完成
+func isDrainFrame(cmdType CommandType) bool {
+	return cmdType == TRANSFER || cmdType == TRANSFERACK
 }
@@ -0,0 +1,143 @@
+package network
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// TimingWheel 哈希时间轮，每个tick推进一个槽，执行该槽中到期的任务。
+// 添加和取消任务都是O(1)，延迟超过一圈的任务记录剩余圈数；
+// 到期的任务在时间轮的goroutine中依次执行，耗时的任务应该交给其他goroutine。
+type TimingWheel struct {
+	tick  time.Duration
+	mu    sync.Mutex
+	slots []*list.List
+	// 当前槽和推进到当前槽的时间，新任务据此计算所在的槽
+	cursor   int
+	lastTick time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// WheelTimer AfterFunc添加的任务，到期之前可以调用Stop取消
+type WheelTimer struct {
+	wheel *TimingWheel
+	// 任务所在的槽和还需要经过的圈数，到期或取消后elem为nil
+	slot   int
+	rounds int
+	elem   *list.Element
+	task   func()
+}
+
+// NewTimingWheel 创建有size个槽、每tick推进一个槽的时间轮，需要调用Start才会开始推进
+func NewTimingWheel(tick time.Duration, size int) *TimingWheel {
+	if tick <= 0 {
+		tick = 10 * time.Millisecond
+	}
+	if size <= 0 {
+		size = 512
+	}
+	slots := make([]*list.List, size)
+	for i := range slots {
+		slots[i] = list.New()
+	}
+	return &TimingWheel{
+		tick:     tick,
+		slots:    slots,
+		lastTick: time.Now(),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start 启动推进时间轮的goroutine
+func (tw *TimingWheel) Start() {
+	tw.mu.Lock()
+	tw.lastTick = time.Now()
+	tw.mu.Unlock()
+	go tw.run(time.NewTicker(tw.tick))
+}
+
+// Stop 停止时间轮，尚未到期的任务不再执行
+func (tw *TimingWheel) Stop() {
+	tw.stopOnce.Do(func() {
+		close(tw.stop)
+	})
+}
+
+// AfterFunc 在delay之后执行task，实际执行时间最多晚一个tick
+func (tw *TimingWheel) AfterFunc(delay time.Duration, task func()) *WheelTimer {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	// 从上一次推进的时间开始计算，保证不会提前执行
+	ticks := int((time.Since(tw.lastTick) + delay + tw.tick - 1) / tw.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	timer := &WheelTimer{
+		wheel:  tw,
+		slot:   (tw.cursor + ticks) % len(tw.slots),
+		rounds: (ticks - 1) / len(tw.slots),
+		task:   task,
+	}
+	timer.elem = tw.slots[timer.slot].PushBack(timer)
+	return timer
+}
+
+// Stop 取消任务，任务已经执行或已经取消时返回false
+func (t *WheelTimer) Stop() bool {
+	t.wheel.mu.Lock()
+	defer t.wheel.mu.Unlock()
+	if t.elem == nil {
+		return false
+	}
+	t.wheel.slots[t.slot].Remove(t.elem)
+	t.elem = nil
+	return true
+}
+
+func (tw *TimingWheel) run(ticker *time.Ticker) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tw.stop:
+			return
+		case now := <-ticker.C:
+			for _, task := range tw.advance(now) {
+				task()
+			}
+		}
+	}
+}
+
+// advance 推进到now对应的槽，返回经过的槽中到期的任务。
+// ticker在任务耗时较长时会丢弃tick，一次推进多个槽避免时间轮落后
+func (tw *TimingWheel) advance(now time.Time) []func() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	ticks := int(now.Sub(tw.lastTick) / tw.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	tw.lastTick = tw.lastTick.Add(time.Duration(ticks) * tw.tick)
+	var expired []func()
+	for i := 0; i < ticks; i++ {
+		tw.cursor = (tw.cursor + 1) % len(tw.slots)
+		slot := tw.slots[tw.cursor]
+		for elem := slot.Front(); elem != nil; {
+			next := elem.Next()
+			timer := elem.Value.(*WheelTimer)
+			if timer.rounds > 0 {
+				timer.rounds--
+			} else {
+				slot.Remove(elem)
+				timer.elem = nil
+				expired = append(expired, timer.task)
+			}
+			elem = next
+		}
+	}
+	return expired
+}
@@ -0,0 +1,51 @@
+package network_test
+
+import (
+	"go-networking/network"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimingWheelShouldRunTaskAfterDelay(t *testing.T) {
+	tw := network.NewTimingWheel(5*time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	// 延迟超过一圈的任务经过多圈后执行
+	fired := make(chan time.Time, 2)
+	start := time.Now()
+	tw.AfterFunc(20*time.Millisecond, func() { fired <- time.Now() })
+	tw.AfterFunc(100*time.Millisecond, func() { fired <- time.Now() })
+
+	for _, delay := range []time.Duration{20 * time.Millisecond, 100 * time.Millisecond} {
+		select {
+		case at := <-fired:
+			assert.GreaterOrEqual(t, at.Sub(start), delay)
+			assert.Less(t, at.Sub(start), delay+500*time.Millisecond)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("task with delay %v did not run", delay)
+		}
+	}
+}
+
+func TestTimingWheelShouldNotRunTaskWhenStopped(t *testing.T) {
+	tw := network.NewTimingWheel(5*time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var count atomic.Int32
+	timer := tw.AfterFunc(30*time.Millisecond, func() { count.Add(1) })
+	require.True(t, timer.Stop())
+	assert.False(t, timer.Stop())
+
+	done := tw.AfterFunc(10*time.Millisecond, func() { count.Add(10) })
+	require.Eventually(t, func() bool { return count.Load() == 10 }, time.Second, 5*time.Millisecond)
+	// 已经执行的任务不能取消
+	assert.False(t, done.Stop())
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(10), count.Load())
+}